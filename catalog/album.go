@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"common/spotifyapi"
+	"github.com/zmb3/spotify"
+)
+
+// labeledAlbum is a spotify album with its label, the spotify library does not decode it
+type labeledAlbum struct {
+	spotify.FullAlbum
+	Label string `json:"label"`
+}
+
+// apiClient is the spotify client completed with the album and its label
+type apiClient struct {
+	*spotifyapi.APIClient
+}
+
+// newAPIClient creates the client authenticated with the access token
+func newAPIClient(factory *spotifyapi.Factory, token string) *apiClient {
+	return &apiClient{factory.APIClient(token)}
+}
+
+// GetLabeledAlbum gets the album like GetAlbumOpt along with its label, market relinks the tracks when given
+func (c *apiClient) GetLabeledAlbum(id spotify.ID, market string) (*labeledAlbum, error) {
+	v := url.Values{}
+	if market != "" {
+		v.Set("market", market)
+	}
+	var album labeledAlbum
+	if err := c.Get(context.Background(), "albums/"+url.PathEscape(string(id)), v, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"common/spotifyapi"
+	"github.com/zmb3/spotify"
+)
+
+func Test_apiClient_GetLabeledAlbum(t *testing.T) {
+	var query string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if r.URL.Path != "/v1/albums/dawn" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"status":404,"message":"non existing id"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"dawn","name":"Dawn","label":"Fake Records","release_date":"2020","release_date_precision":"year","tracks":{"items":[{"id":"sunrise"}],"total":3}}`))
+	}))
+	defer api.Close()
+	factory, err := spotifyapi.NewFactory(api.URL + "/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	album, err := newAPIClient(factory, "token").GetLabeledAlbum("dawn", "FR")
+	if err != nil {
+		t.Fatalf("GetLabeledAlbum() error = %v", err)
+	}
+	if query != "market=FR" {
+		t.Errorf("unexpected query: %v", query)
+	}
+	if album.Label != "Fake Records" || album.Name != "Dawn" || album.Tracks.Total != 3 || album.Tracks.Tracks[0].ID != "sunrise" {
+		t.Errorf("GetLabeledAlbum() = %+v", album)
+	}
+
+	_, err = newAPIClient(factory, "token").GetLabeledAlbum("unknown", "")
+	if serr, ok := err.(spotify.Error); !ok || serr.Status != http.StatusNotFound || serr.Message != "non existing id" || query != "" {
+		t.Errorf("GetLabeledAlbum() error = %v, want the spotify error", err)
+	}
+}
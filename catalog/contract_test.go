@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"common/openapi"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	r := mux.NewRouter()
+	r.HandleFunc("/artists/{artistID}", artistHandler)
+	r.HandleFunc("/albums/{albumID}", albumHandler)
+	tests := []struct {
+		name   string
+		target string
+		path   string
+		client *mockSpotifyClient
+	}{
+		{name: "should document the artist", target: "/artists/the-early-birds?album_type=album,single&limit=10", path: "/artists/the-early-birds", client: earlyBirds()},
+		{name: "should document an artist without albums", target: "/artists/the-early-birds", path: "/artists/the-early-birds", client: &mockSpotifyClient{}},
+		{name: "should document the album", target: "/albums/dawn?market=FR", path: "/albums/dawn", client: dawn()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			spec.Middleware(r).ServeHTTP(rr, catalogRequest(tt.target, nil, tt.client))
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse("GET", tt.path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_releaseWatch(t *testing.T) {
+	spec := openapi.MustLoad()
+	// the single is released today so the watch reports it
+	client := releasing()
+	client.grouped[spotify.AlbumTypeSingle][0].ReleaseDate = time.Now().UTC().Format("2006-01-02")
+	wt := newTestWatcher(t, client)
+	r := mux.NewRouter()
+	r.HandleFunc("/releases/watch", watchHandler(wt)).Methods("POST")
+	r.HandleFunc("/releases/watch", getWatchHandler(wt)).Methods("GET")
+	for _, method := range []string{"POST", "GET"} {
+		req := catalogRequest("/releases/watch", nil, client)
+		req.Method = method
+		req.Body = ioutil.NopCloser(strings.NewReader(`{"deliver":"playlist"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		spec.Middleware(r).ServeHTTP(rr, req)
+		if res := rr.Code; res != http.StatusOK {
+			t.Fatalf("%s handler returned wrong status code: got %v want %v", method, res, http.StatusOK)
+		}
+		if method == "POST" {
+			// the single is reported once the first scan is done
+			waitScanned(t, wt, "early-riser")
+		} else if !strings.Contains(rr.Body.String(), `"artist_id":"the-early-birds"`) {
+			t.Errorf("%s handler response = %s, want the single reported", method, rr.Body.String())
+		}
+		if err := spec.ValidateResponse(method, "/releases/watch", rr.Code, rr.Body.Bytes()); err != nil {
+			t.Errorf("%s handler response does not match the specification: %v", method, err)
+		}
+	}
+}
+
+func Test_contract_invalidRequest(t *testing.T) {
+	spec := openapi.MustLoad()
+	accepted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, target := range []string{
+		"/artists/the-early-birds?album_type=ep",
+		"/artists/the-early-birds?album_type=album,",
+		"/artists/the-early-birds?limit=0",
+		"/albums/dawn?market=france",
+	} {
+		rr := httptest.NewRecorder()
+		spec.Middleware(accepted).ServeHTTP(rr, catalogRequest(target, nil, &mockSpotifyClient{}))
+		if res := rr.Code; res != http.StatusBadRequest {
+			t.Errorf("GET %s: handler returned wrong status code: got %v want %v", target, res, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+module catalog
+
+go 1.16
+
+require (
+	common v0.0.0
+	github.com/gorilla/mux v1.8.0
+	github.com/sirupsen/logrus v1.8.1
+	github.com/zmb3/spotify v1.1.2
+)
+
+replace common => ../common
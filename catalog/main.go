@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"common/config"
+	"common/eventbus"
+	"common/health"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"common/webhooks"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// Key type of spotify client context
+type key int
+
+// CLIENT_CONTEXT is the key used for the spotify client context
+var CLIENT_CONTEXT = key(1)
+
+// maxLimit is the largest page of albums or tracks spotify answers
+const maxLimit = 50
+
+// albumTypes are the types of albums of an artist that can be asked, by their name in the album_type parameter
+var albumTypes = map[string]spotify.AlbumType{
+	"album":       spotify.AlbumTypeAlbum,
+	"single":      spotify.AlbumTypeSingle,
+	"appears_on":  spotify.AlbumTypeAppearsOn,
+	"compilation": spotify.AlbumTypeCompilation,
+}
+
+// marketPattern matches an ISO 3166-1 alpha-2 country code or the market of the user
+var marketPattern = regexp.MustCompile(`^([A-Z]{2}|` + spotify.MarketFromToken + `)$`)
+
+// spotifyClient interface of spotify client
+type spotifyClient interface {
+	GetArtist(id spotify.ID) (*spotify.FullArtist, error)
+	GetArtistsTopTracks(artistID spotify.ID, country string) ([]spotify.FullTrack, error)
+	GetRelatedArtists(id spotify.ID) ([]spotify.FullArtist, error)
+	GetArtistAlbumsOpt(artistID spotify.ID, options *spotify.Options, ts ...spotify.AlbumType) (*spotify.SimpleAlbumPage, error)
+	GetLabeledAlbum(id spotify.ID, market string) (*labeledAlbum, error)
+	GetAlbumTracksOpt(id spotify.ID, opt *spotify.Options) (*spotify.SimpleTrackPage, error)
+	CurrentUser() (*spotify.PrivateUser, error)
+	CurrentUsersFollowedArtistsOpt(limit int, after string) (*spotify.FullArtistCursorPage, error)
+	CreatePlaylistForUser(userID, playlistName, description string, public bool) (*spotify.FullPlaylist, error)
+	AddTracksToPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) (string, error)
+}
+
+// queryInt reads a positive integer query parameter, it is 0 when missing
+func queryInt(r *http.Request, name string, max int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 || max > 0 && value > max {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return value, nil
+}
+
+// market reads the market query parameter, the market of the user is used when missing
+func market(r *http.Request) (string, error) {
+	raw := r.URL.Query().Get("market")
+	if raw == "" {
+		return spotify.MarketFromToken, nil
+	}
+	if !marketPattern.MatchString(raw) {
+		return "", fmt.Errorf("invalid market %q", raw)
+	}
+	return raw, nil
+}
+
+// albumsQuery is the page of albums of an artist asked
+type albumsQuery struct {
+	market string
+	limit  int
+	offset int
+	types  spotify.AlbumType
+}
+
+// options returns the spotify options of the page, spotify uses its defaults for the zero values
+func (q albumsQuery) options() *spotify.Options {
+	opt := &spotify.Options{Country: &q.market}
+	if q.limit > 0 {
+		opt.Limit = &q.limit
+	}
+	if q.offset > 0 {
+		opt.Offset = &q.offset
+	}
+	return opt
+}
+
+// albumsQueryFromRequest reads the market, limit, offset and album_type query parameters
+// album_type is a comma separated list of types, every type is given when none is asked
+func albumsQueryFromRequest(r *http.Request) (albumsQuery, error) {
+	var q albumsQuery
+	var err error
+	if q.market, err = market(r); err != nil {
+		return albumsQuery{}, err
+	}
+	if q.limit, err = queryInt(r, "limit", maxLimit); err != nil {
+		return albumsQuery{}, err
+	}
+	if q.offset, err = queryInt(r, "offset", 0); err != nil {
+		return albumsQuery{}, err
+	}
+	for _, name := range strings.Split(r.URL.Query().Get("album_type"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		t, ok := albumTypes[name]
+		if !ok {
+			return albumsQuery{}, fmt.Errorf("invalid album_type %q", name)
+		}
+		q.types |= t
+	}
+	return q, nil
+}
+
+// catalogStatus returns the status answered for the errors of spotify, the unknown and malformed IDs are told apart
+func catalogStatus(err error) int {
+	var serr spotify.Error
+	if errors.As(err, &serr) && (serr.Status == http.StatusNotFound || serr.Status == http.StatusBadRequest) {
+		return serr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// artistProfile gets the artist with its top tracks and related artists, and the page of its albums asked
+func artistProfile(client spotifyClient, id spotify.ID, q albumsQuery) (models.ArtistProfile, error) {
+	artist, err := client.GetArtist(id)
+	if err != nil {
+		return models.ArtistProfile{}, err
+	}
+	top, err := client.GetArtistsTopTracks(id, q.market)
+	if err != nil {
+		return models.ArtistProfile{}, err
+	}
+	related, err := client.GetRelatedArtists(id)
+	if err != nil {
+		return models.ArtistProfile{}, err
+	}
+	var types []spotify.AlbumType
+	if q.types != 0 {
+		types = append(types, q.types)
+	}
+	albums, err := client.GetArtistAlbumsOpt(id, q.options(), types...)
+	if err != nil {
+		return models.ArtistProfile{}, err
+	}
+	return models.ReduceArtistProfile(*artist, top, related, albums), nil
+}
+
+// completeTracks returns every track of the album from its first page, the other pages are asked to spotify
+func completeTracks(client spotifyClient, id spotify.ID, market string, first spotify.SimpleTrackPage) ([]spotify.SimpleTrack, error) {
+	tracks := first.Tracks
+	limit := maxLimit
+	for len(tracks) < first.Total {
+		offset := len(tracks)
+		page, err := client.GetAlbumTracksOpt(id, &spotify.Options{Limit: &limit, Offset: &offset, Country: &market})
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Tracks) == 0 {
+			break
+		}
+		tracks = append(tracks, page.Tracks...)
+	}
+	return tracks, nil
+}
+
+// albumDetails gets the album with its label and all its tracks, spotify gives the first page of them with the album
+func albumDetails(client spotifyClient, id spotify.ID, market string) (models.AlbumDetails, error) {
+	album, err := client.GetLabeledAlbum(id, market)
+	if err != nil {
+		return models.AlbumDetails{}, err
+	}
+	tracks, err := completeTracks(client, id, market, album.Tracks)
+	if err != nil {
+		return models.AlbumDetails{}, err
+	}
+	return models.ReduceAlbumDetails(album.FullAlbum, album.Label, tracks), nil
+}
+
+// artistHandler is the handler to get the profile of an artist with a page of its albums
+func artistHandler(w http.ResponseWriter, r *http.Request) {
+	artistID := mux.Vars(r)["artistID"]
+	q, err := albumsQueryFromRequest(r)
+	if err != nil {
+		log.WithError(err).Error("artistHandler: invalid artist request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	profile, err := artistProfile(client, spotify.ID(artistID), q)
+	if err != nil {
+		log.WithField("artistID", artistID).WithError(err).Error("artistHandler: could not get artist")
+		w.WriteHeader(catalogStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(profile)
+}
+
+// albumHandler is the handler to get an album with all its tracks
+func albumHandler(w http.ResponseWriter, r *http.Request) {
+	albumID := mux.Vars(r)["albumID"]
+	m, err := market(r)
+	if err != nil {
+		log.WithError(err).Error("albumHandler: invalid album request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	album, err := albumDetails(client, spotify.ID(albumID), m)
+	if err != nil {
+		log.WithField("albumID", albumID).WithError(err).Error("albumHandler: could not get album")
+		w.WriteHeader(catalogStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(album)
+}
+
+// tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := r.Header.Get("Authorization")
+		client := newAPIClient(factory, bearer)
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("catalog", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.HandleFunc("/artists/{artistID}", artistHandler).Methods("GET")
+	r.HandleFunc("/albums/{albumID}", albumHandler).Methods("GET")
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+
+	store, err := openReleaseStore(cfg.Releases.StatePath)
+	if err != nil {
+		log.WithError(err).Fatal("could not open release store")
+	}
+	bus, err := eventbus.Open(cfg.EventBus.URL)
+	if err != nil {
+		log.WithError(err).Fatal("could not open event bus")
+	}
+	defer bus.Close()
+	newClient := func(token string) spotifyClient { return newAPIClient(factory, token) }
+	wt := newWatcher(store, newClient, webhooks.NewPublisher(cfg.Webhooks.URL, cfg.Webhooks.Token), bus)
+	if _, err := eventbus.OnTokenValidated(bus, wt.refresh); err != nil {
+		log.WithError(err).Fatal("could not subscribe to validated tokens")
+	}
+	r.HandleFunc("/releases/watch", watchHandler(wt)).Methods("POST")
+	r.HandleFunc("/releases/watch", getWatchHandler(wt)).Methods("GET")
+	r.HandleFunc("/releases/watch", unwatchHandler(wt)).Methods("DELETE")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go wt.run(ctx, cfg.Releases.WatchInterval)
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.Run(contextedMux, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/models"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+type mockSpotifyClient struct {
+	err       error
+	artist    spotify.FullArtist
+	top       []spotify.FullTrack
+	related   []spotify.FullArtist
+	albums    spotify.SimpleAlbumPage
+	album     labeledAlbum
+	tracks    []spotify.SimpleTrack
+	country   string
+	albumsOpt *spotify.Options
+	types     []spotify.AlbumType
+	market    string
+	offsets   []int
+	followed  []spotify.FullArtist
+	created   []string
+	added     []spotify.ID
+	// grouped are the albums of the artists by type, albums is answered for any type when nil
+	grouped map[spotify.AlbumType][]spotify.SimpleAlbum
+	pages   []albumPage
+}
+
+// albumPage is a page of the albums of a type asked to the mock
+type albumPage struct {
+	group  spotify.AlbumType
+	offset int
+}
+
+func (c *mockSpotifyClient) GetArtist(id spotify.ID) (*spotify.FullArtist, error) {
+	return &c.artist, c.err
+}
+
+func (c *mockSpotifyClient) GetArtistsTopTracks(artistID spotify.ID, country string) ([]spotify.FullTrack, error) {
+	c.country = country
+	return c.top, c.err
+}
+
+func (c *mockSpotifyClient) GetRelatedArtists(id spotify.ID) ([]spotify.FullArtist, error) {
+	return c.related, c.err
+}
+
+func (c *mockSpotifyClient) GetArtistAlbumsOpt(artistID spotify.ID, options *spotify.Options, ts ...spotify.AlbumType) (*spotify.SimpleAlbumPage, error) {
+	c.albumsOpt, c.types = options, ts
+	if c.grouped == nil {
+		return &c.albums, c.err
+	}
+	offset := 0
+	if options.Offset != nil {
+		offset = *options.Offset
+	}
+	c.pages = append(c.pages, albumPage{group: ts[0], offset: offset})
+	albums := c.grouped[ts[0]]
+	end := offset + *options.Limit
+	if end > len(albums) {
+		end = len(albums)
+	}
+	page := &spotify.SimpleAlbumPage{Albums: albums[offset:end]}
+	page.Total = len(albums)
+	if end < len(albums) {
+		page.Next = "next"
+	}
+	return page, c.err
+}
+
+func (c *mockSpotifyClient) GetLabeledAlbum(id spotify.ID, market string) (*labeledAlbum, error) {
+	c.market = market
+	return &c.album, c.err
+}
+
+func (c *mockSpotifyClient) GetAlbumTracksOpt(id spotify.ID, opt *spotify.Options) (*spotify.SimpleTrackPage, error) {
+	offset := 0
+	if opt.Offset != nil {
+		offset = *opt.Offset
+	}
+	c.offsets = append(c.offsets, offset)
+	end := offset + *opt.Limit
+	if end > len(c.tracks) {
+		end = len(c.tracks)
+	}
+	page := &spotify.SimpleTrackPage{Tracks: c.tracks[offset:end]}
+	page.Total = len(c.tracks)
+	return page, c.err
+}
+
+func (c *mockSpotifyClient) CurrentUser() (*spotify.PrivateUser, error) {
+	return &spotify.PrivateUser{User: spotify.User{ID: "early-riser"}}, c.err
+}
+
+func (c *mockSpotifyClient) CurrentUsersFollowedArtistsOpt(limit int, after string) (*spotify.FullArtistCursorPage, error) {
+	return &spotify.FullArtistCursorPage{Artists: c.followed}, c.err
+}
+
+func (c *mockSpotifyClient) CreatePlaylistForUser(userID, playlistName, description string, public bool) (*spotify.FullPlaylist, error) {
+	c.created = append(c.created, playlistName)
+	return &spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{ID: "release-radar", Name: playlistName}}, c.err
+}
+
+func (c *mockSpotifyClient) AddTracksToPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) (string, error) {
+	c.added = append(c.added, trackIDs...)
+	return "snapshot", c.err
+}
+
+// earlyBirds is the artist of the mock, with a top track, a related artist and an album
+func earlyBirds() *mockSpotifyClient {
+	client := &mockSpotifyClient{
+		artist: spotify.FullArtist{
+			SimpleArtist: spotify.SimpleArtist{Name: "The Early Birds", ID: "the-early-birds", URI: "spotify:artist:the-early-birds"},
+			Genres:       []string{"indie", "folk"},
+			Popularity:   42,
+			Followers:    spotify.Followers{Count: 1200},
+		},
+		top: []spotify.FullTrack{{
+			SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise", URI: "spotify:track:sunrise", Artists: []spotify.SimpleArtist{{Name: "The Early Birds"}}},
+			Album:       spotify.SimpleAlbum{Name: "Dawn"},
+		}},
+		related: []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "Traffic", ID: "traffic"}, Genres: []string{"indie"}}},
+		albums:  spotify.SimpleAlbumPage{Albums: []spotify.SimpleAlbum{{Name: "Dawn", ID: "dawn", AlbumType: "album", AlbumGroup: "album", ReleaseDate: "2020-12-15"}}},
+	}
+	client.albums.Total = 1
+	return client
+}
+
+// dawn is the album of the mock, spotify gives the first two of its three tracks with it
+func dawn() *mockSpotifyClient {
+	tracks := []spotify.SimpleTrack{
+		{Name: "Sunrise", ID: "sunrise", DiscNumber: 1, TrackNumber: 1},
+		{Name: "Coffee", ID: "coffee", DiscNumber: 1, TrackNumber: 2},
+		{Name: "Breakfast", ID: "breakfast", DiscNumber: 1, TrackNumber: 3},
+	}
+	album := labeledAlbum{Label: "Fake Records"}
+	album.SimpleAlbum = spotify.SimpleAlbum{
+		Name:                 "Dawn",
+		ID:                   "dawn",
+		URI:                  "spotify:album:dawn",
+		AlbumType:            "album",
+		Artists:              []spotify.SimpleArtist{{Name: "The Early Birds"}},
+		ReleaseDate:          "2020-12",
+		ReleaseDatePrecision: "month",
+	}
+	album.Tracks.Tracks = tracks[:2]
+	album.Tracks.Total = len(tracks)
+	return &mockSpotifyClient{album: album, tracks: tracks}
+}
+
+// catalogRequest creates a GET request sent with the spotify client and the vars of its route
+func catalogRequest(target string, vars map[string]string, client spotifyClient) *http.Request {
+	r := httptest.NewRequest("GET", target, nil)
+	r = mux.SetURLVars(r, vars)
+	return r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func Test_artistHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		target          string
+		err             error
+		expectedCode    int
+		expectedCountry string
+		expectedOpt     *spotify.Options
+		expectedTypes   []spotify.AlbumType
+	}{
+		{
+			name:            "should get the profile in the market of the user",
+			target:          "/artists/the-early-birds",
+			expectedCode:    http.StatusOK,
+			expectedCountry: "from_token",
+			expectedOpt:     &spotify.Options{Country: strPtr("from_token")},
+		},
+		{
+			name:            "should get a page of the albums of the types asked",
+			target:          "/artists/the-early-birds?album_type=single,appears_on&limit=10&offset=20&market=FR",
+			expectedCode:    http.StatusOK,
+			expectedCountry: "FR",
+			expectedOpt:     &spotify.Options{Country: strPtr("FR"), Limit: intPtr(10), Offset: intPtr(20)},
+			expectedTypes:   []spotify.AlbumType{spotify.AlbumTypeSingle | spotify.AlbumTypeAppearsOn},
+		},
+		{name: "should reject an unknown album type", target: "/artists/the-early-birds?album_type=ep", expectedCode: http.StatusBadRequest},
+		{name: "should reject a too large page", target: "/artists/the-early-birds?limit=51", expectedCode: http.StatusBadRequest},
+		{name: "should reject an invalid market", target: "/artists/the-early-birds?market=france", expectedCode: http.StatusBadRequest},
+		{name: "should not find an unknown artist", target: "/artists/unknown", err: spotify.Error{Status: http.StatusNotFound, Message: "non existing id"}, expectedCode: http.StatusNotFound},
+		{name: "should reject an id spotify does not accept", target: "/artists/sun%20rise", err: spotify.Error{Status: http.StatusBadRequest, Message: "invalid id"}, expectedCode: http.StatusBadRequest},
+		{name: "should fail when spotify fails", target: "/artists/the-early-birds", err: errors.New("boom"), expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := earlyBirds()
+			client.err = tt.err
+			rr := httptest.NewRecorder()
+			artistHandler(rr, catalogRequest(tt.target, map[string]string{"artistID": "the-early-birds"}, client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			if client.country != tt.expectedCountry || !reflect.DeepEqual(client.albumsOpt, tt.expectedOpt) || !reflect.DeepEqual(client.types, tt.expectedTypes) {
+				t.Errorf("handler sent wrong options: got %q %+v %v", client.country, client.albumsOpt, client.types)
+			}
+			var got models.ArtistProfile
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("could not decode body: %v", err)
+			}
+			if got.Name != "The Early Birds" || got.Followers != 1200 || got.TopTracks[0].ID != "sunrise" || got.RelatedArtists[0].ID != "traffic" || got.Albums.Items[0].Group != "album" {
+				t.Errorf("handler returned unexpected body: %+v", got)
+			}
+		})
+	}
+}
+
+func Test_albumHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		err          error
+		expectedCode int
+	}{
+		{name: "should get the album with all its tracks", target: "/albums/dawn?market=FR", expectedCode: http.StatusOK},
+		{name: "should reject an invalid market", target: "/albums/dawn?market=fr", expectedCode: http.StatusBadRequest},
+		{name: "should not find an unknown album", target: "/albums/unknown", err: spotify.Error{Status: http.StatusNotFound, Message: "non existing id"}, expectedCode: http.StatusNotFound},
+		{name: "should fail when spotify fails", target: "/albums/dawn", err: errors.New("boom"), expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dawn()
+			client.err = tt.err
+			rr := httptest.NewRecorder()
+			albumHandler(rr, catalogRequest(tt.target, map[string]string{"albumID": "dawn"}, client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			// the first page came with the album, only the last track is left
+			if client.market != "FR" || !reflect.DeepEqual(client.offsets, []int{2}) {
+				t.Errorf("handler asked spotify %q %v, want the FR market and the tracks from the third one", client.market, client.offsets)
+			}
+			var got models.AlbumDetails
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("could not decode body: %v", err)
+			}
+			if got.Label != "Fake Records" || !got.ReleaseDate.Equal(time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)) || len(got.Tracks) != 3 || got.Tracks[2].TrackNumber != 3 || got.Tracks[2].AlbumName != "Dawn" {
+				t.Errorf("handler returned unexpected body: %+v", got)
+			}
+		})
+	}
+}
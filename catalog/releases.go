@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"common/eventbus"
+	"common/identity"
+	"common/jsonfile"
+	"common/models"
+	"common/webhooks"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// releaseWindow is how old a release can be on the first scan, and how long the reported releases are kept
+	releaseWindow = 7 * 24 * time.Hour
+	// playlistBatchSize is the number of tracks spotify adds to a playlist in a single call
+	playlistBatchSize = 100
+	// releaseRadarName is the name of the playlist created for the releases when the user gives none
+	releaseRadarName = "Release Radar"
+	// deliverPlaylist appends the tracks of the releases to a playlist of the user
+	deliverPlaylist = "playlist"
+	// deliverWebhook publishes the releases to the webhooks service, which posts them to the webhooks of the user
+	deliverWebhook = "webhook"
+)
+
+var (
+	// errNotWatching is returned when the user does not watch the new releases
+	errNotWatching = errors.New("the new releases are not watched")
+	// errWebhooksDisabled is returned when delivering by webhook without the webhooks service
+	errWebhooksDisabled = errors.New("the webhooks service is not configured")
+)
+
+// releaseState is the watch of a user kept on disk, Reported are the releases already delivered, the most recent first
+// Token is the last access token of the watching user, empty once rejected by spotify until the gateway validates a new one
+type releaseState struct {
+	Deliver    string           `json:"deliver"`
+	PlaylistID spotify.ID       `json:"playlist_id"`
+	LastScan   time.Time        `json:"last_scan"`
+	Reported   []models.Release `json:"reported"`
+	Watching   bool             `json:"watching"`
+	Token      string           `json:"token,omitempty"`
+	// Added are the tracks already appended to the playlist for releases not reported yet, a failed delivery does not append them again
+	Added []spotify.ID `json:"added,omitempty"`
+}
+
+// releaseStore keeps the watch of each user in a json file
+// The file is rewritten on every change, the reported releases are pruned once older than the window
+type releaseStore struct {
+	path string
+
+	mu    sync.Mutex
+	users map[string]releaseState
+}
+
+// openReleaseStore loads the watches kept in the file at path, there is none when the file does not exist
+func openReleaseStore(path string) (*releaseStore, error) {
+	s := &releaseStore{path: path, users: map[string]releaseState{}}
+	if err := jsonfile.Read(path, &s.users); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// get returns the watch of the user
+func (s *releaseStore) get(userID string) (releaseState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.users[userID]
+	return state, ok
+}
+
+// put replaces the watch of the user
+func (s *releaseStore) put(userID string, state releaseState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make(map[string]releaseState, len(s.users)+1)
+	for id, st := range s.users {
+		users[id] = st
+	}
+	users[userID] = state
+	if err := jsonfile.Write(s.path, users); err != nil {
+		return err
+	}
+	s.users = users
+	return nil
+}
+
+// update changes the watch of the user in place, a watch is created when there is none
+// The fields changed elsewhere in the meantime are kept, unlike put
+func (s *releaseStore) update(userID string, change func(*releaseState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make(map[string]releaseState, len(s.users)+1)
+	for id, st := range s.users {
+		users[id] = st
+	}
+	state := users[userID]
+	change(&state)
+	users[userID] = state
+	if err := jsonfile.Write(s.path, users); err != nil {
+		return err
+	}
+	s.users = users
+	return nil
+}
+
+// tokens returns the watching users with a valid access token along with it
+func (s *releaseStore) tokens() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := map[string]string{}
+	for userID, state := range s.users {
+		if state.Watching && state.Token != "" {
+			tokens[userID] = state.Token
+		}
+	}
+	return tokens
+}
+
+// watcher scans the albums of the artists followed by the watching users and delivers their new releases
+// The access tokens are kept in the watches on disk so the scans resume after a restart
+// The access tokens of spotify expire after an hour, the gateway publishes the new ones of the users on the bus
+type watcher struct {
+	store     *releaseStore
+	newClient func(token string) spotifyClient
+	publisher *webhooks.Publisher
+	bus       eventbus.Bus
+
+	// scanning serializes the scans so a release found by two of them is not delivered twice
+	scanning sync.Mutex
+}
+
+// newWatcher creates a watcher without watching users
+// The releases found and the tracks added to the playlists are published to the webhooks service with the publisher, the tracks added on the bus too
+func newWatcher(store *releaseStore, newClient func(token string) spotifyClient, publisher *webhooks.Publisher, bus eventbus.Bus) *watcher {
+	return &watcher{store: store, newClient: newClient, publisher: publisher, bus: bus}
+}
+
+// register scans the releases of the user with the access token, a registered user gets its token updated
+func (w *watcher) register(userID, token string) error {
+	return w.store.update(userID, func(state *releaseState) {
+		state.Watching, state.Token = true, token
+	})
+}
+
+// unregister stops scanning the releases of the user, the watch is kept with its reported releases
+func (w *watcher) unregister(userID string) error {
+	if _, ok := w.store.get(userID); !ok {
+		return nil
+	}
+	return w.store.update(userID, func(state *releaseState) {
+		state.Watching, state.Token = false, ""
+	})
+}
+
+// refresh gives its new access token to the watching user, the tokens of the other users are ignored
+func (w *watcher) refresh(validated eventbus.TokenValidated) {
+	if state, ok := w.store.get(validated.UserID); !ok || !state.Watching || state.Token == validated.Token {
+		return
+	}
+	err := w.store.update(validated.UserID, func(state *releaseState) {
+		if state.Watching {
+			state.Token = validated.Token
+		}
+	})
+	if err != nil {
+		log.WithError(err).WithField("user", validated.UserID).Error("watcher: could not save the new access token")
+	}
+}
+
+// expire drops the access token rejected by spotify, the user keeps watching until the gateway validates a new one
+// A token refreshed in the meantime is kept
+func (w *watcher) expire(userID, token string) error {
+	return w.store.update(userID, func(state *releaseState) {
+		if state.Token == token {
+			state.Token = ""
+		}
+	})
+}
+
+// watching tells if the releases of the user are scanned
+func (w *watcher) watching(userID string) bool {
+	state, _ := w.store.get(userID)
+	return state.Watching
+}
+
+// users returns the watching users with a valid access token along with it
+func (w *watcher) users() map[string]string {
+	return w.store.tokens()
+}
+
+// followedArtists returns every artist followed by the user, spotify gives them by pages of 50 with a cursor
+func followedArtists(client spotifyClient) ([]spotify.FullArtist, error) {
+	var artists []spotify.FullArtist
+	after := ""
+	for {
+		page, err := client.CurrentUsersFollowedArtistsOpt(maxLimit, after)
+		if err != nil {
+			return nil, err
+		}
+		artists = append(artists, page.Artists...)
+		if page.Next == "" || page.Cursor.After == "" || len(page.Artists) == 0 {
+			return artists, nil
+		}
+		after = page.Cursor.After
+	}
+}
+
+// releaseGroups are the types of the albums scanned for new releases
+// Spotify answers the albums grouped by type, all the albums before the singles, so each type is read on its own
+var releaseGroups = []spotify.AlbumType{spotify.AlbumTypeAlbum, spotify.AlbumTypeSingle}
+
+// newReleases returns the albums and singles of the followed artists released since the day given and not reported yet
+func newReleases(client spotifyClient, since time.Time, reported map[spotify.ID]bool, now time.Time) ([]models.Release, error) {
+	artists, err := followedArtists(client)
+	if err != nil {
+		return nil, err
+	}
+	found := []models.Release{}
+	for _, artist := range artists {
+		for _, group := range releaseGroups {
+			albums, err := releasedSince(client, artist.ID, group, since)
+			if err != nil {
+				return nil, err
+			}
+			for _, album := range albums {
+				if reported[album.ID] {
+					continue
+				}
+				// an album of several followed artists is reported once
+				reported[album.ID] = true
+				found = append(found, models.ReduceRelease(artist.ID, album, now))
+			}
+		}
+	}
+	return found, nil
+}
+
+// releasedSince returns the albums of the type of the artist released since the day given
+// Spotify gives the albums of a type the most recent first, they are paged until one is released before the day
+func releasedSince(client spotifyClient, artistID spotify.ID, group spotify.AlbumType, since time.Time) ([]spotify.SimpleAlbum, error) {
+	limit, market := maxLimit, spotify.MarketFromToken
+	var albums []spotify.SimpleAlbum
+	for offset := 0; ; offset += limit {
+		page, err := client.GetArtistAlbumsOpt(artistID, &spotify.Options{Limit: &limit, Offset: &offset, Country: &market}, group)
+		if err != nil {
+			return nil, err
+		}
+		for _, album := range page.Albums {
+			if album.ReleaseDateTime().Before(since) {
+				return albums, nil
+			}
+			albums = append(albums, album)
+		}
+		if page.Next == "" || len(page.Albums) == 0 {
+			return albums, nil
+		}
+	}
+}
+
+// releaseTracks returns the IDs of every track of the releases, in their order
+func releaseTracks(client spotifyClient, releases []models.Release) ([]spotify.ID, error) {
+	limit, market := maxLimit, spotify.MarketFromToken
+	var ids []spotify.ID
+	for _, release := range releases {
+		first, err := client.GetAlbumTracksOpt(release.ID, &spotify.Options{Limit: &limit, Country: &market})
+		if err != nil {
+			return nil, err
+		}
+		tracks, err := completeTracks(client, release.ID, market, *first)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tracks {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids, nil
+}
+
+// deliverToPlaylist appends the tracks of the releases to the playlist of the watch
+// The Release Radar playlist is created and kept in the watch when the user gave none
+// Each batch appended is kept in the watch, so a delivery tried again after a failure only appends the remaining tracks
+func (w *watcher) deliverToPlaylist(client spotifyClient, userID string, state *releaseState, releases []models.Release) error {
+	ids, err := releaseTracks(client, releases)
+	if err != nil {
+		return err
+	}
+	if state.PlaylistID == "" {
+		playlist, err := client.CreatePlaylistForUser(userID, releaseRadarName, "New releases of the artists you follow", false)
+		if err != nil {
+			return err
+		}
+		state.PlaylistID = playlist.ID
+		err = w.store.update(userID, func(kept *releaseState) {
+			kept.PlaylistID = playlist.ID
+		})
+		if err != nil {
+			return err
+		}
+	}
+	added := map[spotify.ID]bool{}
+	for _, id := range state.Added {
+		added[id] = true
+	}
+	var pending []spotify.ID
+	for _, id := range ids {
+		if !added[id] {
+			pending = append(pending, id)
+		}
+	}
+	var snapshot string
+	for start := 0; start < len(pending); start += playlistBatchSize {
+		end := start + playlistBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+		if snapshot, err = client.AddTracksToPlaylist(state.PlaylistID, batch...); err != nil {
+			return err
+		}
+		state.Added = append(state.Added, batch...)
+		err = w.store.update(userID, func(kept *releaseState) {
+			kept.Added = append(kept.Added, batch...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		update := models.PlaylistUpdate{PlaylistID: state.PlaylistID, SnapshotID: snapshot, Change: models.PlaylistTracksAdded, TrackIDs: ids}
+		w.publisher.Go(models.PlaylistUpdatedEvent, userID, update)
+		eventbus.Go(w.bus, eventbus.PlaylistMutated{UserID: userID, PlaylistUpdate: update, OccurredAt: time.Now().UTC()})
+	}
+	return nil
+}
+
+// deliverToWebhooks publishes the releases as a new_release event to the webhooks service
+// which signs and posts it to the webhooks of the user, the releases are delivered once it queued them
+func (w *watcher) deliverToWebhooks(userID string, releases []models.Release) error {
+	if w.publisher == nil {
+		return errWebhooksDisabled
+	}
+	return w.publisher.Publish(models.NewReleaseEvent, userID, models.NewReleases{Releases: releases})
+}
+
+// scanUser delivers the releases of the followed artists made since the last scan and returns them
+// The releases are only kept as reported once delivered, a failed delivery is tried again on the next scan
+func (w *watcher) scanUser(userID, token string, now time.Time) ([]models.Release, error) {
+	w.scanning.Lock()
+	defer w.scanning.Unlock()
+	state, ok := w.store.get(userID)
+	if !ok {
+		return nil, errNotWatching
+	}
+	// the release dates have no time, the releases of the day of the last scan are scanned again
+	since := state.LastScan
+	if since.IsZero() {
+		since = now.Add(-releaseWindow)
+	}
+	since = since.UTC().Truncate(24 * time.Hour)
+	reported := map[spotify.ID]bool{}
+	for _, r := range state.Reported {
+		reported[r.ID] = true
+	}
+
+	client := w.newClient(token)
+	found, err := newReleases(client, since, reported, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) > 0 {
+		if state.Deliver == deliverWebhook {
+			err = w.deliverToWebhooks(userID, found)
+		} else if err = w.deliverToPlaylist(client, userID, &state, found); err == nil {
+			w.publisher.Go(models.NewReleaseEvent, userID, models.NewReleases{Releases: found})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the releases older than the window are before the day of any later scan, they cannot be found again
+	kept := append([]models.Release{}, found...)
+	for _, r := range state.Reported {
+		if !r.ReportedAt.Before(now.Add(-releaseWindow)) {
+			kept = append(kept, r)
+		}
+	}
+	return found, w.store.update(userID, func(state *releaseState) {
+		state.LastScan, state.Reported, state.Added = now, kept, nil
+	})
+}
+
+// scan delivers the new releases of every watching user
+func (w *watcher) scan() {
+	for userID, token := range w.users() {
+		w.scanLogged(userID, token)
+	}
+}
+
+// scanLogged delivers the new releases of the user and logs the failures
+// A user whose access token is rejected is skipped until the gateway validates a new one
+func (w *watcher) scanLogged(userID, token string) {
+	// the user may have stopped watching since the scan was started
+	if !w.watching(userID) {
+		return
+	}
+	found, err := w.scanUser(userID, token, time.Now())
+	var serr spotify.Error
+	if errors.As(err, &serr) && serr.Status == http.StatusUnauthorized {
+		log.WithField("user", userID).Warn("watcher: access token rejected, waiting for a new one")
+		if err := w.expire(userID, token); err != nil {
+			log.WithError(err).WithField("user", userID).Error("watcher: could not drop the access token")
+		}
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("user", userID).Error("watcher: could not scan new releases")
+		return
+	}
+	log.WithField("user", userID).WithField("found", len(found)).Debug("watcher: new releases scanned")
+}
+
+// run scans the new releases every interval until the context is done
+func (w *watcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.scan()
+		}
+	}
+}
+
+// watch returns the watch of the user as answered by the API
+func (w *watcher) watch(userID string) (models.ReleaseWatch, error) {
+	state, ok := w.store.get(userID)
+	if !ok {
+		return models.ReleaseWatch{}, errNotWatching
+	}
+	releases := state.Reported
+	if releases == nil {
+		releases = []models.Release{}
+	}
+	return models.ReleaseWatch{
+		Deliver:    state.Deliver,
+		PlaylistID: state.PlaylistID,
+		Watching:   w.watching(userID),
+		LastScan:   state.LastScan,
+		Releases:   releases,
+	}, nil
+}
+
+// watchRequest is the body of POST /releases/watch
+// The releases are appended to the playlist, the Release Radar one being created when none is given, or published to the webhooks of the user
+type watchRequest struct {
+	Deliver    string     `json:"deliver"`
+	PlaylistID spotify.ID `json:"playlist_id"`
+}
+
+// validate checks the delivery of the releases and sets the default one, webhooks tells if the webhooks service is configured
+func (req *watchRequest) validate(webhooks bool) error {
+	switch req.Deliver {
+	case "":
+		req.Deliver = deliverPlaylist
+	case deliverPlaylist:
+	case deliverWebhook:
+		if !webhooks {
+			return errWebhooksDisabled
+		}
+	default:
+		return fmt.Errorf("invalid deliver %q", req.Deliver)
+	}
+	return nil
+}
+
+// watchHandler is the handler starting the watch of the new releases of the user with its access token
+// The first scan starts in the background, then the releases are scanned every interval of the watcher; the releases already reported stay reported
+func watchHandler(wt *watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req watchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Error("watchHandler: could not decode watch request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(wt.publisher != nil); err != nil {
+			log.WithError(err).Error("watchHandler: invalid watch request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("watchHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = wt.store.update(id, func(state *releaseState) {
+			if state.PlaylistID != req.PlaylistID {
+				state.Added = nil
+			}
+			state.Deliver, state.PlaylistID = req.Deliver, req.PlaylistID
+		})
+		if err != nil {
+			log.WithError(err).Error("watchHandler: could not save watch")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		token := r.Header.Get("Authorization")
+		if err := wt.register(id, token); err != nil {
+			log.WithError(err).Error("watchHandler: could not save watch")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		go wt.scanLogged(id, token)
+		watch, err := wt.watch(id)
+		if err != nil {
+			log.WithError(err).Error("watchHandler: could not get watch")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(watch)
+	}
+}
+
+// getWatchHandler is the handler to get the watch of the user with the releases reported during the last week
+func getWatchHandler(wt *watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("getWatchHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		watch, err := wt.watch(id)
+		if errors.Is(err, errNotWatching) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.WithError(err).Error("getWatchHandler: could not get watch")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(watch)
+	}
+}
+
+// unwatchHandler is the handler to stop scanning the new releases of the user, the releases reported are kept
+func unwatchHandler(wt *watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("unwatchHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := wt.unregister(id); err != nil {
+			log.WithError(err).Error("unwatchHandler: could not save watch")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
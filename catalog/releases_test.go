@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"common/eventbus"
+	"common/models"
+	"common/webhooks"
+	"github.com/zmb3/spotify"
+)
+
+// releasing is the mock of a followed artist with an album of last year and a single of yesterday
+func releasing() *mockSpotifyClient {
+	client := dawn()
+	client.followed = []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "The Early Birds", ID: "the-early-birds"}}}
+	client.grouped = map[spotify.AlbumType][]spotify.SimpleAlbum{
+		spotify.AlbumTypeAlbum: {
+			{Name: "Dawn", ID: "dawn", AlbumType: "album", AlbumGroup: "album", ReleaseDate: "2020-12", ReleaseDatePrecision: "month"},
+		},
+		spotify.AlbumTypeSingle: {
+			{Name: "Morning", ID: "morning", AlbumType: "single", AlbumGroup: "single", ReleaseDate: "2021-03-09", ReleaseDatePrecision: "day"},
+		},
+	}
+	return client
+}
+
+// scanTime is the time of the scans of the tests, the day after the single
+var scanTime = time.Date(2021, 3, 10, 8, 0, 0, 0, time.UTC)
+
+func newTestWatcher(t *testing.T, client spotifyClient) *watcher {
+	store, err := openReleaseStore(filepath.Join(t.TempDir(), "releases.json"))
+	if err != nil {
+		t.Fatalf("openReleaseStore() error = %v", err)
+	}
+	return newWatcher(store, func(string) spotifyClient { return client }, nil, nil)
+}
+
+func Test_watcher_scanUser_playlist(t *testing.T) {
+	client := releasing()
+	w := newTestWatcher(t, client)
+	if err := w.store.put("early-riser", releaseState{Deliver: deliverPlaylist}); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+
+	found, err := w.scanUser("early-riser", "token", scanTime)
+	if err != nil {
+		t.Fatalf("scanUser() error = %v", err)
+	}
+	if len(found) != 1 || found[0].ID != "morning" || found[0].ArtistID != "the-early-birds" {
+		t.Errorf("scanUser() = %+v, want the single of yesterday", found)
+	}
+	if !reflect.DeepEqual(client.created, []string{releaseRadarName}) {
+		t.Errorf("playlists created = %v, want %v", client.created, []string{releaseRadarName})
+	}
+	want := []spotify.ID{"sunrise", "coffee", "breakfast"}
+	if !reflect.DeepEqual(client.added, want) {
+		t.Errorf("tracks added = %v, want %v", client.added, want)
+	}
+
+	// the single is still released since the day of the last scan, it is not reported twice
+	found, err = w.scanUser("early-riser", "token", scanTime.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("scanUser() error = %v", err)
+	}
+	if len(found) != 0 || len(client.created) != 1 || len(client.added) != len(want) {
+		t.Errorf("second scanUser() = %+v, created %v, added %v, want nothing new", found, client.created, client.added)
+	}
+	state, _ := w.store.get("early-riser")
+	if state.PlaylistID != "release-radar" || !state.LastScan.Equal(scanTime.Add(time.Hour)) || len(state.Reported) != 1 {
+		t.Errorf("state = %+v, want the release radar, the last scan and the single reported", state)
+	}
+
+	// the single is forgotten a week after being reported, it is before the day of the last scan
+	if _, err := w.scanUser("early-riser", "token", scanTime.Add(8*24*time.Hour)); err != nil {
+		t.Fatalf("scanUser() error = %v", err)
+	}
+	if state, _ := w.store.get("early-riser"); len(state.Reported) != 0 {
+		t.Errorf("reported = %+v, want none after a week", state.Reported)
+	}
+}
+
+func Test_watcher_scanUser_partialDelivery(t *testing.T) {
+	client := releasing()
+	w := newTestWatcher(t, client)
+	// the first track of the single was appended by a delivery which failed afterwards
+	if err := w.store.put("early-riser", releaseState{Deliver: deliverPlaylist, PlaylistID: "release-radar", Added: []spotify.ID{"sunrise"}}); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+
+	if _, err := w.scanUser("early-riser", "token", scanTime); err != nil {
+		t.Fatalf("scanUser() error = %v", err)
+	}
+	if want := []spotify.ID{"coffee", "breakfast"}; !reflect.DeepEqual(client.added, want) {
+		t.Errorf("tracks added = %v, want %v", client.added, want)
+	}
+	if state, _ := w.store.get("early-riser"); len(state.Added) != 0 || len(state.Reported) != 1 {
+		t.Errorf("state = %+v, want the single reported and no track pending", state)
+	}
+}
+
+func Test_watcher_scanUser_publishes(t *testing.T) {
+	published := make(chan models.Event, 10)
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event models.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		published <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hooks.Close()
+	w := newTestWatcher(t, releasing())
+	w.publisher = webhooks.NewPublisher(hooks.URL, "services")
+	w.bus = eventbus.NewMemory()
+	defer w.bus.Close()
+	mutations := make(chan eventbus.PlaylistMutated, 10)
+	eventbus.OnPlaylistMutated(w.bus, func(mutation eventbus.PlaylistMutated) { mutations <- mutation })
+	if err := w.store.put("early-riser", releaseState{Deliver: deliverPlaylist}); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+
+	if _, err := w.scanUser("early-riser", "token", scanTime); err != nil {
+		t.Fatalf("scanUser() error = %v", err)
+	}
+	// the events are published in the background, in any order
+	got := map[string]models.Event{}
+	for len(got) < 2 {
+		select {
+		case event := <-published:
+			got[event.Event] = event
+		case <-time.After(time.Second):
+			t.Fatalf("published %v, want the releases and the playlist update", got)
+		}
+	}
+	var releases models.NewReleases
+	json.Unmarshal(got[models.NewReleaseEvent].Data, &releases)
+	if len(releases.Releases) != 1 || releases.Releases[0].ID != "morning" || got[models.NewReleaseEvent].UserID != "early-riser" {
+		t.Errorf("published %+v, want the single of yesterday", got[models.NewReleaseEvent])
+	}
+	var update models.PlaylistUpdate
+	json.Unmarshal(got[models.PlaylistUpdatedEvent].Data, &update)
+	if update.PlaylistID != "release-radar" || update.Change != models.PlaylistTracksAdded || len(update.TrackIDs) != 3 {
+		t.Errorf("published %+v, want the tracks added to the release radar", update)
+	}
+	select {
+	case mutation := <-mutations:
+		if mutation.UserID != "early-riser" || mutation.PlaylistID != "release-radar" || mutation.Change != models.PlaylistTracksAdded {
+			t.Errorf("bus got %+v, want the tracks added to the release radar", mutation)
+		}
+	case <-time.After(time.Second):
+		t.Error("the tracks added were not published on the bus")
+	}
+}
+
+func Test_newReleases(t *testing.T) {
+	// the artist has more albums than a page, the new single comes after all of them
+	client := releasing()
+	var albums []spotify.SimpleAlbum
+	for i := 0; i < maxLimit+10; i++ {
+		date := "2021-03-08"
+		if i >= maxLimit+2 {
+			date = "2019-01-01"
+		}
+		albums = append(albums, spotify.SimpleAlbum{Name: "Album", ID: spotify.ID(fmt.Sprintf("album-%d", i)), AlbumType: "album", AlbumGroup: "album", ReleaseDate: date, ReleaseDatePrecision: "day"})
+	}
+	client.grouped[spotify.AlbumTypeAlbum] = albums
+
+	found, err := newReleases(client, scanTime.Add(-releaseWindow), map[spotify.ID]bool{"album-0": true}, scanTime)
+	if err != nil {
+		t.Fatalf("newReleases() error = %v", err)
+	}
+	if len(found) != maxLimit+2 || found[len(found)-1].ID != "morning" {
+		t.Errorf("newReleases() found %d releases ending with %+v, want the new albums but the reported one and the single", len(found), found[len(found)-1])
+	}
+	want := []albumPage{{group: spotify.AlbumTypeAlbum, offset: 0}, {group: spotify.AlbumTypeAlbum, offset: maxLimit}, {group: spotify.AlbumTypeSingle, offset: 0}}
+	if !reflect.DeepEqual(client.pages, want) {
+		t.Errorf("pages asked = %+v, want %+v", client.pages, want)
+	}
+}
+
+func Test_watcher_scanUser_webhook(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantErr      bool
+		wantReported int
+	}{
+		{name: "should publish the releases to the webhooks service", status: http.StatusAccepted, wantReported: 1},
+		{name: "should keep the releases to report when the webhooks service fails", status: http.StatusBadGateway, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.Event
+			service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer service.Close()
+			client := releasing()
+			w := newTestWatcher(t, client)
+			w.publisher = webhooks.NewPublisher(service.URL, "services")
+			w.store.put("early-riser", releaseState{Deliver: deliverWebhook})
+
+			_, err := w.scanUser("early-riser", "token", scanTime)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scanUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var data models.NewReleases
+			json.Unmarshal(got.Data, &data)
+			if got.Event != models.NewReleaseEvent || got.UserID != "early-riser" || len(data.Releases) != 1 || data.Releases[0].ID != "morning" {
+				t.Errorf("event = %+v with %+v, want the single of early-riser", got, data)
+			}
+			if len(client.added) != 0 {
+				t.Errorf("tracks added = %v, want none", client.added)
+			}
+			state, _ := w.store.get("early-riser")
+			if len(state.Reported) != tt.wantReported || state.LastScan.IsZero() == (tt.wantReported > 0) {
+				t.Errorf("state = %+v, want %d reported", state, tt.wantReported)
+			}
+		})
+	}
+}
+
+func Test_releaseStore_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "releases.json")
+	store, err := openReleaseStore(path)
+	if err != nil {
+		t.Fatalf("openReleaseStore() error = %v", err)
+	}
+	want := releaseState{Deliver: deliverPlaylist, PlaylistID: "release-radar", LastScan: scanTime, Reported: []models.Release{{ArtistID: "the-early-birds", ReportedAt: scanTime}}, Watching: true, Token: "token"}
+	if err := store.put("early-riser", want); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+
+	reopened, err := openReleaseStore(path)
+	if err != nil {
+		t.Fatalf("openReleaseStore() error = %v", err)
+	}
+	got, ok := reopened.get("early-riser")
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("get() = %+v, %v, want %+v", got, ok, want)
+	}
+}
+
+func Test_watcher_scan(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		expectedToken string
+	}{
+		{name: "should keep scanning the user", expectedToken: "token"},
+		{name: "should keep the user when spotify fails", err: fmt.Errorf("boom"), expectedToken: "token"},
+		{name: "should keep the user waiting for a new token when its token is rejected", err: spotify.Error{Status: http.StatusUnauthorized, Message: "The access token expired"}, expectedToken: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := releasing()
+			client.err = tt.err
+			w := newTestWatcher(t, client)
+			w.store.put("early-riser", releaseState{Deliver: deliverPlaylist})
+			w.register("early-riser", "token")
+
+			w.scan()
+			state, _ := w.store.get("early-riser")
+			if !state.Watching || state.Token != tt.expectedToken {
+				t.Errorf("state after scan() = %+v, want watching with the token %q", state, tt.expectedToken)
+			}
+		})
+	}
+}
+
+func Test_watcher_refresh(t *testing.T) {
+	w := newTestWatcher(t, releasing())
+	w.store.put("early-riser", releaseState{Deliver: deliverPlaylist})
+	w.store.put("unwatched", releaseState{Deliver: deliverPlaylist})
+	w.register("early-riser", "old")
+	w.expire("early-riser", "old")
+	if len(w.users()) != 0 {
+		t.Fatalf("users() after expire() = %v, want none", w.users())
+	}
+
+	w.refresh(eventbus.TokenValidated{UserID: "early-riser", Token: "new"})
+	w.refresh(eventbus.TokenValidated{UserID: "unwatched", Token: "token"})
+	w.refresh(eventbus.TokenValidated{UserID: "unknown", Token: "token"})
+	if got, want := w.users(), map[string]string{"early-riser": "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("users() after refresh() = %v, want %v", got, want)
+	}
+	if _, ok := w.store.get("unknown"); ok {
+		t.Error("refresh() created the watch of an unknown user")
+	}
+
+	// a token refreshed while the old one was being rejected is kept
+	w.expire("early-riser", "old")
+	if token := w.users()["early-riser"]; token != "new" {
+		t.Errorf("expire() of an old token dropped the new one, token = %q", token)
+	}
+
+	w.unregister("early-riser")
+	w.refresh(eventbus.TokenValidated{UserID: "early-riser", Token: "newer"})
+	if state, _ := w.store.get("early-riser"); state.Watching || state.Token != "" || state.Deliver != deliverPlaylist {
+		t.Errorf("state after unregister() = %+v, want the watch kept without scanning", state)
+	}
+}
+
+// waitScanned waits for the first scan of the user started in the background
+func waitScanned(t *testing.T, wt *watcher, userID string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for state, _ := wt.store.get(userID); state.LastScan.IsZero(); state, _ = wt.store.get(userID) {
+		if time.Now().After(deadline) {
+			t.Fatal("the releases were not scanned")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func Test_watchHandler(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer service.Close()
+	tests := []struct {
+		name        string
+		body        string
+		webhooks    bool
+		wantStatus  int
+		wantDeliver string
+	}{
+		{name: "should watch to the release radar by default", body: `{}`, wantStatus: http.StatusOK, wantDeliver: deliverPlaylist},
+		{name: "should watch to the webhooks", body: `{"deliver":"webhook"}`, webhooks: true, wantStatus: http.StatusOK, wantDeliver: deliverWebhook},
+		{name: "should refuse the webhooks without the webhooks service", body: `{"deliver":"webhook"}`, wantStatus: http.StatusBadRequest},
+		{name: "should refuse an unknown delivery", body: `{"deliver":"email"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := releasing()
+			client.followed = nil
+			wt := newTestWatcher(t, client)
+			if tt.webhooks {
+				wt.publisher = webhooks.NewPublisher(service.URL, "services")
+			}
+			r := httptest.NewRequest("POST", "/releases/watch", strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+			r.Header.Set("Authorization", "token")
+			rec := httptest.NewRecorder()
+
+			watchHandler(wt)(rec, r)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got models.ReleaseWatch
+			json.NewDecoder(rec.Body).Decode(&got)
+			if got.Deliver != tt.wantDeliver || !got.Watching || got.Releases == nil {
+				t.Errorf("watch = %+v, want a watch delivered by %s", got, tt.wantDeliver)
+			}
+			// the first scan runs in the background
+			waitScanned(t, wt, "early-riser")
+			if !reflect.DeepEqual(wt.users(), map[string]string{"early-riser": "token"}) {
+				t.Errorf("users() = %v, want early-riser", wt.users())
+			}
+		})
+	}
+}
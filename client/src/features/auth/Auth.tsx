@@ -10,7 +10,7 @@ export function Auth() {
   const dispatch = useAppDispatch();
   const token = useAppSelector(selectToken);
   const endpoint = "https://accounts.spotify.com/authorize";
-  const client_id = "e1cfaae8593c4e7b848e909c605e7ba3";
+  const client_id = process.env.REACT_APP_SPOTIFY_CLIENT_ID;
   const uri = "http://localhost:3000";
 
   // Retrieve token if possible (hash or local storage)
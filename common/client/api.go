@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"common/models"
+
+	"github.com/zmb3/spotify"
+)
+
+// CurrentUser returns the current user (GET /user)
+func (c *Client) CurrentUser(ctx context.Context) (*models.User, error) {
+	var user models.User
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/user"}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// User returns the public profile of a user (GET /user/{userID})
+func (c *Client) User(ctx context.Context, id string) (*models.User, error) {
+	var user models.User
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/user/" + url.PathEscape(id)}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Player returns the current player (GET /player), ErrNothingPlaying is returned when nothing is playing
+func (c *Client) Player(ctx context.Context) (*models.Player, error) {
+	var player models.Player
+	status, err := c.do(ctx, request{method: http.MethodGet, path: "/player"}, &player)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		return nil, ErrNothingPlaying
+	}
+	return &player, nil
+}
+
+// PlayOptions are the options of Play
+type PlayOptions struct {
+	// URI of the track / playlist / album to play, the current music is resumed when empty
+	URI spotify.URI `json:"uri,omitempty"`
+}
+
+// Play plays the current music or the given context (POST /player/play)
+func (c *Client) Play(ctx context.Context, opts PlayOptions) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/player/play", body: opts}, nil)
+	return err
+}
+
+// Pause pauses the music (POST /player/pause)
+func (c *Client) Pause(ctx context.Context) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/player/pause", body: struct{}{}}, nil)
+	return err
+}
+
+// Next goes to the next music (POST /player/next)
+func (c *Client) Next(ctx context.Context) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/player/next", body: struct{}{}}, nil)
+	return err
+}
+
+// Previous goes to the previous music (POST /player/prev)
+func (c *Client) Previous(ctx context.Context) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/player/prev", body: struct{}{}}, nil)
+	return err
+}
+
+// Devices returns the devices able to play music (GET /player/devices)
+func (c *Client) Devices(ctx context.Context) ([]models.Device, error) {
+	var devices []models.Device
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/player/devices"}, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Page is a window of a list, the API uses its defaults for the zero values
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// values returns the query parameters of the page
+func (p Page) values() url.Values {
+	query := url.Values{}
+	if p.Limit > 0 {
+		query.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Offset > 0 {
+		query.Set("offset", strconv.Itoa(p.Offset))
+	}
+	return query
+}
+
+// Playlists returns a page of the playlists of the current user (GET /playlist)
+func (c *Client) Playlists(ctx context.Context, page Page) ([]models.PlaylistItem, error) {
+	var playlists []models.PlaylistItem
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/playlist", query: page.values()}, &playlists); err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+// Playlist returns a playlist with a page of its tracks (GET /playlist/{playlistID})
+func (c *Client) Playlist(ctx context.Context, id string, page Page) (*models.Playlist, error) {
+	var playlist models.Playlist
+	req := request{method: http.MethodGet, path: "/playlist/" + url.PathEscape(id), query: page.values()}
+	if _, err := c.do(ctx, req, &playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// Dashboard is the user, the player and the playlists fetched at once
+// The sections that could not be fetched are nil and reported in Errors
+type Dashboard struct {
+	User      *models.User          `json:"user"`
+	Player    *models.Player        `json:"player"`
+	Playlists []models.PlaylistItem `json:"playlists"`
+	Errors    map[string]Error      `json:"errors"`
+}
+
+// Dashboard returns the user, the player and the playlists (GET /dashboard)
+func (c *Client) Dashboard(ctx context.Context) (*Dashboard, error) {
+	var dashboard Dashboard
+	if _, err := c.do(ctx, request{method: http.MethodGet, path: "/dashboard"}, &dashboard); err != nil {
+		return nil, err
+	}
+	return &dashboard, nil
+}
+
+// GraphQLError is an error of a graphql response
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path"`
+}
+
+// GraphQLErrors are the errors of a graphql response
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Message)
+	}
+	return "client: graphql: " + strings.Join(messages, "; ")
+}
+
+// GraphQL runs the query (POST /graphql) and decodes its data in out
+// The errors of the response are returned as GraphQLErrors, out holds the data that could be resolved
+func (c *Client) GraphQL(ctx context.Context, query string, variables map[string]interface{}, out interface{}) error {
+	body := map[string]interface{}{"query": query}
+	if variables != nil {
+		body["variables"] = variables
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors GraphQLErrors   `json:"errors"`
+	}
+	// a query is idempotent, unlike the mutations it is sent with the same method
+	req := request{method: http.MethodPost, path: "/graphql", body: body}
+	if _, err := c.do(ctx, req, &resp); err != nil {
+		return err
+	}
+	if out != nil && len(resp.Data) > 0 && string(resp.Data) != "null" {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			return errors.New("client: graphql: could not decode the data: " + err.Error())
+		}
+	}
+	if len(resp.Errors) > 0 {
+		return resp.Errors
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenSource gives the spotify access token sent to the API
+type TokenSource interface {
+	// Token returns the current access token
+	Token(ctx context.Context) (string, error)
+	// Refresh returns a new access token once the API rejected the current one
+	Refresh(ctx context.Context) (string, error)
+}
+
+// staticToken is an access token that cannot be refreshed
+type staticToken string
+
+// StaticToken returns a source always giving the access token, ErrTokenExpired is returned once it is rejected
+func StaticToken(token string) TokenSource {
+	return staticToken(token)
+}
+
+func (t staticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t staticToken) Refresh(ctx context.Context) (string, error) {
+	return "", ErrTokenExpired
+}
+
+// oauth2Token refreshes the access token with the refresh token of an oauth2 configuration
+type oauth2Token struct {
+	cfg       *oauth2.Config
+	onRefresh func(*oauth2.Token)
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// OAuth2Token returns a source refreshing the access token with the refresh token once it expired or was rejected
+// onRefresh, if not nil, is called with every new token so it can be stored
+func OAuth2Token(cfg *oauth2.Config, token *oauth2.Token, onRefresh func(*oauth2.Token)) TokenSource {
+	return &oauth2Token{cfg: cfg, token: token, onRefresh: onRefresh}
+}
+
+func (s *oauth2Token) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	if token.Valid() {
+		return token.AccessToken, nil
+	}
+	return s.Refresh(ctx)
+}
+
+func (s *oauth2Token) Refresh(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.RefreshToken == "" {
+		return "", ErrTokenExpired
+	}
+	token, err := s.cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: s.token.RefreshToken}).Token()
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	if s.onRefresh != nil {
+		s.onRefresh(token)
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,233 @@
+// Package client is a typed go client of the REST API served by the gateway
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are the settings of the client
+type Options struct {
+	HTTPClient *http.Client
+	// MaxRetries is the number of times a failed request is retried, 0 disables the retries
+	MaxRetries int
+	// RetryWait is the wait before the first retry, it doubles on each retry up to MaxRetryWait
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration
+	UserAgent    string
+}
+
+// DefaultOptions returns the options used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		MaxRetries:   3,
+		RetryWait:    200 * time.Millisecond,
+		MaxRetryWait: 5 * time.Second,
+		UserAgent:    "spotify-app-go-client",
+	}
+}
+
+// Client calls the API of the app, usually through the gateway
+type Client struct {
+	baseURL *url.URL
+	tokens  TokenSource
+	opts    Options
+}
+
+// New creates a client of the API served at baseURL (e.g. http://127.0.0.1:8080) authenticated with the tokens
+func New(baseURL string, tokens TokenSource, opts Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid base url %q", baseURL)
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = DefaultOptions().HTTPClient
+	}
+	return &Client{baseURL: u, tokens: tokens, opts: opts}, nil
+}
+
+// request is a call to the API
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// accept is the expected content type, json when empty
+	accept string
+}
+
+// idempotent tells if the request can be sent again when the previous attempt may have been processed
+func (r request) idempotent() bool {
+	return r.method == http.MethodGet || r.method == http.MethodHead || r.method == http.MethodPut || r.method == http.MethodDelete
+}
+
+// do sends the request and decodes the json response in out, it returns the status of the response
+// An access token rejected by the API is refreshed once, the failed requests are retried with a backoff
+func (c *Client) do(ctx context.Context, req request, out interface{}) (int, error) {
+	var payload []byte
+	if req.body != nil {
+		var err error
+		if payload, err = json.Marshal(req.body); err != nil {
+			return 0, fmt.Errorf("client: could not encode the request: %w", err)
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("client: could not get the access token: %w", err)
+		}
+		resp, err := c.send(ctx, c.opts.HTTPClient, req, payload, token)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			if req.idempotent() && attempt < c.opts.MaxRetries {
+				if err := c.wait(ctx, c.backoff(attempt)); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			return 0, fmt.Errorf("client: %s %s: %w", req.method, req.path, err)
+		}
+
+		if resp.StatusCode < http.StatusBadRequest {
+			defer resp.Body.Close()
+			if out != nil && resp.StatusCode != http.StatusNoContent {
+				if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+					return resp.StatusCode, fmt.Errorf("client: %s %s: could not decode the response: %w", req.method, req.path, err)
+				}
+			}
+			return resp.StatusCode, nil
+		}
+
+		apiErr := decodeError(resp)
+		if apiErr.Status == http.StatusUnauthorized && !refreshed {
+			refreshed = true
+			if _, err := c.tokens.Refresh(ctx); err != nil {
+				apiErr.Err = err
+				return apiErr.Status, apiErr
+			}
+			attempt--
+			continue
+		}
+		if apiErr.temporary(req) && attempt < c.opts.MaxRetries {
+			wait := c.backoff(attempt)
+			if apiErr.RetryAfter > wait {
+				wait = apiErr.RetryAfter
+			}
+			if err := c.wait(ctx, wait); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		return apiErr.Status, apiErr
+	}
+}
+
+// send sends one attempt of the request with the http client
+func (c *Client) send(ctx context.Context, httpClient *http.Client, req request, payload []byte, token string) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + req.path
+	u.RawQuery = req.query.Encode()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// the API expects the raw spotify access token, as sent by the web app
+	httpReq.Header.Set("Authorization", token)
+	accept := req.accept
+	if accept == "" {
+		accept = "application/json"
+	}
+	httpReq.Header.Set("Accept", accept)
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", c.opts.UserAgent)
+	}
+	return httpClient.Do(httpReq)
+}
+
+// backoff returns the wait before the retry following the attempt, with some jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.opts.RetryWait << uint(attempt)
+	if wait <= 0 || (c.opts.MaxRetryWait > 0 && wait > c.opts.MaxRetryWait) {
+		wait = c.opts.MaxRetryWait
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// wait waits for the duration unless the context is done first
+func (c *Client) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// decodeError reads the error envelope of the response
+// The responses that are not an envelope (e.g. from a proxy) only keep their status
+func decodeError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var envelope struct {
+		Error *Error `json:"error"`
+	}
+	apiErr := &Error{}
+	if err := json.Unmarshal(content, &envelope); err == nil && envelope.Error != nil {
+		apiErr = envelope.Error
+	} else {
+		apiErr.Message = strings.TrimSpace(string(content))
+	}
+	apiErr.Status = resp.StatusCode
+	if apiErr.Code == "" {
+		apiErr.Code = strings.ReplaceAll(strings.ToLower(http.StatusText(resp.StatusCode)), " ", "_")
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	apiErr.RetryAfter = retryAfter(resp.Header.Get("Retry-After"))
+	return apiErr
+}
+
+// retryAfter parses the Retry-After header, given in seconds or as a date
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
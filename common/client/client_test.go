@@ -0,0 +1,478 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"common/models"
+	"common/openapi"
+
+	"golang.org/x/oauth2"
+)
+
+// testOptions are options without waits between the retries
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.RetryWait = time.Millisecond
+	opts.MaxRetryWait = time.Millisecond
+	return opts
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, tokens TokenSource) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, tokens, testOptions())
+	if err != nil {
+		t.Fatalf("could not create the client: %v", err)
+	}
+	return c
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		wantErr bool
+	}{
+		{name: "should create the client", baseURL: "http://127.0.0.1:8080"},
+		{name: "should error on a url without host", baseURL: "/api", wantErr: true},
+		{name: "should error on an invalid url", baseURL: "http://[::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.baseURL, StaticToken("token"), Options{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_endpoints(t *testing.T) {
+	user := models.User{Name: "thomas", ID: "id"}
+	player := models.Player{IsPlaying: true, MusicName: "test", ArtistsName: []string{"thomas"}}
+	playlists := []models.PlaylistItem{{Name: "playlist", ID: "id"}}
+
+	tests := []struct {
+		name       string
+		call       func(c *Client) (interface{}, error)
+		method     string
+		path       string
+		query      string
+		body       string
+		response   string
+		status     int
+		expected   interface{}
+		expectedEr error
+	}{
+		{
+			name:     "should get the current user",
+			call:     func(c *Client) (interface{}, error) { return c.CurrentUser(context.Background()) },
+			method:   http.MethodGet,
+			path:     "/user",
+			response: `{"name":"thomas","id":"id"}`,
+			expected: &user,
+		},
+		{
+			name:     "should get a user profile",
+			call:     func(c *Client) (interface{}, error) { return c.User(context.Background(), "id") },
+			method:   http.MethodGet,
+			path:     "/user/id",
+			response: `{"name":"thomas","id":"id"}`,
+			expected: &user,
+		},
+		{
+			name:     "should get the player",
+			call:     func(c *Client) (interface{}, error) { return c.Player(context.Background()) },
+			method:   http.MethodGet,
+			path:     "/player",
+			response: `{"is_playing":true,"music_name":"test","artists_name":["thomas"]}`,
+			expected: &player,
+		},
+		{
+			name:       "should return nothing playing on no content",
+			call:       func(c *Client) (interface{}, error) { return c.Player(context.Background()) },
+			method:     http.MethodGet,
+			path:       "/player",
+			status:     http.StatusNoContent,
+			expected:   (*models.Player)(nil),
+			expectedEr: ErrNothingPlaying,
+		},
+		{
+			name: "should play a context",
+			call: func(c *Client) (interface{}, error) {
+				return nil, c.Play(context.Background(), PlayOptions{URI: "spotify:playlist:id"})
+			},
+			method: http.MethodPost,
+			path:   "/player/play",
+			body:   `{"uri":"spotify:playlist:id"}`,
+		},
+		{
+			name:   "should resume the music",
+			call:   func(c *Client) (interface{}, error) { return nil, c.Play(context.Background(), PlayOptions{}) },
+			method: http.MethodPost,
+			path:   "/player/play",
+			body:   `{}`,
+		},
+		{
+			name:   "should pause the music",
+			call:   func(c *Client) (interface{}, error) { return nil, c.Pause(context.Background()) },
+			method: http.MethodPost,
+			path:   "/player/pause",
+			body:   `{}`,
+		},
+		{
+			name:   "should go to the next music",
+			call:   func(c *Client) (interface{}, error) { return nil, c.Next(context.Background()) },
+			method: http.MethodPost,
+			path:   "/player/next",
+			body:   `{}`,
+		},
+		{
+			name:   "should go to the previous music",
+			call:   func(c *Client) (interface{}, error) { return nil, c.Previous(context.Background()) },
+			method: http.MethodPost,
+			path:   "/player/prev",
+			body:   `{}`,
+		},
+		{
+			name: "should list a page of playlists",
+			call: func(c *Client) (interface{}, error) {
+				return c.Playlists(context.Background(), Page{Limit: 10, Offset: 20})
+			},
+			method:   http.MethodGet,
+			path:     "/playlist",
+			query:    "limit=10&offset=20",
+			response: `[{"name":"playlist","ID":"id"}]`,
+			expected: playlists,
+		},
+		{
+			name:     "should list the playlists with the defaults of the api",
+			call:     func(c *Client) (interface{}, error) { return c.Playlists(context.Background(), Page{}) },
+			method:   http.MethodGet,
+			path:     "/playlist",
+			response: `[{"name":"playlist","ID":"id"}]`,
+			expected: playlists,
+		},
+		{
+			name:     "should list the devices",
+			call:     func(c *Client) (interface{}, error) { return c.Devices(context.Background()) },
+			method:   http.MethodGet,
+			path:     "/player/devices",
+			response: `[{"id":"id","name":"speaker","type":"Speaker","is_active":true,"volume":50}]`,
+			expected: []models.Device{{ID: "id", Name: "speaker", Type: "Speaker", IsActive: true, Volume: 50}},
+		},
+		{
+			name: "should get a playlist with a page of its tracks",
+			call: func(c *Client) (interface{}, error) {
+				return c.Playlist(context.Background(), "morning", Page{Limit: 1})
+			},
+			method:   http.MethodGet,
+			path:     "/playlist/morning",
+			query:    "limit=1",
+			response: `{"name":"Morning","ID":"morning","tracks":[{"name":"Sunrise","ID":"sunrise"}],"total":3}`,
+			expected: &models.Playlist{
+				PlaylistItem: models.PlaylistItem{Name: "Morning", ID: "morning"},
+				Tracks:       []models.Track{{Name: "Sunrise", ID: "sunrise"}},
+				Total:        3,
+			},
+		},
+		{
+			name:     "should get the dashboard",
+			call:     func(c *Client) (interface{}, error) { return c.Dashboard(context.Background()) },
+			method:   http.MethodGet,
+			path:     "/dashboard",
+			response: `{"user":{"name":"thomas","id":"id"},"player":null,"playlists":null,"errors":{"player":{"status":502,"code":"bad_gateway","message":"down"},"playlists":{"status":502,"code":"bad_gateway","message":"down"}}}`,
+			expected: &Dashboard{
+				User: &user,
+				Errors: map[string]Error{
+					"player":    {Status: 502, Code: "bad_gateway", Message: "down"},
+					"playlists": {Status: 502, Code: "bad_gateway", Message: "down"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != tt.method || r.URL.Path != tt.path || r.URL.RawQuery != tt.query {
+					t.Errorf("unexpected request: got %v %v?%v want %v %v?%v", r.Method, r.URL.Path, r.URL.RawQuery, tt.method, tt.path, tt.query)
+				}
+				if got := r.Header.Get("Authorization"); got != "token" {
+					t.Errorf("unexpected authorization: got %v want %v", got, "token")
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != tt.body {
+					t.Errorf("unexpected body: got %v want %v", string(body), tt.body)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte(tt.response))
+			}, StaticToken("token"))
+
+			got, err := tt.call(c)
+			if !errors.Is(err, tt.expectedEr) {
+				t.Fatalf("unexpected error: got %v want %v", err, tt.expectedEr)
+			}
+			if tt.expected != nil && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("unexpected response: got %+v want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		expected *Error
+	}{
+		{
+			name:     "should decode the error envelope",
+			status:   http.StatusNotFound,
+			response: `{"error":{"status":404,"code":"not_found","message":"no route"}}`,
+			expected: &Error{Status: 404, Code: "not_found", Message: "no route"},
+		},
+		{
+			name:     "should decode the invalid fields",
+			status:   http.StatusBadRequest,
+			response: `{"error":{"status":400,"code":"invalid_request","message":"invalid","fields":[{"in":"path","field":"userID","message":"is too long"}]}}`,
+			expected: &Error{Status: 400, Code: "invalid_request", Message: "invalid", Fields: []openapi.FieldError{{In: "path", Field: "userID", Message: "is too long"}}},
+		},
+		{
+			name:     "should keep the status of a response without envelope",
+			status:   http.StatusInternalServerError,
+			response: ``,
+			expected: &Error{Status: 500, Code: "internal_server_error", Message: "Internal Server Error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}, StaticToken("token"))
+			c.opts.MaxRetries = 0
+
+			_, err := c.User(context.Background(), "id")
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an api error, got %v", err)
+			}
+			if !reflect.DeepEqual(apiErr, tt.expected) {
+				t.Errorf("unexpected error: got %+v want %+v", apiErr, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_IsNotFound(t *testing.T) {
+	err := &Error{Status: http.StatusNotFound}
+	if !IsNotFound(err) || IsBadRequest(err) || IsUnauthorized(err) || IsRateLimited(err) {
+		t.Errorf("unexpected status helpers for %v", err)
+	}
+	if IsNotFound(errors.New("not found")) {
+		t.Errorf("IsNotFound should only match api errors")
+	}
+}
+
+func Test_retries(t *testing.T) {
+	tests := []struct {
+		name          string
+		call          func(c *Client) error
+		statuses      []int
+		expectedCalls int32
+		expectedErr   bool
+	}{
+		{
+			name:          "should retry a get on a bad gateway",
+			call:          func(c *Client) error { _, err := c.CurrentUser(context.Background()); return err },
+			statuses:      []int{502, 503, 200},
+			expectedCalls: 3,
+		},
+		{
+			name:          "should stop retrying after the max retries",
+			call:          func(c *Client) error { _, err := c.CurrentUser(context.Background()); return err },
+			statuses:      []int{503, 503, 503, 503, 503},
+			expectedCalls: 4,
+			expectedErr:   true,
+		},
+		{
+			name:          "should not retry a post on a bad gateway",
+			call:          func(c *Client) error { return c.Pause(context.Background()) },
+			statuses:      []int{502, 200},
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+		{
+			name:          "should retry a rate limited post",
+			call:          func(c *Client) error { return c.Pause(context.Background()) },
+			statuses:      []int{429, 200},
+			expectedCalls: 2,
+		},
+		{
+			name:          "should not retry a client error",
+			call:          func(c *Client) error { _, err := c.CurrentUser(context.Background()); return err },
+			statuses:      []int{404, 200},
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.statuses[call-1])
+				w.Write([]byte(`{}`))
+			}, StaticToken("token"))
+
+			err := tt.call(c)
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("unexpected error: got %v wantErr %v", err, tt.expectedErr)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("unexpected number of calls: got %v want %v", calls, tt.expectedCalls)
+			}
+		})
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	var calls int32
+	var first time.Time
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if waited := time.Since(first); waited < time.Second {
+			t.Errorf("retried before the Retry-After: waited %v", waited)
+		}
+	}, StaticToken("token"))
+
+	if err := c.Next(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_retry_contextCancelled(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, StaticToken("token"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.CurrentUser(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: got %v want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the retry did not stop with the context: %v", elapsed)
+	}
+}
+
+func Test_staticToken_expired(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"status":401,"code":"invalid_token","message":"expired"}}`))
+	}, StaticToken("token"))
+
+	_, err := c.CurrentUser(context.Background())
+	if !errors.Is(err, ErrTokenExpired) || !IsUnauthorized(err) {
+		t.Errorf("unexpected error: got %v want %v", err, ErrTokenExpired)
+	}
+}
+
+func Test_oauth2Token_refresh(t *testing.T) {
+	var refreshes int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&refreshes, 1)
+		r.ParseForm()
+		if got := r.PostForm.Get("refresh_token"); got != "refresh" {
+			t.Errorf("unexpected refresh token: got %v want %v", got, "refresh")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "new", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+
+	var stored *oauth2.Token
+	tokens := OAuth2Token(
+		&oauth2.Config{ClientID: "id", ClientSecret: "secret", Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL}},
+		&oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)},
+		func(token *oauth2.Token) { stored = token },
+	)
+
+	var authorizations []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"name":"thomas","id":"id"}`))
+	}, tokens)
+
+	user, err := c.CurrentUser(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != "id" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+	if want := []string{"old", "new"}; !reflect.DeepEqual(authorizations, want) {
+		t.Errorf("unexpected authorizations: got %v want %v", authorizations, want)
+	}
+	if refreshes != 1 {
+		t.Errorf("unexpected number of refreshes: got %v want %v", refreshes, 1)
+	}
+	if stored == nil || stored.AccessToken != "new" || stored.RefreshToken != "refresh" {
+		t.Errorf("the refreshed token was not stored: %+v", stored)
+	}
+
+	// the token is not refreshed again once valid
+	if _, err := c.CurrentUser(context.Background()); err != nil || refreshes != 1 {
+		t.Errorf("unexpected refresh: err %v refreshes %v", err, refreshes)
+	}
+}
+
+func Test_GraphQL(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if !strings.Contains(body["query"].(string), "me") {
+			t.Errorf("unexpected query: %v", body["query"])
+		}
+		w.Write([]byte(`{"data":{"me":{"id":"id"},"player":null},"errors":[{"message":"could not get the player","path":["player"]}]}`))
+	}, StaticToken("token"))
+
+	var data struct {
+		Me struct {
+			ID string `json:"id"`
+		} `json:"me"`
+	}
+	err := c.GraphQL(context.Background(), "{ me { id } player { isPlaying } }", nil, &data)
+	var gqlErrs GraphQLErrors
+	if !errors.As(err, &gqlErrs) || len(gqlErrs) != 1 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if data.Me.ID != "id" {
+		t.Errorf("the partial data was not decoded: %+v", data)
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"common/openapi"
+)
+
+// ErrNothingPlaying is returned by Player when nothing is playing
+var ErrNothingPlaying = errors.New("client: nothing is playing")
+
+// ErrTokenExpired is returned when the access token was rejected and cannot be refreshed
+var ErrTokenExpired = errors.New("client: the access token expired and cannot be refreshed")
+
+// Error is an error answered by the API, decoded from the error envelope of the gateway
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields are the invalid fields of a request rejected with the invalid_request code
+	Fields []openapi.FieldError `json:"fields,omitempty"`
+	// RetryAfter is the wait asked by the API before sending the request again
+	RetryAfter time.Duration `json:"-"`
+	// Err is the error that prevented to recover from this one, e.g. the refresh of the token
+	Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("client: %d %s: %s", e.Status, e.Code, e.Message)
+	for _, f := range e.Fields {
+		msg += "; " + f.Error()
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// temporary tells if sending the request again may succeed
+// The requests that are not idempotent are only sent again when they were rate limited, as they were not processed
+func (e *Error) temporary(req request) bool {
+	switch e.Status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return req.idempotent()
+	}
+	return false
+}
+
+// IsBadRequest tells if the error is a 400 answered by the API, Fields then lists the invalid fields when known
+func IsBadRequest(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// IsNotFound tells if the error is a 404 answered by the API
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized tells if the error is a 401 answered by the API, the access token is then missing or invalid
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsRateLimited tells if the error is a 429 answered by the API
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// hasStatus tells if the error is an API error with the status
+func hasStatus(err error, status int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"common/models"
+)
+
+// streamIdleTimeout is how long a stream can stay silent before it is seen as lost and reconnected
+// The API writes a heartbeat well before it
+var streamIdleTimeout = 45 * time.Second
+
+// PlayerEvent is an event of the player event stream
+type PlayerEvent struct {
+	// Player is the new state of the player, nil when nothing is playing
+	Player *models.Player
+	// Err is set when the stream was lost or spotify could not be reached, the stream goes on after it
+	Err error
+}
+
+// sseEvent is a server sent event
+type sseEvent struct {
+	name string
+	data string
+}
+
+// PlayerEvents subscribes to the player event stream (GET /player/events)
+// The stream is reconnected when it is lost, until the context is done or the token cannot be refreshed; the channel is then closed
+func (c *Client) PlayerEvents(ctx context.Context) <-chan PlayerEvent {
+	events := make(chan PlayerEvent)
+	go func() {
+		defer close(events)
+		emit := func(event PlayerEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		retry := 3 * time.Second
+		for attempt := 0; ; attempt++ {
+			connected, err := c.streamPlayer(ctx, &retry, emit)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if !emit(PlayerEvent{Err: err}) || IsUnauthorized(err) {
+					return
+				}
+			}
+			if connected {
+				attempt = 0
+			}
+			wait := retry
+			if backoff := c.backoff(attempt); backoff > wait {
+				wait = backoff
+			}
+			if c.wait(ctx, wait) != nil {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// streamPlayer reads one connection of the event stream and emits its events
+// It tells if the stream was connected, so the reconnections are only backed off while it cannot connect
+func (c *Client) streamPlayer(ctx context.Context, retry *time.Duration, emit func(PlayerEvent) bool) (bool, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the stream is long lived, it is not cut by the timeout of the http client
+	httpClient := *c.opts.HTTPClient
+	httpClient.Timeout = 0
+	req := request{method: http.MethodGet, path: "/player/events", accept: "text/event-stream"}
+
+	var resp *http.Response
+	for refreshed := false; ; refreshed = true {
+		token, err := c.tokens.Token(ctx)
+		if err != nil {
+			return false, fmt.Errorf("client: could not get the access token: %w", err)
+		}
+		resp, err = c.send(streamCtx, &httpClient, req, nil, token)
+		if err != nil {
+			return false, fmt.Errorf("client: %s %s: %w", req.method, req.path, err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		apiErr := decodeError(resp)
+		if apiErr.Status != http.StatusUnauthorized || refreshed {
+			return false, apiErr
+		}
+		if _, err := c.tokens.Refresh(ctx); err != nil {
+			apiErr.Err = err
+			return false, apiErr
+		}
+	}
+	defer resp.Body.Close()
+
+	// the connection is closed when nothing, not even a heartbeat, was received for too long
+	var idle int32
+	timer := time.AfterFunc(streamIdleTimeout, func() {
+		atomic.StoreInt32(&idle, 1)
+		cancel()
+	})
+	defer timer.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var event sseEvent
+	for scanner.Scan() {
+		timer.Reset(streamIdleTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if playerEvent, ok := event.playerEvent(); ok && !emit(playerEvent) {
+				return true, nil
+			}
+			event = sseEvent{}
+		case strings.HasPrefix(line, ":"):
+			// a comment, e.g. the heartbeat
+		default:
+			field, value := line, ""
+			if i := strings.Index(line, ":"); i >= 0 {
+				field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch field {
+			case "event":
+				event.name = value
+			case "data":
+				if event.data != "" {
+					event.data += "\n"
+				}
+				event.data += value
+			case "retry":
+				if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+					*retry = time.Duration(ms) * time.Millisecond
+				}
+			}
+		}
+	}
+
+	if atomic.LoadInt32(&idle) == 1 {
+		return true, fmt.Errorf("client: the player event stream was idle for more than %v", streamIdleTimeout)
+	}
+	if ctx.Err() != nil {
+		return true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("client: the player event stream was lost: %w", err)
+	}
+	return true, fmt.Errorf("client: the player event stream was closed")
+}
+
+// playerEvent converts the server sent event, the unknown events are ignored
+func (e sseEvent) playerEvent() (PlayerEvent, bool) {
+	switch e.name {
+	case "player":
+		var player models.Player
+		if err := json.Unmarshal([]byte(e.data), &player); err != nil {
+			return PlayerEvent{Err: fmt.Errorf("client: invalid player event: %w", err)}, true
+		}
+		return PlayerEvent{Player: &player}, true
+	case "nothing_playing":
+		return PlayerEvent{}, true
+	case "error":
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal([]byte(e.data), &body)
+		return PlayerEvent{Err: fmt.Errorf("client: %s", body.Message)}, true
+	}
+	return PlayerEvent{}, false
+}
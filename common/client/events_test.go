@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next event of the stream
+func nextEvent(t *testing.T, events <-chan PlayerEvent) (PlayerEvent, bool) {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		return event, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("no player event received")
+		return PlayerEvent{}, false
+	}
+}
+
+func Test_PlayerEvents(t *testing.T) {
+	var connections int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/player/events" || r.Header.Get("Authorization") != "token" || r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("unexpected request: %v %v", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if atomic.AddInt32(&connections, 1) == 1 {
+			fmt.Fprint(w, "retry: 1\n\n")
+			fmt.Fprint(w, "event: player\ndata: {\"is_playing\":true,\"music_name\":\"Sunrise\"}\n\n")
+			fmt.Fprint(w, ": heartbeat\n\n")
+			fmt.Fprint(w, "event: unknown\ndata: {}\n\n")
+			fmt.Fprint(w, "event: nothing_playing\n: a comment inside the event\ndata: {}\n\n")
+			fmt.Fprint(w, "event: error\ndata: {\"message\":\"could not get the player\"}\n\n")
+			return
+		}
+		fmt.Fprint(w, "event: player\ndata: {\"is_playing\":false,\"music_name\":\"Coffee\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}, StaticToken("token"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := c.PlayerEvents(ctx)
+
+	expected := []string{"player Sunrise", "nothing playing", "error could not get the player", "error the player event stream was closed", "player Coffee"}
+	for _, want := range expected {
+		event, ok := nextEvent(t, events)
+		if !ok {
+			t.Fatalf("the events were closed before %v", want)
+		}
+		var got string
+		switch {
+		case event.Err != nil:
+			got = "error " + strings.TrimPrefix(event.Err.Error(), "client: ")
+		case event.Player == nil:
+			got = "nothing playing"
+		default:
+			got = "player " + event.Player.MusicName
+		}
+		if got != want {
+			t.Errorf("unexpected event: got %v want %v", got, want)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func Test_PlayerEvents_unauthorized(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"status":401,"code":"invalid_token","message":"expired"}}`))
+	}, StaticToken("token"))
+
+	events := c.PlayerEvents(context.Background())
+	if event, ok := nextEvent(t, events); !ok || !IsUnauthorized(event.Err) {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if _, ok := nextEvent(t, events); ok {
+		t.Errorf("the events should be closed once the token cannot be refreshed")
+	}
+}
+
+func Test_PlayerEvents_idle(t *testing.T) {
+	defer func(timeout time.Duration) { streamIdleTimeout = timeout }(streamIdleTimeout)
+	streamIdleTimeout = 20 * time.Millisecond
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "retry: 1\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}, StaticToken("token"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	event, _ := nextEvent(t, c.PlayerEvents(ctx))
+	if event.Err == nil || !strings.Contains(event.Err.Error(), "idle") {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
@@ -0,0 +1,603 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/server"
+	"common/spotifyapi"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrPrinted is returned by Parse once the usage or the configuration (--print-config) has been printed
+var ErrPrinted = errors.New("config: configuration printed")
+
+// redacted replaces the secrets when the configuration is printed
+const redacted = "[REDACTED]"
+
+// Config is the configuration of a service
+type Config struct {
+	HTTP     server.Options `yaml:"http"`
+	GRPC     GRPC           `yaml:"grpc"`
+	CORS     CORS           `yaml:"cors"`
+	Spotify  Spotify        `yaml:"spotify"`
+	Gateway  Gateway        `yaml:"gateway"`
+	Player   Player         `yaml:"player"`
+	Playlist Playlist       `yaml:"playlist"`
+	Search   Search         `yaml:"search"`
+	History  History        `yaml:"history"`
+	Scrobble Scrobble       `yaml:"scrobble"`
+	Releases Releases       `yaml:"releases"`
+	Webhooks Webhooks       `yaml:"webhooks"`
+	EventBus EventBus       `yaml:"event_bus"`
+	LogLevel string         `yaml:"log_level"`
+}
+
+// GRPC is the configuration of the grpc server served next to the http one
+type GRPC struct {
+	Addr string `yaml:"addr"`
+}
+
+// CORS is the configuration of the cross origin requests
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Spotify is the configuration of the spotify API
+type Spotify struct {
+	APIURL         string `yaml:"api_url"`
+	ClientID       string `yaml:"client_id"`
+	ClientSecret   string `yaml:"client_secret"`
+	ReadinessCheck bool   `yaml:"readiness_check"`
+}
+
+// Gateway is the configuration of the api gateway
+type Gateway struct {
+	Routes []Route `yaml:"routes"`
+	// SessionTTL is how long a validated access token is trusted before being checked again
+	SessionTTL   time.Duration `yaml:"session_ttl"`
+	MaxBodyBytes int64         `yaml:"max_body_bytes"`
+	// DashboardTimeout is the time given to each microservice to answer the dashboard
+	DashboardTimeout time.Duration `yaml:"dashboard_timeout"`
+}
+
+// Player is the configuration of the player service
+type Player struct {
+	// EventsInterval is how often spotify is polled for the changes sent on the player event stream
+	EventsInterval time.Duration `yaml:"events_interval"`
+	// EventsHeartbeat is how often a comment is written on the event stream so the proxies keep it open
+	EventsHeartbeat time.Duration `yaml:"events_heartbeat"`
+	// TrackInterval is how often the player of each known user is polled in the background for its track changes
+	TrackInterval time.Duration `yaml:"track_interval"`
+}
+
+// Playlist is the configuration of the playlist service
+type Playlist struct {
+	// FeaturesCacheSize is the number of tracks whose audio features are kept in the cache, they never change
+	FeaturesCacheSize int `yaml:"features_cache_size"`
+}
+
+// Search is the configuration of the search service
+type Search struct {
+	// CacheTTL is how long a search result is answered from the cache, 0 disables the cache
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// CacheSize is the number of search results kept in the cache
+	CacheSize int `yaml:"cache_size"`
+}
+
+// History is the configuration of the history service
+type History struct {
+	// DBPath is the path of the sqlite database keeping the plays
+	DBPath string `yaml:"db_path"`
+	// RecordInterval is how often the recently played tracks of each registered user are pulled from spotify
+	RecordInterval time.Duration `yaml:"record_interval"`
+}
+
+// Scrobble is the configuration of the scrobbling of the tracks played, done by the player service
+// Each user registers the token of its own account, the user token of listenbrainz or the session key of lastfm
+type Scrobble struct {
+	// API is listenbrainz or lastfm, the scrobbling is disabled when empty
+	API string `yaml:"api"`
+	// URL is the root of a compatible API, the one of the official service is used when empty
+	URL string `yaml:"url"`
+	// APIKey and APISecret are the credentials of the lastfm application
+	APIKey    string `yaml:"api_key"`
+	APISecret string `yaml:"api_secret"`
+	// AccountsPath is the file keeping the scrobbling accounts of the users
+	AccountsPath string `yaml:"accounts_path"`
+	// QueuePath is the file keeping the scrobbles not submitted yet
+	QueuePath string `yaml:"queue_path"`
+	// RetryInterval is how often the queued scrobbles are submitted again
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+// Releases is the configuration of the new release watcher of the catalog service
+type Releases struct {
+	// StatePath is the file keeping the watch of each user and the releases already reported
+	StatePath string `yaml:"state_path"`
+	// WatchInterval is how often the albums of the artists followed by each watching user are scanned
+	WatchInterval time.Duration `yaml:"watch_interval"`
+}
+
+// Webhooks is the configuration of the webhooks service and of the services publishing their events to it
+type Webhooks struct {
+	// URL is the root of the webhooks service the events are published to, they are not published when empty
+	URL string `yaml:"url"`
+	// Token is shared by the webhooks service and the services publishing to it, the events published without it are refused
+	Token string `yaml:"token"`
+	// StatePath is the file keeping the webhooks, their deliveries and the dead letters
+	StatePath string `yaml:"state_path"`
+	// MaxAttempts is the number of times a delivery is tried before going to the dead letters
+	MaxAttempts int `yaml:"max_attempts"`
+	// RetryBackoff is the delay before the first retry of a delivery, doubled on each of the next ones
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// AllowPrivate lets the webhooks target loopback, private and internal addresses, only for development and tests
+	AllowPrivate bool `yaml:"allow_private"`
+}
+
+// EventBus is the configuration of the bus the services publish their internal events on
+type EventBus struct {
+	// URL is the nats server the events go through, nats://host:port or tls://host:port with the credentials in the userinfo; the bus is in-process when empty
+	// The credentials are required since the access tokens of the users are published on the bus
+	URL string `yaml:"url"`
+}
+
+// Route routes the requests whose path starts with Prefix to Upstream
+type Route struct {
+	Prefix   string `yaml:"prefix"`
+	Upstream string `yaml:"upstream"`
+	// RateLimit is the number of requests per second allowed for each user, 0 disables the limit
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() Config {
+	return Config{
+		HTTP: server.DefaultOptions(),
+		GRPC: GRPC{
+			Addr: ":9090",
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"*"},
+		},
+		Spotify: Spotify{
+			APIURL: spotifyapi.DefaultURL,
+		},
+		Gateway: Gateway{
+			Routes: []Route{
+				{Prefix: "/user", Upstream: "http://user:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/player", Upstream: "http://player:8080", RateLimit: 10, Burst: 20},
+				{Prefix: "/recommendations", Upstream: "http://player:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/playlist", Upstream: "http://playlist:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/tracks", Upstream: "http://playlist:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/graphql", Upstream: "http://graphql:8080", RateLimit: 10, Burst: 20},
+				{Prefix: "/search", Upstream: "http://search:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/library", Upstream: "http://library:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/history", Upstream: "http://history:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/stats", Upstream: "http://history:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/artists", Upstream: "http://catalog:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/albums", Upstream: "http://catalog:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/releases", Upstream: "http://catalog:8080", RateLimit: 5, Burst: 10},
+				{Prefix: "/webhooks", Upstream: "http://webhooks:8080", RateLimit: 5, Burst: 10},
+			},
+			SessionTTL:       5 * time.Minute,
+			MaxBodyBytes:     1 << 20,
+			DashboardTimeout: 2 * time.Second,
+		},
+		Player: Player{
+			EventsInterval:  2 * time.Second,
+			EventsHeartbeat: 15 * time.Second,
+			TrackInterval:   15 * time.Second,
+		},
+		Playlist: Playlist{
+			FeaturesCacheSize: 10000,
+		},
+		Search: Search{
+			CacheTTL:  5 * time.Minute,
+			CacheSize: 1000,
+		},
+		History: History{
+			DBPath:         "history.db",
+			RecordInterval: 5 * time.Minute,
+		},
+		Scrobble: Scrobble{
+			AccountsPath:  "scrobble-accounts.json",
+			QueuePath:     "scrobbles.json",
+			RetryInterval: time.Minute,
+		},
+		Releases: Releases{
+			StatePath:     "releases.json",
+			WatchInterval: 6 * time.Hour,
+		},
+		Webhooks: Webhooks{
+			StatePath:    "webhooks.json",
+			MaxAttempts:  5,
+			RetryBackoff: 10 * time.Second,
+		},
+		LogLevel: "info",
+	}
+}
+
+// Parse parses the command line flags of a service and loads its configuration
+// The yaml file is taken from --config or CONFIG_FILE, the environment variables override it
+// With --print-config the redacted configuration is written to out and ErrPrinted is returned
+func Parse(name string, args []string, out io.Writer) (Config, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(out)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "path of the yaml configuration file")
+	printConfig := flags.Bool("print-config", false, "print the configuration with its secrets redacted and exit")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return Config{}, ErrPrinted
+		}
+		return Config{}, err
+	}
+
+	cfg, err := Load(*path)
+	if err != nil {
+		return cfg, err
+	}
+	if *printConfig {
+		if err := cfg.Print(out); err != nil {
+			return cfg, err
+		}
+		return cfg, ErrPrinted
+	}
+	return cfg, nil
+}
+
+// Load loads the configuration from the defaults, the optional yaml file and the environment then validates it
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("config: could not read %s: %w", path, err)
+		}
+		if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("config: could not parse %s: %w", path, err)
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// loadEnv overrides the configuration with the environment variables that are set
+func (c *Config) loadEnv() error {
+	texts := map[string]*string{
+		"HTTP_ADDR":              &c.HTTP.Addr,
+		"GRPC_ADDR":              &c.GRPC.Addr,
+		"SPOTIFY_API_URL":        &c.Spotify.APIURL,
+		"SPOTIFY_CLIENT_ID":      &c.Spotify.ClientID,
+		"SPOTIFY_CLIENT_SECRET":  &c.Spotify.ClientSecret,
+		"HISTORY_DB_PATH":        &c.History.DBPath,
+		"SCROBBLE_API":           &c.Scrobble.API,
+		"SCROBBLE_URL":           &c.Scrobble.URL,
+		"SCROBBLE_ACCOUNTS_PATH": &c.Scrobble.AccountsPath,
+		"SCROBBLE_API_KEY":       &c.Scrobble.APIKey,
+		"SCROBBLE_API_SECRET":    &c.Scrobble.APISecret,
+		"SCROBBLE_QUEUE_PATH":    &c.Scrobble.QueuePath,
+		"RELEASES_STATE_PATH":    &c.Releases.StatePath,
+		"WEBHOOKS_URL":           &c.Webhooks.URL,
+		"WEBHOOKS_TOKEN":         &c.Webhooks.Token,
+		"WEBHOOKS_STATE_PATH":    &c.Webhooks.StatePath,
+		"EVENT_BUS_URL":          &c.EventBus.URL,
+		"LOG_LEVEL":              &c.LogLevel,
+	}
+	for name, field := range texts {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"HTTP_READ_TIMEOUT":         &c.HTTP.ReadTimeout,
+		"HTTP_READ_HEADER_TIMEOUT":  &c.HTTP.ReadHeaderTimeout,
+		"HTTP_WRITE_TIMEOUT":        &c.HTTP.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":         &c.HTTP.IdleTimeout,
+		"HTTP_SHUTDOWN_TIMEOUT":     &c.HTTP.ShutdownTimeout,
+		"GATEWAY_DASHBOARD_TIMEOUT": &c.Gateway.DashboardTimeout,
+		"PLAYER_EVENTS_INTERVAL":    &c.Player.EventsInterval,
+		"PLAYER_EVENTS_HEARTBEAT":   &c.Player.EventsHeartbeat,
+		"PLAYER_TRACK_INTERVAL":     &c.Player.TrackInterval,
+		"SEARCH_CACHE_TTL":          &c.Search.CacheTTL,
+		"HISTORY_RECORD_INTERVAL":   &c.History.RecordInterval,
+		"SCROBBLE_RETRY_INTERVAL":   &c.Scrobble.RetryInterval,
+		"RELEASES_WATCH_INTERVAL":   &c.Releases.WatchInterval,
+		"WEBHOOKS_RETRY_BACKOFF":    &c.Webhooks.RetryBackoff,
+	}
+	for name, field := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("config: invalid %s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	ints := map[string]*int{
+		"HTTP_MAX_HEADER_BYTES":        &c.HTTP.MaxHeaderBytes,
+		"PLAYLIST_FEATURES_CACHE_SIZE": &c.Playlist.FeaturesCacheSize,
+		"SEARCH_CACHE_SIZE":            &c.Search.CacheSize,
+		"WEBHOOKS_MAX_ATTEMPTS":        &c.Webhooks.MaxAttempts,
+	}
+	for name, field := range ints {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("config: invalid %s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+	if value, ok := os.LookupEnv("SPOTIFY_READINESS_CHECK"); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("config: invalid SPOTIFY_READINESS_CHECK: %w", err)
+		}
+		c.Spotify.ReadinessCheck = parsed
+	}
+	if value, ok := os.LookupEnv("WEBHOOKS_ALLOW_PRIVATE"); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("config: invalid WEBHOOKS_ALLOW_PRIVATE: %w", err)
+		}
+		c.Webhooks.AllowPrivate = parsed
+	}
+	if value, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		c.CORS.AllowedOrigins = splitList(value)
+	}
+	if value, ok := os.LookupEnv("GATEWAY_ROUTES"); ok {
+		routes, err := parseRoutes(value, c.Gateway.Routes)
+		if err != nil {
+			return err
+		}
+		c.Gateway.Routes = routes
+	}
+	return nil
+}
+
+// parseRoutes parses a comma separated list of prefix=upstream routes
+// The rate limit of a prefix is kept from the current routes, otherwise the route is not limited
+func parseRoutes(value string, current []Route) ([]Route, error) {
+	routes := []Route{}
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("config: invalid GATEWAY_ROUTES: %q is not prefix=upstream", item)
+		}
+		route := Route{Prefix: parts[0], Upstream: parts[1]}
+		for _, r := range current {
+			if r.Prefix == route.Prefix {
+				route.RateLimit, route.Burst = r.RateLimit, r.Burst
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// splitList splits a comma separated list and drops its empty values
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Validate checks the configuration and returns every invalid setting at once
+func (c Config) Validate() error {
+	var errs []string
+	if _, _, err := net.SplitHostPort(c.HTTP.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("http.addr %q is not a valid address", c.HTTP.Addr))
+	}
+	if _, _, err := net.SplitHostPort(c.GRPC.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("grpc.addr %q is not a valid address", c.GRPC.Addr))
+	}
+	durations := map[string]time.Duration{
+		"http.read_timeout":        c.HTTP.ReadTimeout,
+		"http.read_header_timeout": c.HTTP.ReadHeaderTimeout,
+		"http.write_timeout":       c.HTTP.WriteTimeout,
+		"http.idle_timeout":        c.HTTP.IdleTimeout,
+	}
+	for name, d := range durations {
+		if d < 0 {
+			errs = append(errs, fmt.Sprintf("%s must not be negative", name))
+		}
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		errs = append(errs, "http.shutdown_timeout must be positive")
+	}
+	if c.HTTP.MaxHeaderBytes <= 0 {
+		errs = append(errs, "http.max_header_bytes must be positive")
+	}
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, "cors.allowed_origins must not be empty")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin != "*" && !isHTTPURL(origin) {
+			errs = append(errs, fmt.Sprintf("cors.allowed_origins %q is not a valid origin", origin))
+		}
+	}
+	if !isHTTPURL(c.Spotify.APIURL) {
+		errs = append(errs, fmt.Sprintf("spotify.api_url %q is not a valid url", c.Spotify.APIURL))
+	}
+	for _, route := range c.Gateway.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Sprintf("gateway.routes prefix %q must start with /", route.Prefix))
+		}
+		if !isHTTPURL(route.Upstream) {
+			errs = append(errs, fmt.Sprintf("gateway.routes upstream %q is not a valid url", route.Upstream))
+		}
+		if route.RateLimit < 0 || route.Burst < 0 || (route.RateLimit > 0 && route.Burst == 0) {
+			errs = append(errs, fmt.Sprintf("gateway.routes %s needs a positive burst with its rate limit", route.Prefix))
+		}
+	}
+	if c.Gateway.SessionTTL < 0 {
+		errs = append(errs, "gateway.session_ttl must not be negative")
+	}
+	if c.Gateway.DashboardTimeout <= 0 {
+		errs = append(errs, "gateway.dashboard_timeout must be positive")
+	}
+	if c.Gateway.MaxBodyBytes <= 0 {
+		errs = append(errs, "gateway.max_body_bytes must be positive")
+	}
+	if c.Player.EventsInterval <= 0 {
+		errs = append(errs, "player.events_interval must be positive")
+	}
+	if c.Player.EventsHeartbeat <= 0 {
+		errs = append(errs, "player.events_heartbeat must be positive")
+	}
+	if c.Player.TrackInterval <= 0 {
+		errs = append(errs, "player.track_interval must be positive")
+	}
+	if c.Playlist.FeaturesCacheSize <= 0 {
+		errs = append(errs, "playlist.features_cache_size must be positive")
+	}
+	if c.Search.CacheTTL < 0 {
+		errs = append(errs, "search.cache_ttl must not be negative")
+	}
+	if c.Search.CacheSize <= 0 {
+		errs = append(errs, "search.cache_size must be positive")
+	}
+	if c.History.DBPath == "" {
+		errs = append(errs, "history.db_path must not be empty")
+	}
+	if c.History.RecordInterval <= 0 {
+		errs = append(errs, "history.record_interval must be positive")
+	}
+	switch c.Scrobble.API {
+	case "", "listenbrainz", "lastfm":
+	default:
+		errs = append(errs, fmt.Sprintf("scrobble.api %q must be listenbrainz or lastfm", c.Scrobble.API))
+	}
+	if c.Scrobble.URL != "" && !isHTTPURL(c.Scrobble.URL) {
+		errs = append(errs, fmt.Sprintf("scrobble.url %q is not a valid url", c.Scrobble.URL))
+	}
+	if c.Scrobble.API == "lastfm" && (c.Scrobble.APIKey == "" || c.Scrobble.APISecret == "") {
+		errs = append(errs, "scrobble.api_key and scrobble.api_secret must not be empty with lastfm")
+	}
+	if c.Scrobble.AccountsPath == "" {
+		errs = append(errs, "scrobble.accounts_path must not be empty")
+	}
+	if c.Scrobble.QueuePath == "" {
+		errs = append(errs, "scrobble.queue_path must not be empty")
+	}
+	if c.Scrobble.RetryInterval <= 0 {
+		errs = append(errs, "scrobble.retry_interval must be positive")
+	}
+	if c.Releases.StatePath == "" {
+		errs = append(errs, "releases.state_path must not be empty")
+	}
+	if c.Releases.WatchInterval <= 0 {
+		errs = append(errs, "releases.watch_interval must be positive")
+	}
+	if c.Webhooks.URL != "" && !isHTTPURL(c.Webhooks.URL) {
+		errs = append(errs, fmt.Sprintf("webhooks.url %q is not a valid url", c.Webhooks.URL))
+	}
+	if c.Webhooks.URL != "" && c.Webhooks.Token == "" {
+		errs = append(errs, "webhooks.token must not be empty when publishing to the webhooks service")
+	}
+	if c.Webhooks.StatePath == "" {
+		errs = append(errs, "webhooks.state_path must not be empty")
+	}
+	if c.Webhooks.MaxAttempts <= 0 {
+		errs = append(errs, "webhooks.max_attempts must be positive")
+	}
+	if c.Webhooks.RetryBackoff <= 0 {
+		errs = append(errs, "webhooks.retry_backoff must be positive")
+	}
+	if c.EventBus.URL != "" && !isNATSURL(c.EventBus.URL) {
+		errs = append(errs, fmt.Sprintf("event_bus.url %q is not a valid nats url", redactURL(c.EventBus.URL)))
+	} else if c.EventBus.URL != "" && !hasCredentials(c.EventBus.URL) {
+		errs = append(errs, fmt.Sprintf("event_bus.url %q must carry the credentials of the nats server, the access tokens of the users go through the bus", c.EventBus.URL))
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("log_level %q is not a valid level", c.LogLevel))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// isHTTPURL returns true when the value is an absolute http(s) url
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isNATSURL tells if the value is a nats url with a host, in plain tcp or tls
+func isNATSURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "nats" || u.Scheme == "tls") && u.Host != ""
+}
+
+// hasCredentials tells if the url carries a user with a password or a token
+func hasCredentials(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.User != nil && u.User.Username() != ""
+}
+
+// Level returns the parsed log level, info is used if the level is invalid
+func (c Config) Level() log.Level {
+	level, err := log.ParseLevel(c.LogLevel)
+	if err != nil {
+		return log.InfoLevel
+	}
+	return level
+}
+
+// Redacted returns a copy of the configuration with its secrets replaced
+func (c Config) Redacted() Config {
+	if c.Spotify.ClientSecret != "" {
+		c.Spotify.ClientSecret = redacted
+	}
+	if c.Scrobble.APISecret != "" {
+		c.Scrobble.APISecret = redacted
+	}
+	if c.Webhooks.Token != "" {
+		c.Webhooks.Token = redacted
+	}
+	c.EventBus.URL = redactURL(c.EventBus.URL)
+	return c
+}
+
+// redactURL replaces the credentials of the url, the value is kept when it is not a url with credentials
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	u.User = nil
+	return strings.Replace(u.String(), "://", "://"+redacted+"@", 1)
+}
+
+// Print writes the redacted configuration as yaml
+func (c Config) Print(w io.Writer) error {
+	content, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
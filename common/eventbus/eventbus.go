@@ -0,0 +1,99 @@
+package eventbus
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// queueSize is the number of events waiting for a subscriber, the next ones are dropped with a warning so the publishers never wait
+const queueSize = 256
+
+// ErrClosed is returned when publishing or subscribing on a closed bus
+var ErrClosed = errors.New("eventbus: closed")
+
+// Handler handles the data of an event published on the topic subscribed to
+type Handler func(data []byte)
+
+// Bus carries the events of the services to the services subscribed to their topic
+// The handlers of a subscription are called one at a time in the order the events were published,
+// the events are dropped for a subscription already having queueSize of them waiting
+type Bus interface {
+	// Publish sends the data to the subscribers of the topic, it does not wait for them to handle it
+	Publish(topic string, data []byte) error
+	// Subscribe calls the handler with the data of each event published on the topic until unsubscribe is called
+	Subscribe(topic string, handler Handler) (unsubscribe func(), err error)
+	// Close stops the subscriptions and releases the bus
+	Close() error
+}
+
+// Open opens the bus at the url: the in-process bus when empty, a nats server for nats://host:port or tls://host:port
+func Open(rawURL string) (Bus, error) {
+	if rawURL == "" {
+		return NewMemory(), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("eventbus: unsupported url %q", u.Redacted())
+	}
+	return DialNATS(rawURL)
+}
+
+// subscriber calls its handler with the events queued for it from its own goroutine
+// so a slow handler does not hold the publisher nor the other subscribers
+type subscriber struct {
+	topic   string
+	handler Handler
+	queue   chan []byte
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// newSubscriber starts the goroutine of the subscriber of the topic
+func newSubscriber(topic string, handler Handler) *subscriber {
+	s := &subscriber{topic: topic, handler: handler, queue: make(chan []byte, queueSize), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// deliver queues the data for the handler, the data is dropped with a warning when the queue is full and silently once stopped
+func (s *subscriber) deliver(data []byte) {
+	select {
+	case <-s.done:
+	case s.queue <- data:
+	default:
+		log.WithField("topic", s.topic).Warn("eventbus: subscriber too slow, event dropped")
+	}
+}
+
+// close stops the subscriber, the events still queued are dropped
+func (s *subscriber) close() {
+	s.stop.Do(func() { close(s.done) })
+}
+
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.queue:
+			call(s.topic, s.handler, data)
+		}
+	}
+}
+
+// call calls the handler of a subscription to the topic, a panic is logged so the subscription goes on with the next events
+func call(topic string, handler Handler, data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("topic", topic).WithField("panic", r).Error("eventbus: handler panicked")
+		}
+	}()
+	handler(data)
+}
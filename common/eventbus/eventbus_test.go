@@ -0,0 +1,207 @@
+package eventbus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"common/eventbus/eventbustest"
+	"common/models"
+)
+
+// receive waits for the next value of the channel
+func receive(t *testing.T, ch <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-ch:
+		return data
+	case <-time.After(time.Second):
+		t.Fatal("nothing received")
+		return nil
+	}
+}
+
+// nothing checks the channel receives nothing for a while
+func nothing(t *testing.T, ch <-chan []byte) {
+	t.Helper()
+	select {
+	case data := <-ch:
+		t.Errorf("received %s, want nothing", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_Memory(t *testing.T) {
+	bus := NewMemory()
+	defer bus.Close()
+	first, second := make(chan []byte, 10), make(chan []byte, 10)
+	unsubscribe, err := bus.Subscribe(TrackChangedTopic, func(data []byte) { first <- data })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	bus.Subscribe(TrackChangedTopic, func(data []byte) { second <- data })
+	bus.Subscribe(UserLoginTopic, func(data []byte) { t.Errorf("the user logins received %s", data) })
+
+	for _, data := range []string{"sunrise", "coffee"} {
+		if err := bus.Publish(TrackChangedTopic, []byte(data)); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+	// each subscriber gets the events in order
+	for _, ch := range []chan []byte{first, second} {
+		if got := string(receive(t, ch)) + "," + string(receive(t, ch)); got != "sunrise,coffee" {
+			t.Errorf("received %s, want sunrise,coffee", got)
+		}
+	}
+
+	unsubscribe()
+	bus.Publish(TrackChangedTopic, []byte("commute"))
+	nothing(t, first)
+	if got := receive(t, second); string(got) != "commute" {
+		t.Errorf("received %s, want commute", got)
+	}
+
+	bus.Close()
+	if err := bus.Publish(TrackChangedTopic, []byte("sunset")); err != ErrClosed {
+		t.Errorf("Publish() on a closed bus error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func Test_Memory_handlerPanics(t *testing.T) {
+	bus := NewMemory()
+	defer bus.Close()
+	received := make(chan []byte, 10)
+	bus.Subscribe(UserLoginTopic, func(data []byte) {
+		if string(data) == "broken" {
+			panic("broken")
+		}
+		received <- data
+	})
+	bus.Publish(UserLoginTopic, []byte("broken"))
+	bus.Publish(UserLoginTopic, []byte("thomas"))
+	if got := receive(t, received); string(got) != "thomas" {
+		t.Errorf("received %s, want the event after the panic", got)
+	}
+}
+
+func Test_typedEvents(t *testing.T) {
+	bus := NewMemory()
+	defer bus.Close()
+	at := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	logins, changes, mutations := make(chan UserLogin, 1), make(chan TrackChanged, 1), make(chan PlaylistMutated, 1)
+	tokens := make(chan TokenValidated, 1)
+	OnUserLogin(bus, func(e UserLogin) { logins <- e })
+	OnTokenValidated(bus, func(e TokenValidated) { tokens <- e })
+	OnTrackChanged(bus, func(e TrackChanged) { changes <- e })
+	OnPlaylistMutated(bus, func(e PlaylistMutated) { mutations <- e })
+	bus.Publish(TrackChangedTopic, []byte("not json"))
+
+	login := UserLogin{UserID: "thomas", Country: "FR", Product: "premium", OccurredAt: at}
+	change := TrackChanged{UserID: "thomas", PreviousTrackID: "sunrise", Player: models.Player{IsPlaying: true, ID: "coffee"}, OccurredAt: at}
+	mutation := PlaylistMutated{UserID: "thomas", PlaylistUpdate: models.PlaylistUpdate{PlaylistID: "morning", SnapshotID: "snapshot-2", Change: models.PlaylistReordered}, OccurredAt: at}
+	token := TokenValidated{UserID: "thomas", Token: "token", OccurredAt: at}
+	for _, e := range []Event{login, token, change, mutation} {
+		if err := Publish(bus, e); err != nil {
+			t.Fatalf("Publish(%T) error = %v", e, err)
+		}
+	}
+
+	select {
+	case got := <-logins:
+		if got != login {
+			t.Errorf("OnUserLogin() got %+v, want %+v", got, login)
+		}
+	case <-time.After(time.Second):
+		t.Error("the user login was not received")
+	}
+	select {
+	case got := <-tokens:
+		if got != token {
+			t.Errorf("OnTokenValidated() got %+v, want %+v", got, token)
+		}
+	case <-time.After(time.Second):
+		t.Error("the validated token was not received")
+	}
+	select {
+	case got := <-changes:
+		if !reflect.DeepEqual(got, change) {
+			t.Errorf("OnTrackChanged() got %+v, want %+v", got, change)
+		}
+	case <-time.After(time.Second):
+		t.Error("the track change was not received")
+	}
+	select {
+	case got := <-mutations:
+		if got.UserID != "thomas" || got.PlaylistID != "morning" || got.Change != models.PlaylistReordered || !got.OccurredAt.Equal(at) {
+			t.Errorf("OnPlaylistMutated() got %+v, want %+v", got, mutation)
+		}
+	case <-time.After(time.Second):
+		t.Error("the playlist mutation was not received")
+	}
+}
+
+func Test_Open(t *testing.T) {
+	broker, err := eventbustest.StartBroker("127.0.0.1:0", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "should open the in-process bus without url", url: ""},
+		{name: "should connect to the nats server", url: broker.URL()},
+		{name: "should refuse another scheme", url: "redis://127.0.0.1:6379", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus, err := Open(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bus != nil {
+				bus.Close()
+			}
+		})
+	}
+}
+
+func Test_Memory_slowSubscriber(t *testing.T) {
+	bus := NewMemory()
+	defer bus.Close()
+	release, received := make(chan struct{}), make(chan []byte, 2*queueSize)
+	bus.Subscribe(TrackChangedTopic, func(data []byte) {
+		<-release
+		received <- data
+	})
+
+	// the publisher does not wait for the subscriber, the events past its queue are dropped
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*queueSize; i++ {
+			bus.Publish(TrackChangedTopic, []byte("sunrise"))
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() waited for the slow subscriber")
+	}
+	close(release)
+	count := 0
+	for {
+		select {
+		case <-received:
+			count++
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if count == 0 || count > queueSize+1 {
+		t.Errorf("the slow subscriber received %d events, want between 1 and %d", count, queueSize+1)
+	}
+}
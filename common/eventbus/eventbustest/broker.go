@@ -0,0 +1,245 @@
+// Package eventbustest provides a local nats broker for the tests of the services using the event bus
+package eventbustest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPayload is the largest event accepted by the local broker
+	maxPayload = 1 << 20
+	// writeTimeout bounds each write to a client of the local broker, a client not reading in time is closed
+	writeTimeout = 5 * time.Second
+)
+
+// Broker is a local broker speaking the core nats protocol (PUB, SUB, UNSUB, PING), it replaces a nats server in the tests
+// so they do not need the nats-server binary nor its module; it listens in plain tcp and is never linked in the services.
+// The subjects are matched like nats does: * matches a token and > the remaining ones; there is no queue group nor header
+// Like the nats server of docker-compose, the clients must connect with the user and the password given, when not empty
+type Broker struct {
+	ln       net.Listener
+	user     string
+	password string
+
+	mu      sync.Mutex
+	clients map[*brokerClient]bool
+	closed  bool
+}
+
+// brokerClient is a connection to the broker with its subscriptions by sid
+type brokerClient struct {
+	conn net.Conn
+
+	mu   sync.Mutex
+	w    *bufio.Writer
+	subs map[string]string
+}
+
+// StartBroker listens on addr and serves the connections in the background until closed
+// The clients must connect with the user and the password, any client is accepted when the user is empty
+func StartBroker(addr, user, password string) (*Broker, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{ln: ln, user: user, password: password, clients: map[*brokerClient]bool{}}
+	go b.serve()
+	return b, nil
+}
+
+// Addr returns the address the broker listens on
+func (b *Broker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+// URL returns the url of the broker to open the bus with, along with its credentials
+func (b *Broker) URL() string {
+	if b.user == "" {
+		return "nats://" + b.Addr()
+	}
+	return "nats://" + b.user + ":" + b.password + "@" + b.Addr()
+}
+
+// Close stops listening and closes every connection
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	for c := range b.clients {
+		c.conn.Close()
+	}
+	b.mu.Unlock()
+	return b.ln.Close()
+}
+
+func (b *Broker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &brokerClient{conn: conn, w: bufio.NewWriter(conn), subs: map[string]string{}}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.clients[c] = true
+		b.mu.Unlock()
+		go b.handle(c)
+	}
+}
+
+// send writes a command to the client, a client not reading it in time is closed
+func (c *brokerClient) send(format string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	fmt.Fprintf(c.w, format, args...)
+	return c.w.Flush()
+}
+
+// handle reads the commands of the client until it disconnects or breaks the protocol
+func (b *Broker) handle(c *brokerClient) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+	if err := c.send("INFO {\"server_id\":\"local\",\"version\":\"local\",\"proto\":0,\"max_payload\":%d,\"auth_required\":%t}\r\n", maxPayload, b.user != ""); err != nil {
+		return
+	}
+	authorized := b.user == ""
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		command := strings.ToUpper(fields[0])
+		if !authorized && command != "CONNECT" {
+			c.send("-ERR 'Authorization Violation'\r\n")
+			return
+		}
+		switch command {
+		case "CONNECT":
+			// CONNECT <json options>, the credentials are checked once
+			if !authorized {
+				var options struct {
+					User     string `json:"user"`
+					Password string `json:"pass"`
+				}
+				json.Unmarshal([]byte(strings.TrimSpace(line[len(fields[0]):])), &options)
+				if options.User != b.user || options.Password != b.password {
+					c.send("-ERR 'Authorization Violation'\r\n")
+					return
+				}
+				authorized = true
+			}
+		case "PONG":
+		case "PING":
+			if err := c.send("PONG\r\n"); err != nil {
+				return
+			}
+		case "SUB":
+			// SUB <subject> [queue group] <sid>
+			if len(fields) != 3 && len(fields) != 4 {
+				c.send("-ERR 'Invalid Subscription'\r\n")
+				return
+			}
+			c.mu.Lock()
+			c.subs[fields[len(fields)-1]] = fields[1]
+			c.mu.Unlock()
+		case "UNSUB":
+			// UNSUB <sid> [max msgs], the maximum is not supported and the subscription is removed right away
+			if len(fields) < 2 {
+				c.send("-ERR 'Invalid Unsubscribe'\r\n")
+				return
+			}
+			c.mu.Lock()
+			delete(c.subs, fields[1])
+			c.mu.Unlock()
+		case "PUB":
+			// PUB <subject> [reply-to] <#bytes>
+			size := -1
+			if len(fields) == 3 || len(fields) == 4 {
+				size, _ = strconv.Atoi(fields[len(fields)-1])
+			}
+			if size < 0 || size > maxPayload {
+				c.send("-ERR 'Invalid Publish'\r\n")
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			b.route(fields[1], payload[:size])
+		default:
+			c.send("-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+// route sends the payload to every subscription matching the subject
+func (b *Broker) route(subject string, payload []byte) {
+	b.mu.Lock()
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+	for _, c := range clients {
+		c.mu.Lock()
+		var sids []string
+		for sid, pattern := range c.subs {
+			if subjectMatches(pattern, subject) {
+				sids = append(sids, sid)
+			}
+		}
+		c.mu.Unlock()
+		for _, sid := range sids {
+			if err := c.send("MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload); err != nil {
+				c.conn.Close()
+				break
+			}
+		}
+	}
+}
+
+// readLine reads a line of the protocol without its \r\n
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// subjectMatches tells if the subject matches the pattern of a subscription, tokens are separated by dots
+func subjectMatches(pattern, subject string) bool {
+	patterns := strings.Split(pattern, ".")
+	tokens := strings.Split(subject, ".")
+	for i, p := range patterns {
+		if p == ">" {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(patterns) == len(tokens)
+}
@@ -0,0 +1,25 @@
+package eventbustest
+
+import "testing"
+
+func Test_subjectMatches(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		subject  string
+		expected bool
+	}{
+		{pattern: "player.track_changed", subject: "player.track_changed", expected: true},
+		{pattern: "player.track_changed", subject: "player.paused"},
+		{pattern: "player.*", subject: "player.track_changed", expected: true},
+		{pattern: "player.*", subject: "player"},
+		{pattern: "*.mutated", subject: "playlist.mutated", expected: true},
+		{pattern: ">", subject: "user.login", expected: true},
+		{pattern: "player.>", subject: "player.track_changed.v2", expected: true},
+		{pattern: "player.>", subject: "player"},
+	}
+	for _, tt := range tests {
+		if got := subjectMatches(tt.pattern, tt.subject); got != tt.expected {
+			t.Errorf("subjectMatches(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.expected)
+		}
+	}
+}
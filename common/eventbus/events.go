@@ -0,0 +1,145 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"time"
+
+	"common/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// The topics of the events, they are nats subjects
+const (
+	UserLoginTopic       = "user.login"
+	TokenValidatedTopic  = "user.token_validated"
+	TrackChangedTopic    = "player.track_changed"
+	PlaylistMutatedTopic = "playlist.mutated"
+)
+
+// Event is an event of the services with its topic
+type Event interface {
+	Topic() string
+}
+
+// UserLogin is published by the gateway the first time it validates an access token, i.e. on a new session of the user
+type UserLogin struct {
+	UserID     string    `json:"user_id"`
+	Country    string    `json:"country"`
+	Product    string    `json:"product"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Topic returns the topic of the user logins
+func (UserLogin) Topic() string { return UserLoginTopic }
+
+// TokenValidated is published by the gateway each time it validates an access token against spotify: on a new session
+// and once the validation it cached expired, so the services working for the user in the background keep a valid token
+// It carries a credential, the nats server only accepts the services with their credentials (event_bus.url)
+type TokenValidated struct {
+	UserID     string    `json:"user_id"`
+	Token      string    `json:"token"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Topic returns the topic of the validated access tokens
+func (TokenValidated) Topic() string { return TokenValidatedTopic }
+
+// TrackChanged is published by the player service when the player of a user plays another track
+// It is published once per change, by the poller of the player of the user and not by its event streams
+type TrackChanged struct {
+	UserID          string        `json:"user_id"`
+	PreviousTrackID spotify.ID    `json:"previous_track_id"`
+	Player          models.Player `json:"player"`
+	OccurredAt      time.Time     `json:"occurred_at"`
+}
+
+// Topic returns the topic of the track changes
+func (TrackChanged) Topic() string { return TrackChangedTopic }
+
+// PlaylistMutated is published when a service creates, fills or reorders a playlist of a user
+type PlaylistMutated struct {
+	UserID string `json:"user_id"`
+	models.PlaylistUpdate
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Topic returns the topic of the playlist mutations
+func (PlaylistMutated) Topic() string { return PlaylistMutatedTopic }
+
+// Publish publishes the event as json on its topic, the time it occurred at is set by the caller
+// A nil bus publishes nothing
+func Publish(bus Bus, event Event) error {
+	if bus == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return bus.Publish(event.Topic(), data)
+}
+
+// Go publishes the event and only logs a failure, the events are a side effect the caller does not wait for
+func Go(bus Bus, event Event) {
+	if err := Publish(bus, event); err != nil {
+		log.WithError(err).WithField("topic", event.Topic()).Error("eventbus: could not publish event")
+	}
+}
+
+// subscribe subscribes to the topic and decodes each event with decode, the events that cannot be decoded are logged and skipped
+func subscribe(bus Bus, topic string, decode func(data []byte) error) (func(), error) {
+	return bus.Subscribe(topic, func(data []byte) {
+		if err := decode(data); err != nil {
+			log.WithError(err).WithField("topic", topic).Error("eventbus: could not decode event")
+		}
+	})
+}
+
+// OnUserLogin calls handle with each user login
+func OnUserLogin(bus Bus, handle func(UserLogin)) (func(), error) {
+	return subscribe(bus, UserLoginTopic, func(data []byte) error {
+		var event UserLogin
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		handle(event)
+		return nil
+	})
+}
+
+// OnTokenValidated calls handle with each access token validated by the gateway
+func OnTokenValidated(bus Bus, handle func(TokenValidated)) (func(), error) {
+	return subscribe(bus, TokenValidatedTopic, func(data []byte) error {
+		var event TokenValidated
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		handle(event)
+		return nil
+	})
+}
+
+// OnTrackChanged calls handle with each track change
+func OnTrackChanged(bus Bus, handle func(TrackChanged)) (func(), error) {
+	return subscribe(bus, TrackChangedTopic, func(data []byte) error {
+		var event TrackChanged
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		handle(event)
+		return nil
+	})
+}
+
+// OnPlaylistMutated calls handle with each playlist mutation
+func OnPlaylistMutated(bus Bus, handle func(PlaylistMutated)) (func(), error) {
+	return subscribe(bus, PlaylistMutatedTopic, func(data []byte) error {
+		var event PlaylistMutated
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		handle(event)
+		return nil
+	})
+}
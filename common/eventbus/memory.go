@@ -0,0 +1,69 @@
+package eventbus
+
+import "sync"
+
+// Memory is the in-process bus, the events only reach the subscribers of the same process
+type Memory struct {
+	mu     sync.Mutex
+	subs   map[string][]*subscriber
+	closed bool
+}
+
+// NewMemory creates an in-process bus without subscriptions
+func NewMemory() *Memory {
+	return &Memory{subs: map[string][]*subscriber{}}
+}
+
+// Publish queues a copy of the data for each subscriber of the topic
+func (m *Memory) Publish(topic string, data []byte) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	subs := append([]*subscriber{}, m.subs[topic]...)
+	m.mu.Unlock()
+	for _, s := range subs {
+		s.deliver(append([]byte{}, data...))
+	}
+	return nil
+}
+
+// Subscribe adds a subscriber of the topic
+func (m *Memory) Subscribe(topic string, handler Handler) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	s := newSubscriber(topic, handler)
+	m.subs[topic] = append(m.subs[topic], s)
+	return func() { m.unsubscribe(s) }, nil
+}
+
+func (m *Memory) unsubscribe(s *subscriber) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs := m.subs[s.topic]
+	for i := range subs {
+		if subs[i] == s {
+			m.subs[s.topic] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	s.close()
+}
+
+// Close stops every subscriber
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, subs := range m.subs {
+		for _, s := range subs {
+			s.close()
+		}
+	}
+	m.subs = map[string][]*subscriber{}
+	return nil
+}
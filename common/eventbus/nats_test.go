@@ -0,0 +1,114 @@
+package eventbus
+
+import (
+	"testing"
+	"time"
+
+	"common/eventbus/eventbustest"
+)
+
+func Test_NATS(t *testing.T) {
+	broker, err := eventbustest.StartBroker("127.0.0.1:0", "services", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	publisher, err := DialNATS(broker.URL())
+	if err != nil {
+		t.Fatalf("DialNATS() error = %v", err)
+	}
+	defer publisher.Close()
+	subscriber, err := DialNATS(broker.URL())
+	if err != nil {
+		t.Fatalf("DialNATS() error = %v", err)
+	}
+	defer subscriber.Close()
+
+	changes, every := make(chan []byte, 10), make(chan []byte, 10)
+	unsubscribe, err := subscriber.Subscribe(TrackChangedTopic, func(data []byte) { changes <- data })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	subscriber.Subscribe("player.*", func(data []byte) { every <- data })
+	if err := subscriber.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if err := publisher.Publish(TrackChangedTopic, []byte("line one\r\nline two")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := receive(t, changes); string(got) != "line one\r\nline two" {
+		t.Errorf("received %q, want the payload as published", got)
+	}
+	if got := receive(t, every); string(got) != "line one\r\nline two" {
+		t.Errorf("the wildcard subscription received %q", got)
+	}
+	publisher.Publish(UserLoginTopic, []byte("thomas"))
+	nothing(t, changes)
+
+	unsubscribe()
+	subscriber.Flush()
+	publisher.Publish(TrackChangedTopic, []byte("coffee"))
+	nothing(t, changes)
+	if got := receive(t, every); string(got) != "coffee" {
+		t.Errorf("received %q, want coffee", got)
+	}
+
+	if err := publisher.Publish("player track", nil); err == nil {
+		t.Error("Publish() of a topic with a space error = nil")
+	}
+}
+
+func Test_NATS_credentials(t *testing.T) {
+	broker, err := eventbustest.StartBroker("127.0.0.1:0", "services", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	for _, url := range []string{"nats://" + broker.Addr(), "nats://services:guess@" + broker.Addr()} {
+		if bus, err := DialNATS(url); err == nil {
+			bus.Close()
+			t.Errorf("DialNATS(%s) error = nil, want the connection refused", url)
+		}
+	}
+}
+
+func Test_NATS_reconnects(t *testing.T) {
+	broker, err := eventbustest.StartBroker("127.0.0.1:0", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := broker.Addr()
+	bus, err := DialNATS(broker.URL())
+	if err != nil {
+		t.Fatalf("DialNATS() error = %v", err)
+	}
+	defer bus.Close()
+	received := make(chan []byte, 10)
+	bus.Subscribe(PlaylistMutatedTopic, func(data []byte) { received <- data })
+
+	// the broker restarts on the same address, the subscription is made again once connected
+	broker.Close()
+	broker, err = eventbustest.StartBroker(addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := bus.Publish(PlaylistMutatedTopic, []byte("morning")); err == nil {
+			select {
+			case data := <-received:
+				if string(data) != "morning" {
+					t.Errorf("received %s, want morning", data)
+				}
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the subscription was not made again")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,1289 @@
+package fakespotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+// State is the scriptable state of the fake spotify API
+type State struct {
+	// Tokens are the accepted access tokens, any non empty token is accepted when empty
+	Tokens []string `json:"tokens"`
+	// User is the current user returned by /me
+	User spotify.PrivateUser `json:"user"`
+	// Users are the public profiles returned by /users/{id}
+	Users map[string]spotify.User `json:"users"`
+	// Playlists are the playlists of the current user
+	Playlists []spotify.SimplePlaylist `json:"playlists"`
+	// Tracks are the tracks of each playlist by playlist URI, used to fill the queue when a playlist is played
+	// They are also the catalog searched and the tracks of the albums and the artists
+	Tracks map[spotify.URI][]spotify.FullTrack `json:"tracks"`
+	// Shows are the podcast shows found by the search
+	Shows []spotify.SimpleShow `json:"shows"`
+	// Genres are the genres of the artists of the catalog by artist ID
+	Genres map[spotify.ID][]string `json:"genres"`
+	// Player is the current player, nothing is playing when its item is nil
+	Player spotify.PlayerState `json:"player"`
+	// Queue are the tracks played by next
+	Queue []spotify.FullTrack `json:"queue"`
+	// History are the tracks played by previous, the last one being the most recent
+	History []spotify.FullTrack `json:"history"`
+	// SavedTracks are the tracks of the library of the user, the most recently saved first
+	SavedTracks []spotify.SavedTrack `json:"saved_tracks"`
+	// SavedAlbums are the albums of the library of the user, the most recently saved first
+	SavedAlbums []spotify.SavedAlbum `json:"saved_albums"`
+	// FollowedArtists are the artists followed by the user
+	FollowedArtists []spotify.FullArtist `json:"followed_artists"`
+	// RecentlyPlayed are the tracks played by the user, the most recent first
+	// The player adds the tracks it leaves for another one
+	RecentlyPlayed []spotify.RecentlyPlayedItem `json:"recently_played"`
+}
+
+// Failure makes the next matching request fail with the given status
+type Failure struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// Request is a request received by the fake API
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query"`
+	Body   string `json:"body"`
+}
+
+// Server is a fake spotify API emulating the endpoints used by the services
+// The API is served under /v1/ and its state can be scripted under /_fake/
+type Server struct {
+	mu       sync.Mutex
+	state    State
+	failures []Failure
+	requests []Request
+	router   *mux.Router
+}
+
+// New creates a fake spotify API with the given initial state
+func New(state State) *Server {
+	s := &Server{state: state}
+
+	r := mux.NewRouter()
+	api := r.PathPrefix("/v1").Subrouter()
+	api.Use(s.recordMiddleware, s.failureMiddleware, s.authMiddleware)
+	api.HandleFunc("/me", s.currentUserHandler).Methods("GET")
+	api.HandleFunc("/users/{userID}", s.userHandler).Methods("GET")
+	api.HandleFunc("/me/playlists", s.playlistsHandler).Methods("GET")
+	api.HandleFunc("/playlists/{playlistID}", s.playlistHandler).Methods("GET")
+	api.HandleFunc("/playlists/{playlistID}/tracks", s.playlistTracksHandler).Methods("GET")
+	api.HandleFunc("/playlists/{playlistID}/tracks", s.addPlaylistTracksHandler).Methods("POST")
+	api.HandleFunc("/playlists/{playlistID}/tracks", s.reorderPlaylistTracksHandler).Methods("PUT")
+	api.HandleFunc("/users/{userID}/playlists", s.createPlaylistHandler).Methods("POST")
+	api.HandleFunc("/search", s.searchHandler).Methods("GET")
+	api.HandleFunc("/tracks", s.tracksHandler).Methods("GET")
+	api.HandleFunc("/artists", s.artistsHandler).Methods("GET")
+	api.HandleFunc("/artists/{artistID}", s.artistHandler).Methods("GET")
+	api.HandleFunc("/artists/{artistID}/top-tracks", s.artistTopTracksHandler).Methods("GET")
+	api.HandleFunc("/artists/{artistID}/related-artists", s.relatedArtistsHandler).Methods("GET")
+	api.HandleFunc("/artists/{artistID}/albums", s.artistAlbumsHandler).Methods("GET")
+	api.HandleFunc("/albums/{albumID}", s.albumHandler).Methods("GET")
+	api.HandleFunc("/albums/{albumID}/tracks", s.albumTracksHandler).Methods("GET")
+	api.HandleFunc("/audio-features", s.audioFeaturesHandler).Methods("GET")
+	api.HandleFunc("/recommendations", s.recommendationsHandler).Methods("GET")
+	api.HandleFunc("/me/top/tracks", s.topTracksHandler).Methods("GET")
+	api.HandleFunc("/me/tracks", s.savedTracksHandler).Methods("GET")
+	api.HandleFunc("/me/tracks", s.saveTracksHandler).Methods("PUT")
+	api.HandleFunc("/me/tracks", s.removeTracksHandler).Methods("DELETE")
+	api.HandleFunc("/me/tracks/contains", s.containsTracksHandler).Methods("GET")
+	api.HandleFunc("/me/albums", s.savedAlbumsHandler).Methods("GET")
+	api.HandleFunc("/me/following", s.followedArtistsHandler).Methods("GET")
+	api.HandleFunc("/me/player", s.playerStateHandler).Methods("GET")
+	api.HandleFunc("/me/player/currently-playing", s.currentlyPlayingHandler).Methods("GET")
+	api.HandleFunc("/me/player/devices", s.devicesHandler).Methods("GET")
+	api.HandleFunc("/me/player/recently-played", s.recentlyPlayedHandler).Methods("GET")
+	api.HandleFunc("/me/player/play", s.playHandler).Methods("PUT")
+	api.HandleFunc("/me/player/pause", s.pauseHandler).Methods("PUT")
+	api.HandleFunc("/me/player/next", s.nextHandler).Methods("POST")
+	api.HandleFunc("/me/player/previous", s.previousHandler).Methods("POST")
+
+	r.HandleFunc("/_fake/state", s.getStateHandler).Methods("GET")
+	r.HandleFunc("/_fake/state", s.setStateHandler).Methods("PUT")
+	r.HandleFunc("/_fake/failures", s.addFailureHandler).Methods("POST")
+	r.HandleFunc("/_fake/requests", s.requestsHandler).Methods("GET")
+	s.router = r
+	return s
+}
+
+// ServeHTTP serves the fake API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// State returns a shallow copy of the current state
+func (s *Server) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// SetState replaces the current state
+func (s *Server) SetState(state State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+}
+
+// Update changes the current state with the given function
+func (s *Server) Update(update func(state *State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.state)
+}
+
+// Fail makes the next request matching the method and the path (without /v1) fail with the status
+func (s *Server) Fail(method, path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, Failure{Method: method, Path: path, Status: status})
+}
+
+// Requests returns the API requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// writeError writes an error the same way the spotify API does
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"status": status, "message": message},
+	})
+}
+
+// writeJSON writes the value as json
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// recordMiddleware keeps track of every API request
+func (s *Server) recordMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   strings.TrimPrefix(r.URL.Path, "/v1"),
+			Query:  r.URL.RawQuery,
+			Body:   string(body),
+		})
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// failureMiddleware fails the request if a failure has been scripted for it
+func (s *Server) failureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1")
+		s.mu.Lock()
+		for i, f := range s.failures {
+			if strings.EqualFold(f.Method, r.Method) && f.Path == path {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+				s.mu.Unlock()
+				writeError(w, f.Status, "scripted failure")
+				return
+			}
+		}
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authMiddleware rejects the requests without a valid bearer token
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			writeError(w, http.StatusUnauthorized, "No token provided")
+			return
+		}
+
+		s.mu.Lock()
+		valid := len(s.state.Tokens) == 0
+		for _, t := range s.state.Tokens {
+			if t == token {
+				valid = true
+			}
+		}
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentUserHandler serves GET /me
+func (s *Server) currentUserHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.State().User)
+}
+
+// userHandler serves GET /users/{userID}
+func (s *Server) userHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.State().Users[mux.Vars(r)["userID"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such user")
+		return
+	}
+	writeJSON(w, user)
+}
+
+// pageBounds returns the bounds of the requested page from the limit and offset parameters
+// The url of the next page is empty when the page is the last one
+func pageBounds(r *http.Request, total, defaultLimit int) (offset, end, limit int, next string) {
+	limit = defaultLimit
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v > 0 {
+		offset = v
+	}
+	if offset > total {
+		offset = total
+	}
+	end = offset + limit
+	if end > total {
+		end = total
+	}
+	if end < total {
+		next = fmt.Sprintf("http://%s%s?offset=%d&limit=%d", r.Host, r.URL.Path, end, limit)
+	}
+	return offset, end, limit, next
+}
+
+// playlistsHandler serves GET /me/playlists with the limit and offset parameters
+func (s *Server) playlistsHandler(w http.ResponseWriter, r *http.Request) {
+	playlists := s.State().Playlists
+	offset, end, limit, next := pageBounds(r, len(playlists), 20)
+
+	page := spotify.SimplePlaylistPage{Playlists: append([]spotify.SimplePlaylist{}, playlists[offset:end]...)}
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(playlists)
+	page.Next = next
+	writeJSON(w, page)
+}
+
+// findPlaylist returns the playlist with its tracks
+func (s *Server) findPlaylist(id string) (spotify.SimplePlaylist, []spotify.FullTrack, bool) {
+	state := s.State()
+	for _, p := range state.Playlists {
+		if string(p.ID) == id {
+			return p, state.Tracks[p.URI], true
+		}
+	}
+	return spotify.SimplePlaylist{}, nil, false
+}
+
+// playlistTrackPage returns the requested page of the tracks of a playlist
+func playlistTrackPage(r *http.Request, owner spotify.User, tracks []spotify.FullTrack) spotify.PlaylistTrackPage {
+	offset, end, limit, next := pageBounds(r, len(tracks), 100)
+
+	page := spotify.PlaylistTrackPage{Tracks: []spotify.PlaylistTrack{}}
+	for _, t := range tracks[offset:end] {
+		page.Tracks = append(page.Tracks, spotify.PlaylistTrack{AddedAt: "2020-12-15T00:00:00Z", AddedBy: owner, Track: t})
+	}
+	page.Limit = limit
+	page.Offset = offset
+	page.Total = len(tracks)
+	page.Next = next
+	return page
+}
+
+// catalog returns every track of the playlists once, in the order of the playlists
+func (state State) catalog() []spotify.FullTrack {
+	var tracks []spotify.FullTrack
+	seen := map[spotify.ID]bool{}
+	for _, p := range state.Playlists {
+		for _, t := range state.Tracks[p.URI] {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				tracks = append(tracks, t)
+			}
+		}
+	}
+	return tracks
+}
+
+// contextTracks returns the tracks of a playlist, an album or an artist of the catalog
+func (state State) contextTracks(uri spotify.URI) []spotify.FullTrack {
+	if tracks, ok := state.Tracks[uri]; ok {
+		return tracks
+	}
+	var tracks []spotify.FullTrack
+	for _, t := range state.catalog() {
+		if t.Album.URI == uri {
+			tracks = append(tracks, t)
+			continue
+		}
+		for _, a := range t.Artists {
+			if a.URI == uri {
+				tracks = append(tracks, t)
+				break
+			}
+		}
+	}
+	return tracks
+}
+
+// searchHandler serves GET /search, the items whose name contains the query are found
+// The tracks, albums and artists are searched in the catalog, the playlists are the ones of the user
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "No search query")
+		return
+	}
+	matches := func(name string) bool { return strings.Contains(strings.ToLower(name), query) }
+	state := s.State()
+
+	result := map[string]interface{}{}
+	for _, t := range strings.Split(r.URL.Query().Get("type"), ",") {
+		switch t {
+		case "track":
+			var found []spotify.FullTrack
+			for _, track := range state.catalog() {
+				if matches(track.Name) {
+					found = append(found, track)
+				}
+			}
+			offset, end, limit, next := pageBounds(r, len(found), 20)
+			page := spotify.FullTrackPage{Tracks: append([]spotify.FullTrack{}, found[offset:end]...)}
+			page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(found), next
+			result["tracks"] = page
+		case "album":
+			var found []spotify.SimpleAlbum
+			seen := map[spotify.ID]bool{}
+			for _, track := range state.catalog() {
+				if !seen[track.Album.ID] && matches(track.Album.Name) {
+					seen[track.Album.ID] = true
+					found = append(found, track.Album)
+				}
+			}
+			offset, end, limit, next := pageBounds(r, len(found), 20)
+			page := spotify.SimpleAlbumPage{Albums: append([]spotify.SimpleAlbum{}, found[offset:end]...)}
+			page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(found), next
+			result["albums"] = page
+		case "artist":
+			var found []spotify.FullArtist
+			seen := map[spotify.ID]bool{}
+			for _, track := range state.catalog() {
+				for _, a := range track.Artists {
+					if !seen[a.ID] && matches(a.Name) {
+						seen[a.ID] = true
+						found = append(found, spotify.FullArtist{SimpleArtist: a})
+					}
+				}
+			}
+			offset, end, limit, next := pageBounds(r, len(found), 20)
+			page := spotify.FullArtistPage{Artists: append([]spotify.FullArtist{}, found[offset:end]...)}
+			page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(found), next
+			result["artists"] = page
+		case "playlist":
+			var found []spotify.SimplePlaylist
+			for _, p := range state.Playlists {
+				if matches(p.Name) {
+					found = append(found, p)
+				}
+			}
+			offset, end, limit, next := pageBounds(r, len(found), 20)
+			page := spotify.SimplePlaylistPage{Playlists: append([]spotify.SimplePlaylist{}, found[offset:end]...)}
+			page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(found), next
+			result["playlists"] = page
+		case "show":
+			var found []spotify.SimpleShow
+			for _, show := range state.Shows {
+				if matches(show.Name) {
+					found = append(found, show)
+				}
+			}
+			offset, end, limit, next := pageBounds(r, len(found), 20)
+			result["shows"] = map[string]interface{}{
+				"items":  append([]spotify.SimpleShow{}, found[offset:end]...),
+				"limit":  limit,
+				"offset": offset,
+				"total":  len(found),
+				"next":   next,
+			}
+		default:
+			writeError(w, http.StatusBadRequest, "Bad search type field "+t)
+			return
+		}
+	}
+	writeJSON(w, result)
+}
+
+// tracksHandler serves GET /tracks, the unknown tracks are null like in spotify
+func (s *Server) tracksHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := trackIDs(r, 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+	catalog := map[spotify.ID]spotify.FullTrack{}
+	for _, t := range s.State().catalog() {
+		catalog[t.ID] = t
+	}
+	tracks := []*spotify.FullTrack{}
+	for _, id := range ids {
+		if t, ok := catalog[id]; ok {
+			tracks = append(tracks, &t)
+		} else {
+			tracks = append(tracks, nil)
+		}
+	}
+	writeJSON(w, map[string][]*spotify.FullTrack{"tracks": tracks})
+}
+
+// artistsHandler serves GET /artists, the artists are the ones of the catalog with their genres
+// The unknown artists are null like in spotify
+func (s *Server) artistsHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := trackIDs(r, 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+	state := s.State()
+	catalog := map[spotify.ID]spotify.SimpleArtist{}
+	for _, t := range state.catalog() {
+		for _, a := range t.Artists {
+			catalog[a.ID] = a
+		}
+	}
+	artists := []*spotify.FullArtist{}
+	for _, id := range ids {
+		a, ok := catalog[id]
+		if !ok {
+			artists = append(artists, nil)
+			continue
+		}
+		genres := state.Genres[id]
+		if genres == nil {
+			genres = []string{}
+		}
+		artists = append(artists, &spotify.FullArtist{SimpleArtist: a, Genres: genres})
+	}
+	writeJSON(w, map[string][]*spotify.FullArtist{"artists": artists})
+}
+
+// fakeLabel is the label of every album of the catalog
+const fakeLabel = "Fake Records"
+
+// catalogArtist returns the artist of the catalog with its genres
+func (state State) catalogArtist(id spotify.ID) (spotify.FullArtist, bool) {
+	for _, t := range state.catalog() {
+		for _, a := range t.Artists {
+			if a.ID != id {
+				continue
+			}
+			genres := state.Genres[id]
+			if genres == nil {
+				genres = []string{}
+			}
+			return spotify.FullArtist{SimpleArtist: a, Genres: genres}, true
+		}
+	}
+	return spotify.FullArtist{}, false
+}
+
+// catalogAlbum returns the album with its type, the albums of the catalog are albums unless told otherwise
+func catalogAlbum(album spotify.SimpleAlbum) spotify.SimpleAlbum {
+	if album.AlbumType == "" {
+		album.AlbumType = "album"
+	}
+	album.AlbumGroup = album.AlbumType
+	return album
+}
+
+// artistHandler serves GET /artists/{artistID}
+func (s *Server) artistHandler(w http.ResponseWriter, r *http.Request) {
+	artist, ok := s.State().catalogArtist(spotify.ID(mux.Vars(r)["artistID"]))
+	if !ok {
+		writeError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	writeJSON(w, artist)
+}
+
+// artistTopTracksHandler serves GET /artists/{artistID}/top-tracks, the top tracks are the first ten of the catalog
+func (s *Server) artistTopTracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("country") == "" {
+		writeError(w, http.StatusBadRequest, "Missing country parameter")
+		return
+	}
+	state := s.State()
+	artist, ok := state.catalogArtist(spotify.ID(mux.Vars(r)["artistID"]))
+	if !ok {
+		writeError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	tracks := []spotify.FullTrack{}
+	for _, t := range state.contextTracks(artist.URI) {
+		if len(tracks) < 10 {
+			tracks = append(tracks, t)
+		}
+	}
+	writeJSON(w, map[string][]spotify.FullTrack{"tracks": tracks})
+}
+
+// relatedArtistsHandler serves GET /artists/{artistID}/related-artists, the related artists share a genre with the artist
+func (s *Server) relatedArtistsHandler(w http.ResponseWriter, r *http.Request) {
+	state := s.State()
+	artist, ok := state.catalogArtist(spotify.ID(mux.Vars(r)["artistID"]))
+	if !ok {
+		writeError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	genres := map[string]bool{}
+	for _, g := range artist.Genres {
+		genres[g] = true
+	}
+	related := []spotify.FullArtist{}
+	seen := map[spotify.ID]bool{artist.ID: true}
+	for _, t := range state.catalog() {
+		for _, a := range t.Artists {
+			if seen[a.ID] {
+				continue
+			}
+			seen[a.ID] = true
+			for _, g := range state.Genres[a.ID] {
+				if genres[g] {
+					other, _ := state.catalogArtist(a.ID)
+					related = append(related, other)
+					break
+				}
+			}
+		}
+	}
+	writeJSON(w, map[string][]spotify.FullArtist{"artists": related})
+}
+
+// artistAlbumsHandler serves GET /artists/{artistID}/albums with the include_groups, limit and offset parameters
+func (s *Server) artistAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	state := s.State()
+	artist, ok := state.catalogArtist(spotify.ID(mux.Vars(r)["artistID"]))
+	if !ok {
+		writeError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	groups := map[string]bool{}
+	for _, g := range strings.Split(r.URL.Query().Get("include_groups"), ",") {
+		if g != "" {
+			groups[g] = true
+		}
+	}
+	var albums []spotify.SimpleAlbum
+	seen := map[spotify.ID]bool{}
+	for _, t := range state.contextTracks(artist.URI) {
+		album := catalogAlbum(t.Album)
+		if seen[album.ID] || len(groups) > 0 && !groups[album.AlbumGroup] {
+			continue
+		}
+		seen[album.ID] = true
+		albums = append(albums, album)
+	}
+	offset, end, limit, next := pageBounds(r, len(albums), 20)
+
+	page := spotify.SimpleAlbumPage{Albums: append([]spotify.SimpleAlbum{}, albums[offset:end]...)}
+	page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(albums), next
+	writeJSON(w, page)
+}
+
+// albumTracks returns the album of the catalog with its tracks
+func (state State) albumTracks(id spotify.ID) (spotify.SimpleAlbum, []spotify.SimpleTrack, bool) {
+	var album spotify.SimpleAlbum
+	var tracks []spotify.SimpleTrack
+	for _, t := range state.catalog() {
+		if t.Album.ID == id {
+			album = catalogAlbum(t.Album)
+			tracks = append(tracks, t.SimpleTrack)
+		}
+	}
+	return album, tracks, len(tracks) > 0
+}
+
+// albumHandler serves GET /albums/{albumID} with the label spotify gives and the first 50 tracks
+func (s *Server) albumHandler(w http.ResponseWriter, r *http.Request) {
+	album, tracks, ok := s.State().albumTracks(spotify.ID(mux.Vars(r)["albumID"]))
+	if !ok {
+		writeError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	end := len(tracks)
+	if end > 50 {
+		end = 50
+	}
+	full := struct {
+		spotify.FullAlbum
+		Label string `json:"label"`
+	}{FullAlbum: spotify.FullAlbum{SimpleAlbum: album}, Label: fakeLabel}
+	full.Tracks.Tracks = tracks[:end]
+	full.Tracks.Limit, full.Tracks.Total = 50, len(tracks)
+	writeJSON(w, full)
+}
+
+// albumTracksHandler serves GET /albums/{albumID}/tracks with the limit and offset parameters
+func (s *Server) albumTracksHandler(w http.ResponseWriter, r *http.Request) {
+	_, tracks, ok := s.State().albumTracks(spotify.ID(mux.Vars(r)["albumID"]))
+	if !ok {
+		writeError(w, http.StatusNotFound, "non existing id")
+		return
+	}
+	offset, end, limit, next := pageBounds(r, len(tracks), 20)
+
+	page := spotify.SimpleTrackPage{Tracks: append([]spotify.SimpleTrack{}, tracks[offset:end]...)}
+	page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(tracks), next
+	writeJSON(w, page)
+}
+
+// audioFeaturesHandler serves GET /audio-features for up to 100 tracks, the unknown tracks are null like in spotify
+func (s *Server) audioFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := trackIDs(r, 100)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+	known := map[spotify.ID]bool{}
+	for _, t := range s.State().catalog() {
+		known[t.ID] = true
+	}
+	features := []*spotify.AudioFeatures{}
+	for _, id := range ids {
+		if known[id] {
+			f := audioFeatures(id)
+			features = append(features, &f)
+		} else {
+			features = append(features, nil)
+		}
+	}
+	writeJSON(w, map[string][]*spotify.AudioFeatures{"audio_features": features})
+}
+
+// audioFeatures derives stable audio features from the ID of a track, the fake has no audio to analyse
+func audioFeatures(id spotify.ID) spotify.AudioFeatures {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	n := h.Sum32()
+	return spotify.AudioFeatures{
+		ID:           id,
+		URI:          spotify.URI("spotify:track:" + id),
+		Tempo:        float32(60 + n%120),
+		Key:          int(n % 12),
+		Mode:         int(n / 12 % 2),
+		Energy:       float32(n/24%1000) / 1000,
+		Danceability: float32(n/24000%1000) / 1000,
+		Valence:      float32(n/24000000%100) / 100,
+	}
+}
+
+// recommendationsHandler serves GET /recommendations, the tracks of the catalog that are not seeds are recommended in order
+// Only the seed tracks are supported, the tunable track attributes are ignored
+func (s *Server) recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	seeds := map[spotify.ID]bool{}
+	for _, id := range strings.Split(query.Get("seed_tracks"), ",") {
+		if id != "" {
+			seeds[spotify.ID(id)] = true
+		}
+	}
+	if len(seeds) == 0 || len(seeds) > 5 {
+		writeError(w, http.StatusBadRequest, "Between 1 and 5 seeds are expected")
+		return
+	}
+	limit := 20
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 && v <= 100 {
+		limit = v
+	}
+
+	recommendations := spotify.Recommendations{Seeds: []spotify.RecommendationSeed{}, Tracks: []spotify.SimpleTrack{}}
+	for id := range seeds {
+		recommendations.Seeds = append(recommendations.Seeds, spotify.RecommendationSeed{ID: id, Type: "TRACK"})
+	}
+	for _, t := range s.State().catalog() {
+		if !seeds[t.ID] && len(recommendations.Tracks) < limit {
+			recommendations.Tracks = append(recommendations.Tracks, t.SimpleTrack)
+		}
+	}
+	writeJSON(w, recommendations)
+}
+
+// topTracksHandler serves GET /me/top/tracks with the limit and offset parameters
+// The top tracks are the tracks recently played, the most recent first
+func (s *Server) topTracksHandler(w http.ResponseWriter, r *http.Request) {
+	state := s.State()
+	catalog := map[spotify.ID]spotify.FullTrack{}
+	for _, t := range state.catalog() {
+		catalog[t.ID] = t
+	}
+	var tracks []spotify.FullTrack
+	seen := map[spotify.ID]bool{}
+	for _, item := range state.RecentlyPlayed {
+		if t, ok := catalog[item.Track.ID]; ok && !seen[t.ID] {
+			seen[t.ID] = true
+			tracks = append(tracks, t)
+		}
+	}
+	offset, end, limit, next := pageBounds(r, len(tracks), 20)
+
+	page := spotify.FullTrackPage{Tracks: append([]spotify.FullTrack{}, tracks[offset:end]...)}
+	page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(tracks), next
+	writeJSON(w, page)
+}
+
+// trackIDs reads the ids parameter of the library and catalog requests, spotify accepts up to max of them
+func trackIDs(r *http.Request, max int) ([]spotify.ID, bool) {
+	var ids []spotify.ID
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id != "" {
+			ids = append(ids, spotify.ID(id))
+		}
+	}
+	return ids, len(ids) > 0 && len(ids) <= max
+}
+
+// savedTracksHandler serves GET /me/tracks with the limit and offset parameters
+func (s *Server) savedTracksHandler(w http.ResponseWriter, r *http.Request) {
+	tracks := s.State().SavedTracks
+	offset, end, limit, next := pageBounds(r, len(tracks), 20)
+
+	page := spotify.SavedTrackPage{Tracks: append([]spotify.SavedTrack{}, tracks[offset:end]...)}
+	page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(tracks), next
+	writeJSON(w, page)
+}
+
+// saveTracksHandler serves PUT /me/tracks, the tracks of the catalog are saved once
+func (s *Server) saveTracksHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := trackIDs(r, 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	catalog := map[spotify.ID]spotify.FullTrack{}
+	for _, t := range s.state.catalog() {
+		catalog[t.ID] = t
+	}
+	saved := map[spotify.ID]bool{}
+	for _, t := range s.state.SavedTracks {
+		saved[t.ID] = true
+	}
+	var added []spotify.SavedTrack
+	for _, id := range ids {
+		t, ok := catalog[id]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid id "+string(id))
+			return
+		}
+		if !saved[id] {
+			saved[id] = true
+			added = append([]spotify.SavedTrack{{AddedAt: time.Now().UTC().Format(time.RFC3339), FullTrack: t}}, added...)
+		}
+	}
+	s.state.SavedTracks = append(added, s.state.SavedTracks...)
+	w.WriteHeader(http.StatusOK)
+}
+
+// removeTracksHandler serves DELETE /me/tracks, the tracks not saved are ignored
+func (s *Server) removeTracksHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := trackIDs(r, 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := map[spotify.ID]bool{}
+	for _, id := range ids {
+		removed[id] = true
+	}
+	kept := []spotify.SavedTrack{}
+	for _, t := range s.state.SavedTracks {
+		if !removed[t.ID] {
+			kept = append(kept, t)
+		}
+	}
+	s.state.SavedTracks = kept
+	w.WriteHeader(http.StatusOK)
+}
+
+// containsTracksHandler serves GET /me/tracks/contains, telling for each id if the track is saved
+func (s *Server) containsTracksHandler(w http.ResponseWriter, r *http.Request) {
+	ids, ok := trackIDs(r, 50)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid ids")
+		return
+	}
+	saved := map[spotify.ID]bool{}
+	for _, t := range s.State().SavedTracks {
+		saved[t.ID] = true
+	}
+	contains := []bool{}
+	for _, id := range ids {
+		contains = append(contains, saved[id])
+	}
+	writeJSON(w, contains)
+}
+
+// savedAlbumsHandler serves GET /me/albums with the limit and offset parameters
+func (s *Server) savedAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	albums := s.State().SavedAlbums
+	offset, end, limit, next := pageBounds(r, len(albums), 20)
+
+	page := spotify.SavedAlbumPage{Albums: append([]spotify.SavedAlbum{}, albums[offset:end]...)}
+	page.Limit, page.Offset, page.Total, page.Next = limit, offset, len(albums), next
+	writeJSON(w, page)
+}
+
+// followedArtistsHandler serves GET /me/following?type=artist with the limit and after parameters
+// The page starts after the artist whose id is given, the cursor of the next page is the id of its last artist
+func (s *Server) followedArtistsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("type") != "artist" {
+		writeError(w, http.StatusBadRequest, "Bad type field")
+		return
+	}
+	artists := s.State().FollowedArtists
+	start := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		for i, a := range artists {
+			if string(a.ID) == after {
+				start = i + 1
+			}
+		}
+	}
+	limit := 20
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	end := start + limit
+	if end > len(artists) {
+		end = len(artists)
+	}
+
+	page := spotify.FullArtistCursorPage{Artists: append([]spotify.FullArtist{}, artists[start:end]...)}
+	page.Limit, page.Total = limit, len(artists)
+	if end > start {
+		page.Cursor.After = string(artists[end-1].ID)
+	}
+	if end < len(artists) {
+		page.Next = fmt.Sprintf("http://%s%s?type=artist&after=%s&limit=%d", r.Host, r.URL.Path, page.Cursor.After, limit)
+	}
+	writeJSON(w, map[string]spotify.FullArtistCursorPage{"artists": page})
+}
+
+// playlistHandler serves GET /playlists/{playlistID}
+func (s *Server) playlistHandler(w http.ResponseWriter, r *http.Request) {
+	playlist, tracks, ok := s.findPlaylist(mux.Vars(r)["playlistID"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	writeJSON(w, spotify.FullPlaylist{
+		SimplePlaylist: playlist,
+		Tracks:         playlistTrackPage(r, playlist.Owner, tracks),
+	})
+}
+
+// playlistTracksHandler serves GET /playlists/{playlistID}/tracks with the limit and offset parameters
+func (s *Server) playlistTracksHandler(w http.ResponseWriter, r *http.Request) {
+	playlist, tracks, ok := s.findPlaylist(mux.Vars(r)["playlistID"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	writeJSON(w, playlistTrackPage(r, playlist.Owner, tracks))
+}
+
+// createPlaylistHandler serves POST /users/{userID}/playlists, the empty playlist is added to the playlists of the current user
+func (s *Server) createPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string `json:"name"`
+		Public      bool   `json:"public"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing name")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mux.Vars(r)["userID"] != s.state.User.ID {
+		writeError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+	id := fmt.Sprintf("%s-%d", slug(body.Name), len(s.state.Playlists)+1)
+	created := playlist(id, body.Name, s.state.User.User, 0)
+	created.IsPublic = body.Public
+	s.state.Playlists = append(append([]spotify.SimplePlaylist{}, s.state.Playlists...), created)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(spotify.FullPlaylist{SimplePlaylist: created, Description: body.Description})
+}
+
+// addPlaylistTracksHandler serves POST /playlists/{playlistID}/tracks, the tracks of the catalog are added at the end
+func (s *Server) addPlaylistTracksHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URIs []spotify.URI `json:"uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.URIs) == 0 || len(body.URIs) > 100 {
+		writeError(w, http.StatusBadRequest, "Invalid uris")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.playlistIndex(mux.Vars(r)["playlistID"])
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	catalog := map[spotify.URI]spotify.FullTrack{}
+	for _, t := range s.state.catalog() {
+		catalog[t.URI] = t
+	}
+	tracks := append([]spotify.FullTrack{}, s.state.Tracks[s.state.Playlists[index].URI]...)
+	for _, u := range body.URIs {
+		t, ok := catalog[u]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid track uri: "+string(u))
+			return
+		}
+		tracks = append(tracks, t)
+	}
+	snapshot := s.setPlaylistTracks(index, tracks)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"snapshot_id": snapshot})
+}
+
+// reorderPlaylistTracksHandler serves PUT /playlists/{playlistID}/tracks, the range of tracks is moved before insert_before
+func (s *Server) reorderPlaylistTracksHandler(w http.ResponseWriter, r *http.Request) {
+	var body spotify.PlaylistReorderOptions
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid reorder")
+		return
+	}
+	if body.RangeLength == 0 {
+		body.RangeLength = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := s.playlistIndex(mux.Vars(r)["playlistID"])
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	tracks := s.state.Tracks[s.state.Playlists[index].URI]
+	start, end, before := body.RangeStart, body.RangeStart+body.RangeLength, body.InsertBefore
+	if start < 0 || body.RangeLength < 0 || end > len(tracks) || before < 0 || before > len(tracks) {
+		writeError(w, http.StatusBadRequest, "Invalid range")
+		return
+	}
+	moved := append([]spotify.FullTrack{}, tracks[start:end]...)
+	left := append(append([]spotify.FullTrack{}, tracks[:start]...), tracks[end:]...)
+	if before > start {
+		before -= body.RangeLength
+		if before < start {
+			before = start
+		}
+	}
+	reordered := append(append(append([]spotify.FullTrack{}, left[:before]...), moved...), left[before:]...)
+	writeJSON(w, map[string]string{"snapshot_id": s.setPlaylistTracks(index, reordered)})
+}
+
+// playlistIndex returns the index of the playlist in the playlists of the state, -1 when unknown
+func (s *Server) playlistIndex(id string) int {
+	for i, p := range s.state.Playlists {
+		if string(p.ID) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// setPlaylistTracks replaces the tracks of the playlist at the index and returns its new snapshot
+// The maps and the slices of the state are shared with the copies returned by State, they are replaced instead of modified
+func (s *Server) setPlaylistTracks(index int, tracks []spotify.FullTrack) string {
+	uri := s.state.Playlists[index].URI
+	all := map[spotify.URI][]spotify.FullTrack{uri: tracks}
+	for k, v := range s.state.Tracks {
+		if k != uri {
+			all[k] = v
+		}
+	}
+	s.state.Tracks = all
+	s.state.Playlists = append([]spotify.SimplePlaylist{}, s.state.Playlists...)
+	s.state.Playlists[index].Tracks.Total = uint(len(tracks))
+	s.state.Playlists[index].SnapshotID = nextSnapshot(s.state.Playlists[index].SnapshotID)
+	return s.state.Playlists[index].SnapshotID
+}
+
+// nextSnapshot returns the snapshot following the given one, snapshot-1 then snapshot-2 and so on
+func nextSnapshot(snapshot string) string {
+	version, _ := strconv.Atoi(strings.TrimPrefix(snapshot, "snapshot-"))
+	return fmt.Sprintf("snapshot-%d", version+1)
+}
+
+// playerStateHandler serves GET /me/player
+func (s *Server) playerStateHandler(w http.ResponseWriter, r *http.Request) {
+	player := s.State().Player
+	if player.Item == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	player.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	writeJSON(w, player)
+}
+
+// currentlyPlayingHandler serves GET /me/player/currently-playing
+func (s *Server) currentlyPlayingHandler(w http.ResponseWriter, r *http.Request) {
+	player := s.State().Player
+	if player.Item == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	player.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	writeJSON(w, player.CurrentlyPlaying)
+}
+
+// devicesHandler serves GET /me/player/devices, the device of the player is the only one
+func (s *Server) devicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices := []spotify.PlayerDevice{}
+	if device := s.State().Player.Device; device.ID != "" {
+		devices = append(devices, device)
+	}
+	writeJSON(w, map[string][]spotify.PlayerDevice{"devices": devices})
+}
+
+// recentlyPlayedHandler serves GET /me/player/recently-played with the limit and the after or before parameters
+// The parameters are unix times in milliseconds, the most recent plays matching them are returned
+func (s *Server) recentlyPlayedHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("after") != "" && query.Get("before") != "" {
+		writeError(w, http.StatusBadRequest, "Only one of after and before can be given")
+		return
+	}
+	after, _ := strconv.ParseInt(query.Get("after"), 10, 64)
+	before, _ := strconv.ParseInt(query.Get("before"), 10, 64)
+	limit := 20
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 && v <= 50 {
+		limit = v
+	}
+
+	items := []spotify.RecentlyPlayedItem{}
+	for _, item := range s.State().RecentlyPlayed {
+		playedAt := item.PlayedAt.UnixNano() / int64(time.Millisecond)
+		if after != 0 && playedAt <= after || before != 0 && playedAt >= before {
+			continue
+		}
+		if len(items) == limit {
+			break
+		}
+		items = append(items, item)
+	}
+	writeJSON(w, spotify.RecentlyPlayedResult{Items: items})
+}
+
+// played adds the track to the recently played ones
+// The plays are at least a millisecond apart like the ones of spotify so quick skips are all kept
+func (state *State) played(track spotify.FullTrack) {
+	playedAt := time.Now().UTC().Truncate(time.Millisecond)
+	if len(state.RecentlyPlayed) > 0 && !playedAt.After(state.RecentlyPlayed[0].PlayedAt) {
+		playedAt = state.RecentlyPlayed[0].PlayedAt.Add(time.Millisecond)
+	}
+	item := spotify.RecentlyPlayedItem{Track: track.SimpleTrack, PlayedAt: playedAt, PlaybackContext: state.Player.PlaybackContext}
+	state.RecentlyPlayed = append([]spotify.RecentlyPlayedItem{item}, state.RecentlyPlayed...)
+}
+
+// playHandler serves PUT /me/player/play, playing a context fills the queue with its tracks
+// Tracks given by their uris are played without context
+func (s *Server) playHandler(w http.ResponseWriter, r *http.Request) {
+	var opts spotify.PlayOptions
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeError(w, http.StatusBadRequest, "Malformed json")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(opts.URIs) > 0 {
+		var tracks []spotify.FullTrack
+		for _, uri := range opts.URIs {
+			for _, t := range s.state.catalog() {
+				if t.URI == uri {
+					tracks = append(tracks, t)
+					break
+				}
+			}
+		}
+		if len(tracks) != len(opts.URIs) {
+			writeError(w, http.StatusNotFound, "Track not found")
+			return
+		}
+		if s.state.Player.Item != nil {
+			s.state.History = append(s.state.History, *s.state.Player.Item)
+			s.state.played(*s.state.Player.Item)
+		}
+		first := tracks[0]
+		s.state.Player.Item = &first
+		s.state.Player.PlaybackContext = spotify.PlaybackContext{}
+		s.state.Queue = tracks[1:]
+		s.state.Player.Progress = 0
+	} else if opts.PlaybackContext != nil {
+		tracks := s.state.contextTracks(*opts.PlaybackContext)
+		if len(tracks) == 0 {
+			writeError(w, http.StatusNotFound, "Context not found")
+			return
+		}
+		if s.state.Player.Item != nil {
+			s.state.History = append(s.state.History, *s.state.Player.Item)
+			s.state.played(*s.state.Player.Item)
+		}
+		first := tracks[0]
+		s.state.Player.Item = &first
+		s.state.Player.PlaybackContext = spotify.PlaybackContext{Type: contextType(*opts.PlaybackContext), URI: *opts.PlaybackContext}
+		s.state.Queue = append([]spotify.FullTrack{}, tracks[1:]...)
+		s.state.Player.Progress = 0
+	}
+	if s.state.Player.Item == nil {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+	s.state.Player.Playing = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// contextType returns the type of the context from its uri, e.g. playlist for spotify:playlist:{id}
+func contextType(uri spotify.URI) string {
+	parts := strings.Split(string(uri), ":")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// pauseHandler serves PUT /me/player/pause
+func (s *Server) pauseHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.Player.Item == nil {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+	s.state.Player.Playing = false
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nextHandler serves POST /me/player/next, the player stops at the end of the queue
+func (s *Server) nextHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.Player.Item == nil {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+	if len(s.state.Queue) == 0 {
+		s.state.Player.Playing = false
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.state.History = append(s.state.History, *s.state.Player.Item)
+	s.state.played(*s.state.Player.Item)
+	next := s.state.Queue[0]
+	s.state.Queue = s.state.Queue[1:]
+	s.state.Player.Item = &next
+	s.state.Player.Progress = 0
+	s.state.Player.Playing = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// previousHandler serves POST /me/player/previous, the current track is restarted without history
+func (s *Server) previousHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.Player.Item == nil {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+	s.state.Player.Progress = 0
+	s.state.Player.Playing = true
+	if len(s.state.History) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	previous := s.state.History[len(s.state.History)-1]
+	s.state.History = s.state.History[:len(s.state.History)-1]
+	s.state.Queue = append([]spotify.FullTrack{*s.state.Player.Item}, s.state.Queue...)
+	s.state.Player.Item = &previous
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getStateHandler serves GET /_fake/state
+func (s *Server) getStateHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.State())
+}
+
+// setStateHandler serves PUT /_fake/state
+func (s *Server) setStateHandler(w http.ResponseWriter, r *http.Request) {
+	var state State
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.SetState(state)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addFailureHandler serves POST /_fake/failures
+func (s *Server) addFailureHandler(w http.ResponseWriter, r *http.Request) {
+	var f Failure
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.Fail(f.Method, f.Path, f.Status)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestsHandler serves GET /_fake/requests
+func (s *Server) requestsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Requests())
+}
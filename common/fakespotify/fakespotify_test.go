@@ -0,0 +1,365 @@
+package fakespotify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/spotifyapi"
+
+	"github.com/zmb3/spotify"
+)
+
+func newClient(t *testing.T, fake *Server, token string) *spotify.Client {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	factory, err := spotifyapi.NewFactory(srv.URL + "/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return factory.Client(token)
+}
+
+func Test_Server_auth(t *testing.T) {
+	state := DefaultState()
+	state.Tokens = []string{"valid"}
+	fake := New(state)
+
+	if _, err := newClient(t, fake, "valid").CurrentUser(); err != nil {
+		t.Errorf("CurrentUser() with valid token error = %v", err)
+	}
+	_, err := newClient(t, fake, "invalid").CurrentUser()
+	if serr, ok := err.(spotify.Error); !ok || serr.Status != http.StatusUnauthorized {
+		t.Errorf("CurrentUser() with invalid token error = %v, want 401", err)
+	}
+}
+
+func Test_Server_users(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	me, err := client.CurrentUser()
+	if err != nil || me.ID != "thomas" {
+		t.Errorf("CurrentUser() = %v, %v", me, err)
+	}
+	alice, err := client.GetUsersPublicProfile("alice")
+	if err != nil || alice.DisplayName != "Alice" {
+		t.Errorf("GetUsersPublicProfile() = %v, %v", alice, err)
+	}
+	if _, err := client.GetUsersPublicProfile("nobody"); err == nil {
+		t.Errorf("GetUsersPublicProfile() of unknown user should error")
+	}
+}
+
+func Test_Server_playlists(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	limit := 1
+	page, err := client.CurrentUsersPlaylistsOpt(&spotify.Options{Limit: &limit})
+	if err != nil {
+		t.Fatalf("CurrentUsersPlaylistsOpt() error = %v", err)
+	}
+	if page.Total != 2 || len(page.Playlists) != 1 || page.Playlists[0].Name != "Morning" {
+		t.Errorf("CurrentUsersPlaylistsOpt() = %+v", page)
+	}
+	if err := client.NextPage(page); err != nil || page.Playlists[0].Name != "Evening" {
+		t.Errorf("NextPage() = %+v, %v", page, err)
+	}
+}
+
+func Test_Server_playlistTracks(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	playlist, err := client.GetPlaylist("morning")
+	if err != nil {
+		t.Fatalf("GetPlaylist() error = %v", err)
+	}
+	if playlist.Name != "Morning" || playlist.Tracks.Total != 3 || playlist.Tracks.Tracks[0].Track.ID != "sunrise" {
+		t.Errorf("GetPlaylist() = %+v", playlist)
+	}
+
+	limit, offset := 2, 2
+	page, err := client.GetPlaylistTracksOpt("morning", &spotify.Options{Limit: &limit, Offset: &offset}, "")
+	if err != nil || len(page.Tracks) != 1 || page.Tracks[0].Track.ID != "commute" || page.Next != "" {
+		t.Errorf("GetPlaylistTracksOpt() = %+v, %v", page, err)
+	}
+	if _, err := client.GetPlaylist("unknown"); err == nil {
+		t.Errorf("GetPlaylist() of unknown playlist should error")
+	}
+}
+
+func Test_Server_player(t *testing.T) {
+	fake := New(DefaultState())
+	client := newClient(t, fake, "token")
+
+	current := func() string {
+		playing, err := client.PlayerCurrentlyPlaying()
+		if err != nil {
+			t.Fatalf("PlayerCurrentlyPlaying() error = %v", err)
+		}
+		return string(playing.Item.ID)
+	}
+
+	uri := spotify.URI("spotify:playlist:morning")
+	album := spotify.URI("spotify:album:twilight")
+	tracks := []spotify.URI{"spotify:track:commute"}
+	steps := []struct {
+		name   string
+		action func() error
+		want   string
+	}{
+		{name: "should play a playlist", action: func() error { return client.PlayOpt(&spotify.PlayOptions{PlaybackContext: &uri}) }, want: "sunrise"},
+		{name: "should go to next track", action: client.Next, want: "coffee"},
+		{name: "should go to previous track", action: client.Previous, want: "sunrise"},
+		{name: "should pause", action: client.Pause, want: "sunrise"},
+		{name: "should play an album", action: func() error { return client.PlayOpt(&spotify.PlayOptions{PlaybackContext: &album}) }, want: "sunset"},
+		{name: "should play a track", action: func() error { return client.PlayOpt(&spotify.PlayOptions{URIs: tracks}) }, want: "commute"},
+		{name: "should pause again", action: client.Pause, want: "commute"},
+	}
+	for _, step := range steps {
+		if err := step.action(); err != nil {
+			t.Fatalf("%s: error = %v", step.name, err)
+		}
+		if got := current(); got != step.want {
+			t.Errorf("%s: playing %v, want %v", step.name, got, step.want)
+		}
+	}
+	if fake.State().Player.Playing {
+		t.Errorf("player should be paused")
+	}
+
+	fake.Fail("POST", "/me/player/next", http.StatusBadGateway)
+	if err := client.Next(); err == nil {
+		t.Errorf("Next() should fail once scripted")
+	}
+	if err := client.Next(); err != nil {
+		t.Errorf("Next() should only fail once, error = %v", err)
+	}
+	if n := len(fake.Requests()); n == 0 {
+		t.Errorf("requests were not recorded")
+	}
+}
+
+func Test_Server_devices(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	devices, err := client.PlayerDevices()
+	if err != nil || len(devices) != 1 || devices[0].Name != "Fake speaker" || !devices[0].Active {
+		t.Errorf("PlayerDevices() = %+v, %v", devices, err)
+	}
+}
+
+func Test_Server_search(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	result, err := client.Search("SUN", spotify.SearchTypeTrack|spotify.SearchTypeAlbum|spotify.SearchTypeArtist|spotify.SearchTypePlaylist)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if result.Tracks.Total != 2 || result.Tracks.Tracks[0].ID != "sunrise" || result.Tracks.Tracks[1].ID != "sunset" {
+		t.Errorf("Search() tracks = %+v", result.Tracks)
+	}
+	if result.Albums.Total != 0 || result.Artists.Total != 0 || result.Playlists.Total != 0 {
+		t.Errorf("Search() found unexpected results: %+v", result)
+	}
+
+	limit := 1
+	result, err = client.SearchOpt("birds", spotify.SearchTypeArtist, &spotify.Options{Limit: &limit})
+	if err != nil || result.Artists.Total != 1 || result.Artists.Artists[0].URI != "spotify:artist:the-early-birds" || result.Tracks != nil {
+		t.Errorf("SearchOpt() = %+v, %v", result, err)
+	}
+
+	if _, err := client.Search("", spotify.SearchTypeTrack); err == nil {
+		t.Errorf("Search() should fail without query")
+	}
+}
+
+func Test_Server_library(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	tracks, err := client.CurrentUsersTracks()
+	if err != nil || tracks.Total != 1 || tracks.Tracks[0].ID != "sunrise" {
+		t.Fatalf("CurrentUsersTracks() = %+v, %v", tracks, err)
+	}
+	if err := client.AddTracksToLibrary("coffee", "sunrise"); err != nil {
+		t.Fatalf("AddTracksToLibrary() error = %v", err)
+	}
+	if err := client.AddTracksToLibrary("unknown"); err == nil {
+		t.Errorf("AddTracksToLibrary() of unknown track should error")
+	}
+	contains, err := client.UserHasTracks("sunrise", "coffee", "sunset")
+	if err != nil || !reflect.DeepEqual(contains, []bool{true, true, false}) {
+		t.Errorf("UserHasTracks() = %v, %v", contains, err)
+	}
+	if err := client.RemoveTracksFromLibrary("sunrise"); err != nil {
+		t.Fatalf("RemoveTracksFromLibrary() error = %v", err)
+	}
+	tracks, err = client.CurrentUsersTracks()
+	if err != nil || tracks.Total != 1 || tracks.Tracks[0].ID != "coffee" {
+		t.Errorf("CurrentUsersTracks() after changes = %+v, %v", tracks, err)
+	}
+
+	albums, err := client.CurrentUsersAlbums()
+	if err != nil || albums.Total != 1 || albums.Albums[0].Name != "Twilight" {
+		t.Errorf("CurrentUsersAlbums() = %+v, %v", albums, err)
+	}
+
+	artists, err := client.CurrentUsersFollowedArtistsOpt(1, "")
+	if err != nil || artists.Total != 2 || artists.Artists[0].Name != "The Early Birds" || artists.Cursor.After != "the-early-birds" {
+		t.Fatalf("CurrentUsersFollowedArtistsOpt() = %+v, %v", artists, err)
+	}
+	artists, err = client.CurrentUsersFollowedArtistsOpt(1, artists.Cursor.After)
+	if err != nil || len(artists.Artists) != 1 || artists.Artists[0].Name != "Dusk" || artists.Next != "" {
+		t.Errorf("CurrentUsersFollowedArtistsOpt() next page = %+v, %v", artists, err)
+	}
+}
+
+func Test_Server_recentlyPlayed(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	items, err := client.PlayerRecentlyPlayed()
+	if err != nil || len(items) != 2 || items[0].Track.ID != "coffee" || items[1].Track.ID != "sunrise" {
+		t.Fatalf("PlayerRecentlyPlayed() = %+v, %v", items, err)
+	}
+	if err := client.Next(); err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	items, err = client.PlayerRecentlyPlayedOpt(&spotify.RecentlyPlayedOptions{Limit: 1})
+	if err != nil || len(items) != 1 || items[0].Track.ID != "sunset" || items[0].PlaybackContext.URI != "spotify:playlist:evening" {
+		t.Errorf("PlayerRecentlyPlayedOpt() after Next() = %+v, %v", items, err)
+	}
+
+	after := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	items, err = client.PlayerRecentlyPlayedOpt(&spotify.RecentlyPlayedOptions{AfterEpochMs: after})
+	if err != nil || len(items) != 2 || items[1].Track.ID != "coffee" {
+		t.Errorf("PlayerRecentlyPlayedOpt() after the first play = %+v, %v", items, err)
+	}
+}
+
+func Test_Server_catalog(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	tracks, err := client.GetTracks("sunrise", "unknown")
+	if err != nil || len(tracks) != 2 || tracks[0].Album.Name != "Dawn" || tracks[1] != nil {
+		t.Errorf("GetTracks() = %+v, %v", tracks, err)
+	}
+	artists, err := client.GetArtists("dusk", "unknown")
+	if err != nil || len(artists) != 2 || artists[0].Name != "Dusk" || !reflect.DeepEqual(artists[0].Genres, []string{"ambient"}) || artists[1] != nil {
+		t.Errorf("GetArtists() = %+v, %v", artists, err)
+	}
+}
+
+func Test_Server_artistsAndAlbums(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	artist, err := client.GetArtist("the-early-birds")
+	if err != nil || artist.Name != "The Early Birds" || !reflect.DeepEqual(artist.Genres, []string{"indie", "folk"}) {
+		t.Errorf("GetArtist() = %+v, %v", artist, err)
+	}
+	if _, err := client.GetArtist("unknown"); err == nil {
+		t.Errorf("GetArtist() of an unknown artist should error")
+	}
+	top, err := client.GetArtistsTopTracks("the-early-birds", "FR")
+	if err != nil || len(top) != 2 || top[0].ID != "sunrise" {
+		t.Errorf("GetArtistsTopTracks() = %+v, %v", top, err)
+	}
+	related, err := client.GetRelatedArtists("the-early-birds")
+	if err != nil || len(related) != 1 || related[0].ID != "traffic" {
+		t.Errorf("GetRelatedArtists() = %+v, %v", related, err)
+	}
+	albums, err := client.GetArtistAlbumsOpt("dusk", nil, spotify.AlbumTypeAlbum)
+	if err != nil || albums.Total != 1 || albums.Albums[0].ID != "twilight" || albums.Albums[0].AlbumGroup != "album" {
+		t.Errorf("GetArtistAlbumsOpt() = %+v, %v", albums, err)
+	}
+	albums, err = client.GetArtistAlbumsOpt("dusk", nil, spotify.AlbumTypeSingle)
+	if err != nil || albums.Total != 0 {
+		t.Errorf("GetArtistAlbumsOpt() of the singles = %+v, %v", albums, err)
+	}
+
+	album, err := client.GetAlbum("dawn")
+	if err != nil || album.Name != "Dawn" || album.Tracks.Total != 2 || album.Tracks.Tracks[1].ID != "coffee" {
+		t.Errorf("GetAlbum() = %+v, %v", album, err)
+	}
+	limit, offset := 1, 1
+	tracks, err := client.GetAlbumTracksOpt("dawn", &spotify.Options{Limit: &limit, Offset: &offset})
+	if err != nil || tracks.Total != 2 || len(tracks.Tracks) != 1 || tracks.Tracks[0].ID != "coffee" {
+		t.Errorf("GetAlbumTracksOpt() = %+v, %v", tracks, err)
+	}
+}
+
+func Test_Server_reorderPlaylist(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	snapshot, err := client.ReorderPlaylistTracks("morning", spotify.PlaylistReorderOptions{RangeStart: 0, InsertBefore: 3, SnapshotID: "snapshot-1"})
+	if err != nil || snapshot != "snapshot-2" {
+		t.Fatalf("ReorderPlaylistTracks() = %q, %v", snapshot, err)
+	}
+	snapshot, err = client.ReorderPlaylistTracks("morning", spotify.PlaylistReorderOptions{RangeStart: 1, RangeLength: 2, InsertBefore: 0, SnapshotID: snapshot})
+	if err != nil || snapshot != "snapshot-3" {
+		t.Fatalf("ReorderPlaylistTracks() of a range = %q, %v", snapshot, err)
+	}
+	playlist, err := client.GetPlaylist("morning")
+	var got []spotify.ID
+	for _, track := range playlist.Tracks.Tracks {
+		got = append(got, track.Track.ID)
+	}
+	if err != nil || playlist.SnapshotID != "snapshot-3" || !reflect.DeepEqual(got, []spotify.ID{"commute", "sunrise", "coffee"}) {
+		t.Errorf("GetPlaylist() after the reorders = %s %v, %v", playlist.SnapshotID, got, err)
+	}
+	if _, err := client.ReorderPlaylistTracks("morning", spotify.PlaylistReorderOptions{RangeStart: 3, InsertBefore: 0}); err == nil {
+		t.Errorf("ReorderPlaylistTracks() out of the playlist should error")
+	}
+}
+
+func Test_Server_audioFeatures(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	features, err := client.GetAudioFeatures("sunrise", "unknown", "coffee")
+	if err != nil || len(features) != 3 || features[0].ID != "sunrise" || features[1] != nil || features[2].ID != "coffee" {
+		t.Fatalf("GetAudioFeatures() = %+v, %v", features, err)
+	}
+	again, err := client.GetAudioFeatures("sunrise")
+	if err != nil || !reflect.DeepEqual(again[0], features[0]) {
+		t.Errorf("GetAudioFeatures() = %+v, want the same features %+v", again[0], features[0])
+	}
+	if f := features[0]; f.Tempo < 60 || f.Tempo >= 180 || f.Key < 0 || f.Key > 11 || f.Energy < 0 || f.Energy >= 1 || f.Valence < 0 || f.Valence >= 1 {
+		t.Errorf("GetAudioFeatures() = %+v, want features in the spotify ranges", f)
+	}
+}
+
+func Test_Server_recommendations(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	limit := 2
+	recommendations, err := client.GetRecommendations(spotify.Seeds{Tracks: []spotify.ID{"sunrise"}}, nil, &spotify.Options{Limit: &limit})
+	if err != nil || len(recommendations.Tracks) != 2 || recommendations.Tracks[0].ID != "coffee" || recommendations.Tracks[1].ID != "commute" {
+		t.Errorf("GetRecommendations() = %+v, %v", recommendations, err)
+	}
+	top, err := client.CurrentUsersTopTracksOpt(nil)
+	if err != nil || top.Total != 2 || top.Tracks[0].ID != "coffee" || top.Tracks[1].ID != "sunrise" {
+		t.Errorf("CurrentUsersTopTracksOpt() = %+v, %v", top, err)
+	}
+}
+
+func Test_Server_createPlaylist(t *testing.T) {
+	client := newClient(t, New(DefaultState()), "token")
+
+	created, err := client.CreatePlaylistForUser("thomas", "Radio", "recommended", false)
+	if err != nil || created.Name != "Radio" || created.Owner.ID != "thomas" {
+		t.Fatalf("CreatePlaylistForUser() = %+v, %v", created, err)
+	}
+	if _, err := client.AddTracksToPlaylist(created.ID, "sunset", "coffee"); err != nil {
+		t.Fatalf("AddTracksToPlaylist() error = %v", err)
+	}
+	playlist, err := client.GetPlaylist(created.ID)
+	if err != nil || playlist.Tracks.Total != 2 || playlist.Tracks.Tracks[0].Track.ID != "sunset" {
+		t.Errorf("GetPlaylist() of the created playlist = %+v, %v", playlist, err)
+	}
+	if _, err := client.AddTracksToPlaylist(created.ID, "unknown"); err == nil {
+		t.Errorf("AddTracksToPlaylist() of an unknown track should error")
+	}
+	if _, err := client.CreatePlaylistForUser("alice", "Radio", "", false); err == nil {
+		t.Errorf("CreatePlaylistForUser() for another user should error")
+	}
+}
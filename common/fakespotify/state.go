@@ -0,0 +1,115 @@
+package fakespotify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// slug returns the fake spotify ID of a name, e.g. the-early-birds
+func slug(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+}
+
+// track creates a fake track, the IDs of its artist and album are made from their names
+func track(id, name, artist, album string, duration int) spotify.FullTrack {
+	artists := []spotify.SimpleArtist{{Name: artist, ID: spotify.ID(slug(artist)), URI: spotify.URI("spotify:artist:" + slug(artist))}}
+	return spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:       spotify.ID(id),
+			URI:      spotify.URI("spotify:track:" + id),
+			Name:     name,
+			Duration: duration,
+			Artists:  artists,
+		},
+		Album: spotify.SimpleAlbum{
+			Name:                 album,
+			ID:                   spotify.ID(slug(album)),
+			URI:                  spotify.URI("spotify:album:" + slug(album)),
+			Artists:              artists,
+			ReleaseDate:          "2020-12-15",
+			ReleaseDatePrecision: "day",
+			Images:               []spotify.Image{{URL: fmt.Sprintf("https://images.example.com/%s.png", slug(album))}},
+		},
+	}
+}
+
+// playlist creates a fake playlist owned by the given user
+func playlist(id, name string, owner spotify.User, tracks int) spotify.SimplePlaylist {
+	return spotify.SimplePlaylist{
+		ID:         spotify.ID(id),
+		URI:        spotify.URI("spotify:playlist:" + id),
+		Name:       name,
+		Owner:      owner,
+		Images:     []spotify.Image{{URL: fmt.Sprintf("https://images.example.com/%s.png", id)}},
+		Tracks:     spotify.PlaylistTracks{Total: uint(tracks)},
+		SnapshotID: "snapshot-1",
+	}
+}
+
+// DefaultState returns a state with a user, two playlists, a show, a small library, two plays and a paused player
+func DefaultState() State {
+	user := spotify.User{DisplayName: "Thomas", ID: "thomas"}
+	morning := playlist("morning", "Morning", user, 3)
+	evening := playlist("evening", "Evening", user, 2)
+	morningTracks := []spotify.FullTrack{
+		track("sunrise", "Sunrise", "The Early Birds", "Dawn", 180000),
+		track("coffee", "Coffee", "The Early Birds", "Dawn", 200000),
+		track("commute", "Commute", "Traffic", "Rush Hour", 240000),
+	}
+	eveningTracks := []spotify.FullTrack{
+		track("sunset", "Sunset", "Dusk", "Twilight", 210000),
+		track("lullaby", "Lullaby", "Dusk", "Twilight", 190000),
+	}
+	current := eveningTracks[0]
+
+	return State{
+		User: spotify.PrivateUser{User: user, Email: "thomas@example.com", Country: "FR", Product: "premium"},
+		Users: map[string]spotify.User{
+			"thomas": user,
+			"alice":  {DisplayName: "Alice", ID: "alice", Images: []spotify.Image{{URL: "https://images.example.com/alice.png"}}},
+		},
+		Playlists: []spotify.SimplePlaylist{morning, evening},
+		Shows: []spotify.SimpleShow{{
+			ID:        "morning-news",
+			URI:       "spotify:show:morning-news",
+			Name:      "Morning News",
+			Publisher: "Fake Radio",
+			MediaType: "audio",
+		}},
+		Genres: map[spotify.ID][]string{
+			"the-early-birds": {"indie", "folk"},
+			"traffic":         {"rock", "indie"},
+			"dusk":            {"ambient"},
+		},
+		Tracks: map[spotify.URI][]spotify.FullTrack{
+			morning.URI: morningTracks,
+			evening.URI: eveningTracks,
+		},
+		Player: spotify.PlayerState{
+			CurrentlyPlaying: spotify.CurrentlyPlaying{
+				Item:            &current,
+				PlaybackContext: spotify.PlaybackContext{Type: "playlist", URI: evening.URI},
+				Progress:        42000,
+			},
+			Device: spotify.PlayerDevice{ID: "device", Active: true, Name: "Fake speaker", Type: "Speaker", Volume: 50},
+		},
+		Queue: eveningTracks[1:],
+		SavedTracks: []spotify.SavedTrack{
+			{AddedAt: "2021-01-02T08:00:00Z", FullTrack: morningTracks[0]},
+		},
+		SavedAlbums: []spotify.SavedAlbum{
+			{AddedAt: "2021-01-01T20:00:00Z", FullAlbum: spotify.FullAlbum{SimpleAlbum: eveningTracks[0].Album}},
+		},
+		FollowedArtists: []spotify.FullArtist{
+			{SimpleArtist: morningTracks[0].Artists[0], Genres: []string{"indie"}},
+			{SimpleArtist: eveningTracks[0].Artists[0], Genres: []string{"ambient"}},
+		},
+		RecentlyPlayed: []spotify.RecentlyPlayedItem{
+			{Track: morningTracks[1].SimpleTrack, PlayedAt: time.Date(2021, 1, 2, 8, 3, 0, 0, time.UTC), PlaybackContext: spotify.PlaybackContext{Type: "playlist", URI: morning.URI}},
+			{Track: morningTracks[0].SimpleTrack, PlayedAt: time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC), PlaybackContext: spotify.PlaybackContext{Type: "playlist", URI: morning.URI}},
+		},
+	}
+}
@@ -0,0 +1,14 @@
+module common
+
+go 1.16
+
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/nats-io/nats.go v1.11.0
+	github.com/sirupsen/logrus v1.8.1
+	github.com/zmb3/spotify v1.1.2
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v2 v2.4.0
+)
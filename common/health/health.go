@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is a readiness probe, it returns an error when the dependency is not ready
+type Check func(ctx context.Context) error
+
+// namedCheck is a check registered with its name
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the readiness checks of a service
+type Checker struct {
+	mu      sync.RWMutex
+	checks  []namedCheck
+	timeout time.Duration
+}
+
+// status is the response of the health endpoints
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// New creates a checker, each readiness check will be cancelled after the given timeout
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check under the given name
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Ready runs every readiness check concurrently and returns the result of each of them
+// A nil error means the check passed
+func (c *Checker) Ready(ctx context.Context) map[string]error {
+	c.mu.RLock()
+	checks := append([]namedCheck{}, c.checks...)
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(checks))
+	for _, nc := range checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			err := nc.check(ctx)
+			mu.Lock()
+			results[nc.name] = err
+			mu.Unlock()
+		}(nc)
+	}
+	wg.Wait()
+	return results
+}
+
+// LivenessHandler is the handler telling the process is up and serving requests
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status{Status: "ok"})
+}
+
+// ReadinessHandler is the handler telling the service is able to serve traffic
+// It answers 503 as soon as one of the readiness checks fails
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	resp := status{Status: "ok", Checks: map[string]string{}}
+	code := http.StatusOK
+	for name, err := range c.Ready(r.Context()) {
+		if err != nil {
+			resp.Checks[name] = err.Error()
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HTTPCheck returns a check verifying the given url is reachable
+// Any response under 500 is considered as reachable (the API may answer 401 without a token)
+func HTTPCheck(client *http.Client, url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s answered with status %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
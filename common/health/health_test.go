@@ -0,0 +1,110 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LivenessHandler(t *testing.T) {
+	checker := New(time.Second)
+	checker.Add("failing", func(ctx context.Context) error { return errors.New("down") })
+
+	rr := httptest.NewRecorder()
+	checker.LivenessHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"status":"ok"}` {
+		t.Errorf("handler returned unexpected body: got %v", body)
+	}
+}
+
+func Test_ReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		checks       map[string]Check
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "should be ready without checks",
+			checks:       map[string]Check{},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"ok"}`,
+		},
+		{
+			name: "should be ready when every check passes",
+			checks: map[string]Check{
+				"config": func(ctx context.Context) error { return nil },
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"ok","checks":{"config":"ok"}}`,
+		},
+		{
+			name: "should not be ready when a check fails",
+			checks: map[string]Check{
+				"config":  func(ctx context.Context) error { return nil },
+				"spotify": func(ctx context.Context) error { return errors.New("unreachable") },
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `{"status":"unavailable","checks":{"config":"ok","spotify":"unreachable"}}`,
+		},
+		{
+			name: "should not be ready when a check times out",
+			checks: map[string]Check{
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `{"status":"unavailable","checks":{"slow":"context deadline exceeded"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := New(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				checker.Add(name, check)
+			}
+			rr := httptest.NewRecorder()
+			checker.ReadinessHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if body := strings.TrimSpace(rr.Body.String()); body != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", body, tt.expectedBody)
+			}
+		})
+	}
+}
+
+func Test_HTTPCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		expectErr bool
+	}{
+		{name: "should be reachable on success", status: http.StatusOK},
+		{name: "should be reachable on unauthorized", status: http.StatusUnauthorized},
+		{name: "should not be reachable on server error", status: http.StatusBadGateway, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := HTTPCheck(srv.Client(), srv.URL)(context.Background())
+			if (err != nil) != tt.expectErr {
+				t.Errorf("HTTPCheck() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+// Package identity carries the user verified by the gateway to the microservices
+package identity
+
+import (
+	"net/http"
+
+	"github.com/zmb3/spotify"
+)
+
+// Header is the header carrying the verified spotify ID of the user from the gateway to the microservices
+// The gateway drops the one sent by the client
+const Header = "X-User-ID"
+
+// CurrentUserClient is the spotify client asked for the user when the service is called directly
+type CurrentUserClient interface {
+	CurrentUser() (*spotify.PrivateUser, error)
+}
+
+// UserID returns the spotify ID of the user, sent by the gateway or asked to spotify when the service is called directly
+func UserID(r *http.Request, client CurrentUserClient) (string, error) {
+	if id := r.Header.Get(Header); id != "" {
+		return id, nil
+	}
+	user, err := client.CurrentUser()
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
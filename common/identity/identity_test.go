@@ -0,0 +1,49 @@
+package identity
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+// currentUser is the spotify client of the tests, answering the user or the error
+type currentUser struct {
+	id  string
+	err error
+}
+
+func (c currentUser) CurrentUser() (*spotify.PrivateUser, error) {
+	return &spotify.PrivateUser{User: spotify.User{ID: c.id}}, c.err
+}
+
+func Test_UserID(t *testing.T) {
+	unauthorized := errors.New("unauthorized")
+	tests := []struct {
+		name    string
+		header  string
+		client  currentUser
+		want    string
+		wantErr error
+	}{
+		{name: "should use the user of the gateway", header: "thomas", client: currentUser{err: unauthorized}, want: "thomas"},
+		{name: "should ask spotify without the gateway", client: currentUser{id: "bob"}, want: "bob"},
+		{name: "should return the error of spotify", client: currentUser{err: unauthorized}, wantErr: unauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(Header, tt.header)
+			}
+			got, err := UserID(r, tt.client)
+			if err != tt.wantErr {
+				t.Fatalf("UserID() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("UserID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
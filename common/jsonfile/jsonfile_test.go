@@ -0,0 +1,39 @@
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_WriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	got := map[string]int{"kept": 1}
+	if err := Read(path, &got); err != nil || !reflect.DeepEqual(got, map[string]int{"kept": 1}) {
+		t.Fatalf("Read() of a missing file = %v, %v, want the value unchanged", got, err)
+	}
+
+	want := map[string]int{"sunrise": 3, "coffee": 2}
+	if err := Write(path, want); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got = map[string]int{}
+	if err := Read(path, &got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %v, %v, want %v", got, err, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Write() created the file with %v, %v, want it only readable by the service", info.Mode(), err)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("Write() left temporary files %v", matches)
+	}
+
+	if err := Write(filepath.Join(path, "nested.json"), want); err == nil {
+		t.Error("Write() below a file error = nil")
+	}
+	os.WriteFile(path, []byte("{"), 0600)
+	if err := Read(path, &got); err == nil {
+		t.Error("Read() of an invalid file error = nil")
+	}
+}
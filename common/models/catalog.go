@@ -0,0 +1,110 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// ArtistAlbum is an album of an artist, Group tells how the artist takes part in it (album, single, appears_on or compilation)
+type ArtistAlbum struct {
+	Album
+	Type  string `json:"album_type"`
+	Group string `json:"album_group"`
+}
+
+// ArtistAlbums is a page of the albums of an artist, Total counts all of them
+type ArtistAlbums struct {
+	Items []ArtistAlbum `json:"items"`
+	Total int           `json:"total"`
+}
+
+// ReduceArtistAlbums will reduce the spotify album page of an artist to a simplified one
+func ReduceArtistAlbums(page *spotify.SimpleAlbumPage) ArtistAlbums {
+	reduced := ArtistAlbums{Items: []ArtistAlbum{}, Total: page.Total}
+	for _, a := range page.Albums {
+		reduced.Items = append(reduced.Items, ArtistAlbum{Album: ReduceAlbum(a), Type: a.AlbumType, Group: a.AlbumGroup})
+	}
+	return reduced
+}
+
+// ArtistProfile is an artist with its audience, its top tracks, the artists related to it and a page of its albums
+type ArtistProfile struct {
+	Artist
+	Followers      uint         `json:"followers"`
+	Popularity     int          `json:"popularity"`
+	TopTracks      []Track      `json:"top_tracks"`
+	RelatedArtists []Artist     `json:"related_artists"`
+	Albums         ArtistAlbums `json:"albums"`
+}
+
+// ReduceArtistProfile will reduce the spotify artist and its catalog to a profile
+func ReduceArtistProfile(artist spotify.FullArtist, top []spotify.FullTrack, related []spotify.FullArtist, albums *spotify.SimpleAlbumPage) ArtistProfile {
+	profile := ArtistProfile{
+		Artist:         ReduceArtist(artist),
+		Followers:      artist.Followers.Count,
+		Popularity:     artist.Popularity,
+		TopTracks:      []Track{},
+		RelatedArtists: []Artist{},
+		Albums:         ReduceArtistAlbums(albums),
+	}
+	for _, t := range top {
+		profile.TopTracks = append(profile.TopTracks, ReduceTrack(t))
+	}
+	for _, a := range related {
+		profile.RelatedArtists = append(profile.RelatedArtists, ReduceArtist(a))
+	}
+	return profile
+}
+
+// AlbumTrack is a track of an album with its position on the album
+type AlbumTrack struct {
+	Track
+	DiscNumber  int `json:"disc_number"`
+	TrackNumber int `json:"track_number"`
+}
+
+// AlbumDetails is an album with all its tracks
+// ReleaseDate is normalized like the one of the player, only the part given by ReleaseDatePrecision (year, month or day) is meaningful
+type AlbumDetails struct {
+	Name                 string       `json:"name"`
+	ArtistsName          []string     `json:"artists_name"`
+	ID                   spotify.ID   `json:"ID"`
+	URI                  spotify.URI  `json:"uri"`
+	Image                string       `json:"image"`
+	Type                 string       `json:"album_type"`
+	Label                string       `json:"label"`
+	Genres               []string     `json:"genres"`
+	Popularity           int          `json:"popularity"`
+	ReleaseDate          time.Time    `json:"release_date"`
+	ReleaseDatePrecision string       `json:"release_date_precision"`
+	Tracks               []AlbumTrack `json:"tracks"`
+}
+
+// ReduceAlbumDetails will reduce the spotify album with its label and all its tracks to a simplified one
+func ReduceAlbumDetails(album spotify.FullAlbum, label string, tracks []spotify.SimpleTrack) AlbumDetails {
+	reduced := AlbumDetails{
+		Name:                 album.Name,
+		ID:                   album.ID,
+		URI:                  album.URI,
+		Image:                firstImage(album.Images),
+		Type:                 album.AlbumType,
+		Label:                label,
+		Genres:               album.Genres,
+		Popularity:           album.Popularity,
+		ReleaseDate:          album.ReleaseDateTime(),
+		ReleaseDatePrecision: album.ReleaseDatePrecision,
+		Tracks:               []AlbumTrack{},
+	}
+	if reduced.Genres == nil {
+		reduced.Genres = []string{}
+	}
+	for _, a := range album.Artists {
+		reduced.ArtistsName = append(reduced.ArtistsName, a.Name)
+	}
+	for _, t := range tracks {
+		track := ReduceTrack(spotify.FullTrack{SimpleTrack: t, Album: album.SimpleAlbum})
+		reduced.Tracks = append(reduced.Tracks, AlbumTrack{Track: track, DiscNumber: t.DiscNumber, TrackNumber: t.TrackNumber})
+	}
+	return reduced
+}
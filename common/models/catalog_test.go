@@ -0,0 +1,67 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_ReduceArtistProfile(t *testing.T) {
+	artist := spotify.FullArtist{
+		SimpleArtist: spotify.SimpleArtist{Name: "The Early Birds", ID: "the-early-birds"},
+		Popularity:   42,
+		Followers:    spotify.Followers{Count: 1200},
+	}
+	top := []spotify.FullTrack{{SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise"}, Album: spotify.SimpleAlbum{Name: "Dawn"}}}
+	albums := &spotify.SimpleAlbumPage{Albums: []spotify.SimpleAlbum{{Name: "Dawn", ID: "dawn", AlbumType: "album", AlbumGroup: "appears_on"}}}
+	albums.Total = 4
+
+	got := ReduceArtistProfile(artist, top, nil, albums)
+	want := ArtistProfile{
+		Artist:         Artist{Name: "The Early Birds", ID: "the-early-birds", Genres: []string{}},
+		Followers:      1200,
+		Popularity:     42,
+		TopTracks:      []Track{{Name: "Sunrise", AlbumName: "Dawn", ID: "sunrise"}},
+		RelatedArtists: []Artist{},
+		Albums:         ArtistAlbums{Total: 4, Items: []ArtistAlbum{{Album: Album{Name: "Dawn", ID: "dawn"}, Type: "album", Group: "appears_on"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceArtistProfile() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_ReduceAlbumDetails(t *testing.T) {
+	tests := []struct {
+		name        string
+		releaseDate string
+		precision   string
+		want        time.Time
+	}{
+		{name: "should keep the day", releaseDate: "2020-12-15", precision: "day", want: time.Date(2020, 12, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "should start the month", releaseDate: "2020-12", precision: "month", want: time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "should start the year", releaseDate: "2020", precision: "year", want: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			album := spotify.FullAlbum{SimpleAlbum: spotify.SimpleAlbum{
+				Name:                 "Dawn",
+				ID:                   "dawn",
+				Artists:              []spotify.SimpleArtist{{Name: "The Early Birds"}},
+				ReleaseDate:          tt.releaseDate,
+				ReleaseDatePrecision: tt.precision,
+			}}
+			tracks := []spotify.SimpleTrack{{Name: "Coffee", ID: "coffee", DiscNumber: 1, TrackNumber: 2}}
+
+			got := ReduceAlbumDetails(album, "Fake Records", tracks)
+			if !got.ReleaseDate.Equal(tt.want) || got.ReleaseDatePrecision != tt.precision {
+				t.Errorf("ReduceAlbumDetails() release date = %v %s, want %v", got.ReleaseDate, got.ReleaseDatePrecision, tt.want)
+			}
+			wantTracks := []AlbumTrack{{Track: Track{Name: "Coffee", AlbumName: "Dawn", ID: "coffee"}, DiscNumber: 1, TrackNumber: 2}}
+			if got.Label != "Fake Records" || got.Genres == nil || !reflect.DeepEqual(got.ArtistsName, []string{"The Early Birds"}) || !reflect.DeepEqual(got.Tracks, wantTracks) {
+				t.Errorf("ReduceAlbumDetails() = %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"math"
+
+	"github.com/zmb3/spotify"
+)
+
+// TrackFeatures are the audio features of a track, spotify computes them once so they never change
+// Key is the pitch class (0 for C, 1 for C♯ ... 11 for B, -1 when unknown), Mode is 1 for major and 0 for minor
+// Energy, Danceability and Valence are between 0 and 1, Tempo is in beats per minute
+type TrackFeatures struct {
+	ID           spotify.ID `json:"ID"`
+	Tempo        float64    `json:"tempo"`
+	Key          int        `json:"key"`
+	Mode         int        `json:"mode"`
+	Energy       float64    `json:"energy"`
+	Danceability float64    `json:"danceability"`
+	Valence      float64    `json:"valence"`
+}
+
+// TracksFeatures are the audio features of tracks asked together, in the order of the ids
+// The tracks spotify does not know have no features, their item is null
+type TracksFeatures struct {
+	Items []*TrackFeatures `json:"items"`
+}
+
+// roundFeature rounds a feature to 3 decimals like spotify, its float32 values are not exact once widened
+func roundFeature(value float32) float64 {
+	return math.Round(float64(value)*1000) / 1000
+}
+
+// ReduceAudioFeatures will reduce the spotify audio features to the ones kept
+func ReduceAudioFeatures(features spotify.AudioFeatures) TrackFeatures {
+	return TrackFeatures{
+		ID:           features.ID,
+		Tempo:        roundFeature(features.Tempo),
+		Key:          features.Key,
+		Mode:         features.Mode,
+		Energy:       roundFeature(features.Energy),
+		Danceability: roundFeature(features.Danceability),
+		Valence:      roundFeature(features.Valence),
+	}
+}
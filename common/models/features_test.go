@@ -0,0 +1,24 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_ReduceAudioFeatures(t *testing.T) {
+	features := spotify.AudioFeatures{
+		ID:           "sunrise",
+		Tempo:        118.211,
+		Key:          7,
+		Mode:         1,
+		Energy:       0.8,
+		Danceability: 0.735,
+		Valence:      0.624,
+		Loudness:     -5.883,
+	}
+	want := TrackFeatures{ID: "sunrise", Tempo: 118.211, Key: 7, Mode: 1, Energy: 0.8, Danceability: 0.735, Valence: 0.624}
+	if got := ReduceAudioFeatures(features); got != want {
+		t.Errorf("ReduceAudioFeatures() = %+v, want %+v", got, want)
+	}
+}
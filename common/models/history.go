@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// Play is a track played by the user
+// The album is not given by the recently played tracks of spotify, it is set once the track is looked up
+type Play struct {
+	Name        string      `json:"name"`
+	ArtistsName []string    `json:"artists_name"`
+	AlbumName   string      `json:"album_name"`
+	ID          spotify.ID  `json:"ID"`
+	URI         spotify.URI `json:"uri"`
+	Duration    int         `json:"duration"`
+	ContextURI  spotify.URI `json:"context_uri"`
+	PlayedAt    time.Time   `json:"played_at"`
+}
+
+// ReducePlay will reduce a spotify recently played item to a simplified play
+func ReducePlay(item spotify.RecentlyPlayedItem) Play {
+	var artists []string
+	for _, a := range item.Track.Artists {
+		artists = append(artists, a.Name)
+	}
+	return Play{
+		Name:        item.Track.Name,
+		ArtistsName: artists,
+		ID:          item.Track.ID,
+		URI:         item.Track.URI,
+		Duration:    item.Track.Duration,
+		ContextURI:  item.PlaybackContext.URI,
+		PlayedAt:    item.PlayedAt,
+	}
+}
+
+// History is a page of the plays of the user, the most recent first, Total counts all the plays matching the filters
+type History struct {
+	Items []Play `json:"items"`
+	Total int    `json:"total"`
+}
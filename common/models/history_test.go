@@ -0,0 +1,36 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_ReducePlay(t *testing.T) {
+	playedAt := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	item := spotify.RecentlyPlayedItem{
+		Track: spotify.SimpleTrack{
+			Name:     "Sunrise",
+			ID:       "sunrise",
+			URI:      "spotify:track:sunrise",
+			Duration: 180000,
+			Artists:  []spotify.SimpleArtist{{Name: "The Early Birds"}, {Name: "Dusk"}},
+		},
+		PlayedAt:        playedAt,
+		PlaybackContext: spotify.PlaybackContext{Type: "playlist", URI: "spotify:playlist:morning"},
+	}
+	want := Play{
+		Name:        "Sunrise",
+		ArtistsName: []string{"The Early Birds", "Dusk"},
+		ID:          "sunrise",
+		URI:         "spotify:track:sunrise",
+		Duration:    180000,
+		ContextURI:  "spotify:playlist:morning",
+		PlayedAt:    playedAt,
+	}
+	if got := ReducePlay(item); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReducePlay() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"github.com/zmb3/spotify"
+)
+
+// SavedTrack is a track of the library of the user
+type SavedTrack struct {
+	Track
+	AddedAt string `json:"added_at"`
+}
+
+// SavedTracks is a page of the saved tracks, Total counts all of them
+type SavedTracks struct {
+	Items []SavedTrack `json:"items"`
+	Total int          `json:"total"`
+}
+
+// ReduceSavedTracks will reduce the spotify saved track page to a simplified one
+func ReduceSavedTracks(page *spotify.SavedTrackPage) SavedTracks {
+	reduced := SavedTracks{Items: []SavedTrack{}, Total: page.Total}
+	for _, t := range page.Tracks {
+		reduced.Items = append(reduced.Items, SavedTrack{Track: ReduceTrack(t.FullTrack), AddedAt: t.AddedAt})
+	}
+	return reduced
+}
+
+// SavedAlbum is an album of the library of the user
+type SavedAlbum struct {
+	Album
+	AddedAt string `json:"added_at"`
+}
+
+// SavedAlbums is a page of the saved albums, Total counts all of them
+type SavedAlbums struct {
+	Items []SavedAlbum `json:"items"`
+	Total int          `json:"total"`
+}
+
+// ReduceSavedAlbums will reduce the spotify saved album page to a simplified one
+func ReduceSavedAlbums(page *spotify.SavedAlbumPage) SavedAlbums {
+	reduced := SavedAlbums{Items: []SavedAlbum{}, Total: page.Total}
+	for _, a := range page.Albums {
+		reduced.Items = append(reduced.Items, SavedAlbum{Album: ReduceAlbum(a.SimpleAlbum), AddedAt: a.AddedAt})
+	}
+	return reduced
+}
+
+// FollowedArtists is a page of the artists followed by the user
+// The artists are paged with a cursor: After is given to get the next page, it is empty on the last one
+type FollowedArtists struct {
+	Items []Artist `json:"items"`
+	Total int      `json:"total"`
+	After string   `json:"after"`
+}
+
+// ReduceFollowedArtists will reduce the spotify followed artist page to a simplified one
+func ReduceFollowedArtists(page *spotify.FullArtistCursorPage) FollowedArtists {
+	reduced := FollowedArtists{Items: []Artist{}, Total: page.Total}
+	for _, a := range page.Artists {
+		reduced.Items = append(reduced.Items, ReduceArtist(a))
+	}
+	if page.Next != "" {
+		reduced.After = page.Cursor.After
+	}
+	return reduced
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_ReduceSavedTracks(t *testing.T) {
+	page := &spotify.SavedTrackPage{Tracks: []spotify.SavedTrack{{
+		AddedAt:   "2021-01-02T10:00:00Z",
+		FullTrack: spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise", URI: "spotify:track:sunrise"}, Album: spotify.SimpleAlbum{Name: "Dawn"}},
+	}}}
+	page.Total = 3
+	want := SavedTracks{Total: 3, Items: []SavedTrack{{
+		Track:   Track{Name: "Sunrise", AlbumName: "Dawn", ID: "sunrise", URI: "spotify:track:sunrise"},
+		AddedAt: "2021-01-02T10:00:00Z",
+	}}}
+	if got := ReduceSavedTracks(page); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceSavedTracks() = %+v, want %+v", got, want)
+	}
+	if got := ReduceSavedTracks(&spotify.SavedTrackPage{}); got.Items == nil {
+		t.Errorf("ReduceSavedTracks() should return an empty page, not nil")
+	}
+}
+
+func Test_ReduceSavedAlbums(t *testing.T) {
+	page := &spotify.SavedAlbumPage{Albums: []spotify.SavedAlbum{{
+		AddedAt:   "2021-01-02T10:00:00Z",
+		FullAlbum: spotify.FullAlbum{SimpleAlbum: spotify.SimpleAlbum{Name: "Dawn", ID: "dawn", URI: "spotify:album:dawn", ReleaseDate: "2020-12-15"}},
+	}}}
+	page.Total = 1
+	want := SavedAlbums{Total: 1, Items: []SavedAlbum{{
+		Album:   Album{Name: "Dawn", ID: "dawn", URI: "spotify:album:dawn", ReleaseDate: "2020-12-15"},
+		AddedAt: "2021-01-02T10:00:00Z",
+	}}}
+	if got := ReduceSavedAlbums(page); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceSavedAlbums() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_ReduceFollowedArtists(t *testing.T) {
+	tests := []struct {
+		name string
+		next string
+		want string
+	}{
+		{name: "should give the cursor of the next page", next: "https://api.spotify.com/v1/me/following?after=birds", want: "birds"},
+		{name: "should not give a cursor on the last page", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := &spotify.FullArtistCursorPage{Artists: []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "The Early Birds", ID: "birds"}}}}
+			page.Total = 2
+			page.Next = tt.next
+			page.Cursor.After = "birds"
+			got := ReduceFollowedArtists(page)
+			if got.After != tt.want || got.Total != 2 || len(got.Items) != 1 || got.Items[0].Name != "The Early Birds" {
+				t.Errorf("ReduceFollowedArtists() = %+v", got)
+			}
+		})
+	}
+}
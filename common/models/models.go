@@ -0,0 +1,187 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// Player is a simplified structure of a player
+type Player struct {
+	IsPlaying   bool       `json:"is_playing"`
+	AlbumName   string     `json:"album_name"`
+	ArtistsName []string   `json:"artists_name"`
+	MusicName   string     `json:"music_name"`
+	ID          spotify.ID `json:"ID"`
+	ReleaseDate time.Time  `json:"release_date"`
+	Progress    int        `json:"progress"`
+	Duration    int        `json:"duration"`
+}
+
+// ReducePlayer will reduce the spotify player to a simplified one
+func ReducePlayer(playerResp *spotify.CurrentlyPlaying) Player {
+	var artists []string
+
+	for _, p := range playerResp.Item.Artists {
+		artists = append(artists, p.Name)
+	}
+
+	return Player{
+		IsPlaying:   playerResp.Playing,
+		AlbumName:   playerResp.Item.Album.Name,
+		ArtistsName: artists,
+		MusicName:   playerResp.Item.Name,
+		ID:          playerResp.Item.ID,
+		ReleaseDate: playerResp.Item.Album.ReleaseDateTime(),
+		Progress:    playerResp.Progress,
+		Duration:    playerResp.Item.Duration,
+	}
+}
+
+// PlayOptions returns the options to play the uri, the current music is resumed when it is empty
+// A track is not a context, it is played on its own
+func PlayOptions(uri spotify.URI) *spotify.PlayOptions {
+	playOptions := &spotify.PlayOptions{}
+	if strings.HasPrefix(string(uri), "spotify:track:") {
+		playOptions.URIs = []spotify.URI{uri}
+	} else if len(uri) > 0 {
+		playOptions.PlaybackContext = &uri
+	}
+	return playOptions
+}
+
+// PlaylistItem is a simplified structure of a playlist item
+type PlaylistItem struct {
+	Image     string      `json:"image"`
+	Name      string      `json:"name"`
+	OwnerName string      `json:"owner_name"`
+	ID        spotify.ID  `json:"ID"`
+	URI       spotify.URI `json:"uri"`
+}
+
+// ReducePlaylistItem will reduce a spotify playlist to a simplified one
+func ReducePlaylistItem(item spotify.SimplePlaylist) PlaylistItem {
+	var image string
+	if len(item.Images) > 0 {
+		image = item.Images[0].URL
+	}
+	return PlaylistItem{
+		Image:     image,
+		Name:      item.Name,
+		OwnerName: item.Owner.DisplayName,
+		ID:        item.ID,
+		URI:       item.URI,
+	}
+}
+
+// ReducePlaylist will reduce the spotify playlist page to a simplified one
+func ReducePlaylist(playlistResp *spotify.SimplePlaylistPage) []PlaylistItem {
+	playlist := []PlaylistItem{}
+
+	for _, item := range playlistResp.Playlists {
+		playlist = append(playlist, ReducePlaylistItem(item))
+	}
+	return playlist
+}
+
+// User is a simplified structure of a user
+type User struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Image string `json:"image"`
+}
+
+// ReduceUser will reduce the spotify user to a simplified one
+func ReduceUser(user *spotify.User) User {
+	var image string
+	if len(user.Images) > 0 {
+		image = user.Images[0].URL
+	}
+
+	return User{
+		Name:  user.DisplayName,
+		ID:    user.ID,
+		Image: image,
+	}
+}
+
+// Track is a simplified structure of a track
+type Track struct {
+	Name        string      `json:"name"`
+	ArtistsName []string    `json:"artists_name"`
+	AlbumName   string      `json:"album_name"`
+	ID          spotify.ID  `json:"ID"`
+	URI         spotify.URI `json:"uri"`
+	Duration    int         `json:"duration"`
+	// Features are only attached on demand, e.g. to the tracks of a playlist
+	Features *TrackFeatures `json:"features,omitempty"`
+}
+
+// ReduceTrack will reduce a spotify track to a simplified one
+func ReduceTrack(track spotify.FullTrack) Track {
+	var artists []string
+	for _, a := range track.Artists {
+		artists = append(artists, a.Name)
+	}
+	return Track{
+		Name:        track.Name,
+		ArtistsName: artists,
+		AlbumName:   track.Album.Name,
+		ID:          track.ID,
+		URI:         track.URI,
+		Duration:    track.Duration,
+	}
+}
+
+// Playlist is a simplified structure of a playlist along with a page of its tracks
+type Playlist struct {
+	PlaylistItem
+	Tracks []Track `json:"tracks"`
+	// Total is the number of tracks of the playlist, not only of the page
+	Total int `json:"total"`
+}
+
+// ReducePlaylistTracks will reduce the spotify playlist and a page of its tracks to a simplified one
+func ReducePlaylistTracks(playlist *spotify.FullPlaylist, tracks *spotify.PlaylistTrackPage) Playlist {
+	reduced := Playlist{
+		PlaylistItem: ReducePlaylistItem(playlist.SimplePlaylist),
+		Tracks:       []Track{},
+		Total:        tracks.Total,
+	}
+	for _, t := range tracks.Tracks {
+		reduced.Tracks = append(reduced.Tracks, ReduceTrack(t.Track))
+	}
+	return reduced
+}
+
+// Device is a simplified structure of a device able to play music
+type Device struct {
+	ID       spotify.ID `json:"id"`
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	IsActive bool       `json:"is_active"`
+	Volume   int        `json:"volume"`
+}
+
+// ReduceDevices will reduce the spotify devices to simplified ones
+func ReduceDevices(devices []spotify.PlayerDevice) []Device {
+	reduced := []Device{}
+	for _, d := range devices {
+		reduced = append(reduced, Device{
+			ID:       d.ID,
+			Name:     d.Name,
+			Type:     d.Type,
+			IsActive: d.Active,
+			Volume:   d.Volume,
+		})
+	}
+	return reduced
+}
+
+// Scrobbling is the scrobbling of the tracks played by a user, API is empty when the scrobbling is disabled
+// Registered is true once the user registered its account, its token is never answered
+type Scrobbling struct {
+	API        string `json:"api"`
+	Registered bool   `json:"registered"`
+}
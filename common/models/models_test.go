@@ -0,0 +1,246 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+func getTimeFromString(str string) time.Time {
+	date, _ := time.Parse(spotify.DateLayout, str)
+	return date
+}
+
+func Test_ReducePlayer(t *testing.T) {
+	type args struct {
+		playerResp *spotify.CurrentlyPlaying
+	}
+	tests := []struct {
+		name string
+		args args
+		want Player
+	}{
+		{
+			name: "",
+			args: args{
+				&spotify.CurrentlyPlaying{
+					Playing: true,
+					Item: &spotify.FullTrack{
+						Album: spotify.SimpleAlbum{
+							Name:                 "album",
+							ReleaseDate:          "2020-12-15",
+							ReleaseDatePrecision: "day",
+						},
+						SimpleTrack: spotify.SimpleTrack{
+							Name: "test",
+							ID:   "id",
+							Artists: []spotify.SimpleArtist{
+								{
+									Name: "artist name",
+								},
+								{
+									Name: "thomas",
+								},
+							},
+						},
+					},
+				},
+			},
+			want: Player{
+				IsPlaying:   true,
+				AlbumName:   "album",
+				ArtistsName: []string{"artist name", "thomas"},
+				MusicName:   "test",
+				ID:          "id",
+				ReleaseDate: getTimeFromString("2020-12-15"),
+				Duration:    0,
+				Progress:    0,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReducePlayer(tt.args.playerResp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReducePlayer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ReducePlaylist(t *testing.T) {
+	type args struct {
+		playlistResp *spotify.SimplePlaylistPage
+	}
+	tests := []struct {
+		name string
+		args args
+		want []PlaylistItem
+	}{
+		{
+			name: "should handle empty playlists",
+			args: args{
+				playlistResp: &spotify.SimplePlaylistPage{
+					Playlists: []spotify.SimplePlaylist{},
+				},
+			},
+			want: []PlaylistItem{},
+		},
+		{
+			name: "should get all playlists",
+			args: args{
+				playlistResp: &spotify.SimplePlaylistPage{
+					Playlists: []spotify.SimplePlaylist{
+						{
+							Name: "test-name",
+							ID:   "ID",
+							URI:  "uri:...",
+							Owner: spotify.User{
+								DisplayName: "Thomas",
+							},
+						},
+					},
+				},
+			},
+			want: []PlaylistItem{
+				{
+					Name:      "test-name",
+					OwnerName: "Thomas",
+					ID:        "ID",
+					URI:       "uri:...",
+				},
+			},
+		},
+		{
+			name: "should get all the playlists with images",
+			args: args{
+				playlistResp: &spotify.SimplePlaylistPage{
+					Playlists: []spotify.SimplePlaylist{
+						{
+							Name: "test-name",
+							ID:   "ID",
+							URI:  "uri:...",
+							Owner: spotify.User{
+								DisplayName: "Thomas",
+							},
+						},
+						{
+							Name: "test-name-2",
+							ID:   "ID-2",
+							URI:  "uri:2",
+							Owner: spotify.User{
+								DisplayName: "Thomas2",
+							},
+							Images: []spotify.Image{
+								{
+									URL: "http://...",
+								},
+							},
+						},
+					},
+				},
+			},
+			want: []PlaylistItem{
+				{
+					Name:      "test-name",
+					OwnerName: "Thomas",
+					ID:        "ID",
+					URI:       "uri:...",
+				},
+				{
+					Name:      "test-name-2",
+					OwnerName: "Thomas2",
+					ID:        "ID-2",
+					URI:       "uri:2",
+					Image:     "http://...",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReducePlaylist(tt.args.playlistResp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReducePlaylist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// playlistTrackPage creates a page of tracks of a playlist with the given total
+func playlistTrackPage(total int, tracks ...spotify.PlaylistTrack) *spotify.PlaylistTrackPage {
+	page := &spotify.PlaylistTrackPage{Tracks: tracks}
+	page.Total = total
+	return page
+}
+
+func Test_ReducePlaylistTracks(t *testing.T) {
+	type args struct {
+		playlist *spotify.FullPlaylist
+		tracks   *spotify.PlaylistTrackPage
+	}
+	tests := []struct {
+		name string
+		args args
+		want Playlist
+	}{
+		{
+			name: "should get the playlist without tracks",
+			args: args{
+				playlist: &spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{Name: "test-name", ID: "ID"}},
+				tracks:   &spotify.PlaylistTrackPage{},
+			},
+			want: Playlist{PlaylistItem: PlaylistItem{Name: "test-name", ID: "ID"}, Tracks: []Track{}},
+		},
+		{
+			name: "should get the playlist with a page of tracks",
+			args: args{
+				playlist: &spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{Name: "test-name", ID: "ID", Owner: spotify.User{DisplayName: "Thomas"}}},
+				tracks: playlistTrackPage(10, spotify.PlaylistTrack{
+					Track: spotify.FullTrack{
+						SimpleTrack: spotify.SimpleTrack{
+							Name:     "test",
+							ID:       "track",
+							URI:      "spotify:track:track",
+							Duration: 1000,
+							Artists:  []spotify.SimpleArtist{{Name: "artist name"}, {Name: "thomas"}},
+						},
+						Album: spotify.SimpleAlbum{Name: "album"},
+					},
+				}),
+			},
+			want: Playlist{
+				PlaylistItem: PlaylistItem{Name: "test-name", ID: "ID", OwnerName: "Thomas"},
+				Tracks: []Track{
+					{
+						Name:        "test",
+						ArtistsName: []string{"artist name", "thomas"},
+						AlbumName:   "album",
+						ID:          "track",
+						URI:         "spotify:track:track",
+						Duration:    1000,
+					},
+				},
+				Total: 10,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReducePlaylistTracks(tt.args.playlist, tt.args.tracks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReducePlaylistTracks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ReduceDevices(t *testing.T) {
+	got := ReduceDevices([]spotify.PlayerDevice{{ID: "id", Active: true, Name: "speaker", Type: "Speaker", Volume: 50}})
+	want := []Device{{ID: "id", IsActive: true, Name: "speaker", Type: "Speaker", Volume: 50}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceDevices() = %v, want %v", got, want)
+	}
+	if got := ReduceDevices(nil); got == nil || len(got) != 0 {
+		t.Errorf("ReduceDevices() = %v, want an empty list", got)
+	}
+}
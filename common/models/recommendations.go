@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/zmb3/spotify"
+)
+
+// Recommendations are the tracks recommended by spotify from the seed tracks
+// Seed tells where the seeds come from: the player, a playlist or the top tracks of the user
+type Recommendations struct {
+	Seed       string       `json:"seed"`
+	SeedTracks []spotify.ID `json:"seed_tracks"`
+	Tracks     []Track      `json:"tracks"`
+}
+
+// ReduceRecommendations will reduce the spotify recommendations to a simplified structure
+// Spotify answers simple tracks, the recommended tracks have no album name
+func ReduceRecommendations(seed string, seedTracks []spotify.ID, recommendations *spotify.Recommendations) Recommendations {
+	reduced := Recommendations{Seed: seed, SeedTracks: seedTracks, Tracks: []Track{}}
+	for _, t := range recommendations.Tracks {
+		reduced.Tracks = append(reduced.Tracks, ReduceTrack(spotify.FullTrack{SimpleTrack: t}))
+	}
+	return reduced
+}
+
+// Radio is the radio started from recommendations, either played as a queue of tracks or as a new playlist
+// Playlist is only set in the playlist mode
+type Radio struct {
+	Mode     string        `json:"mode"`
+	Playlist *PlaylistItem `json:"playlist,omitempty"`
+	Recommendations
+}
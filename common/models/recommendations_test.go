@@ -0,0 +1,29 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_ReduceRecommendations(t *testing.T) {
+	recommendations := &spotify.Recommendations{Tracks: []spotify.SimpleTrack{{
+		Name:     "Coffee",
+		ID:       "coffee",
+		URI:      "spotify:track:coffee",
+		Duration: 200000,
+		Artists:  []spotify.SimpleArtist{{Name: "The Early Birds"}},
+	}}}
+	want := Recommendations{
+		Seed:       "player",
+		SeedTracks: []spotify.ID{"sunrise"},
+		Tracks:     []Track{{Name: "Coffee", ArtistsName: []string{"The Early Birds"}, ID: "coffee", URI: "spotify:track:coffee", Duration: 200000}},
+	}
+	if got := ReduceRecommendations("player", []spotify.ID{"sunrise"}, recommendations); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceRecommendations() = %+v, want %+v", got, want)
+	}
+	if got := ReduceRecommendations("top", nil, &spotify.Recommendations{}); got.Tracks == nil {
+		t.Errorf("ReduceRecommendations() should return empty tracks, not nil")
+	}
+}
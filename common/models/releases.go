@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// NewReleaseEvent is the event of the notifications of new releases
+const NewReleaseEvent = "new_release"
+
+// Release is a new album or single of an artist followed by the user, ReportedAt is when it was delivered
+type Release struct {
+	ArtistAlbum
+	ArtistID   spotify.ID `json:"artist_id"`
+	ReportedAt time.Time  `json:"reported_at"`
+}
+
+// ReleaseWatch is the watch of the new releases of the artists followed by a user
+// The releases are delivered to the playlist, or to the webhooks of the user, then kept for a week
+// Watching is false once the user stopped watching, the scans also pause while the access token of the user is expired
+type ReleaseWatch struct {
+	Deliver    string     `json:"deliver"`
+	PlaylistID spotify.ID `json:"playlist_id"`
+	Watching   bool       `json:"watching"`
+	LastScan   time.Time  `json:"last_scan"`
+	Releases   []Release  `json:"releases"`
+}
+
+// ReduceRelease will reduce the spotify album of the followed artist to a release reported at the given time
+func ReduceRelease(artistID spotify.ID, album spotify.SimpleAlbum, reportedAt time.Time) Release {
+	return Release{
+		ArtistAlbum: ArtistAlbum{Album: ReduceAlbum(album), Type: album.AlbumType, Group: album.AlbumGroup},
+		ArtistID:    artistID,
+		ReportedAt:  reportedAt,
+	}
+}
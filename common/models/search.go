@@ -0,0 +1,213 @@
+package models
+
+import (
+	"net/http"
+
+	"github.com/zmb3/spotify"
+)
+
+// PlayPath is the route of the player service playing a uri
+const PlayPath = "/player/play"
+
+// PlayLink is the request playing a result, its body is the one expected by POST /player/play
+type PlayLink struct {
+	Method string   `json:"method"`
+	Href   string   `json:"href"`
+	Body   PlayBody `json:"body"`
+}
+
+// PlayBody is the body of POST /player/play
+type PlayBody struct {
+	URI spotify.URI `json:"uri"`
+}
+
+// NewPlayLink returns the link playing the uri
+func NewPlayLink(uri spotify.URI) PlayLink {
+	return PlayLink{Method: http.MethodPost, Href: PlayPath, Body: PlayBody{URI: uri}}
+}
+
+// Album is a simplified structure of an album
+type Album struct {
+	Name        string      `json:"name"`
+	ArtistsName []string    `json:"artists_name"`
+	ID          spotify.ID  `json:"ID"`
+	URI         spotify.URI `json:"uri"`
+	Image       string      `json:"image"`
+	ReleaseDate string      `json:"release_date"`
+}
+
+// ReduceAlbum will reduce a spotify album to a simplified one
+func ReduceAlbum(album spotify.SimpleAlbum) Album {
+	var artists []string
+	for _, a := range album.Artists {
+		artists = append(artists, a.Name)
+	}
+	return Album{
+		Name:        album.Name,
+		ArtistsName: artists,
+		ID:          album.ID,
+		URI:         album.URI,
+		Image:       firstImage(album.Images),
+		ReleaseDate: album.ReleaseDate,
+	}
+}
+
+// Artist is a simplified structure of an artist
+type Artist struct {
+	Name   string      `json:"name"`
+	ID     spotify.ID  `json:"ID"`
+	URI    spotify.URI `json:"uri"`
+	Image  string      `json:"image"`
+	Genres []string    `json:"genres"`
+}
+
+// ReduceArtist will reduce a spotify artist to a simplified one
+func ReduceArtist(artist spotify.FullArtist) Artist {
+	genres := artist.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+	return Artist{
+		Name:   artist.Name,
+		ID:     artist.ID,
+		URI:    artist.URI,
+		Image:  firstImage(artist.Images),
+		Genres: genres,
+	}
+}
+
+// Show is a simplified structure of a podcast show
+type Show struct {
+	Name      string      `json:"name"`
+	Publisher string      `json:"publisher"`
+	ID        spotify.ID  `json:"ID"`
+	URI       spotify.URI `json:"uri"`
+	Image     string      `json:"image"`
+}
+
+// ReduceShow will reduce a spotify show to a simplified one
+func ReduceShow(show spotify.SimpleShow) Show {
+	return Show{
+		Name:      show.Name,
+		Publisher: show.Publisher,
+		ID:        show.ID,
+		URI:       show.URI,
+		Image:     firstImage(show.Images),
+	}
+}
+
+// TrackResult is a track found by a search
+type TrackResult struct {
+	Track
+	Play PlayLink `json:"play"`
+}
+
+// AlbumResult is an album found by a search
+type AlbumResult struct {
+	Album
+	Play PlayLink `json:"play"`
+}
+
+// ArtistResult is an artist found by a search
+type ArtistResult struct {
+	Artist
+	Play PlayLink `json:"play"`
+}
+
+// PlaylistResult is a playlist found by a search
+type PlaylistResult struct {
+	PlaylistItem
+	Play PlayLink `json:"play"`
+}
+
+// ShowResult is a show found by a search
+type ShowResult struct {
+	Show
+	Play PlayLink `json:"play"`
+}
+
+// TrackResults is a page of the tracks found, Total counts all of them
+type TrackResults struct {
+	Items []TrackResult `json:"items"`
+	Total int           `json:"total"`
+}
+
+// AlbumResults is a page of the albums found, Total counts all of them
+type AlbumResults struct {
+	Items []AlbumResult `json:"items"`
+	Total int           `json:"total"`
+}
+
+// ArtistResults is a page of the artists found, Total counts all of them
+type ArtistResults struct {
+	Items []ArtistResult `json:"items"`
+	Total int            `json:"total"`
+}
+
+// PlaylistResults is a page of the playlists found, Total counts all of them
+type PlaylistResults struct {
+	Items []PlaylistResult `json:"items"`
+	Total int              `json:"total"`
+}
+
+// ShowResults is a page of the shows found, Total counts all of them
+type ShowResults struct {
+	Items []ShowResult `json:"items"`
+	Total int          `json:"total"`
+}
+
+// SearchResult is a simplified structure of a search, only the searched types are set
+type SearchResult struct {
+	Tracks    *TrackResults    `json:"tracks,omitempty"`
+	Albums    *AlbumResults    `json:"albums,omitempty"`
+	Artists   *ArtistResults   `json:"artists,omitempty"`
+	Playlists *PlaylistResults `json:"playlists,omitempty"`
+	Shows     *ShowResults     `json:"shows,omitempty"`
+}
+
+// ReduceSearchResult will reduce the spotify search result to a simplified one
+func ReduceSearchResult(result *spotify.SearchResult) SearchResult {
+	var reduced SearchResult
+	if result.Tracks != nil {
+		reduced.Tracks = &TrackResults{Items: []TrackResult{}, Total: result.Tracks.Total}
+		for _, t := range result.Tracks.Tracks {
+			reduced.Tracks.Items = append(reduced.Tracks.Items, TrackResult{Track: ReduceTrack(t), Play: NewPlayLink(t.URI)})
+		}
+	}
+	if result.Albums != nil {
+		reduced.Albums = &AlbumResults{Items: []AlbumResult{}, Total: result.Albums.Total}
+		for _, a := range result.Albums.Albums {
+			reduced.Albums.Items = append(reduced.Albums.Items, AlbumResult{Album: ReduceAlbum(a), Play: NewPlayLink(a.URI)})
+		}
+	}
+	if result.Artists != nil {
+		reduced.Artists = &ArtistResults{Items: []ArtistResult{}, Total: result.Artists.Total}
+		for _, a := range result.Artists.Artists {
+			reduced.Artists.Items = append(reduced.Artists.Items, ArtistResult{Artist: ReduceArtist(a), Play: NewPlayLink(a.URI)})
+		}
+	}
+	if result.Playlists != nil {
+		reduced.Playlists = &PlaylistResults{Items: []PlaylistResult{}, Total: result.Playlists.Total}
+		for _, p := range result.Playlists.Playlists {
+			reduced.Playlists.Items = append(reduced.Playlists.Items, PlaylistResult{PlaylistItem: ReducePlaylistItem(p), Play: NewPlayLink(p.URI)})
+		}
+	}
+	return reduced
+}
+
+// ReduceShows will reduce the spotify shows found by a search to simplified ones
+func ReduceShows(shows []spotify.SimpleShow, total int) *ShowResults {
+	reduced := &ShowResults{Items: []ShowResult{}, Total: total}
+	for _, s := range shows {
+		reduced.Items = append(reduced.Items, ShowResult{Show: ReduceShow(s), Play: NewPlayLink(s.URI)})
+	}
+	return reduced
+}
+
+// firstImage returns the url of the widest image, spotify sorts them by size
+func firstImage(images []spotify.Image) string {
+	if len(images) > 0 {
+		return images[0].URL
+	}
+	return ""
+}
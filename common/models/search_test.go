@@ -0,0 +1,71 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_ReduceSearchResult(t *testing.T) {
+	tracks := &spotify.FullTrackPage{Tracks: []spotify.FullTrack{{
+		SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise", URI: "spotify:track:sunrise", Duration: 180000, Artists: []spotify.SimpleArtist{{Name: "The Early Birds"}}},
+		Album:       spotify.SimpleAlbum{Name: "Dawn"},
+	}}}
+	tracks.Total = 12
+	albums := &spotify.SimpleAlbumPage{Albums: []spotify.SimpleAlbum{{
+		Name: "Dawn", ID: "dawn", URI: "spotify:album:dawn", ReleaseDate: "2020-12-15",
+		Artists: []spotify.SimpleArtist{{Name: "The Early Birds"}}, Images: []spotify.Image{{URL: "http://dawn"}, {URL: "http://dawn-small"}},
+	}}}
+	albums.Total = 1
+	artists := &spotify.FullArtistPage{Artists: []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "The Early Birds", ID: "birds", URI: "spotify:artist:birds"}}}}
+	artists.Total = 1
+
+	tests := []struct {
+		name   string
+		result *spotify.SearchResult
+		want   SearchResult
+	}{
+		{
+			name:   "should only set the searched types",
+			result: &spotify.SearchResult{Playlists: &spotify.SimplePlaylistPage{}},
+			want:   SearchResult{Playlists: &PlaylistResults{Items: []PlaylistResult{}}},
+		},
+		{
+			name:   "should reduce the results with a link playing them",
+			result: &spotify.SearchResult{Tracks: tracks, Albums: albums, Artists: artists},
+			want: SearchResult{
+				Tracks: &TrackResults{Total: 12, Items: []TrackResult{{
+					Track: Track{Name: "Sunrise", ArtistsName: []string{"The Early Birds"}, AlbumName: "Dawn", ID: "sunrise", URI: "spotify:track:sunrise", Duration: 180000},
+					Play:  PlayLink{Method: "POST", Href: "/player/play", Body: PlayBody{URI: "spotify:track:sunrise"}},
+				}}},
+				Albums: &AlbumResults{Total: 1, Items: []AlbumResult{{
+					Album: Album{Name: "Dawn", ArtistsName: []string{"The Early Birds"}, ID: "dawn", URI: "spotify:album:dawn", Image: "http://dawn", ReleaseDate: "2020-12-15"},
+					Play:  PlayLink{Method: "POST", Href: "/player/play", Body: PlayBody{URI: "spotify:album:dawn"}},
+				}}},
+				Artists: &ArtistResults{Total: 1, Items: []ArtistResult{{
+					Artist: Artist{Name: "The Early Birds", ID: "birds", URI: "spotify:artist:birds", Genres: []string{}},
+					Play:   PlayLink{Method: "POST", Href: "/player/play", Body: PlayBody{URI: "spotify:artist:birds"}},
+				}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReduceSearchResult(tt.result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReduceSearchResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ReduceShows(t *testing.T) {
+	got := ReduceShows([]spotify.SimpleShow{{Name: "Morning News", Publisher: "Radio", ID: "news", URI: "spotify:show:news"}}, 3)
+	want := &ShowResults{Total: 3, Items: []ShowResult{{
+		Show: Show{Name: "Morning News", Publisher: "Radio", ID: "news", URI: "spotify:show:news"},
+		Play: PlayLink{Method: "POST", Href: "/player/play", Body: PlayBody{URI: "spotify:show:news"}},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReduceShows() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+// PlaylistMove moves the track at RangeStart before the track at InsertBefore
+// The positions are the ones before the move, like the reorder of spotify
+type PlaylistMove struct {
+	RangeStart   int `json:"range_start"`
+	InsertBefore int `json:"insert_before"`
+}
+
+// SortedPlaylist is a playlist with all its tracks in the sorted order and the moves reordering it
+// SnapshotID is the version of the playlist sorted, or the version left by the moves once applied
+type SortedPlaylist struct {
+	PlaylistItem
+	SnapshotID string         `json:"snapshot_id"`
+	By         string         `json:"by"`
+	Order      string         `json:"order"`
+	Applied    bool           `json:"applied"`
+	Tracks     []Track        `json:"tracks"`
+	Moves      []PlaylistMove `json:"moves"`
+}
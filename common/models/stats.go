@@ -0,0 +1,85 @@
+package models
+
+// TopItem is a track, an artist, an album or a genre ranked by its plays
+// The genres have no ID, ArtistsName is only set for the tracks and the albums
+type TopItem struct {
+	Name        string   `json:"name"`
+	ID          string   `json:"ID,omitempty"`
+	ArtistsName []string `json:"artists_name,omitempty"`
+	Plays       int      `json:"plays"`
+	Minutes     int      `json:"minutes"`
+}
+
+// Top is the ranking of the items of a type over a window, the most played first
+type Top struct {
+	Type  string    `json:"type"`
+	Items []TopItem `json:"items"`
+}
+
+// DayMinutes are the listening minutes of a day, e.g. 2021-01-02
+type DayMinutes struct {
+	Date    string `json:"date"`
+	Minutes int    `json:"minutes"`
+}
+
+// MonthMinutes are the listening minutes of a month, e.g. 2021-01
+type MonthMinutes struct {
+	Month   string `json:"month"`
+	Minutes int    `json:"minutes"`
+}
+
+// ListeningMinutes are the listening minutes over a window, per day and per hour of the week
+// HourOfWeek is indexed by the day of the week from monday, then by the hour of the day
+type ListeningMinutes struct {
+	Total      int          `json:"total"`
+	Days       []DayMinutes `json:"days"`
+	HourOfWeek [7][24]int   `json:"hour_of_week"`
+}
+
+// Streak is a run of consecutive days with plays, its dates are empty when there is none
+type Streak struct {
+	Days int    `json:"days"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Streaks are the current and the longest runs of listening days
+// The current streak is still running when nothing was played yet today
+type Streaks struct {
+	Current Streak `json:"current"`
+	Longest Streak `json:"longest"`
+}
+
+// DiscoveryMonth counts the artists played during a month and the ones played for the first time
+type DiscoveryMonth struct {
+	Month      string  `json:"month"`
+	Artists    int     `json:"artists"`
+	NewArtists int     `json:"new_artists"`
+	Rate       float64 `json:"rate"`
+}
+
+// Discovery is the rate of new artists over a window, Rate is the share of the artists played for the first time
+type Discovery struct {
+	Months     []DiscoveryMonth `json:"months"`
+	Artists    int              `json:"artists"`
+	NewArtists int              `json:"new_artists"`
+	Rate       float64          `json:"rate"`
+}
+
+// Report is the summary of a year of listening
+type Report struct {
+	Year          int            `json:"year"`
+	Plays         int            `json:"plays"`
+	Minutes       int            `json:"minutes"`
+	Tracks        int            `json:"tracks"`
+	Artists       int            `json:"artists"`
+	TopTracks     []TopItem      `json:"top_tracks"`
+	TopArtists    []TopItem      `json:"top_artists"`
+	TopAlbums     []TopItem      `json:"top_albums"`
+	TopGenres     []TopItem      `json:"top_genres"`
+	TopDay        DayMinutes     `json:"top_day"`
+	Months        []MonthMinutes `json:"months"`
+	HourOfWeek    [7][24]int     `json:"hour_of_week"`
+	LongestStreak Streak         `json:"longest_streak"`
+	Discovery     Discovery      `json:"discovery"`
+}
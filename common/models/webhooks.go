@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// TrackChangedEvent is the event of another track played by the player of the user
+	TrackChangedEvent = "track_changed"
+	// PlaylistUpdatedEvent is the event of a playlist created, filled or reordered by the services
+	PlaylistUpdatedEvent = "playlist_updated"
+)
+
+// The changes of a playlist_updated event
+const (
+	PlaylistCreated     = "created"
+	PlaylistTracksAdded = "tracks_added"
+	PlaylistReordered   = "reordered"
+)
+
+// WebhookEvents are the events a webhook can be registered for
+var WebhookEvents = []string{TrackChangedEvent, PlaylistUpdatedEvent, NewReleaseEvent}
+
+// Event is an event of a user published by the services, it is the body posted to the webhooks
+type Event struct {
+	ID         string          `json:"id"`
+	Event      string          `json:"event"`
+	UserID     string          `json:"user_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// PlaylistUpdate is the data of the playlist_updated event, Change is created, tracks_added or reordered
+type PlaylistUpdate struct {
+	PlaylistID spotify.ID   `json:"playlist_id"`
+	SnapshotID string       `json:"snapshot_id"`
+	Change     string       `json:"change"`
+	TrackIDs   []spotify.ID `json:"track_ids"`
+}
+
+// NewReleases is the data of the new_release event, the releases found by a scan of the followed artists
+type NewReleases struct {
+	Releases []Release `json:"releases"`
+}
+
+// Webhook is an url the events of a user are posted to, the secret signing them is never answered
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook
+// Status is pending until the webhook answers 2xx (delivered) or every attempt failed (dead_letter)
+// ResponseStatus and Error are the outcome of the last attempt
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status"`
+	Error          string          `json:"error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	LastAttemptAt  time.Time       `json:"last_attempt_at"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	Payload        json.RawMessage `json:"payload"`
+}
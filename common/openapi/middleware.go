@@ -0,0 +1,37 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorBody is the json body of a rejected request, with the same envelope as the gateway errors
+type errorBody struct {
+	Error struct {
+		Status  int          `json:"status"`
+		Code    string       `json:"code"`
+		Message string       `json:"message"`
+		Fields  []FieldError `json:"fields"`
+	} `json:"error"`
+}
+
+// Middleware rejects the requests that do not match the specification with a 400 listing the invalid fields
+// The routes that are not in the specification are passed through
+func (s *Spec) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs := s.ValidateRequest(r)
+		if len(errs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body errorBody
+		body.Error.Status = http.StatusBadRequest
+		body.Error.Code = "invalid_request"
+		body.Error.Message = "the request does not match the api specification"
+		body.Error.Fields = errs
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(body)
+	})
+}
@@ -0,0 +1,328 @@
+// Package openapi holds the openapi specification of the REST API and validates the requests and the responses against it
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// specJSON is the openapi specification of every route of the REST API
+//
+//go:embed openapi.json
+var specJSON []byte
+
+// Spec is the subset of the openapi document used to validate the requests and the responses
+type Spec struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+
+	raw []byte
+	// templates are the paths in the order Find tries them
+	templates []string
+}
+
+// Operation is a method of a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []Parameter          `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path or a query parameter of an operation
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the body expected by an operation
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation, Ref points to a response of the components
+type Response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]MediaType `json:"content"`
+}
+
+// MediaType is the schema of a content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Load parses the embedded specification
+func Load() (*Spec, error) {
+	return Parse(specJSON)
+}
+
+// MustLoad parses the embedded specification and panics if it is invalid
+func MustLoad() *Spec {
+	spec, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+// Parse parses an openapi specification
+func Parse(raw []byte) (*Spec, error) {
+	spec := &Spec{raw: raw}
+	if err := json.Unmarshal(raw, spec); err != nil {
+		return nil, fmt.Errorf("openapi: could not parse the specification: %w", err)
+	}
+	var schemas []*Schema
+	for _, s := range spec.Components.Schemas {
+		schemas = append(schemas, s)
+	}
+	for _, methods := range spec.Paths {
+		for _, op := range methods {
+			for _, p := range op.Parameters {
+				schemas = append(schemas, p.Schema)
+			}
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					schemas = append(schemas, m.Schema)
+				}
+			}
+			for _, r := range op.Responses {
+				for _, m := range r.Content {
+					schemas = append(schemas, m.Schema)
+				}
+			}
+		}
+	}
+	for _, r := range spec.Components.Responses {
+		for _, m := range r.Content {
+			schemas = append(schemas, m.Schema)
+		}
+	}
+	for _, s := range schemas {
+		if err := s.compile(); err != nil {
+			return nil, fmt.Errorf("openapi: %w", err)
+		}
+	}
+	spec.templates = sortTemplates(spec.Paths)
+	return spec, nil
+}
+
+// sortTemplates returns the path templates with the static ones first so /user/me would win over /user/{userID}
+func sortTemplates(paths map[string]map[string]*Operation) []string {
+	templates := make([]string, 0, len(paths))
+	for template := range paths {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return strings.Count(templates[i], "{") < strings.Count(templates[j], "{") ||
+			strings.Count(templates[i], "{") == strings.Count(templates[j], "{") && templates[i] < templates[j]
+	})
+	return templates
+}
+
+// Handler serves the specification
+func (s *Spec) Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.raw)
+}
+
+// Find returns the operation matching the method and the path along with the path parameters
+// It returns nil when the route is not in the specification
+func (s *Spec) Find(method, path string) (*Operation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, template := range s.templates {
+		params, ok := matchPath(strings.Split(strings.Trim(template, "/"), "/"), segments)
+		if !ok {
+			continue
+		}
+		if op, ok := s.Paths[template][strings.ToLower(method)]; ok {
+			return op, params
+		}
+	}
+	return nil, nil
+}
+
+// matchPath matches the segments of the path against the segments of a template
+func matchPath(template, segments []string) (map[string]string, bool) {
+	if len(template) != len(segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, t := range template {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[strings.Trim(t, "{}")] = segments[i]
+		} else if t != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// ValidateRequest checks the parameters and the body of the request against its operation
+// The body is read and replaced so the next handlers can still read it
+func (s *Spec) ValidateRequest(r *http.Request) []FieldError {
+	op, pathParams := s.Find(r.Method, r.URL.Path)
+	if op == nil {
+		return nil
+	}
+
+	var errs []FieldError
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		v := &validator{schemas: s.Components.Schemas, in: p.In}
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = pathParams[p.Name]
+		case "query":
+			_, present = query[p.Name]
+			raw = query.Get(p.Name)
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				v.fail(p.Name, "is required")
+			}
+			errs = append(errs, v.errs...)
+			continue
+		}
+		value, err := parseParameter(v.resolve(p.Schema), raw)
+		if err != nil {
+			v.fail(p.Name, "%s", err)
+		} else {
+			v.validate(p.Schema, value, p.Name)
+		}
+		errs = append(errs, v.errs...)
+	}
+
+	if op.RequestBody != nil {
+		errs = append(errs, s.validateRequestBody(r, op.RequestBody)...)
+	}
+	return errs
+}
+
+// validateRequestBody checks the json body of the request
+func (s *Spec) validateRequestBody(r *http.Request, body *RequestBody) []FieldError {
+	v := &validator{schemas: s.Components.Schemas, in: "body"}
+	var content []byte
+	if r.Body != nil {
+		var err error
+		content, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(content))
+		if err != nil {
+			v.fail("", "could not be read")
+			return v.errs
+		}
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		if body.Required {
+			v.fail("", "is required")
+		}
+		return v.errs
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		v.fail("", "must be valid json: %s", err)
+		return v.errs
+	}
+	if media, ok := body.Content["application/json"]; ok {
+		v.validate(media.Schema, value, "")
+	}
+	return v.errs
+}
+
+// parseParameter converts the raw value of a parameter to the type of its schema
+func parseParameter(schema *Schema, raw string) (interface{}, error) {
+	if schema == nil {
+		return raw, nil
+	}
+	switch schema.Type {
+	case "integer", "number":
+		number, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be %s", typeName(schema.Type))
+		}
+		return number, nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	case "array":
+		var items []interface{}
+		for _, item := range strings.Split(raw, ",") {
+			value, err := parseParameter(schema.Items, item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	}
+	return raw, nil
+}
+
+// ValidateResponse checks a response of the operation against the schema of its status
+// It is used by the contract tests of the microservices
+func (s *Spec) ValidateResponse(method, path string, status int, body []byte) error {
+	op, _ := s.Find(method, path)
+	if op == nil {
+		return fmt.Errorf("%s %s is not in the specification", method, path)
+	}
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s %s does not document the status %d", method, path, status)
+	}
+	if resp.Ref != "" {
+		resp = s.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+	}
+
+	media, ok := resp.Content["application/json"]
+	if !ok {
+		// only the json bodies are validated, e.g. an event stream is not
+		if len(resp.Content) > 0 {
+			return nil
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("%s %s %d documents no body, got %q", method, path, status, body)
+		}
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("%s %s %d: invalid json body: %w", method, path, status, err)
+	}
+	v := &validator{schemas: s.Components.Schemas, in: "body"}
+	v.validate(media.Schema, value, "")
+	if len(v.errs) > 0 {
+		var messages []string
+		for _, e := range v.errs {
+			messages = append(messages, e.Error())
+		}
+		return fmt.Errorf("%s %s %d: %s", method, path, status, strings.Join(messages, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Load(t *testing.T) {
+	spec, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/user"}, {"GET", "/user/thomas"}, {"GET", "/player"}, {"POST", "/player/play"},
+		{"POST", "/player/pause"}, {"POST", "/player/next"}, {"POST", "/player/prev"}, {"GET", "/playlist"},
+		{"GET", "/dashboard"}, {"POST", "/graphql"}, {"GET", "/healthz"}, {"GET", "/readyz"},
+	} {
+		if op, _ := spec.Find(route.method, route.path); op == nil {
+			t.Errorf("%s %s is not in the specification", route.method, route.path)
+		}
+	}
+}
+
+func Test_Find(t *testing.T) {
+	spec := MustLoad()
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		expectedID  string
+		expectedPar map[string]string
+	}{
+		{name: "should find a static path", method: "GET", path: "/user", expectedID: "getCurrentUser", expectedPar: map[string]string{}},
+		{name: "should find a templated path", method: "GET", path: "/user/thomas", expectedID: "getUser", expectedPar: map[string]string{"userID": "thomas"}},
+		{name: "should ignore the trailing slash", method: "POST", path: "/player/play/", expectedID: "play", expectedPar: map[string]string{}},
+		{name: "should not find an unknown method", method: "DELETE", path: "/user"},
+		{name: "should not find an unknown path", method: "GET", path: "/user/thomas/friends"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, params := spec.Find(tt.method, tt.path)
+			if tt.expectedID == "" {
+				if op != nil {
+					t.Errorf("Find() = %v, want nil", op.OperationID)
+				}
+				return
+			}
+			if op == nil || op.OperationID != tt.expectedID || !reflect.DeepEqual(params, tt.expectedPar) {
+				t.Errorf("Find() = %+v, %v, want %v, %v", op, params, tt.expectedID, tt.expectedPar)
+			}
+		})
+	}
+}
+
+func Test_ValidateRequest(t *testing.T) {
+	spec := MustLoad()
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected []FieldError
+	}{
+		{name: "should accept an empty play request", method: "POST", path: "/player/play", body: `{}`},
+		{name: "should accept a play request with a uri", method: "POST", path: "/player/play", body: `{"uri":"spotify:playlist:37i9dQZF1DX"}`},
+		{name: "should accept a route without body", method: "POST", path: "/player/pause", body: `not json`},
+		{name: "should accept a user ID", method: "GET", path: "/user/thomas"},
+		{name: "should accept a route not in the specification", method: "GET", path: "/unknown", body: `not json`},
+		{
+			name: "should reject a missing body", method: "POST", path: "/player/play",
+			expected: []FieldError{{In: "body", Message: "is required"}},
+		},
+		{
+			name: "should reject malformed json", method: "POST", path: "/player/play", body: `{"uri":`,
+			expected: []FieldError{{In: "body", Message: "must be valid json: unexpected end of JSON input"}},
+		},
+		{
+			name: "should reject a body of the wrong type", method: "POST", path: "/player/play", body: `[]`,
+			expected: []FieldError{{In: "body", Message: "must be an object"}},
+		},
+		{
+			name: "should reject a field of the wrong type", method: "POST", path: "/player/play", body: `{"uri":42}`,
+			expected: []FieldError{{In: "body", Field: "uri", Message: "must be a string"}},
+		},
+		{
+			name: "should reject an invalid uri and an unknown field", method: "POST", path: "/player/play", body: `{"uri":"http://x","volume":2}`,
+			expected: []FieldError{
+				{In: "body", Field: "uri", Message: "must match ^(spotify(:[A-Za-z0-9._-]+)+)?$"},
+				{In: "body", Field: "volume", Message: "is not allowed"},
+			},
+		},
+		{
+			name: "should reject a missing required field", method: "POST", path: "/graphql", body: `{"variables":null}`,
+			expected: []FieldError{{In: "body", Field: "query", Message: "is required"}},
+		},
+		{
+			name: "should reject a too long path parameter", method: "GET", path: "/user/" + strings.Repeat("a", 129),
+			expected: []FieldError{{In: "path", Field: "userID", Message: "must be at most 128 characters long"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			errs := spec.ValidateRequest(r)
+			if !reflect.DeepEqual(errs, tt.expected) {
+				t.Errorf("ValidateRequest() = %+v, want %+v", errs, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_validateQuery(t *testing.T) {
+	min, max := 1.0, 50.0
+	spec := &Spec{Paths: map[string]map[string]*Operation{
+		"/search": {"get": {Parameters: []Parameter{
+			{Name: "q", In: "query", Required: true, Schema: &Schema{Type: "string"}},
+			{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: &min, Maximum: &max}},
+			{Name: "type", In: "query", Schema: &Schema{Type: "array", Items: &Schema{Type: "string", Enum: []interface{}{"track", "album"}}}},
+		}}},
+	}}
+	spec.templates = sortTemplates(spec.Paths)
+	tests := []struct {
+		name     string
+		query    string
+		expected []FieldError
+	}{
+		{name: "should accept valid parameters", query: "q=daft&limit=10&type=track,album"},
+		{
+			name:  "should reject invalid parameters",
+			query: "limit=ten&type=track,show",
+			expected: []FieldError{
+				{In: "query", Field: "q", Message: "is required"},
+				{In: "query", Field: "limit", Message: "must be an integer"},
+				{In: "query", Field: "type[1]", Message: "must be one of [track album]"},
+			},
+		},
+		{
+			name:     "should reject an out of range integer",
+			query:    "q=daft&limit=51",
+			expected: []FieldError{{In: "query", Field: "limit", Message: "must be less than or equal to 50"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := spec.ValidateRequest(httptest.NewRequest("GET", "/search?"+tt.query, nil))
+			if !reflect.DeepEqual(errs, tt.expected) {
+				t.Errorf("ValidateRequest() = %+v, want %+v", errs, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_Middleware(t *testing.T) {
+	spec := MustLoad()
+	var received string
+	handler := spec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ URI string }
+		json.NewDecoder(r.Body).Decode(&body)
+		received = body.URI
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/player/play", strings.NewReader(`{"uri":"spotify:album:1"}`)))
+	if rr.Code != http.StatusOK || received != "spotify:album:1" {
+		t.Errorf("valid request was not passed with its body: got %v, %q", rr.Code, received)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/player/play", strings.NewReader(`{"uri":1}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	expected := `{"error":{"status":400,"code":"invalid_request","message":"the request does not match the api specification","fields":[{"in":"body","field":"uri","message":"must be a string"}]}}`
+	if strings.TrimSpace(rr.Body.String()) != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", strings.TrimSpace(rr.Body.String()), expected)
+	}
+	if err := spec.ValidateResponse("POST", "/player/play", rr.Code, rr.Body.Bytes()); err != nil {
+		t.Errorf("the error does not match the specification: %v", err)
+	}
+}
+
+func Test_ValidateResponse(t *testing.T) {
+	spec := MustLoad()
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		status      int
+		body        string
+		expectedErr string
+	}{
+		{name: "should accept a valid user", method: "GET", path: "/user", status: 200, body: `{"name":"Thomas","id":"thomas","image":""}`},
+		{name: "should accept no content", method: "GET", path: "/player", status: 204},
+		{name: "should accept a documented error", method: "GET", path: "/user", status: 500, body: `{"error":{"status":500,"code":"internal_server_error","message":"oops"}}`},
+		{name: "should not validate an event stream", method: "GET", path: "/player/events", status: 200, body: "retry: 3000\n\nevent: nothing_playing\ndata: {}\n\n"},
+		{name: "should accept a null artists list", method: "GET", path: "/player", status: 200, body: `{"is_playing":false,"album_name":"","artists_name":null,"music_name":"","ID":"","release_date":"0001-01-01T00:00:00Z","progress":0,"duration":0}`},
+		{name: "should accept a null reference", method: "POST", path: "/tracks/features", status: 200, body: `{"items":[null,{"ID":"sunrise","tempo":120,"key":2,"mode":1,"energy":0.5,"danceability":0.25,"valence":0.75}]}`},
+		{
+			name: "should reject a missing field", method: "GET", path: "/user", status: 200, body: `{"name":"Thomas","id":"thomas"}`,
+			expectedErr: "GET /user 200: body image: is required",
+		},
+		{
+			name: "should reject an invalid date", method: "GET", path: "/player", status: 200, body: `{"is_playing":false,"album_name":"","artists_name":[],"music_name":"","ID":"","release_date":"2020","progress":0,"duration":0}`,
+			expectedErr: "GET /player 200: body release_date: must be a RFC 3339 date-time",
+		},
+		{
+			name: "should reject a body on a route without content", method: "POST", path: "/player/pause", status: 200, body: `{}`,
+			expectedErr: `POST /player/pause 200 documents no body, got "{}"`,
+		},
+		{
+			name: "should reject an unknown route", method: "GET", path: "/unknown", status: 200,
+			expectedErr: "GET /unknown is not in the specification",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := spec.ValidateResponse(tt.method, tt.path, tt.status, []byte(tt.body))
+			if (err == nil) != (tt.expectedErr == "") || err != nil && err.Error() != tt.expectedErr {
+				t.Errorf("ValidateResponse() error = %v, want %v", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func Test_ValidateRequest_playlistPage(t *testing.T) {
+	spec := MustLoad()
+	if errs := spec.ValidateRequest(httptest.NewRequest("GET", "/playlist?limit=50&offset=100", nil)); errs != nil {
+		t.Errorf("ValidateRequest() = %+v, want no error", errs)
+	}
+	expected := []FieldError{{In: "query", Field: "limit", Message: "must be less than or equal to 50"}}
+	if errs := spec.ValidateRequest(httptest.NewRequest("GET", "/playlist?limit=51", nil)); !reflect.DeepEqual(errs, expected) {
+		t.Errorf("ValidateRequest() = %+v, want %+v", errs, expected)
+	}
+}
@@ -0,0 +1,255 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of the openapi schema object used by the spec
+type Schema struct {
+	Ref                     string             `json:"$ref"`
+	Type                    string             `json:"type"`
+	Format                  string             `json:"format"`
+	Nullable                bool               `json:"nullable"`
+	Enum                    []interface{}      `json:"enum"`
+	Pattern                 string             `json:"pattern"`
+	MinLength               *int               `json:"minLength"`
+	MaxLength               *int               `json:"maxLength"`
+	Minimum                 *float64           `json:"minimum"`
+	Maximum                 *float64           `json:"maximum"`
+	MinItems                *int               `json:"minItems"`
+	MaxItems                *int               `json:"maxItems"`
+	Items                   *Schema            `json:"items"`
+	Properties              map[string]*Schema `json:"properties"`
+	Required                []string           `json:"required"`
+	RawAdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Description             string             `json:"description"`
+
+	// noAdditionalProperties and additionalProperties are parsed from RawAdditionalProperties, which is a boolean or a schema
+	noAdditionalProperties bool
+	additionalProperties   *Schema
+	pattern                *regexp.Regexp
+}
+
+// compile parses the additional properties and the pattern of the schema and its sub schemas
+func (s *Schema) compile() error {
+	if s == nil {
+		return nil
+	}
+	if len(s.RawAdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(s.RawAdditionalProperties, &allowed); err == nil {
+			s.noAdditionalProperties = !allowed
+		} else if err := json.Unmarshal(s.RawAdditionalProperties, &s.additionalProperties); err != nil {
+			return fmt.Errorf("invalid additionalProperties: %w", err)
+		}
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for _, sub := range append([]*Schema{s.Items, s.additionalProperties}, propertySchemas(s)...) {
+		if err := sub.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propertySchemas returns the schemas of the properties
+func propertySchemas(s *Schema) []*Schema {
+	var schemas []*Schema
+	for _, p := range s.Properties {
+		schemas = append(schemas, p)
+	}
+	return schemas
+}
+
+// FieldError is an invalid field of a request or a response
+type FieldError struct {
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.In, e.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", e.In, e.Field, e.Message)
+}
+
+// validator validates the values against the schemas of a spec
+type validator struct {
+	schemas map[string]*Schema
+	in      string
+	errs    []FieldError
+}
+
+// fail records an invalid field
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{In: v.in, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// resolve follows the reference of the schema, if any
+func (v *validator) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = v.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// validate checks the decoded json value against the schema, field is the path of the value
+func (v *validator) validate(s *Schema, value interface{}, field string) {
+	// a reference is nullable on its own, e.g. the items of a list where some are missing
+	if value == nil && s != nil && s.Nullable {
+		return
+	}
+	s = v.resolve(s)
+	if s == nil {
+		return
+	}
+	if value == nil {
+		if !s.Nullable && s.Type != "" {
+			v.fail(field, "must not be null")
+		}
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		v.fail(field, "must be one of %v", s.Enum)
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "must be an object")
+			return
+		}
+		v.validateObject(s, object, field)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "must be an array")
+			return
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			v.fail(field, "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(array) > *s.MaxItems {
+			v.fail(field, "must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range array {
+			v.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i))
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+			return
+		}
+		v.validateString(s, text, field)
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			v.fail(field, "must be %s", typeName(s.Type))
+			return
+		}
+		if s.Type == "integer" && number != math.Trunc(number) {
+			v.fail(field, "must be an integer")
+			return
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			v.fail(field, "must be greater than or equal to %v", *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			v.fail(field, "must be less than or equal to %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be a boolean")
+		}
+	}
+}
+
+// validateObject checks the required, the known and the additional properties of the object
+func (v *validator) validateObject(s *Schema, object map[string]interface{}, field string) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			v.fail(join(field, name), "is required")
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := object[name]
+		if property, ok := s.Properties[name]; ok {
+			v.validate(property, value, join(field, name))
+		} else if s.noAdditionalProperties {
+			v.fail(join(field, name), "is not allowed")
+		} else if s.additionalProperties != nil {
+			v.validate(s.additionalProperties, value, join(field, name))
+		}
+	}
+}
+
+// validateString checks the length, the pattern and the format of the string
+func (v *validator) validateString(s *Schema, text, field string) {
+	if s.MinLength != nil && len([]rune(text)) < *s.MinLength {
+		v.fail(field, "must be at least %d characters long", *s.MinLength)
+	}
+	if s.MaxLength != nil && len([]rune(text)) > *s.MaxLength {
+		v.fail(field, "must be at most %d characters long", *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(text) {
+		v.fail(field, "must match %s", s.Pattern)
+	}
+	switch s.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, text); err != nil {
+			v.fail(field, "must be a RFC 3339 date-time")
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", text); err != nil {
+			v.fail(field, "must be a date (YYYY-MM-DD)")
+		}
+	}
+}
+
+// typeName returns the type with its article, e.g. "an integer"
+func typeName(t string) string {
+	if t == "integer" || t == "object" || t == "array" {
+		return "an " + t
+	}
+	return "a " + t
+}
+
+// inEnum tells if the value is one of the values of the enum
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+// join returns the path of the property name in the field
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
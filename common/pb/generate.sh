@@ -0,0 +1,7 @@
+#!/bin/sh
+# Regenerates the go code of the protobuf definitions, run from common/pb
+# Requires protoc, protoc-gen-go v1.27.1 and protoc-gen-go-grpc v1.2.0
+set -e
+for proto in playerpb/player.proto playlistpb/playlist.proto userpb/user.proto; do
+	protoc -I . --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. "$proto"
+done
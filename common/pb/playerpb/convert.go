@@ -0,0 +1,36 @@
+package playerpb
+
+import (
+	"common/models"
+
+	"github.com/zmb3/spotify"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromModel converts the player model to its protobuf message
+func FromModel(p models.Player) *Player {
+	return &Player{
+		IsPlaying:   p.IsPlaying,
+		AlbumName:   p.AlbumName,
+		ArtistsName: p.ArtistsName,
+		MusicName:   p.MusicName,
+		Id:          string(p.ID),
+		ReleaseDate: timestamppb.New(p.ReleaseDate),
+		Progress:    int32(p.Progress),
+		Duration:    int32(p.Duration),
+	}
+}
+
+// ToModel converts the protobuf message to the player model
+func (x *Player) ToModel() models.Player {
+	return models.Player{
+		IsPlaying:   x.GetIsPlaying(),
+		AlbumName:   x.GetAlbumName(),
+		ArtistsName: x.GetArtistsName(),
+		MusicName:   x.GetMusicName(),
+		ID:          spotify.ID(x.GetId()),
+		ReleaseDate: x.GetReleaseDate().AsTime(),
+		Progress:    int(x.GetProgress()),
+		Duration:    int(x.GetDuration()),
+	}
+}
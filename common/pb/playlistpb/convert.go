@@ -0,0 +1,29 @@
+package playlistpb
+
+import (
+	"common/models"
+
+	"github.com/zmb3/spotify"
+)
+
+// FromModel converts the playlist item model to its protobuf message
+func FromModel(p models.PlaylistItem) *PlaylistItem {
+	return &PlaylistItem{
+		Image:     p.Image,
+		Name:      p.Name,
+		OwnerName: p.OwnerName,
+		Id:        string(p.ID),
+		Uri:       string(p.URI),
+	}
+}
+
+// ToModel converts the protobuf message to the playlist item model
+func (x *PlaylistItem) ToModel() models.PlaylistItem {
+	return models.PlaylistItem{
+		Image:     x.GetImage(),
+		Name:      x.GetName(),
+		OwnerName: x.GetOwnerName(),
+		ID:        spotify.ID(x.GetId()),
+		URI:       spotify.URI(x.GetUri()),
+	}
+}
@@ -0,0 +1,107 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.19.1
+// source: playlistpb/playlist.proto
+
+package playlistpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PlaylistServiceClient is the client API for PlaylistService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PlaylistServiceClient interface {
+	// ListPlaylists returns the playlists of the current user (GET /playlist)
+	ListPlaylists(ctx context.Context, in *ListPlaylistsRequest, opts ...grpc.CallOption) (*ListPlaylistsResponse, error)
+}
+
+type playlistServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPlaylistServiceClient(cc grpc.ClientConnInterface) PlaylistServiceClient {
+	return &playlistServiceClient{cc}
+}
+
+func (c *playlistServiceClient) ListPlaylists(ctx context.Context, in *ListPlaylistsRequest, opts ...grpc.CallOption) (*ListPlaylistsResponse, error) {
+	out := new(ListPlaylistsResponse)
+	err := c.cc.Invoke(ctx, "/spotifyapp.playlist.v1.PlaylistService/ListPlaylists", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PlaylistServiceServer is the server API for PlaylistService service.
+// All implementations must embed UnimplementedPlaylistServiceServer
+// for forward compatibility
+type PlaylistServiceServer interface {
+	// ListPlaylists returns the playlists of the current user (GET /playlist)
+	ListPlaylists(context.Context, *ListPlaylistsRequest) (*ListPlaylistsResponse, error)
+	mustEmbedUnimplementedPlaylistServiceServer()
+}
+
+// UnimplementedPlaylistServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedPlaylistServiceServer struct {
+}
+
+func (UnimplementedPlaylistServiceServer) ListPlaylists(context.Context, *ListPlaylistsRequest) (*ListPlaylistsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPlaylists not implemented")
+}
+func (UnimplementedPlaylistServiceServer) mustEmbedUnimplementedPlaylistServiceServer() {}
+
+// UnsafePlaylistServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PlaylistServiceServer will
+// result in compilation errors.
+type UnsafePlaylistServiceServer interface {
+	mustEmbedUnimplementedPlaylistServiceServer()
+}
+
+func RegisterPlaylistServiceServer(s grpc.ServiceRegistrar, srv PlaylistServiceServer) {
+	s.RegisterService(&PlaylistService_ServiceDesc, srv)
+}
+
+func _PlaylistService_ListPlaylists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPlaylistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PlaylistServiceServer).ListPlaylists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/spotifyapp.playlist.v1.PlaylistService/ListPlaylists",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PlaylistServiceServer).ListPlaylists(ctx, req.(*ListPlaylistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PlaylistService_ServiceDesc is the grpc.ServiceDesc for PlaylistService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PlaylistService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "spotifyapp.playlist.v1.PlaylistService",
+	HandlerType: (*PlaylistServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPlaylists",
+			Handler:    _PlaylistService_ListPlaylists_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "playlistpb/playlist.proto",
+}
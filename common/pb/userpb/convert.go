@@ -0,0 +1,23 @@
+package userpb
+
+import (
+	"common/models"
+)
+
+// FromModel converts the user model to its protobuf message
+func FromModel(u models.User) *User {
+	return &User{
+		Name:  u.Name,
+		Id:    u.ID,
+		Image: u.Image,
+	}
+}
+
+// ToModel converts the protobuf message to the user model
+func (x *User) ToModel() models.User {
+	return models.User{
+		Name:  x.GetName(),
+		ID:    x.GetId(),
+		Image: x.GetImage(),
+	}
+}
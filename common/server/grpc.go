@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// RunWithGRPC serves the handler like Run and the grpc server on grpcAddr until SIGTERM or SIGINT is received
+// Both servers are stopped as soon as one of them stops
+func RunWithGRPC(handler http.Handler, opts Options, grpcServer *grpc.Server, grpcAddr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	grpcLn, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		err := ServeGRPC(ctx, grpcLn, grpcServer, opts.ShutdownTimeout)
+		cancel()
+		errc <- err
+	}()
+
+	err = Serve(ctx, ln, handler, opts)
+	cancel()
+	if grpcErr := <-errc; err == nil {
+		err = grpcErr
+	}
+	return err
+}
+
+// ServeGRPC serves the grpc server on the listener until the context is done
+// In-flight calls are then given shutdownTimeout to finish before the connections are closed
+func ServeGRPC(ctx context.Context, ln net.Listener, srv *grpc.Server, shutdownTimeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		log.WithField("addr", ln.Addr().String()).Info("server: grpc listening")
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.WithField("timeout", shutdownTimeout).Info("server: grpc shutting down")
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		log.Warn("server: could not drain in-flight grpc calls, closing connections")
+		srv.Stop()
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	log.Info("server: grpc stopped")
+	return nil
+}
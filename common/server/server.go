@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Options are the settings of the http server of a service
+type Options struct {
+	Addr              string        `yaml:"addr"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes"`
+	// ShutdownTimeout is the time given to in-flight requests to finish once a shutdown is asked
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// DefaultOptions returns the options used when nothing is configured
+// The write timeout is kept at 0 so long lived streams are not cut, slow clients are handled by the read timeouts
+func DefaultOptions() Options {
+	return Options{
+		Addr:              ":8080",
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      0,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ShutdownTimeout:   10 * time.Second,
+	}
+}
+
+// shutdownKey is the key of the shutdown channel in the request context
+type shutdownKey struct{}
+
+// ShuttingDown returns a channel closed once the server serving the request starts to shut down
+// Long lived handlers (event streams) should return when it is closed so the shutdown is not held
+func ShuttingDown(ctx context.Context) <-chan struct{} {
+	done, _ := ctx.Value(shutdownKey{}).(chan struct{})
+	return done
+}
+
+// Run listens on the configured address and serves the handler until SIGTERM or SIGINT is received
+func Run(handler http.Handler, opts Options) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ctx, ln, handler, opts)
+}
+
+// Serve serves the handler on the listener until the context is done
+// In-flight requests are then given ShutdownTimeout to finish before the connections are closed
+func Serve(ctx context.Context, ln net.Listener, handler http.Handler, opts Options) error {
+	shuttingDown := make(chan struct{})
+	srv := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       opts.ReadTimeout,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		MaxHeaderBytes:    opts.MaxHeaderBytes,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), shutdownKey{}, shuttingDown)
+		},
+	}
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	errc := make(chan error, 1)
+	go func() {
+		log.WithField("addr", ln.Addr().String()).Info("server: listening")
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.WithField("timeout", opts.ShutdownTimeout).Info("server: shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Warn("server: could not drain in-flight requests, closing connections")
+		srv.Close()
+		return err
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Info("server: stopped")
+	return nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, handler http.Handler, opts Options) (string, context.CancelFunc, chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- Serve(ctx, ln, handler, opts) }()
+	return "http://" + ln.Addr().String(), cancel, errc
+}
+
+func Test_Serve_drainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	opts := DefaultOptions()
+	opts.ShutdownTimeout = time.Second
+	url, cancel, errc := startServer(t, handler, opts)
+
+	respc := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			respc <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		respc <- string(body)
+	}()
+	<-started
+	cancel()
+
+	if body := <-respc; body != "done" {
+		t.Errorf("in-flight request was not drained: got %v", body)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+}
+
+func Test_Serve_stopsStreams(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		close(started)
+		select {
+		case <-ShuttingDown(r.Context()):
+		case <-r.Context().Done():
+		}
+	})
+	opts := DefaultOptions()
+	opts.ShutdownTimeout = 2 * time.Second
+	url, cancel, errc := startServer(t, handler, opts)
+
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	<-started
+	begin := time.Now()
+	cancel()
+
+	if err := <-errc; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("stream held the shutdown for %v", elapsed)
+	}
+}
+
+func Test_Serve_closesAfterTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	opts := DefaultOptions()
+	opts.ShutdownTimeout = 50 * time.Millisecond
+	url, cancel, errc := startServer(t, handler, opts)
+
+	go http.Get(url)
+	<-started
+	cancel()
+
+	if err := <-errc; err != context.DeadlineExceeded {
+		t.Errorf("Serve() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
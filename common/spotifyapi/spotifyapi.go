@@ -0,0 +1,127 @@
+package spotifyapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+// DefaultURL is the base url of the real spotify web API
+const DefaultURL = "https://api.spotify.com/v1/"
+
+// Factory creates spotify clients sending their requests to a configurable base url
+type Factory struct {
+	transport http.RoundTripper
+}
+
+// NewFactory creates a factory whose clients call the API served at baseURL
+func NewFactory(baseURL string) (*Factory, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("spotifyapi: %q is not an absolute url", baseURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if base.String() != DefaultURL {
+		transport = &rewriteTransport{base: base, next: http.DefaultTransport}
+	}
+	return &Factory{transport: transport}, nil
+}
+
+// Client creates a spotify client authenticated with the given access token
+func (f *Factory) Client(accessToken string) *spotify.Client {
+	token := &oauth2.Token{AccessToken: accessToken}
+	httpClient := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.StaticTokenSource(token),
+			Base:   f.transport,
+		},
+	}
+	client := spotify.NewClient(httpClient)
+	return &client
+}
+
+// HTTPClient returns a plain http client sending the spotify API requests to the configured base url
+func (f *Factory) HTTPClient() *http.Client {
+	return &http.Client{Transport: f.transport}
+}
+
+// APIClient is the spotify client completed with the requests of the API the spotify library does not make
+type APIClient struct {
+	*spotify.Client
+	httpClient *http.Client
+	token      string
+}
+
+// APIClient creates the client authenticated with the given access token
+func (f *Factory) APIClient(accessToken string) *APIClient {
+	return &APIClient{Client: f.Client(accessToken), httpClient: f.HTTPClient(), token: accessToken}
+}
+
+// Get asks path of the API, relative to DefaultURL, with the query and decodes the answer into out
+// The errors of the API are returned as spotify.Error, like the spotify library does
+func (c *APIClient) Get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	target := DefaultURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	// the factory http client sends the requests made to the real spotify API to the configured one
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error spotify.Error `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Status == 0 {
+			return spotify.Error{Status: resp.StatusCode, Message: fmt.Sprintf("spotify: HTTP %d", resp.StatusCode)}
+		}
+		return body.Error
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// rewriteTransport redirects the requests made to the real spotify API to another base url
+// The spotify library does not allow to change its base url so it is done at the transport level
+type rewriteTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+// RoundTrip rewrites the request url before sending it
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.String()
+	if !strings.HasPrefix(target, DefaultURL) {
+		return t.next.RoundTrip(req)
+	}
+
+	rewritten, err := url.Parse(t.base.String() + strings.TrimPrefix(target, DefaultURL))
+	if err != nil {
+		return nil, err
+	}
+	// The request must not be modified, a copy with the new url is sent instead
+	out := req.Clone(req.Context())
+	out.URL = rewritten
+	out.Host = rewritten.Host
+	return t.next.RoundTrip(out)
+}
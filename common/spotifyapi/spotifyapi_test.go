@@ -0,0 +1,111 @@
+package spotifyapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/zmb3/spotify"
+)
+
+func Test_NewFactory(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseURL   string
+		expectErr bool
+	}{
+		{name: "should accept the real API", baseURL: DefaultURL},
+		{name: "should accept another API", baseURL: "http://fakespotify:8080/v1"},
+		{name: "should error on relative url", baseURL: "fakespotify/v1", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFactory(tt.baseURL)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("NewFactory() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func Test_Factory_Client(t *testing.T) {
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"id":"thomas","display_name":"Thomas"}`))
+	}))
+	defer srv.Close()
+
+	factory, err := NewFactory(srv.URL + "/fake/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := factory.Client("token").CurrentUser()
+	if err != nil {
+		t.Fatalf("CurrentUser() error = %v", err)
+	}
+	if user.ID != "thomas" {
+		t.Errorf("CurrentUser() = %v, want thomas", user.ID)
+	}
+	if gotPath != "/fake/v1/me" {
+		t.Errorf("request sent to %v, want /fake/v1/me", gotPath)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("request sent with Authorization %v, want Bearer token", gotAuth)
+	}
+}
+
+func Test_APIClient_Get(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer token":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"status":401,"message":"The access token expired"}}`))
+		case r.URL.Path == "/fake/v1/albums/dawn" && r.URL.Query().Get("market") == "FR":
+			w.Write([]byte(`{"id":"dawn","label":"Early Records"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	factory, err := NewFactory(srv.URL + "/fake/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		wantLabel  string
+		wantStatus int
+	}{
+		{name: "should decode the answer", token: "token", path: "albums/dawn", wantLabel: "Early Records"},
+		{name: "should return the error of the API", token: "expired", path: "albums/dawn", wantStatus: http.StatusUnauthorized},
+		{name: "should return the status without an error body", token: "token", path: "albums/unknown", wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var album struct {
+				Label string `json:"label"`
+			}
+			err := factory.APIClient(tt.token).Get(context.Background(), tt.path, url.Values{"market": {"FR"}}, &album)
+			var serr spotify.Error
+			if tt.wantStatus != 0 {
+				if !errors.As(err, &serr) || serr.Status != tt.wantStatus {
+					t.Fatalf("Get() error = %v, want a spotify error %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if album.Label != tt.wantLabel {
+				t.Errorf("Get() label = %q, want %q", album.Label, tt.wantLabel)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"common/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// publishTimeout bounds the publication of an event, the webhooks service only queues it
+	publishTimeout = 5 * time.Second
+	// TokenHeader is the header of the token shared by the services and the webhooks service, the events are refused without it
+	TokenHeader = "X-Webhooks-Token"
+)
+
+// Publisher publishes the events of the users to the webhooks service, which delivers them to the registered webhooks
+// A nil publisher publishes nothing so the services run without the webhooks service
+type Publisher struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewPublisher creates the publisher posting to the webhooks service at root with the token of the services, it is nil when root is empty
+func NewPublisher(root, token string) *Publisher {
+	if root == "" {
+		return nil
+	}
+	return &Publisher{url: strings.TrimSuffix(root, "/") + "/events", token: token, client: &http.Client{Timeout: publishTimeout}}
+}
+
+// Publish posts the event of the user with its data, the webhooks service answers once the deliveries are queued
+func (p *Publisher) Publish(event, userID string, data interface{}) error {
+	if p == nil {
+		return nil
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(models.Event{Event: event, UserID: userID, OccurredAt: time.Now().UTC(), Data: content})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TokenHeader, p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("webhooks: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Go publishes the event in the background so the caller is not slowed down, a failure is only logged
+func (p *Publisher) Go(event, userID string, data interface{}) {
+	if p == nil {
+		return
+	}
+	go func() {
+		if err := p.Publish(event, userID, data); err != nil {
+			log.WithError(err).WithField("event", event).Error("webhooks: could not publish event")
+		}
+	}()
+}
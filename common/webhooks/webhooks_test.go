@@ -0,0 +1,55 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"common/models"
+)
+
+func Test_Publisher_Publish(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "should publish the event", status: http.StatusAccepted},
+		{name: "should fail when the event is refused", status: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "POST" || r.URL.Path != "/events" || r.Header.Get(TokenHeader) != "services" {
+					t.Errorf("webhooks called with %s %s and the token %q", r.Method, r.URL.Path, r.Header.Get(TokenHeader))
+				}
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := NewPublisher(server.URL+"/", "services").Publish(models.PlaylistUpdatedEvent, "thomas", models.PlaylistUpdate{PlaylistID: "morning", Change: "reordered"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var data models.PlaylistUpdate
+			json.Unmarshal(got.Data, &data)
+			if got.Event != models.PlaylistUpdatedEvent || got.UserID != "thomas" || got.OccurredAt.IsZero() || data.PlaylistID != "morning" {
+				t.Errorf("webhooks got %+v with %+v", got, data)
+			}
+		})
+	}
+}
+
+func Test_Publisher_disabled(t *testing.T) {
+	p := NewPublisher("", "services")
+	if p != nil {
+		t.Fatalf("NewPublisher(\"\") = %+v, want nil", p)
+	}
+	if err := p.Publish(models.TrackChangedEvent, "thomas", nil); err != nil {
+		t.Errorf("Publish() error = %v, want nothing published", err)
+	}
+	p.Go(models.TrackChangedEvent, "thomas", nil)
+}
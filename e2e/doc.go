@@ -0,0 +1,3 @@
+// Package e2e holds the end-to-end tests of the project
+// The microservices are built and started against the fake spotify API, behind the gateway
+package e2e
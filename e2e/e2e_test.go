@@ -0,0 +1,970 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/eventbus"
+	"common/eventbus/eventbustest"
+	"common/fakespotify"
+	"common/models"
+	"common/pb/userpb"
+
+	"github.com/zmb3/spotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// services are the microservices started by the tests, with the path prefixes routed to them
+var services = map[string][]string{
+	"user":     {"/user"},
+	"player":   {"/player", "/recommendations"},
+	"playlist": {"/playlist", "/tracks"},
+	"graphql":  {"/graphql"},
+	"search":   {"/search"},
+	"library":  {"/library"},
+	"history":  {"/history", "/stats"},
+	"catalog":  {"/artists", "/albums", "/releases"},
+	"webhooks": {"/webhooks"},
+}
+
+// freeAddr returns a local address that can be listened on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startService builds and starts a microservice calling the given spotify API, it returns its url
+// The extra environment variables are given to the microservice
+func startService(t *testing.T, name, apiURL string, env ...string) *url.URL {
+	bin := filepath.Join(t.TempDir(), name)
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Dir = filepath.Join("..", name)
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("could not build %s: %v\n%s", name, err, out)
+	}
+
+	addr := freeAddr(t)
+	var logs bytes.Buffer
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "HTTP_ADDR="+addr, "GRPC_ADDR="+freeAddr(t), "SPOTIFY_API_URL="+apiURL, "LOG_LEVEL=warn")
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = &logs
+	cmd.Stderr = &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("could not start %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+		if t.Failed() {
+			t.Logf("%s logs:\n%s", name, logs.String())
+		}
+	})
+
+	base := &url.URL{Scheme: "http", Host: addr}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(base.String() + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return base
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not ready: %v", name, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// stack is the whole project started against the fake spotify API
+type stack struct {
+	fake    *fakespotify.Server
+	gateway *url.URL
+	// userGRPC is the address of the grpc API of the user microservice
+	userGRPC string
+	// broker carries the events between the microservices
+	broker *eventbustest.Broker
+}
+
+// startStack starts the fake spotify API, the microservices and the gateway routing to them
+func startStack(t *testing.T, state fakespotify.State) *stack {
+	if testing.Short() {
+		t.Skip("skipping end-to-end tests in short mode")
+	}
+	fake := fakespotify.New(state)
+	api := httptest.NewServer(fake)
+	t.Cleanup(api.Close)
+	broker, err := eventbustest.StartBroker("127.0.0.1:0", "services", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	// the webhooks are started first, the other microservices publish their events to it
+	// the receivers of the tests listen on the loopback so the private addresses are allowed
+	webhooks := startService(t, "webhooks", api.URL+"/v1/",
+		"WEBHOOKS_STATE_PATH="+filepath.Join(t.TempDir(), "webhooks.json"), "WEBHOOKS_MAX_ATTEMPTS=2", "WEBHOOKS_RETRY_BACKOFF=100ms",
+		"WEBHOOKS_TOKEN=services", "WEBHOOKS_ALLOW_PRIVATE=true").String()
+	var routes []string
+	for _, prefix := range services["webhooks"] {
+		routes = append(routes, prefix+"="+webhooks)
+	}
+	userGRPC := freeAddr(t)
+	for name, prefixes := range services {
+		if name == "webhooks" {
+			continue
+		}
+		env := []string{"WEBHOOKS_URL=" + webhooks, "WEBHOOKS_TOKEN=services", "EVENT_BUS_URL=" + broker.URL()}
+		if name == "user" {
+			env = append(env, "GRPC_ADDR="+userGRPC)
+		}
+		if name == "player" {
+			env = append(env, "PLAYER_EVENTS_INTERVAL=100ms")
+		}
+		if name == "history" {
+			env = append(env, "HISTORY_DB_PATH="+filepath.Join(t.TempDir(), "history.db"), "HISTORY_RECORD_INTERVAL=100ms")
+		}
+		if name == "catalog" {
+			env = append(env, "RELEASES_STATE_PATH="+filepath.Join(t.TempDir(), "releases.json"))
+		}
+		addr := startService(t, name, api.URL+"/v1/", env...).String()
+		for _, prefix := range prefixes {
+			routes = append(routes, prefix+"="+addr)
+		}
+	}
+	gateway := startService(t, "gateway", api.URL+"/v1/", "GATEWAY_ROUTES="+strings.Join(routes, ","), "EVENT_BUS_URL="+broker.URL())
+	return &stack{fake: fake, gateway: gateway, userGRPC: userGRPC, broker: broker}
+}
+
+// do sends a request through the gateway with the given token and decodes the json response in out
+func (s *stack) do(t *testing.T, method, path, token string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	if body != nil {
+		content, _ := json.Marshal(body)
+		reader = bytes.NewReader(content)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, s.gateway.String()+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(content, out); err != nil {
+			t.Fatalf("%s %s: could not decode %q: %v", method, path, content, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// player is the player returned by the player microservice
+type player struct {
+	IsPlaying   bool     `json:"is_playing"`
+	AlbumName   string   `json:"album_name"`
+	ArtistsName []string `json:"artists_name"`
+	MusicName   string   `json:"music_name"`
+	ID          string   `json:"ID"`
+	Progress    int      `json:"progress"`
+	Duration    int      `json:"duration"`
+}
+
+// playlistItem is a playlist returned by the playlist microservice
+type playlistItem struct {
+	Name      string `json:"name"`
+	OwnerName string `json:"owner_name"`
+	ID        string `json:"ID"`
+	URI       string `json:"uri"`
+}
+
+// user is a user returned by the user microservice
+type user struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Image string `json:"image"`
+}
+
+func Test_fullFlow(t *testing.T) {
+	state := fakespotify.DefaultState()
+	state.Tokens = []string{"valid-token", "second-token"}
+	s := startStack(t, state)
+	token := "valid-token"
+
+	t.Run("should reject an invalid token", func(t *testing.T) {
+		if code := s.do(t, "GET", "/user", "expired-token", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("GET /user returned %v, want %v", code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("should login and get the current user", func(t *testing.T) {
+		var me user
+		if code := s.do(t, "GET", "/user", token, nil, &me); code != http.StatusOK {
+			t.Fatalf("GET /user returned %v", code)
+		}
+		if me.ID != "thomas" || me.Name != "Thomas" {
+			t.Errorf("GET /user = %+v", me)
+		}
+
+		var alice user
+		if code := s.do(t, "GET", "/user/alice", token, nil, &alice); code != http.StatusOK {
+			t.Fatalf("GET /user/alice returned %v", code)
+		}
+		if alice.Image != "https://images.example.com/alice.png" {
+			t.Errorf("GET /user/alice = %+v", alice)
+		}
+	})
+
+	t.Run("should get the current user over grpc", func(t *testing.T) {
+		conn, err := grpc.Dial(s.userGRPC, grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+		me, err := userpb.NewUserServiceClient(conn).GetCurrentUser(ctx, &userpb.GetCurrentUserRequest{})
+		if err != nil {
+			t.Fatalf("GetCurrentUser() error = %v", err)
+		}
+		if me.Id != "thomas" || me.Name != "Thomas" {
+			t.Errorf("GetCurrentUser() = %+v", me)
+		}
+	})
+
+	var playlists []playlistItem
+	t.Run("should list the playlists", func(t *testing.T) {
+		if code := s.do(t, "GET", "/playlist", token, nil, &playlists); code != http.StatusOK {
+			t.Fatalf("GET /playlist returned %v", code)
+		}
+		if len(playlists) != 2 || playlists[0].Name != "Morning" || playlists[0].OwnerName != "Thomas" {
+			t.Fatalf("GET /playlist = %+v", playlists)
+		}
+	})
+
+	steps := []struct {
+		name     string
+		path     string
+		body     interface{}
+		expected player
+	}{
+		{
+			name:     "should start a playlist",
+			path:     "/player/play",
+			body:     map[string]string{"uri": "spotify:playlist:morning"},
+			expected: player{IsPlaying: true, MusicName: "Sunrise", ID: "sunrise"},
+		},
+		{
+			name:     "should skip to the next track",
+			path:     "/player/next",
+			expected: player{IsPlaying: true, MusicName: "Coffee", ID: "coffee"},
+		},
+		{
+			name:     "should go back to the previous track",
+			path:     "/player/prev",
+			expected: player{IsPlaying: true, MusicName: "Sunrise", ID: "sunrise"},
+		},
+		{
+			name:     "should pause",
+			path:     "/player/pause",
+			expected: player{IsPlaying: false, MusicName: "Sunrise", ID: "sunrise"},
+		},
+		{
+			name:     "should resume",
+			path:     "/player/play",
+			body:     map[string]string{},
+			expected: player{IsPlaying: true, MusicName: "Sunrise", ID: "sunrise"},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			body := step.body
+			if body == nil {
+				body = map[string]string{}
+			}
+			if code := s.do(t, "POST", step.path, token, body, nil); code != http.StatusOK {
+				t.Fatalf("POST %s returned %v", step.path, code)
+			}
+
+			var got player
+			if code := s.do(t, "GET", "/player", token, nil, &got); code != http.StatusOK {
+				t.Fatalf("GET /player returned %v", code)
+			}
+			if got.IsPlaying != step.expected.IsPlaying || got.ID != step.expected.ID || got.MusicName != step.expected.MusicName {
+				t.Errorf("GET /player = %+v, want %+v", got, step.expected)
+			}
+		})
+	}
+
+	t.Run("should aggregate the dashboard", func(t *testing.T) {
+		var dashboard struct {
+			User      user                       `json:"user"`
+			Player    player                     `json:"player"`
+			Playlists []playlistItem             `json:"playlists"`
+			Errors    map[string]json.RawMessage `json:"errors"`
+		}
+		if code := s.do(t, "GET", "/dashboard", token, nil, &dashboard); code != http.StatusOK {
+			t.Fatalf("GET /dashboard returned %v", code)
+		}
+		if dashboard.User.ID != "thomas" || dashboard.Player.ID != "sunrise" || len(dashboard.Playlists) != 2 || len(dashboard.Errors) != 0 {
+			t.Errorf("GET /dashboard = %+v", dashboard)
+		}
+	})
+
+	t.Run("should query the graphql API", func(t *testing.T) {
+		var result struct {
+			Data struct {
+				Me       user
+				Playlist struct {
+					Name   string
+					Owner  user
+					Tracks struct {
+						TotalCount int
+					}
+				}
+			}
+			Errors []json.RawMessage
+		}
+		query := `{ me { id name } playlist(id: "morning") { name owner { id name } tracks { totalCount } } }`
+		if code := s.do(t, "POST", "/graphql", token, map[string]string{"query": query}, &result); code != http.StatusOK {
+			t.Fatalf("POST /graphql returned %v", code)
+		}
+		if len(result.Errors) != 0 || result.Data.Me.ID != "thomas" || result.Data.Playlist.Name != "Morning" ||
+			result.Data.Playlist.Owner.Name != "Thomas" || result.Data.Playlist.Tracks.TotalCount != 3 {
+			t.Errorf("POST /graphql = %+v", result)
+		}
+	})
+
+	t.Run("should reject requests not matching the api specification", func(t *testing.T) {
+		if code := s.do(t, "POST", "/player/play", token, map[string]int{"uri": 42}, nil); code != http.StatusBadRequest {
+			t.Errorf("POST /player/play returned %v, want %v", code, http.StatusBadRequest)
+		}
+		var spec map[string]interface{}
+		if code := s.do(t, "GET", "/openapi.json", "", nil, &spec); code != http.StatusOK || spec["openapi"] == nil {
+			t.Errorf("GET /openapi.json returned %v", code)
+		}
+	})
+
+	t.Run("should use the api through the go client", func(t *testing.T) {
+		c, err := client.New(s.gateway.String(), client.StaticToken(token), client.DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if me, err := c.CurrentUser(ctx); err != nil || me.ID != "thomas" {
+			t.Errorf("CurrentUser() = %+v, %v", me, err)
+		}
+		if page, err := c.Playlists(ctx, client.Page{Limit: 1, Offset: 1}); err != nil || len(page) != 1 || page[0].Name == "Morning" {
+			t.Errorf("Playlists() = %+v, %v", page, err)
+		}
+		if err := c.Next(ctx); err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if got, err := c.Player(ctx); err != nil || got.ID != "coffee" {
+			t.Errorf("Player() = %+v, %v", got, err)
+		}
+		if _, err := c.User(ctx, strings.Repeat("a", 200)); !client.IsBadRequest(err) {
+			t.Errorf("User() error = %v, want a bad request", err)
+		}
+
+		expired, _ := client.New(s.gateway.String(), client.StaticToken("expired-token"), client.DefaultOptions())
+		if _, err := expired.CurrentUser(ctx); !errors.Is(err, client.ErrTokenExpired) {
+			t.Errorf("CurrentUser() error = %v, want %v", err, client.ErrTokenExpired)
+		}
+	})
+
+	t.Run("should stream the player events", func(t *testing.T) {
+		c, err := client.New(s.gateway.String(), client.StaticToken(token), client.DefaultOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		events := c.PlayerEvents(ctx)
+
+		next := func() client.PlayerEvent {
+			event, ok := <-events
+			if !ok {
+				t.Fatal("the player events were closed")
+			}
+			return event
+		}
+		if event := next(); event.Err != nil || event.Player == nil || event.Player.ID != "coffee" {
+			t.Fatalf("first player event = %+v", event)
+		}
+		if err := c.Previous(ctx); err != nil {
+			t.Fatalf("Previous() error = %v", err)
+		}
+		if event := next(); event.Err != nil || event.Player == nil || event.Player.ID != "sunrise" {
+			t.Errorf("player event after Previous() = %+v", event)
+		}
+	})
+
+	t.Run("should search and play a result", func(t *testing.T) {
+		type playLink struct {
+			Method string            `json:"method"`
+			Href   string            `json:"href"`
+			Body   map[string]string `json:"body"`
+		}
+		var result struct {
+			Tracks struct {
+				Items []struct {
+					ID   string   `json:"ID"`
+					Play playLink `json:"play"`
+				} `json:"items"`
+				Total int `json:"total"`
+			} `json:"tracks"`
+			Shows struct {
+				Items []struct {
+					Name string `json:"name"`
+				} `json:"items"`
+			} `json:"shows"`
+			Albums *json.RawMessage `json:"albums"`
+		}
+		if code := s.do(t, "GET", "/search?q=commute&type=track,show", token, nil, &result); code != http.StatusOK {
+			t.Fatalf("GET /search returned %v", code)
+		}
+		if result.Tracks.Total != 1 || len(result.Tracks.Items) != 1 || result.Tracks.Items[0].ID != "commute" || len(result.Shows.Items) != 0 || result.Albums != nil {
+			t.Fatalf("GET /search = %+v", result)
+		}
+
+		play := result.Tracks.Items[0].Play
+		if code := s.do(t, play.Method, play.Href, token, play.Body, nil); code != http.StatusOK {
+			t.Fatalf("%s %s returned %v", play.Method, play.Href, code)
+		}
+		var got player
+		if code := s.do(t, "GET", "/player", token, nil, &got); code != http.StatusOK || got.ID != "commute" || !got.IsPlaying {
+			t.Errorf("GET /player = %v %+v, want the played result", code, got)
+		}
+
+		if code := s.do(t, "GET", "/search?q=commute&type=episode", token, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /search with an invalid type returned %v, want %v", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("should like the current track and browse the library", func(t *testing.T) {
+		var liked struct {
+			ID string `json:"ID"`
+		}
+		if code := s.do(t, "PUT", "/library/tracks/current", token, nil, &liked); code != http.StatusOK || liked.ID != "commute" {
+			t.Fatalf("PUT /library/tracks/current = %v %+v, want the current track", code, liked)
+		}
+		var tracks struct {
+			Items []struct {
+				ID      string `json:"ID"`
+				AddedAt string `json:"added_at"`
+			} `json:"items"`
+			Total int `json:"total"`
+		}
+		if code := s.do(t, "GET", "/library/tracks?limit=1", token, nil, &tracks); code != http.StatusOK || tracks.Total != 2 || len(tracks.Items) != 1 || tracks.Items[0].ID != "commute" {
+			t.Fatalf("GET /library/tracks = %v %+v", code, tracks)
+		}
+
+		if code := s.do(t, "DELETE", "/library/tracks", token, map[string][]string{"ids": {"sunrise"}}, nil); code != http.StatusOK {
+			t.Fatalf("DELETE /library/tracks returned %v", code)
+		}
+		var contains map[string]bool
+		if code := s.do(t, "GET", "/library/tracks/contains?ids=sunrise,commute", token, nil, &contains); code != http.StatusOK || contains["sunrise"] || !contains["commute"] {
+			t.Errorf("GET /library/tracks/contains = %v %v", code, contains)
+		}
+		if code := s.do(t, "PUT", "/library/tracks", token, map[string][]string{"ids": {"unknown"}}, nil); code != http.StatusBadRequest {
+			t.Errorf("PUT /library/tracks with an unknown track returned %v, want %v", code, http.StatusBadRequest)
+		}
+
+		var artists struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			After string `json:"after"`
+		}
+		if code := s.do(t, "GET", "/library/artists?limit=1", token, nil, &artists); code != http.StatusOK || len(artists.Items) != 1 || artists.After == "" {
+			t.Fatalf("GET /library/artists = %v %+v", code, artists)
+		}
+		if code := s.do(t, "GET", "/library/artists?limit=1&after="+artists.After, token, nil, &artists); code != http.StatusOK || artists.Items[0].Name != "Dusk" || artists.After != "" {
+			t.Errorf("GET /library/artists next page = %v %+v", code, artists)
+		}
+	})
+
+	t.Run("should record the listening history", func(t *testing.T) {
+		type history struct {
+			Items []struct {
+				ID       string    `json:"ID"`
+				PlayedAt time.Time `json:"played_at"`
+			} `json:"items"`
+			Total int `json:"total"`
+		}
+		if code := s.do(t, "POST", "/history/register", token, nil, nil); code != http.StatusOK {
+			t.Fatalf("POST /history/register returned %v", code)
+		}
+		var got history
+		if code := s.do(t, "GET", "/history?artist=the%20early%20birds&to=2021-01-03T00:00:00Z", token, nil, &got); code != http.StatusOK || got.Total != 2 || got.Items[0].ID != "coffee" || got.Items[1].ID != "sunrise" {
+			t.Fatalf("GET /history = %v %+v, want the plays of the default state", code, got)
+		}
+
+		var current player
+		s.do(t, "GET", "/player", token, nil, &current)
+		from := time.Now().UTC()
+		if code := s.do(t, "POST", "/player/play", token, map[string]string{"uri": "spotify:playlist:morning"}, nil); code != http.StatusOK {
+			t.Fatalf("POST /player/play returned %v", code)
+		}
+		if code := s.do(t, "POST", "/player/next", token, map[string]string{}, nil); code != http.StatusOK {
+			t.Fatalf("POST /player/next returned %v", code)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			code := s.do(t, "GET", "/history?from="+from.Format(time.RFC3339Nano), token, nil, &got)
+			if code == http.StatusOK && got.Total == 2 && got.Items[0].ID == "sunrise" && got.Items[1].ID == current.ID {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GET /history = %v %+v, want the tracks left by the play and the skip", code, got)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		if code := s.do(t, "GET", "/history?limit=100", token, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /history with a too large page returned %v, want %v", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("should compute the listening statistics", func(t *testing.T) {
+		type top struct {
+			Items []struct {
+				Name    string `json:"name"`
+				Plays   int    `json:"plays"`
+				Minutes int    `json:"minutes"`
+			} `json:"items"`
+		}
+		var albums top
+		if code := s.do(t, "GET", "/stats/top?type=albums&to=2021-01-03T00:00:00Z", token, nil, &albums); code != http.StatusOK || len(albums.Items) != 1 || albums.Items[0].Name != "Dawn" || albums.Items[0].Plays != 2 || albums.Items[0].Minutes != 6 {
+			t.Errorf("GET /stats/top albums = %v %+v, want the album of the plays of the default state", code, albums)
+		}
+		var genres top
+		if code := s.do(t, "GET", "/stats/top?type=genres&to=2021-01-03T00:00:00Z", token, nil, &genres); code != http.StatusOK || len(genres.Items) != 2 || genres.Items[0].Name != "folk" || genres.Items[1].Name != "indie" {
+			t.Errorf("GET /stats/top genres = %v %+v, want the genres of the early birds", code, genres)
+		}
+
+		var report struct {
+			Plays         int `json:"plays"`
+			LongestStreak struct {
+				Days int    `json:"days"`
+				From string `json:"from"`
+			} `json:"longest_streak"`
+		}
+		if code := s.do(t, "GET", "/stats/report?year=2021&tz=Europe/Paris", token, nil, &report); code != http.StatusOK || report.Plays != 2 || report.LongestStreak.From != "2021-01-02" {
+			t.Errorf("GET /stats/report = %v %+v, want the plays of the default state", code, report)
+		}
+		if code := s.do(t, "GET", "/stats/report?year=2021&format=html", token, nil, nil); code != http.StatusOK {
+			t.Errorf("GET /stats/report as a page returned %v", code)
+		}
+		if code := s.do(t, "GET", "/stats/top?type=podcasts", token, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /stats/top of an unknown type returned %v, want %v", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("should start a radio from the recommendations", func(t *testing.T) {
+		type recommendations struct {
+			SeedTracks []string `json:"seed_tracks"`
+			Tracks     []struct {
+				ID string `json:"ID"`
+			} `json:"tracks"`
+			Playlist *struct {
+				ID string `json:"ID"`
+			} `json:"playlist"`
+		}
+		// the player is checked on the fake API, the requests to /player are rate limited by the gateway
+		current := s.fake.State().Player.Item.ID
+		var got recommendations
+		if code := s.do(t, "GET", "/recommendations?limit=2&target_energy=0.8", token, nil, &got); code != http.StatusOK || len(got.SeedTracks) != 1 || got.SeedTracks[0] != string(current) || len(got.Tracks) != 2 {
+			t.Fatalf("GET /recommendations = %v %+v, want 2 tracks recommended from the track playing", code, got)
+		}
+
+		var radio recommendations
+		if code := s.do(t, "POST", "/player/radio", token, map[string]interface{}{"seed": "playlist", "playlist_id": "evening", "mode": "playlist", "limit": 3}, &radio); code != http.StatusOK || radio.Playlist == nil || len(radio.Tracks) != 3 {
+			t.Fatalf("POST /player/radio = %v %+v, want a playlist of 3 tracks", code, radio)
+		}
+		if playing := s.fake.State().Player; playing.Item == nil || string(playing.Item.ID) != radio.Tracks[0].ID || playing.PlaybackContext.URI != spotify.URI("spotify:playlist:"+radio.Playlist.ID) {
+			t.Errorf("player = %+v, want the first track of the radio playlist", playing)
+		}
+		var playlist struct {
+			Tracks []struct {
+				ID string `json:"ID"`
+			} `json:"tracks"`
+		}
+		if code := s.do(t, "GET", "/playlist/"+radio.Playlist.ID, token, nil, &playlist); code != http.StatusOK || len(playlist.Tracks) != 3 {
+			t.Errorf("GET /playlist of the radio = %v %+v", code, playlist)
+		}
+
+		if code := s.do(t, "GET", "/recommendations?seed=playlist&playlist_id=unknown", token, nil, nil); code != http.StatusNotFound {
+			t.Errorf("GET /recommendations from an unknown playlist returned %v, want %v", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("should get the audio features of the tracks", func(t *testing.T) {
+		type features struct {
+			ID    string  `json:"ID"`
+			Tempo float64 `json:"tempo"`
+		}
+		var sunrise features
+		if code := s.do(t, "GET", "/tracks/sunrise/features", token, nil, &sunrise); code != http.StatusOK || sunrise.ID != "sunrise" || sunrise.Tempo == 0 {
+			t.Fatalf("GET /tracks/sunrise/features = %v %+v", code, sunrise)
+		}
+		if code := s.do(t, "GET", "/tracks/unknown/features", token, nil, nil); code != http.StatusNotFound {
+			t.Errorf("GET /tracks/unknown/features returned %v, want %v", code, http.StatusNotFound)
+		}
+
+		var batch struct {
+			Items []*features `json:"items"`
+		}
+		if code := s.do(t, "POST", "/tracks/features", token, map[string][]string{"ids": {"unknown", "sunrise"}}, &batch); code != http.StatusOK || len(batch.Items) != 2 || batch.Items[0] != nil || *batch.Items[1] != sunrise {
+			t.Fatalf("POST /tracks/features = %v %+v, want the features in the order of the ids", code, batch)
+		}
+
+		var playlist struct {
+			Tracks []struct {
+				ID       string    `json:"ID"`
+				Features *features `json:"features"`
+			} `json:"tracks"`
+		}
+		if code := s.do(t, "GET", "/playlist/morning?features=true", token, nil, &playlist); code != http.StatusOK || len(playlist.Tracks) == 0 {
+			t.Fatalf("GET /playlist/morning?features=true = %v %+v", code, playlist)
+		}
+		for _, track := range playlist.Tracks {
+			if track.Features == nil || track.Features.ID != track.ID {
+				t.Errorf("track %s of the playlist has the features %+v", track.ID, track.Features)
+			}
+		}
+
+		// sunrise is cached since the first request, spotify is only asked the tracks it was not asked yet
+		asked := 0
+		for _, req := range s.fake.Requests() {
+			if req.Path == "/audio-features" && strings.Contains(req.Query, "sunrise") {
+				asked++
+			}
+		}
+		if asked != 1 {
+			t.Errorf("the features of sunrise were asked %d times to spotify, want once", asked)
+		}
+	})
+
+	t.Run("should preview and apply the sort of a playlist", func(t *testing.T) {
+		var preview struct {
+			SnapshotID string `json:"snapshot_id"`
+			Tracks     []struct {
+				ID string `json:"ID"`
+			} `json:"tracks"`
+			Moves []struct {
+				RangeStart   int `json:"range_start"`
+				InsertBefore int `json:"insert_before"`
+			} `json:"moves"`
+		}
+		if code := s.do(t, "POST", "/playlist/morning/sort", token, map[string]string{"by": "title"}, &preview); code != http.StatusOK || len(preview.Tracks) != 3 || preview.Tracks[0].ID != "coffee" || len(preview.Moves) != 1 {
+			t.Fatalf("POST /playlist/morning/sort = %v %+v, want coffee first with a single move", code, preview)
+		}
+		if tracks := s.fake.State().Tracks["spotify:playlist:morning"]; tracks[0].ID != "sunrise" {
+			t.Fatalf("the preview reordered the playlist: %v first", tracks[0].ID)
+		}
+
+		if code := s.do(t, "POST", "/playlist/morning/sort", token, map[string]interface{}{"by": "title", "apply": true, "snapshot_id": "stale"}, nil); code != http.StatusConflict {
+			t.Errorf("POST /playlist/morning/sort of a stale snapshot returned %v, want %v", code, http.StatusConflict)
+		}
+		var applied struct {
+			SnapshotID string `json:"snapshot_id"`
+			Applied    bool   `json:"applied"`
+		}
+		if code := s.do(t, "POST", "/playlist/morning/sort", token, map[string]interface{}{"by": "title", "apply": true, "snapshot_id": preview.SnapshotID}, &applied); code != http.StatusOK || !applied.Applied || applied.SnapshotID == preview.SnapshotID {
+			t.Fatalf("POST /playlist/morning/sort with apply = %v %+v", code, applied)
+		}
+		var got []spotify.ID
+		for _, track := range s.fake.State().Tracks["spotify:playlist:morning"] {
+			got = append(got, track.ID)
+		}
+		if !reflect.DeepEqual(got, []spotify.ID{"coffee", "commute", "sunrise"}) {
+			t.Errorf("the sorted playlist is %v, want the tracks by title", got)
+		}
+	})
+
+	t.Run("should browse an artist and its albums", func(t *testing.T) {
+		var artist struct {
+			Name      string `json:"name"`
+			TopTracks []struct {
+				ID string `json:"ID"`
+			} `json:"top_tracks"`
+			RelatedArtists []struct {
+				ID string `json:"ID"`
+			} `json:"related_artists"`
+			Albums struct {
+				Items []struct {
+					ID string `json:"ID"`
+				} `json:"items"`
+				Total int `json:"total"`
+			} `json:"albums"`
+		}
+		if code := s.do(t, "GET", "/artists/the-early-birds?album_type=album", token, nil, &artist); code != http.StatusOK || artist.Name != "The Early Birds" || len(artist.TopTracks) == 0 || len(artist.RelatedArtists) != 1 || artist.Albums.Total != 1 || artist.Albums.Items[0].ID != "dawn" {
+			t.Fatalf("GET /artists/the-early-birds = %v %+v", code, artist)
+		}
+		if code := s.do(t, "GET", "/artists/unknown", token, nil, nil); code != http.StatusNotFound {
+			t.Errorf("GET /artists/unknown returned %v, want %v", code, http.StatusNotFound)
+		}
+
+		var album struct {
+			Label       string    `json:"label"`
+			ReleaseDate time.Time `json:"release_date"`
+			Tracks      []struct {
+				ID string `json:"ID"`
+			} `json:"tracks"`
+		}
+		if code := s.do(t, "GET", "/albums/dawn", token, nil, &album); code != http.StatusOK || album.Label == "" || len(album.Tracks) != 2 || !album.ReleaseDate.Equal(time.Date(2020, 12, 15, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("GET /albums/dawn = %v %+v", code, album)
+		}
+		if code := s.do(t, "GET", "/albums/dawn?market=france", token, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /albums/dawn with an invalid market returned %v, want %v", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("should deliver the new releases of the followed artists once", func(t *testing.T) {
+		// a single of a followed artist is released today, it is in a playlist so the fake catalog knows it
+		today := time.Now().UTC().Format("2006-01-02")
+		s.fake.Update(func(state *fakespotify.State) {
+			single := state.Tracks["spotify:playlist:morning"][0]
+			single.ID, single.URI, single.Name = "daybreak", "spotify:track:daybreak", "Daybreak"
+			single.Album.ID, single.Album.URI, single.Album.Name = "daybreak", "spotify:album:daybreak", "Daybreak"
+			single.Album.AlbumType, single.Album.ReleaseDate = "single", today
+			fresh := spotify.SimplePlaylist{ID: "fresh", URI: "spotify:playlist:fresh", Name: "Fresh", Owner: state.User.User, SnapshotID: "snapshot-1"}
+			fresh.Tracks.Total = 1
+			state.Playlists = append(append([]spotify.SimplePlaylist{}, state.Playlists...), fresh)
+			tracks := map[spotify.URI][]spotify.FullTrack{fresh.URI: {single}}
+			for uri, t := range state.Tracks {
+				tracks[uri] = t
+			}
+			state.Tracks = tracks
+		})
+		type release struct {
+			ID       string `json:"ID"`
+			ArtistID string `json:"artist_id"`
+		}
+		var watch struct {
+			Deliver    string    `json:"deliver"`
+			PlaylistID string    `json:"playlist_id"`
+			Watching   bool      `json:"watching"`
+			Releases   []release `json:"releases"`
+		}
+		if code := s.do(t, "POST", "/releases/watch", token, map[string]string{}, &watch); code != http.StatusOK || !watch.Watching {
+			t.Fatalf("POST /releases/watch = %v %+v, want the watch started", code, watch)
+		}
+		// the first scan runs in the background
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if code := s.do(t, "GET", "/releases/watch", token, nil, &watch); code == http.StatusOK && len(watch.Releases) > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GET /releases/watch = %+v, want the single reported", watch)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if watch.PlaylistID == "" || len(watch.Releases) != 1 || watch.Releases[0].ID != "daybreak" {
+			t.Fatalf("GET /releases/watch = %+v, want the single delivered to a release radar", watch)
+		}
+		if tracks := s.fake.State().Tracks[spotify.URI("spotify:playlist:"+watch.PlaylistID)]; len(tracks) != 1 || tracks[0].ID != "daybreak" {
+			t.Errorf("release radar tracks = %+v, want the single", tracks)
+		}
+
+		// the single was reported, watching by webhook does not find it again
+		if code := s.do(t, "POST", "/releases/watch", token, map[string]string{"deliver": "webhook"}, &watch); code != http.StatusOK || watch.Deliver != "webhook" || len(watch.Releases) != 1 {
+			t.Fatalf("POST /releases/watch by webhook = %v %+v", code, watch)
+		}
+
+		if code := s.do(t, "DELETE", "/releases/watch", token, nil, nil); code != http.StatusOK {
+			t.Fatalf("DELETE /releases/watch returned %v", code)
+		}
+		if code := s.do(t, "GET", "/releases/watch", token, nil, &watch); code != http.StatusOK || watch.Watching || len(watch.Releases) != 1 {
+			t.Errorf("GET /releases/watch = %v %+v, want the single kept without watching", code, watch)
+		}
+	})
+
+	t.Run("should deliver the signed events to the webhooks", func(t *testing.T) {
+		type posted struct {
+			body      []byte
+			signature string
+		}
+		received := make(chan posted, 10)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- posted{body: body, signature: r.Header.Get("X-Webhook-Signature")}
+		}))
+		defer receiver.Close()
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer failing.Close()
+
+		type webhook struct {
+			ID     string   `json:"id"`
+			Events []string `json:"events"`
+		}
+		var hook, broken webhook
+		if code := s.do(t, "POST", "/webhooks", token, map[string]interface{}{"url": receiver.URL, "secret": "s3cret", "events": []string{"playlist_updated"}}, &hook); code != http.StatusOK || hook.ID == "" {
+			t.Fatalf("POST /webhooks = %v %+v", code, hook)
+		}
+		if code := s.do(t, "POST", "/webhooks", token, map[string]interface{}{"url": failing.URL, "secret": "s3cret", "events": []string{"playlist_updated"}}, &broken); code != http.StatusOK {
+			t.Fatalf("POST /webhooks of the failing receiver = %v %+v", code, broken)
+		}
+
+		// the sort applied is published by the playlist microservice and posted to both webhooks
+		if code := s.do(t, "POST", "/playlist/morning/sort", token, map[string]interface{}{"by": "title", "order": "desc", "apply": true}, nil); code != http.StatusOK {
+			t.Fatalf("POST /playlist/morning/sort returned %v", code)
+		}
+		var event struct {
+			Event  string `json:"event"`
+			UserID string `json:"user_id"`
+			Data   struct {
+				PlaylistID string `json:"playlist_id"`
+				Change     string `json:"change"`
+			} `json:"data"`
+		}
+		select {
+		case p := <-received:
+			mac := hmac.New(sha256.New, []byte("s3cret"))
+			mac.Write(p.body)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); p.signature != want {
+				t.Errorf("signature = %q, want %q", p.signature, want)
+			}
+			json.Unmarshal(p.body, &event)
+			if event.Event != "playlist_updated" || event.UserID != "thomas" || event.Data.PlaylistID != "morning" || event.Data.Change != "reordered" {
+				t.Errorf("webhook received %s, want the playlist reordered", p.body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the webhook received nothing")
+		}
+
+		type delivery struct {
+			WebhookID string `json:"webhook_id"`
+			Status    string `json:"status"`
+			Attempts  int    `json:"attempts"`
+		}
+		var deliveries, dead []delivery
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			s.do(t, "GET", "/webhooks/"+hook.ID+"/deliveries", token, nil, &deliveries)
+			s.do(t, "GET", "/webhooks/dead-letters", token, nil, &dead)
+			if len(deliveries) == 1 && deliveries[0].Status == "delivered" && len(dead) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("deliveries = %+v, dead letters = %+v, want the delivery logged and the failing one given up", deliveries, dead)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if dead[0].WebhookID != broken.ID || dead[0].Attempts != 2 {
+			t.Errorf("dead letters = %+v, want the failing webhook after 2 attempts", dead)
+		}
+
+		if code := s.do(t, "DELETE", "/webhooks/"+broken.ID, token, nil, nil); code != http.StatusOK {
+			t.Fatalf("DELETE /webhooks/%s returned %v", broken.ID, code)
+		}
+		var hooks []webhook
+		if code := s.do(t, "GET", "/webhooks", token, nil, &hooks); code != http.StatusOK || len(hooks) != 1 || hooks[0].ID != hook.ID {
+			t.Errorf("GET /webhooks = %v %+v, want the webhook left", code, hooks)
+		}
+	})
+
+	t.Run("should publish the events on the bus", func(t *testing.T) {
+		bus, err := eventbus.DialNATS(s.broker.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bus.Close()
+		logins := make(chan eventbus.UserLogin, 10)
+		mutations := make(chan eventbus.PlaylistMutated, 10)
+		if _, err := eventbus.OnUserLogin(bus, func(e eventbus.UserLogin) { logins <- e }); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := eventbus.OnPlaylistMutated(bus, func(e eventbus.PlaylistMutated) { mutations <- e }); err != nil {
+			t.Fatal(err)
+		}
+		if err := bus.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the gateway publishes a login on the first request with a token, not on each one
+		for i := 0; i < 2; i++ {
+			if code := s.do(t, "GET", "/user", "second-token", nil, nil); code != http.StatusOK {
+				t.Fatalf("GET /user returned %v", code)
+			}
+		}
+		select {
+		case e := <-logins:
+			if e.UserID != "thomas" {
+				t.Errorf("user login = %+v, want thomas", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no user login was published")
+		}
+		select {
+		case e := <-logins:
+			t.Errorf("another user login was published: %+v", e)
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		if code := s.do(t, "POST", "/playlist/morning/sort", token, map[string]interface{}{"by": "title", "order": "asc", "apply": true}, nil); code != http.StatusOK {
+			t.Fatalf("POST /playlist/morning/sort returned %v", code)
+		}
+		select {
+		case e := <-mutations:
+			if e.UserID != "thomas" || e.PlaylistID != "morning" || e.Change != models.PlaylistReordered {
+				t.Errorf("playlist mutation = %+v, want the playlist reordered", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no playlist mutation was published")
+		}
+	})
+
+	t.Run("should forward spotify errors", func(t *testing.T) {
+		s.fake.Fail("POST", "/me/player/next", http.StatusBadGateway)
+		if code := s.do(t, "POST", "/player/next", token, map[string]string{}, nil); code != http.StatusInternalServerError {
+			t.Errorf("POST /player/next returned %v, want %v", code, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("should have sent the playlist context to spotify", func(t *testing.T) {
+		want := fmt.Sprintf(`"context_uri":%q`, spotify.URI("spotify:playlist:morning"))
+		for _, req := range s.fake.Requests() {
+			if req.Method == "PUT" && req.Path == "/me/player/play" && strings.Contains(req.Body, want) {
+				return
+			}
+		}
+		t.Errorf("no play request with %s was sent", want)
+	})
+}
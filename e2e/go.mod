@@ -0,0 +1,11 @@
+module e2e
+
+go 1.16
+
+require (
+	common v0.0.0
+	github.com/zmb3/spotify v1.1.2
+	google.golang.org/grpc v1.43.0
+)
+
+replace common => ../common
@@ -0,0 +1,11 @@
+module fakespotify
+
+go 1.16
+
+require (
+	common v0.0.0
+	github.com/gorilla/mux v1.8.0
+	github.com/sirupsen/logrus v1.8.1
+)
+
+replace common => ../common
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"common/config"
+	"common/fakespotify"
+	"common/health"
+	"common/server"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadState loads the initial state from the json file given in FAKESPOTIFY_STATE
+// The default state is used when the variable is not set
+func loadState() (fakespotify.State, error) {
+	path := os.Getenv("FAKESPOTIFY_STATE")
+	if path == "" {
+		return fakespotify.DefaultState(), nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fakespotify.State{}, err
+	}
+	var state fakespotify.State
+	err = json.Unmarshal(content, &state)
+	return state, err
+}
+
+func main() {
+	cfg, err := config.Parse("fakespotify", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+
+	state, err := loadState()
+	if err != nil {
+		log.WithError(err).Fatal("could not load fake spotify state")
+	}
+
+	r := mux.NewRouter()
+	checker := health.New(time.Second)
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.PathPrefix("/").Handler(fakespotify.New(state))
+
+	if err := server.Run(r, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
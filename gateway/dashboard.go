@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"common/identity"
+	log "github.com/sirupsen/logrus"
+)
+
+// dashboardSection is a part of the dashboard fetched from a microservice
+type dashboardSection struct {
+	name     string
+	upstream *url.URL
+	path     string
+}
+
+// dashboard is the aggregate of the user, the player and the playlists
+// A section failing is left empty and its error is given in errors, a section without content like an idle player is null
+type dashboard struct {
+	User      json.RawMessage     `json:"user,omitempty"`
+	Player    json.RawMessage     `json:"player,omitempty"`
+	Playlists json.RawMessage     `json:"playlists,omitempty"`
+	Errors    map[string]apiError `json:"errors,omitempty"`
+}
+
+// dashboardHandler fans out concurrently to the microservices, each call being cancelled after the timeout
+type dashboardHandler struct {
+	client   *http.Client
+	sections []dashboardSection
+	timeout  time.Duration
+}
+
+// newDashboardHandler creates the dashboard handler, the upstream of each section is found from the routes
+func newDashboardHandler(routes map[string]*url.URL, timeout time.Duration) *dashboardHandler {
+	sections := []dashboardSection{
+		{name: "user", path: "/user"},
+		{name: "player", path: "/player"},
+		{name: "playlists", path: "/playlist"},
+	}
+	for i := range sections {
+		sections[i].upstream = routes[sections[i].path]
+	}
+	return &dashboardHandler{client: &http.Client{}, sections: sections, timeout: timeout}
+}
+
+// fetch gets a section with the credentials of the incoming request, the section is null when the microservice has no content
+func (h *dashboardHandler) fetch(r *http.Request, section dashboardSection) (json.RawMessage, *apiError) {
+	if section.upstream == nil {
+		return nil, &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "no route for " + section.path}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	target := *section.upstream
+	target.Path = singleJoiningSlash(target.Path, section.path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusInternalServerError, Code: errorCode(http.StatusInternalServerError), Message: err.Error()}
+	}
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	req.Header.Set(identity.Header, r.Header.Get(identity.Header))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &apiError{Status: http.StatusGatewayTimeout, Code: "timeout", Message: fmt.Sprintf("%s did not answer within %v", section.name, h.timeout)}
+		}
+		log.WithError(err).WithField("section", section.name).Error("dashboardHandler: could not reach upstream")
+		return nil, &apiError{Status: http.StatusBadGateway, Code: "upstream_unavailable", Message: "the service is unavailable"}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadGateway, Code: "upstream_unavailable", Message: err.Error()}
+	}
+	// the player answers 204 when nothing is playing
+	if resp.StatusCode == http.StatusNoContent {
+		return json.RawMessage("null"), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &apiError{Status: resp.StatusCode, Code: errorCode(resp.StatusCode), Message: fmt.Sprintf("%s answered with status %d", section.name, resp.StatusCode)}
+	}
+	if !json.Valid(body) {
+		return nil, &apiError{Status: http.StatusBadGateway, Code: "invalid_response", Message: section.name + " answered with invalid json"}
+	}
+	return body, nil
+}
+
+// ServeHTTP serves GET /dashboard
+// It answers with the sections that could be fetched, or 502 when every section failed
+func (h *dashboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]json.RawMessage{}
+	errs := map[string]apiError{}
+	for _, section := range h.sections {
+		wg.Add(1)
+		go func(section dashboardSection) {
+			defer wg.Done()
+			body, err := h.fetch(r, section)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[section.name] = *err
+				return
+			}
+			results[section.name] = body
+		}(section)
+	}
+	wg.Wait()
+
+	if len(results) == 0 {
+		writeError(w, http.StatusBadGateway, "dashboard_unavailable", "every section of the dashboard failed")
+		return
+	}
+	resp := dashboard{
+		User:      results["user"],
+		Player:    results["player"],
+		Playlists: results["playlists"],
+	}
+	if len(errs) > 0 {
+		resp.Errors = errs
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// singleJoiningSlash joins two url paths with a single slash, the same way httputil.ReverseProxy does
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case len(a) > 0 && a[len(a)-1] == '/' && len(b) > 0 && b[0] == '/':
+		return a + b[1:]
+	case (len(a) == 0 || a[len(a)-1] != '/') && (len(b) == 0 || b[0] != '/'):
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"common/identity"
+	"common/openapi"
+)
+
+// newStub creates a backend answering the body with the status after the delay
+func newStub(t *testing.T, status int, body string, delay time.Duration) *url.URL {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token" || r.Header.Get(identity.Header) != "thomas" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return u
+}
+
+func Test_dashboardHandler(t *testing.T) {
+	user := `{"name":"Thomas","id":"thomas","image":""}`
+	player := `{"is_playing":true,"album_name":"Evening","artists_name":["Thomas"],"music_name":"Sunset","ID":"sunset","release_date":"2020-12-15T00:00:00Z","progress":42000,"duration":180000}`
+	playlists := `[{"image":"","name":"Morning","owner_name":"Thomas","ID":"morning","uri":"spotify:playlist:morning"}]`
+	spec := openapi.MustLoad()
+
+	tests := []struct {
+		name         string
+		routes       func(t *testing.T) map[string]*url.URL
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name: "should aggregate every section",
+			routes: func(t *testing.T) map[string]*url.URL {
+				return map[string]*url.URL{
+					"/user":     newStub(t, http.StatusOK, user, 0),
+					"/player":   newStub(t, http.StatusOK, player, 0),
+					"/playlist": newStub(t, http.StatusOK, playlists, 0),
+				}
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user":` + user + `,"player":` + player + `,"playlists":` + playlists + `}`,
+		},
+		{
+			name: "should give a null player when nothing is playing",
+			routes: func(t *testing.T) map[string]*url.URL {
+				return map[string]*url.URL{
+					"/user":     newStub(t, http.StatusOK, user, 0),
+					"/player":   newStub(t, http.StatusNoContent, "", 0),
+					"/playlist": newStub(t, http.StatusOK, playlists, 0),
+				}
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user":` + user + `,"player":null,"playlists":` + playlists + `}`,
+		},
+		{
+			name: "should return partial results with the section errors",
+			routes: func(t *testing.T) map[string]*url.URL {
+				return map[string]*url.URL{
+					"/user":     newStub(t, http.StatusOK, user, 0),
+					"/player":   newStub(t, http.StatusInternalServerError, "", 0),
+					"/playlist": newStub(t, http.StatusOK, playlists, time.Second),
+				}
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user":` + user + `,"errors":{"player":{"status":500,"code":"internal_server_error","message":"player answered with status 500"},"playlists":{"status":504,"code":"timeout","message":"playlists did not answer within 50ms"}}}`,
+		},
+		{
+			name: "should report a section without route",
+			routes: func(t *testing.T) map[string]*url.URL {
+				return map[string]*url.URL{
+					"/user":   newStub(t, http.StatusOK, user, 0),
+					"/player": newStub(t, http.StatusOK, "not json", 0),
+				}
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: `{"user":` + user + `,"errors":{"player":{"status":502,"code":"invalid_response","message":"player answered with invalid json"},"playlists":{"status":404,"code":"not_found","message":"no route for /playlist"}}}`,
+		},
+		{
+			name: "should fail when every section fails",
+			routes: func(t *testing.T) map[string]*url.URL {
+				return map[string]*url.URL{}
+			},
+			expectedCode: http.StatusBadGateway,
+			expectedBody: `{"error":{"status":502,"code":"dashboard_unavailable","message":"every section of the dashboard failed"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newDashboardHandler(tt.routes(t), 50*time.Millisecond)
+			req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+			req.Header.Set("Authorization", "token")
+			req.Header.Set(identity.Header, "thomas")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if body := strings.TrimSpace(rr.Body.String()); body != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", body, tt.expectedBody)
+			}
+			if err := spec.ValidateResponse(http.MethodGet, "/dashboard", rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
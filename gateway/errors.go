@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// apiError is the unified error returned by the gateway
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorEnvelope wraps the error in the response body
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+// errorCode returns the default error code of a status, e.g. "too_many_requests"
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// newErrorBody returns the json body of an error
+func newErrorBody(status int, code, message string) []byte {
+	if code == "" {
+		code = errorCode(status)
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	body, _ := json.Marshal(errorEnvelope{Error: apiError{Status: status, Code: code, Message: message}})
+	return body
+}
+
+// writeError writes an error with the unified envelope
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(newErrorBody(status, code, message))
+}
+
+// wrapUpstreamError replaces the body of the upstream errors that are not json by the unified envelope
+// The microservices only answer with a status code on error, the client then always gets a json error
+func wrapUpstreamError(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	body := newErrorBody(resp.StatusCode, "", strings.TrimSpace(string(message)))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// proxyErrorHandler answers when the upstream could not be reached or the request body was too large
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.Contains(err.Error(), "request body too large") {
+		writeError(w, http.StatusRequestEntityTooLarge, "", "request body too large")
+		return
+	}
+	log.WithError(err).WithField("path", r.URL.Path).Error("proxyErrorHandler: could not reach upstream")
+	writeError(w, http.StatusBadGateway, "upstream_unavailable", "the service is unavailable")
+}
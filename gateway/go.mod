@@ -0,0 +1,14 @@
+module gateway
+
+go 1.16
+
+require (
+	common v0.0.0
+	github.com/gorilla/mux v1.8.0
+	github.com/rs/cors v1.7.0
+	github.com/sirupsen/logrus v1.8.1
+	github.com/zmb3/spotify v1.1.2
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+)
+
+replace common => ../common
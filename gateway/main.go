@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"common/config"
+	"common/eventbus"
+	"common/health"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/cors"
+	log "github.com/sirupsen/logrus"
+)
+
+// route is a path prefix with the handler serving it
+type route struct {
+	prefix  string
+	handler http.Handler
+}
+
+// routeHandler routes the requests to the handler of the longest matching prefix
+// A prefix matches the path itself and its sub paths ("/user" matches "/user/1" but not "/users")
+func routeHandler(routes []route) http.Handler {
+	sorted := append([]route{}, routes...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i].prefix) > len(sorted[j].prefix) })
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range sorted {
+			if r.URL.Path == rt.prefix || strings.HasPrefix(r.URL.Path, strings.TrimSuffix(rt.prefix, "/")+"/") {
+				rt.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "", "no route for "+r.URL.Path)
+	})
+}
+
+// newProxy creates the reverse proxy to an upstream, its errors are wrapped in the unified envelope
+func newProxy(upstream *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.ModifyResponse = wrapUpstreamError
+	proxy.ErrorHandler = proxyErrorHandler
+	proxy.FlushInterval = -1
+	return proxy
+}
+
+// bodyLimitMiddleware rejects the requests whose body is larger than max bytes
+func bodyLimitMiddleware(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			writeError(w, http.StatusRequestEntityTooLarge, "", "request body too large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newGateway creates the handler of the gateway
+// The health endpoints are public, every other request needs a valid session and is rate limited per route
+// GET /dashboard aggregates the user, the player and the playlists in a single response
+func newGateway(cfg config.Config, validator *sessionValidator, checker *health.Checker) (http.Handler, error) {
+	var routes []route
+	upstreams := map[string]*url.URL{}
+	for _, rc := range cfg.Gateway.Routes {
+		upstream, err := url.Parse(rc.Upstream)
+		if err != nil {
+			return nil, err
+		}
+		upstreams[rc.Prefix] = upstream
+		var handler http.Handler = newProxy(upstream)
+		if rc.RateLimit > 0 {
+			handler = rateLimitMiddleware(newRateLimiter(rc.RateLimit, rc.Burst), handler)
+		}
+		routes = append(routes, route{prefix: rc.Prefix, handler: handler})
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.HandleFunc("/openapi.json", spec.Handler).Methods("GET")
+	r.Handle("/dashboard", sessionMiddleware(validator, newDashboardHandler(upstreams, cfg.Gateway.DashboardTimeout))).Methods("GET")
+	r.PathPrefix("/").Handler(bodyLimitMiddleware(cfg.Gateway.MaxBodyBytes, sessionMiddleware(validator, routeHandler(routes))))
+
+	corsWrapper := cors.New(cors.Options{
+		AllowedOrigins: cfg.CORS.AllowedOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "Origin", "Accept"},
+	})
+	return corsWrapper.Handler(r), nil
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// The gateway is ready once every upstream is ready
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	for _, rc := range cfg.Gateway.Routes {
+		checker.Add(rc.Prefix, health.HTTPCheck(http.DefaultClient, strings.TrimSuffix(rc.Upstream, "/")+"/readyz"))
+	}
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("gateway", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+	bus, err := eventbus.Open(cfg.EventBus.URL)
+	if err != nil {
+		log.WithError(err).Fatal("could not open event bus")
+	}
+	defer bus.Close()
+	validator := newSessionValidator(spotifyUserFetcher(factory.Client), cfg.Gateway.SessionTTL, bus)
+
+	gateway, err := newGateway(cfg, validator, newHealthChecker(cfg))
+	if err != nil {
+		log.WithError(err).Fatal("could not create gateway")
+	}
+	if err := server.Run(gateway, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"common/config"
+	"common/eventbus"
+	"common/health"
+	"common/identity"
+	"common/openapi"
+	"github.com/zmb3/spotify"
+)
+
+// newUpstream creates an upstream answering with its name, the path and the user ID it received
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/player/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(name + " " + r.URL.Path + " " + r.Header.Get(identity.Header)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestGateway(t *testing.T) http.Handler {
+	cfg := config.Default()
+	cfg.Gateway.MaxBodyBytes = 16
+	cfg.Gateway.Routes = []config.Route{
+		{Prefix: "/user", Upstream: newUpstream(t, "user").URL},
+		{Prefix: "/player", Upstream: newUpstream(t, "player").URL, RateLimit: 1, Burst: 2},
+		{Prefix: "/player/special", Upstream: newUpstream(t, "special").URL},
+		{Prefix: "/down", Upstream: "http://127.0.0.1:1"},
+	}
+	validator := newSessionValidator(func(token string) (*spotify.PrivateUser, error) {
+		switch token {
+		case "valid", "other":
+			return &spotify.PrivateUser{User: spotify.User{ID: "id-" + token}}, nil
+		case "spotify-down":
+			return nil, errors.New("connection refused")
+		}
+		return nil, errInvalidToken
+	}, time.Minute, nil)
+
+	gateway, err := newGateway(cfg, validator, health.New(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func Test_newGateway(t *testing.T) {
+	gateway := newTestGateway(t)
+	spec := openapi.MustLoad()
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        string
+		userID       string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "should route to the upstream with the verified user ID",
+			path:         "/user/alice",
+			token:        "valid",
+			userID:       "forged",
+			expectedCode: http.StatusOK,
+			expectedBody: "user /user/alice id-valid",
+		},
+		{
+			name:         "should route to the longest prefix",
+			path:         "/player/special/1",
+			token:        "valid",
+			expectedCode: http.StatusOK,
+			expectedBody: "special /player/special/1 id-valid",
+		},
+		{
+			name:         "should not route a prefix of another word",
+			path:         "/users",
+			token:        "valid",
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"error":{"status":404,"code":"not_found","message":"no route for /users"}}`,
+		},
+		{
+			name:         "should serve the health endpoints without token",
+			path:         "/healthz",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"ok"}`,
+		},
+		{
+			name:         "should reject requests without token",
+			path:         "/user",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":{"status":401,"code":"missing_token","message":"an access token is required"}}`,
+		},
+		{
+			name:         "should reject invalid tokens",
+			path:         "/user",
+			token:        "expired",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"error":{"status":401,"code":"invalid_token","message":"the access token is invalid or expired"}}`,
+		},
+		{
+			name:         "should answer bad gateway when spotify is down",
+			path:         "/user",
+			token:        "spotify-down",
+			expectedCode: http.StatusBadGateway,
+			expectedBody: `{"error":{"status":502,"code":"spotify_unavailable","message":"could not validate the access token"}}`,
+		},
+		{
+			name:         "should wrap upstream errors",
+			path:         "/player/fail",
+			token:        "other",
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: `{"error":{"status":500,"code":"internal_server_error","message":"Internal Server Error"}}`,
+		},
+		{
+			name:         "should answer bad gateway when the upstream is down",
+			path:         "/down",
+			token:        "valid",
+			expectedCode: http.StatusBadGateway,
+			expectedBody: `{"error":{"status":502,"code":"upstream_unavailable","message":"the service is unavailable"}}`,
+		},
+		{
+			name:         "should reject large bodies",
+			method:       http.MethodPost,
+			path:         "/user",
+			token:        "valid",
+			body:         `{"uri":"spotify:playlist:too-long"}`,
+			expectedCode: http.StatusRequestEntityTooLarge,
+			expectedBody: `{"error":{"status":413,"code":"request_entity_too_large","message":"request body too large"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			if tt.userID != "" {
+				req.Header.Set(identity.Header, tt.userID)
+			}
+			rr := httptest.NewRecorder()
+			gateway.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if body := strings.TrimSpace(rr.Body.String()); body != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", body, tt.expectedBody)
+			}
+			if op, _ := spec.Find(method, tt.path); op != nil && rr.Code >= http.StatusBadRequest {
+				if err := spec.ValidateResponse(method, tt.path, rr.Code, rr.Body.Bytes()); err != nil {
+					t.Errorf("error does not match the specification: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_newGateway_openapi(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newTestGateway(t).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("could not decode the specification: %v", err)
+	}
+	for _, path := range []string{"/user", "/user/{userID}", "/player", "/player/play", "/playlist", "/dashboard"} {
+		if _, ok := doc.Paths[path]; !ok || !strings.HasPrefix(doc.OpenAPI, "3.") {
+			t.Errorf("the specification does not document %s", path)
+		}
+	}
+}
+
+func Test_newGateway_rateLimit(t *testing.T) {
+	gateway := newTestGateway(t)
+	do := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/player", nil)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		gateway.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do("valid"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d returned %v", i, rr.Code)
+		}
+	}
+	rr := do("valid")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("request over the burst returned %v with Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := do("other"); rr.Code != http.StatusOK {
+		t.Errorf("another user should not be limited, got %v", rr.Code)
+	}
+}
+
+func Test_sessionValidator_validate(t *testing.T) {
+	calls := 0
+	now := time.Now()
+	bus := eventbus.NewMemory()
+	defer bus.Close()
+	logins := make(chan eventbus.UserLogin, 10)
+	eventbus.OnUserLogin(bus, func(login eventbus.UserLogin) { logins <- login })
+	tokens := make(chan eventbus.TokenValidated, 10)
+	eventbus.OnTokenValidated(bus, func(validated eventbus.TokenValidated) { tokens <- validated })
+	v := newSessionValidator(func(token string) (*spotify.PrivateUser, error) {
+		calls++
+		return &spotify.PrivateUser{User: spotify.User{ID: "thomas"}, Country: "FR", Product: "premium"}, nil
+	}, time.Minute, bus)
+	v.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if userID, err := v.validate("token"); err != nil || userID != "thomas" {
+			t.Fatalf("validate() = %v, %v", userID, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("token was validated %d times, want 1", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	v.validate("token")
+	if calls != 2 {
+		t.Errorf("expired session was not validated again")
+	}
+
+	// only the first validation of a token is a login
+	select {
+	case login := <-logins:
+		if login.UserID != "thomas" || login.Country != "FR" || login.Product != "premium" || !login.OccurredAt.Equal(now.Add(-2*time.Minute)) {
+			t.Errorf("login = %+v", login)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the login was not published")
+	}
+	select {
+	case login := <-logins:
+		t.Errorf("the validations of a known token published %+v", login)
+	case <-time.After(100 * time.Millisecond):
+	}
+	v.validate("new-token")
+	select {
+	case <-logins:
+	case <-time.After(time.Second):
+		t.Fatal("the login of the new token was not published")
+	}
+
+	// each validation against spotify publishes the token
+	validated := map[string]int{}
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-tokens:
+			if got.UserID != "thomas" {
+				t.Errorf("validated token = %+v", got)
+			}
+			validated[got.Token]++
+		case <-time.After(time.Second):
+			t.Fatalf("only %d validated tokens were published, want 3", i)
+		}
+	}
+	if validated["token"] != 2 || validated["new-token"] != 1 {
+		t.Errorf("validated tokens = %v, want token twice and new-token once", validated)
+	}
+	select {
+	case got := <-tokens:
+		t.Errorf("a cached session published %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_rateLimiter_allow(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(1, 1)
+	l.now = func() time.Time { return now }
+
+	if !l.allow("a") || l.allow("a") {
+		t.Fatalf("limiter should allow a single request per second")
+	}
+	now = now.Add(time.Second)
+	if !l.allow("a") {
+		t.Errorf("limiter should allow a request after a second")
+	}
+
+	now = now.Add(2 * limiterIdleTime)
+	l.allow("b")
+	if _, ok := l.clients["a"]; ok {
+		t.Errorf("idle client limiter was not dropped")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTime is the time after which the limiter of an inactive client is dropped
+const limiterIdleTime = 10 * time.Minute
+
+// clientLimiter is the limiter of a client with the last time it was used
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter limits the requests of each client of a route
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+	swept   time.Time
+}
+
+// newRateLimiter creates a limiter allowing limit requests per second per client
+func newRateLimiter(limit float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		now:     time.Now,
+		clients: map[string]*clientLimiter{},
+	}
+}
+
+// allow returns true if the client can do a request now
+func (l *rateLimiter) allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	if now.Sub(l.swept) > limiterIdleTime {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTime {
+				delete(l.clients, key)
+			}
+		}
+		l.swept = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	return c.limiter.AllowN(now, 1)
+}
+
+// clientKey returns the key identifying the client of a request, its user ID or its IP address
+func clientKey(r *http.Request) string {
+	if userID := userIDFromContext(r.Context()); userID != "" {
+		return "user:" + userID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitMiddleware answers 429 when the client exceeded the limit of the route
+func rateLimitMiddleware(l *rateLimiter, next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(math.Ceil(1 / float64(l.limit))))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(clientKey(r)) {
+			w.Header().Set("Retry-After", retryAfter)
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"common/eventbus"
+	"common/identity"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// maxSessions is the number of cached sessions from which the expired ones are removed
+const maxSessions = 10000
+
+// errInvalidToken is returned when spotify rejects the access token
+var errInvalidToken = errors.New("invalid access token")
+
+// userFetcher returns the spotify user owning the access token
+type userFetcher func(token string) (*spotify.PrivateUser, error)
+
+// session is a validated access token
+type session struct {
+	userID  string
+	expires time.Time
+}
+
+// sessionValidator validates the access tokens against spotify and caches the result
+// The first validation of a token is a login of its user, published on the bus
+// Each validation against spotify publishes the token too, for the services working for the user in the background
+type sessionValidator struct {
+	fetch userFetcher
+	ttl   time.Duration
+	now   func() time.Time
+	bus   eventbus.Bus
+
+	mu       sync.Mutex
+	sessions map[[sha256.Size]byte]session
+}
+
+// newSessionValidator creates a validator trusting a validated token for the given ttl
+func newSessionValidator(fetch userFetcher, ttl time.Duration, bus eventbus.Bus) *sessionValidator {
+	return &sessionValidator{
+		fetch:    fetch,
+		ttl:      ttl,
+		now:      time.Now,
+		bus:      bus,
+		sessions: map[[sha256.Size]byte]session{},
+	}
+}
+
+// validate returns the spotify ID of the owner of the token
+// Only a hash of the token is kept in memory, an expired session checked again is not a new login
+// unless it was removed to make room for the others
+func (v *sessionValidator) validate(token string) (string, error) {
+	key := sha256.Sum256([]byte(token))
+	now := v.now()
+
+	v.mu.Lock()
+	s, ok := v.sessions[key]
+	v.mu.Unlock()
+	if ok && now.Before(s.expires) {
+		return s.userID, nil
+	}
+
+	user, err := v.fetch(token)
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	_, known := v.sessions[key]
+	if len(v.sessions) >= maxSessions {
+		for k, s := range v.sessions {
+			if !now.Before(s.expires) {
+				delete(v.sessions, k)
+			}
+		}
+	}
+	v.sessions[key] = session{userID: user.ID, expires: now.Add(v.ttl)}
+	v.mu.Unlock()
+	if !known {
+		eventbus.Go(v.bus, eventbus.UserLogin{UserID: user.ID, Country: user.Country, Product: user.Product, OccurredAt: now.UTC()})
+	}
+	eventbus.Go(v.bus, eventbus.TokenValidated{UserID: user.ID, Token: token, OccurredAt: now.UTC()})
+	return user.ID, nil
+}
+
+// spotifyUserFetcher returns a fetcher asking spotify for the current user of the token
+func spotifyUserFetcher(client func(token string) *spotify.Client) userFetcher {
+	return func(token string) (*spotify.PrivateUser, error) {
+		user, err := client(token).CurrentUser()
+		if err != nil {
+			var serr spotify.Error
+			if errors.As(err, &serr) && serr.Status == http.StatusUnauthorized {
+				return nil, errInvalidToken
+			}
+			return nil, err
+		}
+		return user, nil
+	}
+}
+
+// userKey is the key of the user ID in the request context
+type userKey struct{}
+
+// userIDFromContext returns the verified user ID of the request
+func userIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+// sessionMiddleware rejects the requests without a valid access token
+// The verified user ID is sent to the microservices in the X-User-ID header, the one sent by the client is dropped
+func sessionMiddleware(v *sessionValidator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(identity.Header)
+		token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "missing_token", "an access token is required")
+			return
+		}
+
+		userID, err := v.validate(token)
+		if errors.Is(err, errInvalidToken) {
+			writeError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid or expired")
+			return
+		}
+		if err != nil {
+			log.WithError(err).Error("sessionMiddleware: could not validate access token")
+			writeError(w, http.StatusBadGateway, "spotify_unavailable", "could not validate the access token")
+			return
+		}
+
+		r.Header.Set(identity.Header, userID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, userID)))
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// result is the value or the error loaded for a key
+type result struct {
+	value interface{}
+	err   error
+}
+
+// batchFunc loads the values of the keys at once
+type batchFunc func(keys []string) map[string]result
+
+// call is a pending or done load of a key
+type call struct {
+	done chan struct{}
+	result
+}
+
+// loader batches the loads of the keys asked within the wait window and caches them for the request
+// It avoids asking spotify several times for the same user or playlist when it appears many times in a query
+type loader struct {
+	batch    batchFunc
+	wait     time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	calls   map[string]*call
+	pending []string
+	timer   *time.Timer
+}
+
+// newLoader creates a loader dispatching its batches after wait or once maxBatch keys are pending
+func newLoader(batch batchFunc, wait time.Duration, maxBatch int) *loader {
+	return &loader{batch: batch, wait: wait, maxBatch: maxBatch, calls: map[string]*call{}}
+}
+
+// load returns the value of the key, waiting for the batch it belongs to
+func (l *loader) load(key string) (interface{}, error) {
+	l.mu.Lock()
+	c, ok := l.calls[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		l.calls[key] = c
+		l.pending = append(l.pending, key)
+		if len(l.pending) >= l.maxBatch {
+			l.dispatchLocked()
+		} else if l.timer == nil {
+			l.timer = time.AfterFunc(l.wait, l.flush)
+		}
+	}
+	l.mu.Unlock()
+
+	<-c.done
+	return c.value, c.err
+}
+
+// flush dispatches the pending keys once the wait window is over
+func (l *loader) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dispatchLocked()
+}
+
+// dispatchLocked loads the pending keys in background, the lock must be held
+func (l *loader) dispatchLocked() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	keys := l.pending
+	l.pending = nil
+	if len(keys) == 0 {
+		return
+	}
+
+	go func() {
+		results := l.batch(keys)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, key := range keys {
+			c := l.calls[key]
+			r, ok := results[key]
+			if !ok {
+				r = result{err: errNotFound}
+			}
+			c.result = r
+			close(c.done)
+		}
+	}()
+}
+
+// fetchEach returns a batch function fetching each key concurrently
+// Spotify has no batch endpoint for users and playlists so a batch only removes the duplicated keys
+func fetchEach(fetch func(key string) (interface{}, error)) batchFunc {
+	return func(keys []string) map[string]result {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		results := make(map[string]result, len(keys))
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				value, err := fetch(key)
+				mu.Lock()
+				results[key] = result{value: value, err: err}
+				mu.Unlock()
+			}(key)
+		}
+		wg.Wait()
+		return results
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"common/config"
+	"common/health"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"github.com/gorilla/mux"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// Key type of spotify client context
+type key int
+
+// CLIENT_CONTEXT is the key used for the spotify client context
+var CLIENT_CONTEXT = key(1)
+
+// LOADERS_CONTEXT is the key used for the loaders of the request
+var LOADERS_CONTEXT = key(2)
+
+// schemaSDL is the graphql schema of the API
+//
+//go:embed schema.graphql
+var schemaSDL string
+
+// spotifyClient interface of spotify client
+type spotifyClient interface {
+	CurrentUser() (*spotify.PrivateUser, error)
+	GetUsersPublicProfile(userID spotify.ID) (*spotify.User, error)
+	PlayerCurrentlyPlaying() (*spotify.CurrentlyPlaying, error)
+	PlayOpt(opt *spotify.PlayOptions) error
+	Pause() error
+	Next() error
+	Previous() error
+	CurrentUsersPlaylistsOpt(opt *spotify.Options) (*spotify.SimplePlaylistPage, error)
+	GetPlaylist(playlistID spotify.ID) (*spotify.FullPlaylist, error)
+	GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error)
+}
+
+// newSchema parses the schema with its resolvers
+func newSchema() *graphql.Schema {
+	return graphql.MustParseSchema(schemaSDL, &resolver{}, graphql.UseFieldResolvers())
+}
+
+// tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
+// The loaders of the request are created along with the client
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := r.Header.Get("Authorization")
+		client := factory.Client(bearer)
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+		ctx = context.WithValue(ctx, LOADERS_CONTEXT, newLoaders(client))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("graphql", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.Handle("/graphql", &relay.Handler{Schema: newSchema()}).Methods("POST")
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.Run(contextedMux, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/graph-gophers/graphql-go/relay"
+	"github.com/zmb3/spotify"
+)
+
+type mockSpotifyClient struct {
+	mu        sync.Mutex
+	err       error
+	calls     map[string]int
+	player    spotify.CurrentlyPlaying
+	playlists []spotify.SimplePlaylist
+	tracks    []spotify.PlaylistTrack
+	played    *spotify.PlayOptions
+}
+
+func (c *mockSpotifyClient) called(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[name]++
+}
+
+func (c *mockSpotifyClient) CurrentUser() (*spotify.PrivateUser, error) {
+	c.called("CurrentUser")
+	return &spotify.PrivateUser{User: spotify.User{ID: "thomas", DisplayName: "Thomas"}}, c.err
+}
+
+func (c *mockSpotifyClient) GetUsersPublicProfile(userID spotify.ID) (*spotify.User, error) {
+	c.called("GetUsersPublicProfile")
+	return &spotify.User{ID: string(userID), DisplayName: strings.Title(string(userID))}, c.err
+}
+
+func (c *mockSpotifyClient) PlayerCurrentlyPlaying() (*spotify.CurrentlyPlaying, error) {
+	c.called("PlayerCurrentlyPlaying")
+	return &c.player, c.err
+}
+
+func (c *mockSpotifyClient) PlayOpt(opt *spotify.PlayOptions) error {
+	c.called("PlayOpt")
+	c.played = opt
+	return c.err
+}
+
+func (c *mockSpotifyClient) Pause() error {
+	c.called("Pause")
+	return c.err
+}
+
+func (c *mockSpotifyClient) Next() error {
+	c.called("Next")
+	return c.err
+}
+
+func (c *mockSpotifyClient) Previous() error {
+	c.called("Previous")
+	return c.err
+}
+
+func (c *mockSpotifyClient) CurrentUsersPlaylistsOpt(opt *spotify.Options) (*spotify.SimplePlaylistPage, error) {
+	c.called("CurrentUsersPlaylistsOpt")
+	page := &spotify.SimplePlaylistPage{}
+	page.Total = len(c.playlists)
+	for i := *opt.Offset; i < len(c.playlists) && i < *opt.Offset+*opt.Limit; i++ {
+		page.Playlists = append(page.Playlists, c.playlists[i])
+	}
+	return page, c.err
+}
+
+func (c *mockSpotifyClient) GetPlaylist(playlistID spotify.ID) (*spotify.FullPlaylist, error) {
+	c.called("GetPlaylist")
+	for _, p := range c.playlists {
+		if p.ID == playlistID {
+			return &spotify.FullPlaylist{SimplePlaylist: p}, c.err
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (c *mockSpotifyClient) GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error) {
+	c.called("GetPlaylistTracksOpt")
+	page := &spotify.PlaylistTrackPage{}
+	page.Total = len(c.tracks)
+	for i := *opt.Offset; i < len(c.tracks) && i < *opt.Offset+*opt.Limit; i++ {
+		page.Tracks = append(page.Tracks, c.tracks[i])
+	}
+	return page, c.err
+}
+
+func newMockClient() *mockSpotifyClient {
+	playlist := func(id, name, owner string) spotify.SimplePlaylist {
+		return spotify.SimplePlaylist{ID: spotify.ID(id), Name: name, URI: spotify.URI("spotify:playlist:" + id), Owner: spotify.User{ID: owner, DisplayName: owner}}
+	}
+	track := func(id, name string) spotify.PlaylistTrack {
+		return spotify.PlaylistTrack{
+			AddedAt: "2021-01-01T00:00:00Z",
+			Track: spotify.FullTrack{
+				SimpleTrack: spotify.SimpleTrack{ID: spotify.ID(id), Name: name, URI: spotify.URI("spotify:track:" + id), Duration: 1000, Artists: []spotify.SimpleArtist{{Name: "artist"}}},
+				Album:       spotify.SimpleAlbum{Name: "album"},
+			},
+		}
+	}
+	return &mockSpotifyClient{
+		player: spotify.CurrentlyPlaying{
+			Playing:  true,
+			Progress: 42,
+			Item: &spotify.FullTrack{
+				Album:       spotify.SimpleAlbum{Name: "album", ReleaseDate: "2020-12-15", ReleaseDatePrecision: "day"},
+				SimpleTrack: spotify.SimpleTrack{ID: "id", Name: "test", Duration: 100, Artists: []spotify.SimpleArtist{{Name: "artist name"}}},
+			},
+		},
+		playlists: []spotify.SimplePlaylist{
+			playlist("morning", "Morning", "thomas"),
+			playlist("evening", "Evening", "thomas"),
+			playlist("party", "Party", "alice"),
+		},
+		tracks: []spotify.PlaylistTrack{track("sunrise", "Sunrise"), track("coffee", "Coffee"), track("commute", "Commute")},
+	}
+}
+
+func getRequestMock(client *mockSpotifyClient, query string) *http.Request {
+	body, _ := json.Marshal(map[string]string{"query": query})
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+	ctx = context.WithValue(ctx, LOADERS_CONTEXT, newLoaders(client))
+	return r.WithContext(ctx)
+}
+
+func Test_graphqlHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		client        *mockSpotifyClient
+		query         string
+		expectedBody  string
+		expectedCalls map[string]int
+		check         func(t *testing.T, client *mockSpotifyClient)
+	}{
+		{
+			name:         "should get the current user",
+			client:       newMockClient(),
+			query:        `{ me { id name } }`,
+			expectedBody: `{"data":{"me":{"id":"thomas","name":"Thomas"}}}`,
+		},
+		{
+			name:         "should get the current player",
+			client:       newMockClient(),
+			query:        `{ player { id isPlaying musicName artistsName releaseDate progress duration } }`,
+			expectedBody: `{"data":{"player":{"id":"id","isPlaying":true,"musicName":"test","artistsName":["artist name"],"releaseDate":"2020-12-15T00:00:00Z","progress":42,"duration":100}}}`,
+		},
+		{
+			name:         "should get a null player when nothing is playing",
+			client:       &mockSpotifyClient{},
+			query:        `{ player { id } }`,
+			expectedBody: `{"data":{"player":null}}`,
+		},
+		{
+			name:         "should paginate the playlists",
+			client:       newMockClient(),
+			query:        `{ playlists(first: 2, after: "b2Zmc2V0OjA=") { totalCount edges { cursor node { id name } } pageInfo { hasNextPage endCursor } } }`,
+			expectedBody: `{"data":{"playlists":{"totalCount":3,"edges":[{"cursor":"b2Zmc2V0OjE=","node":{"id":"evening","name":"Evening"}},{"cursor":"b2Zmc2V0OjI=","node":{"id":"party","name":"Party"}}],"pageInfo":{"hasNextPage":false,"endCursor":"b2Zmc2V0OjI="}}}}`,
+		},
+		{
+			name:          "should fetch each owner once",
+			client:        newMockClient(),
+			query:         `{ playlists { edges { node { owner { id name } } } } }`,
+			expectedBody:  `{"data":{"playlists":{"edges":[{"node":{"owner":{"id":"thomas","name":"Thomas"}}},{"node":{"owner":{"id":"thomas","name":"Thomas"}}},{"node":{"owner":{"id":"alice","name":"Alice"}}}]}}}`,
+			expectedCalls: map[string]int{"CurrentUsersPlaylistsOpt": 1, "GetUsersPublicProfile": 2},
+		},
+		{
+			name:          "should get a playlist with its tracks",
+			client:        newMockClient(),
+			query:         `{ playlist(id: "morning") { name tracks(first: 2) { totalCount edges { node { id name uri albumName artistsName duration addedAt } } pageInfo { hasNextPage } } } }`,
+			expectedBody:  `{"data":{"playlist":{"name":"Morning","tracks":{"totalCount":3,"edges":[{"node":{"id":"sunrise","name":"Sunrise","uri":"spotify:track:sunrise","albumName":"album","artistsName":["artist"],"duration":1000,"addedAt":"2021-01-01T00:00:00Z"}},{"node":{"id":"coffee","name":"Coffee","uri":"spotify:track:coffee","albumName":"album","artistsName":["artist"],"duration":1000,"addedAt":"2021-01-01T00:00:00Z"}}],"pageInfo":{"hasNextPage":true}}}}}`,
+			expectedCalls: map[string]int{"GetPlaylist": 1, "GetPlaylistTracksOpt": 1},
+		},
+		{
+			name:          "should fetch an aliased playlist once",
+			client:        newMockClient(),
+			query:         `{ a: playlist(id: "morning") { name } b: playlist(id: "morning") { id } }`,
+			expectedBody:  `{"data":{"a":{"name":"Morning"},"b":{"id":"morning"}}}`,
+			expectedCalls: map[string]int{"GetPlaylist": 1},
+		},
+		{
+			name:         "should play a playlist",
+			client:       newMockClient(),
+			query:        `mutation { play(uri: "spotify:playlist:morning") }`,
+			expectedBody: `{"data":{"play":true}}`,
+			check: func(t *testing.T, client *mockSpotifyClient) {
+				if client.played == nil || client.played.PlaybackContext == nil || *client.played.PlaybackContext != "spotify:playlist:morning" {
+					t.Errorf("play was not called with the playlist context: got %+v", client.played)
+				}
+			},
+		},
+		{
+			name:         "should play a track on its own",
+			client:       newMockClient(),
+			query:        `mutation { play(uri: "spotify:track:sunrise") }`,
+			expectedBody: `{"data":{"play":true}}`,
+			check: func(t *testing.T, client *mockSpotifyClient) {
+				if client.played == nil || client.played.PlaybackContext != nil || len(client.played.URIs) != 1 || client.played.URIs[0] != "spotify:track:sunrise" {
+					t.Errorf("play was not called with the track: got %+v", client.played)
+				}
+			},
+		},
+		{
+			name:          "should control the player",
+			client:        newMockClient(),
+			query:         `mutation { pause next previous }`,
+			expectedBody:  `{"data":{"pause":true,"next":true,"previous":true}}`,
+			expectedCalls: map[string]int{"Pause": 1, "Next": 1, "Previous": 1},
+		},
+		{
+			name:         "should error on invalid cursor",
+			client:       newMockClient(),
+			query:        `{ playlists(after: "nope") { totalCount } }`,
+			expectedBody: `{"errors":[{"message":"invalid cursor \"nope\"","path":["playlists"]}],"data":null}`,
+		},
+		{
+			name:         "should error on spotify api call",
+			client:       &mockSpotifyClient{err: errors.New("could not fetch player")},
+			query:        `mutation { pause }`,
+			expectedBody: `{"errors":[{"message":"could not fetch player","path":["pause"]}],"data":null}`,
+		},
+	}
+	handler := &relay.Handler{Schema: newSchema()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, getRequestMock(tt.client, tt.query))
+			if res := rr.Code; res != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, http.StatusOK)
+			}
+			if strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					strings.TrimSpace(rr.Body.String()), tt.expectedBody)
+			}
+			for name, expected := range tt.expectedCalls {
+				if got := tt.client.calls[name]; got != expected {
+					t.Errorf("unexpected number of calls to %s: got %v want %v", name, got, expected)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, tt.client)
+			}
+		})
+	}
+}
+
+func Test_loader(t *testing.T) {
+	var mu sync.Mutex
+	batches := [][]string{}
+	l := newLoader(func(keys []string) map[string]result {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		results := map[string]result{}
+		for _, k := range keys {
+			if k != "missing" {
+				results[k] = result{value: "value " + k}
+			}
+		}
+		return results
+	}, 5*time.Millisecond, 3)
+
+	var wg sync.WaitGroup
+	values := make([]interface{}, 4)
+	errs := make([]error, 4)
+	for i, key := range []string{"a", "b", "a", "missing"} {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			values[i], errs[i] = l.load(key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Errorf("unexpected batches: got %v want a single batch of 3 keys", batches)
+	}
+	if values[0] != "value a" || values[2] != "value a" || values[1] != "value b" {
+		t.Errorf("unexpected values: got %v", values)
+	}
+	if !errors.Is(errs[3], errNotFound) {
+		t.Errorf("unexpected error for missing key: got %v want %v", errs[3], errNotFound)
+	}
+
+	if v, _ := l.load("a"); v != "value a" || len(batches) != 1 {
+		t.Errorf("cached key should not be loaded again: got %v after %d batches", v, len(batches))
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/models"
+	graphql "github.com/graph-gophers/graphql-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// errNotFound is returned when spotify did not return a requested key
+var errNotFound = errors.New("not found")
+
+// loaders are the loaders of a request
+type loaders struct {
+	users     *loader
+	playlists *loader
+	tracks    *loader
+}
+
+// newLoaders creates the loaders of a request using its spotify client
+func newLoaders(client spotifyClient) *loaders {
+	const wait, maxBatch = 2 * time.Millisecond, 50
+	return &loaders{
+		users: newLoader(fetchEach(func(id string) (interface{}, error) {
+			return client.GetUsersPublicProfile(spotify.ID(id))
+		}), wait, maxBatch),
+		playlists: newLoader(fetchEach(func(id string) (interface{}, error) {
+			return client.GetPlaylist(spotify.ID(id))
+		}), wait, maxBatch),
+		tracks: newLoader(fetchEach(func(key string) (interface{}, error) {
+			id, offset, limit := parseTracksKey(key)
+			return client.GetPlaylistTracksOpt(spotify.ID(id), &spotify.Options{Offset: &offset, Limit: &limit}, "")
+		}), wait, maxBatch),
+	}
+}
+
+// tracksKey is the loader key of a page of tracks of a playlist
+func tracksKey(id spotify.ID, offset, limit int) string {
+	return fmt.Sprintf("%s|%d|%d", id, offset, limit)
+}
+
+// parseTracksKey parses a key created by tracksKey
+func parseTracksKey(key string) (string, int, int) {
+	parts := strings.Split(key, "|")
+	offset, _ := strconv.Atoi(parts[1])
+	limit, _ := strconv.Atoi(parts[2])
+	return parts[0], offset, limit
+}
+
+// clientFromContext returns the spotify client and the loaders of the request
+func clientFromContext(ctx context.Context) (spotifyClient, *loaders) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	l, ok := ctx.Value(LOADERS_CONTEXT).(*loaders)
+	if !ok {
+		l = newLoaders(client)
+	}
+	return client, l
+}
+
+// encodeCursor encodes an offset as an opaque cursor
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodeCursor returns the offset following the cursor, 0 without cursor
+func decodeCursor(cursor *string) (int, error) {
+	if cursor == nil || *cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(*cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "offset:") {
+		return 0, fmt.Errorf("invalid cursor %q", *cursor)
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), "offset:"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", *cursor)
+	}
+	return offset + 1, nil
+}
+
+// pageArgs are the arguments of a paginated field
+type pageArgs struct {
+	First int32
+	After *string
+}
+
+// bounds returns the offset and the limit of the page, spotify allows at most max items per page
+func (a pageArgs) bounds(max int) (int, int, error) {
+	offset, err := decodeCursor(a.After)
+	if err != nil {
+		return 0, 0, err
+	}
+	if a.First <= 0 || int(a.First) > max {
+		return 0, 0, fmt.Errorf("first must be between 1 and %d", max)
+	}
+	return offset, int(a.First), nil
+}
+
+// pageInfoResolver resolves the PageInfo type
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+// newPageInfo returns the page info of a page of count items starting at offset
+func newPageInfo(offset, count, total int) *pageInfoResolver {
+	info := &pageInfoResolver{hasNextPage: offset+count < total}
+	if count > 0 {
+		cursor := encodeCursor(offset + count - 1)
+		info.endCursor = &cursor
+	}
+	return info
+}
+
+func (r *pageInfoResolver) HasNextPage() bool  { return r.hasNextPage }
+func (r *pageInfoResolver) EndCursor() *string { return r.endCursor }
+
+// resolver is the root resolver of the queries and the mutations
+type resolver struct{}
+
+// Me resolves the current user
+func (r *resolver) Me(ctx context.Context) (*userResolver, error) {
+	client, _ := clientFromContext(ctx)
+	user, err := client.CurrentUser()
+	if err != nil {
+		log.WithError(err).Error("Me: could not get current user")
+		return nil, err
+	}
+	return &userResolver{models.ReduceUser(&user.User)}, nil
+}
+
+// User resolves the public profile of a user
+func (r *resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	_, l := clientFromContext(ctx)
+	user, err := l.users.load(string(args.ID))
+	if err != nil {
+		log.WithField("userID", args.ID).WithError(err).Error("User: could not get user public profile")
+		return nil, err
+	}
+	return &userResolver{models.ReduceUser(user.(*spotify.User))}, nil
+}
+
+// Player resolves the current player, nil when nothing is playing
+func (r *resolver) Player(ctx context.Context) (*playerResolver, error) {
+	client, _ := clientFromContext(ctx)
+	player, err := client.PlayerCurrentlyPlaying()
+	if err != nil {
+		log.WithError(err).Error("Player: could not get player currently playing")
+		return nil, err
+	}
+	if player.Item == nil {
+		return nil, nil
+	}
+	return &playerResolver{models.ReducePlayer(player)}, nil
+}
+
+// Playlists resolves a page of the playlists of the current user
+func (r *resolver) Playlists(ctx context.Context, args pageArgs) (*playlistConnectionResolver, error) {
+	offset, limit, err := args.bounds(50)
+	if err != nil {
+		return nil, err
+	}
+	client, _ := clientFromContext(ctx)
+	page, err := client.CurrentUsersPlaylistsOpt(&spotify.Options{Offset: &offset, Limit: &limit})
+	if err != nil {
+		log.WithError(err).Error("Playlists: could not get user playlists")
+		return nil, err
+	}
+
+	conn := &playlistConnectionResolver{
+		totalCount: page.Total,
+		pageInfo:   newPageInfo(offset, len(page.Playlists), page.Total),
+	}
+	for i, p := range page.Playlists {
+		conn.edges = append(conn.edges, &playlistEdgeResolver{
+			cursor: encodeCursor(offset + i),
+			node:   &playlistResolver{item: models.ReducePlaylistItem(p), ownerID: p.Owner.ID},
+		})
+	}
+	return conn, nil
+}
+
+// Playlist resolves a playlist by its ID
+func (r *resolver) Playlist(ctx context.Context, args struct{ ID graphql.ID }) (*playlistResolver, error) {
+	_, l := clientFromContext(ctx)
+	value, err := l.playlists.load(string(args.ID))
+	if err != nil {
+		log.WithField("playlistID", args.ID).WithError(err).Error("Playlist: could not get playlist")
+		return nil, err
+	}
+	playlist := value.(*spotify.FullPlaylist)
+	return &playlistResolver{item: models.ReducePlaylistItem(playlist.SimplePlaylist), ownerID: playlist.Owner.ID}, nil
+}
+
+// Play plays the current music or the given track or context
+func (r *resolver) Play(ctx context.Context, args struct{ URI *string }) (bool, error) {
+	client, _ := clientFromContext(ctx)
+	var uri spotify.URI
+	if args.URI != nil {
+		uri = spotify.URI(*args.URI)
+	}
+	if err := client.PlayOpt(models.PlayOptions(uri)); err != nil {
+		log.WithError(err).Error("Play: could not play music")
+		return false, err
+	}
+	return true, nil
+}
+
+// Pause pauses the music
+func (r *resolver) Pause(ctx context.Context) (bool, error) {
+	client, _ := clientFromContext(ctx)
+	if err := client.Pause(); err != nil {
+		log.WithError(err).Error("Pause: could not pause music")
+		return false, err
+	}
+	return true, nil
+}
+
+// Next goes to the next music
+func (r *resolver) Next(ctx context.Context) (bool, error) {
+	client, _ := clientFromContext(ctx)
+	if err := client.Next(); err != nil {
+		log.WithError(err).Error("Next: could not play next music")
+		return false, err
+	}
+	return true, nil
+}
+
+// Previous goes to the previous music
+func (r *resolver) Previous(ctx context.Context) (bool, error) {
+	client, _ := clientFromContext(ctx)
+	if err := client.Previous(); err != nil {
+		log.WithError(err).Error("Previous: could not play previous music")
+		return false, err
+	}
+	return true, nil
+}
+
+// userResolver resolves the User type
+type userResolver struct {
+	user models.User
+}
+
+func (r *userResolver) ID() graphql.ID { return graphql.ID(r.user.ID) }
+func (r *userResolver) Name() string   { return r.user.Name }
+func (r *userResolver) Image() string  { return r.user.Image }
+
+// playerResolver resolves the Player type
+type playerResolver struct {
+	player models.Player
+}
+
+func (r *playerResolver) ID() graphql.ID        { return graphql.ID(r.player.ID) }
+func (r *playerResolver) IsPlaying() bool       { return r.player.IsPlaying }
+func (r *playerResolver) AlbumName() string     { return r.player.AlbumName }
+func (r *playerResolver) ArtistsName() []string { return append([]string{}, r.player.ArtistsName...) }
+func (r *playerResolver) MusicName() string     { return r.player.MusicName }
+func (r *playerResolver) ReleaseDate() string   { return r.player.ReleaseDate.Format(time.RFC3339) }
+func (r *playerResolver) Progress() int32       { return int32(r.player.Progress) }
+func (r *playerResolver) Duration() int32       { return int32(r.player.Duration) }
+
+// playlistResolver resolves the Playlist type
+type playlistResolver struct {
+	item    models.PlaylistItem
+	ownerID string
+}
+
+func (r *playlistResolver) ID() graphql.ID    { return graphql.ID(r.item.ID) }
+func (r *playlistResolver) Name() string      { return r.item.Name }
+func (r *playlistResolver) Image() string     { return r.item.Image }
+func (r *playlistResolver) URI() string       { return string(r.item.URI) }
+func (r *playlistResolver) OwnerName() string { return r.item.OwnerName }
+
+// Owner resolves the owner of the playlist, the owners shared by many playlists are only fetched once
+func (r *playlistResolver) Owner(ctx context.Context) (*userResolver, error) {
+	if r.ownerID == "" {
+		return nil, nil
+	}
+	return (&resolver{}).User(ctx, struct{ ID graphql.ID }{graphql.ID(r.ownerID)})
+}
+
+// Tracks resolves a page of the tracks of the playlist
+func (r *playlistResolver) Tracks(ctx context.Context, args pageArgs) (*trackConnectionResolver, error) {
+	offset, limit, err := args.bounds(100)
+	if err != nil {
+		return nil, err
+	}
+	_, l := clientFromContext(ctx)
+	value, err := l.tracks.load(tracksKey(r.item.ID, offset, limit))
+	if err != nil {
+		log.WithField("playlistID", r.item.ID).WithError(err).Error("Tracks: could not get playlist tracks")
+		return nil, err
+	}
+	page := value.(*spotify.PlaylistTrackPage)
+
+	conn := &trackConnectionResolver{
+		totalCount: page.Total,
+		pageInfo:   newPageInfo(offset, len(page.Tracks), page.Total),
+	}
+	for i, t := range page.Tracks {
+		conn.edges = append(conn.edges, &trackEdgeResolver{cursor: encodeCursor(offset + i), node: &trackResolver{t}})
+	}
+	return conn, nil
+}
+
+// trackResolver resolves the Track type
+type trackResolver struct {
+	track spotify.PlaylistTrack
+}
+
+func (r *trackResolver) ID() graphql.ID    { return graphql.ID(r.track.Track.ID) }
+func (r *trackResolver) Name() string      { return r.track.Track.Name }
+func (r *trackResolver) URI() string       { return string(r.track.Track.URI) }
+func (r *trackResolver) Duration() int32   { return int32(r.track.Track.Duration) }
+func (r *trackResolver) AlbumName() string { return r.track.Track.Album.Name }
+func (r *trackResolver) AddedAt() string   { return r.track.AddedAt }
+
+// ArtistsName resolves the names of the artists of the track
+func (r *trackResolver) ArtistsName() []string {
+	artists := []string{}
+	for _, a := range r.track.Track.Artists {
+		artists = append(artists, a.Name)
+	}
+	return artists
+}
+
+// playlistConnectionResolver resolves the PlaylistConnection type
+type playlistConnectionResolver struct {
+	totalCount int
+	edges      []*playlistEdgeResolver
+	pageInfo   *pageInfoResolver
+}
+
+func (r *playlistConnectionResolver) TotalCount() int32              { return int32(r.totalCount) }
+func (r *playlistConnectionResolver) Edges() []*playlistEdgeResolver { return r.edges }
+func (r *playlistConnectionResolver) PageInfo() *pageInfoResolver    { return r.pageInfo }
+
+// playlistEdgeResolver resolves the PlaylistEdge type
+type playlistEdgeResolver struct {
+	cursor string
+	node   *playlistResolver
+}
+
+func (r *playlistEdgeResolver) Cursor() string          { return r.cursor }
+func (r *playlistEdgeResolver) Node() *playlistResolver { return r.node }
+
+// trackConnectionResolver resolves the TrackConnection type
+type trackConnectionResolver struct {
+	totalCount int
+	edges      []*trackEdgeResolver
+	pageInfo   *pageInfoResolver
+}
+
+func (r *trackConnectionResolver) TotalCount() int32           { return int32(r.totalCount) }
+func (r *trackConnectionResolver) Edges() []*trackEdgeResolver { return r.edges }
+func (r *trackConnectionResolver) PageInfo() *pageInfoResolver { return r.pageInfo }
+
+// trackEdgeResolver resolves the TrackEdge type
+type trackEdgeResolver struct {
+	cursor string
+	node   *trackResolver
+}
+
+func (r *trackEdgeResolver) Cursor() string       { return r.cursor }
+func (r *trackEdgeResolver) Node() *trackResolver { return r.node }
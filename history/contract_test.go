@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"common/models"
+	"common/openapi"
+	"github.com/zmb3/spotify"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	st := newTestStore(t)
+	st.add("thomas", []recordedPlay{play("sunrise", 0, "The Early Birds"), play("lullaby", 10)})
+	st.saveGenres(map[spotify.ID][]string{"the-early-birds": {"indie", "folk"}})
+	st.add("thomas", []recordedPlay{{Play: models.Play{Name: "Commute", ID: "commute", URI: "spotify:track:commute", PlayedAt: at(20)}}})
+	client := &mockSpotifyClient{played: []spotify.RecentlyPlayedItem{recentlyPlayed("coffee", 3)}}
+	rec := newRecorder(st, func(token string) spotifyClient { return client })
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		handler http.HandlerFunc
+	}{
+		{name: "should document the plays", method: "GET", target: "/history?limit=10", handler: historyHandler(st)},
+		{name: "should document the filtered plays", method: "GET", target: "/history?from=2021-01-02T08:00:00Z&to=2021-01-02T09:00:00Z&artist=Dusk", handler: historyHandler(st)},
+		{name: "should document the registration", method: "POST", target: "/history/register", handler: registerHandler(rec)},
+		{name: "should document the unregistration", method: "DELETE", target: "/history/register", handler: unregisterHandler(rec)},
+		{name: "should document the ranking of the tracks", method: "GET", target: "/stats/top?type=tracks", handler: topHandler(st)},
+		{name: "should document the ranking of the genres", method: "GET", target: "/stats/top?type=genres&limit=5", handler: topHandler(st)},
+		{name: "should document the listening minutes", method: "GET", target: "/stats/minutes?from=2021-01-01T00:00:00Z&tz=Europe/Paris", handler: minutesHandler(st)},
+		{name: "should document the streaks", method: "GET", target: "/stats/streaks", handler: streaksHandler(st)},
+		{name: "should document the discovery", method: "GET", target: "/stats/discovery?to=2022-01-01T00:00:00Z", handler: discoveryHandler(st)},
+		{name: "should document the report", method: "GET", target: "/stats/report?year=2021", handler: reportHandler(st)},
+		{name: "should document the report of a year without plays", method: "GET", target: "/stats/report?year=2020&format=json", handler: reportHandler(st)},
+		{name: "should document the page of the report", method: "GET", target: "/stats/report?year=2021&format=html", handler: reportHandler(st)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := historyRequest(tt.method, tt.target, "thomas", client)
+			rr := httptest.NewRecorder()
+			spec.Middleware(tt.handler).ServeHTTP(rr, r)
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if rr.Header().Get("Content-Type") == "text/html; charset=utf-8" {
+				// only the json bodies are validated
+				return
+			}
+			if err := spec.ValidateResponse(tt.method, r.URL.Path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_invalidQuery(t *testing.T) {
+	spec := openapi.MustLoad()
+	accepted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, target := range []string{
+		"/history?from=yesterday", "/history?limit=100", "/history?offset=-1", "/history?artist=",
+		"/stats/top", "/stats/top?type=podcasts", "/stats/top?type=tracks&limit=0", "/stats/minutes?from=yesterday",
+		"/stats/report", "/stats/report?year=2021&format=pdf",
+	} {
+		rr := httptest.NewRecorder()
+		spec.Middleware(accepted).ServeHTTP(rr, historyRequest("GET", target, "thomas", &mockSpotifyClient{}))
+		if res := rr.Code; res != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", target, res, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"common/config"
+	"common/eventbus"
+	"common/health"
+	"common/identity"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// Key type of spotify client context
+type key int
+
+// CLIENT_CONTEXT is the key used for the spotify client context
+var CLIENT_CONTEXT = key(1)
+
+const (
+	// defaultLimit is the size of a page of the history when none is asked
+	defaultLimit = 20
+	// maxLimit is the largest page of the history
+	maxLimit = 50
+)
+
+// spotifyClient interface of spotify client
+type spotifyClient interface {
+	CurrentUser() (*spotify.PrivateUser, error)
+	PlayerRecentlyPlayedOpt(opt *spotify.RecentlyPlayedOptions) ([]spotify.RecentlyPlayedItem, error)
+	GetTracks(ids ...spotify.ID) ([]*spotify.FullTrack, error)
+	GetArtists(ids ...spotify.ID) ([]*spotify.FullArtist, error)
+}
+
+// historyQueryFromRequest reads the from, to, artist, limit and offset query parameters
+// from and to are RFC 3339 times, from is included and to is excluded
+func historyQueryFromRequest(r *http.Request) (historyQuery, error) {
+	query := r.URL.Query()
+	q := historyQuery{artist: query.Get("artist"), limit: defaultLimit}
+	for name, field := range map[string]*time.Time{"from": &q.from, "to": &q.to} {
+		if raw := query.Get(name); raw != "" {
+			value, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return historyQuery{}, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*field = value
+		}
+	}
+	if !q.from.IsZero() && !q.to.IsZero() && !q.from.Before(q.to) {
+		return historyQuery{}, errors.New("from must be before to")
+	}
+	for name, field := range map[string]*int{"limit": &q.limit, "offset": &q.offset} {
+		if raw := query.Get(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				return historyQuery{}, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*field = value
+		}
+	}
+	if q.limit < 1 || q.limit > maxLimit {
+		return historyQuery{}, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return q, nil
+}
+
+// historyHandler is the handler to get a page of the recorded plays of the user
+func historyHandler(st *store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := historyQueryFromRequest(r)
+		if err != nil {
+			log.WithError(err).Error("historyHandler: could not read query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("historyHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		history, err := st.list(id, q)
+		if err != nil {
+			log.WithError(err).Error("historyHandler: could not list plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// registerHandler is the handler registering the user to the recorder with its access token
+// The recently played tracks are recorded right away, then every interval of the recorder
+func registerHandler(rec *recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("registerHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		token := r.Header.Get("Authorization")
+		if _, err := rec.recordUser(id, token); err != nil {
+			log.WithError(err).Error("registerHandler: could not record plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := rec.register(id, token); err != nil {
+			log.WithError(err).Error("registerHandler: could not save registration")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// unregisterHandler is the handler to stop recording the plays of the user, the recorded ones are kept
+func unregisterHandler(rec *recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("unregisterHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := rec.unregister(id); err != nil {
+			log.WithError(err).Error("unregisterHandler: could not delete registration")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// topHandler is the handler ranking the tracks, artists, albums or genres played by the user over a window
+func topHandler(st *store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := statsQueryFromRequest(r)
+		if err == nil && q.topType == "" {
+			err = errors.New("missing type")
+		}
+		if err != nil {
+			log.WithError(err).Error("topHandler: could not read query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("topHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		plays, err := st.statPlays(id, q.from, q.to)
+		if err != nil {
+			log.WithError(err).Error("topHandler: could not list plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		genres, err := st.genres(id)
+		if err != nil {
+			log.WithError(err).Error("topHandler: could not get genres")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(top(plays, genres, q.topType, q.limit))
+	}
+}
+
+// minutesHandler is the handler of the listening minutes of the user per day and per hour of the week over a window
+func minutesHandler(st *store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := statsQueryFromRequest(r)
+		if err != nil {
+			log.WithError(err).Error("minutesHandler: could not read query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("minutesHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		plays, err := st.statPlays(id, q.from, q.to)
+		if err != nil {
+			log.WithError(err).Error("minutesHandler: could not list plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(listeningMinutes(plays, q.loc))
+	}
+}
+
+// streaksHandler is the handler of the current and the longest runs of listening days of the user
+func streaksHandler(st *store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := statsQueryFromRequest(r)
+		if err != nil {
+			log.WithError(err).Error("streaksHandler: could not read query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("streaksHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		times, err := st.playTimes(id)
+		if err != nil {
+			log.WithError(err).Error("streaksHandler: could not list plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(streaks(times, q.loc, time.Now()))
+	}
+}
+
+// discoveryHandler is the handler of the rate of new artists played by the user per month over a window
+func discoveryHandler(st *store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := statsQueryFromRequest(r)
+		if err != nil {
+			log.WithError(err).Error("discoveryHandler: could not read query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("discoveryHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		plays, err := st.statPlays(id, q.from, q.to)
+		if err != nil {
+			log.WithError(err).Error("discoveryHandler: could not list plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		first, err := st.firstPlayed(id)
+		if err != nil {
+			log.WithError(err).Error("discoveryHandler: could not get first plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(discovery(plays, first, q.loc, q.from))
+	}
+}
+
+// reportHandler is the handler of the summary of a year of listening of the user
+// The report is answered as JSON, or as a self-contained HTML page with format=html
+func reportHandler(st *store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := statsQueryFromRequest(r)
+		if err != nil {
+			log.WithError(err).Error("reportHandler: could not read query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		year, err := strconv.Atoi(r.URL.Query().Get("year"))
+		if err != nil || year < 1 || year > 9999 {
+			log.WithField("year", r.URL.Query().Get("year")).Error("reportHandler: invalid year")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "html" {
+			log.WithField("format", format).Error("reportHandler: invalid format")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("reportHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		plays, err := st.statPlays(id, time.Date(year, 1, 1, 0, 0, 0, 0, q.loc), time.Date(year+1, 1, 1, 0, 0, 0, 0, q.loc))
+		if err != nil {
+			log.WithError(err).Error("reportHandler: could not list plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		genres, err := st.genres(id)
+		if err != nil {
+			log.WithError(err).Error("reportHandler: could not get genres")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		first, err := st.firstPlayed(id)
+		if err != nil {
+			log.WithError(err).Error("reportHandler: could not get first plays")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		summary := report(year, plays, genres, first, q.loc)
+		if format != "html" {
+			json.NewEncoder(w).Encode(summary)
+			return
+		}
+		var page bytes.Buffer
+		if err := renderReport(&page, summary); err != nil {
+			log.WithError(err).Error("reportHandler: could not render report")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page.WriteTo(w)
+	}
+}
+
+// tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := r.Header.Get("Authorization")
+		client := factory.Client(bearer)
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config, st *store) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	checker.Add("database", func(ctx context.Context) error { return st.ping() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("history", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+
+	st, err := openStore(cfg.History.DBPath)
+	if err != nil {
+		log.WithError(err).Fatal("could not open history database")
+	}
+	defer st.close()
+	checker := newHealthChecker(cfg, st)
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+	rec := newRecorder(st, func(token string) spotifyClient { return factory.Client(token) })
+	if err := rec.restore(); err != nil {
+		log.WithError(err).Fatal("could not restore the registrations")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go rec.run(ctx, cfg.History.RecordInterval)
+
+	bus, err := eventbus.Open(cfg.EventBus.URL)
+	if err != nil {
+		log.WithError(err).Fatal("could not open event bus")
+	}
+	defer bus.Close()
+	if _, err := eventbus.OnTrackChanged(bus, rec.trackChanged); err != nil {
+		log.WithError(err).Fatal("could not subscribe to track changes")
+	}
+	if _, err := eventbus.OnTokenValidated(bus, rec.refresh); err != nil {
+		log.WithError(err).Fatal("could not subscribe to validated tokens")
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.HandleFunc("/history", historyHandler(st)).Methods("GET")
+	r.HandleFunc("/history/register", registerHandler(rec)).Methods("POST")
+	r.HandleFunc("/history/register", unregisterHandler(rec)).Methods("DELETE")
+	r.HandleFunc("/stats/top", topHandler(st)).Methods("GET")
+	r.HandleFunc("/stats/minutes", minutesHandler(st)).Methods("GET")
+	r.HandleFunc("/stats/streaks", streaksHandler(st)).Methods("GET")
+	r.HandleFunc("/stats/discovery", discoveryHandler(st)).Methods("GET")
+	r.HandleFunc("/stats/report", reportHandler(st)).Methods("GET")
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.Run(contextedMux, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
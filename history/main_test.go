@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"common/identity"
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+// historyRequest creates a request of the user sent with the spotify client, the user is asked to spotify when empty
+func historyRequest(method, target, userID string, client spotifyClient) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "token")
+	if userID != "" {
+		r.Header.Set(identity.Header, userID)
+	}
+	return r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+}
+
+func Test_historyQueryFromRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		want      historyQuery
+		expectErr string
+	}{
+		{name: "should use the default page", target: "/history", want: historyQuery{limit: defaultLimit}},
+		{
+			name:   "should read the filters and the page",
+			target: "/history?from=2021-01-02T08:00:00Z&to=2021-01-02T10:00:00%2B01:00&artist=Dusk&limit=5&offset=10",
+			want:   historyQuery{from: at(0), to: at(60), artist: "Dusk", limit: 5, offset: 10},
+		},
+		{name: "should reject an invalid time", target: "/history?from=yesterday", expectErr: `invalid from "yesterday"`},
+		{name: "should reject an empty range", target: "/history?from=2021-01-02T08:00:00Z&to=2021-01-02T08:00:00Z", expectErr: "from must be before to"},
+		{name: "should reject a too large page", target: "/history?limit=51", expectErr: "limit must be between 1 and 50"},
+		{name: "should reject an invalid offset", target: "/history?offset=-1", expectErr: `invalid offset "-1"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := historyQueryFromRequest(httptest.NewRequest("GET", tt.target, nil))
+			if tt.expectErr != "" {
+				if err == nil || err.Error() != tt.expectErr {
+					t.Errorf("historyQueryFromRequest() error = %v, want %v", err, tt.expectErr)
+				}
+				return
+			}
+			if err != nil || !got.from.Equal(tt.want.from) || !got.to.Equal(tt.want.to) || got.artist != tt.want.artist || got.limit != tt.want.limit || got.offset != tt.want.offset {
+				t.Errorf("historyQueryFromRequest() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_historyHandler(t *testing.T) {
+	st := newTestStore(t)
+	st.add("thomas", []recordedPlay{play("sunrise", 0, "The Early Birds"), play("sunset", 10, "Dusk")})
+	tests := []struct {
+		name         string
+		target       string
+		userID       string
+		client       *mockSpotifyClient
+		expectedCode int
+		expectedIDs  []spotify.ID
+	}{
+		{name: "should list the plays of the user sent by the gateway", target: "/history", userID: "thomas", client: &mockSpotifyClient{}, expectedCode: http.StatusOK, expectedIDs: []spotify.ID{"sunset", "sunrise"}},
+		{name: "should ask spotify for the user", target: "/history?artist=dusk", client: &mockSpotifyClient{user: spotify.PrivateUser{User: spotify.User{ID: "thomas"}}}, expectedCode: http.StatusOK, expectedIDs: []spotify.ID{"sunset"}},
+		{name: "should list no plays of a new user", target: "/history", userID: "alice", client: &mockSpotifyClient{}, expectedCode: http.StatusOK, expectedIDs: []spotify.ID{}},
+		{name: "should reject an invalid query", target: "/history?limit=0", userID: "thomas", client: &mockSpotifyClient{}, expectedCode: http.StatusBadRequest},
+		{name: "should fail when the user is unknown", target: "/history", client: &mockSpotifyClient{err: errors.New("boom")}, expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			historyHandler(st)(rr, historyRequest("GET", tt.target, tt.userID, tt.client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var got models.History
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || !reflect.DeepEqual(ids(got.Items), tt.expectedIDs) {
+				t.Errorf("handler returned unexpected body: got %+v want %v", got, tt.expectedIDs)
+			}
+		})
+	}
+}
+
+func Test_registerHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		client             *mockSpotifyClient
+		expectedCode       int
+		expectedRegistered bool
+		expectedTotal      int
+	}{
+		{name: "should register the user and record its plays", client: &mockSpotifyClient{played: []spotify.RecentlyPlayedItem{recentlyPlayed("sunrise", 0)}}, expectedCode: http.StatusOK, expectedRegistered: true, expectedTotal: 1},
+		{name: "should not register the user when spotify fails", client: &mockSpotifyClient{err: errors.New("boom")}, expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			rec := newRecorder(st, func(token string) spotifyClient { return tt.client })
+			rr := httptest.NewRecorder()
+			registerHandler(rec)(rr, historyRequest("POST", "/history/register", "thomas", tt.client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if token, ok := rec.users()["thomas"]; ok != tt.expectedRegistered || ok && token != "token" {
+				t.Errorf("handler registered %v with token %q, want %v", ok, token, tt.expectedRegistered)
+			}
+			if history, _ := st.list("thomas", historyQuery{limit: 10}); history.Total != tt.expectedTotal {
+				t.Errorf("handler recorded %d plays, want %d", history.Total, tt.expectedTotal)
+			}
+		})
+	}
+}
+
+func Test_unregisterHandler(t *testing.T) {
+	client := &mockSpotifyClient{}
+	rec := newRecorder(newTestStore(t), func(token string) spotifyClient { return client })
+	rec.register("thomas", "token")
+	rr := httptest.NewRecorder()
+	unregisterHandler(rec)(rr, historyRequest("DELETE", "/history/register", "thomas", client))
+	if res := rr.Code; res != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+	}
+	if _, ok := rec.users()["thomas"]; ok {
+		t.Errorf("handler did not unregister the user")
+	}
+}
+
+func Test_statsHandlers(t *testing.T) {
+	st := newTestStore(t)
+	st.add("thomas", []recordedPlay{play("sunrise", 0, "The Early Birds"), play("coffee", 3, "The Early Birds", "Dusk"), play("sunrise", 70, "The Early Birds")})
+	st.saveGenres(map[spotify.ID][]string{"dusk": {"ambient"}})
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		target       string
+		client       *mockSpotifyClient
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "should rank the items", handler: topHandler(st), target: "/stats/top?type=tracks&limit=1", expectedCode: http.StatusOK,
+			expectedBody: `{"type":"tracks","items":[{"name":"sunrise","ID":"sunrise","artists_name":["The Early Birds"],"plays":2,"minutes":6}]}`},
+		{name: "should rank the genres", handler: topHandler(st), target: "/stats/top?type=genres", expectedCode: http.StatusOK,
+			expectedBody: `{"type":"genres","items":[{"name":"ambient","plays":1,"minutes":3}]}`},
+		{name: "should rank over the window", handler: topHandler(st), target: "/stats/top?type=artists&from=2021-01-02T09:00:00Z", expectedCode: http.StatusOK,
+			expectedBody: `{"type":"artists","items":[{"name":"The Early Birds","ID":"the-early-birds","plays":1,"minutes":3}]}`},
+		{name: "should require the type of the ranking", handler: topHandler(st), target: "/stats/top", expectedCode: http.StatusBadRequest},
+		{name: "should answer the streaks", handler: streaksHandler(st), target: "/stats/streaks?tz=Europe/Paris", expectedCode: http.StatusOK,
+			expectedBody: `{"current":{"days":0,"from":"","to":""},"longest":{"days":1,"from":"2021-01-02","to":"2021-01-02"}}`},
+		{name: "should answer the discovery", handler: discoveryHandler(st), target: "/stats/discovery?from=2021-01-02T08:01:00Z", expectedCode: http.StatusOK,
+			expectedBody: `{"months":[{"month":"2021-01","artists":2,"new_artists":2,"rate":1}],"artists":2,"new_artists":1,"rate":0.5}`},
+		{name: "should reject an unknown time zone", handler: minutesHandler(st), target: "/stats/minutes?tz=Mars/Olympus", expectedCode: http.StatusBadRequest},
+		{name: "should require the year of the report", handler: reportHandler(st), target: "/stats/report", expectedCode: http.StatusBadRequest},
+		{name: "should reject an unknown format", handler: reportHandler(st), target: "/stats/report?year=2021&format=pdf", expectedCode: http.StatusBadRequest},
+		{name: "should fail when the user is unknown", handler: minutesHandler(st), target: "/stats/minutes", client: &mockSpotifyClient{err: errors.New("boom")}, expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := "thomas"
+			if tt.client == nil {
+				tt.client = &mockSpotifyClient{}
+			} else {
+				userID = ""
+			}
+			rr := httptest.NewRecorder()
+			tt.handler(rr, historyRequest("GET", tt.target, userID, tt.client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if got := strings.TrimSpace(rr.Body.String()); tt.expectedBody != "" && got != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", got, tt.expectedBody)
+			}
+		})
+	}
+}
+
+func Test_reportHandler(t *testing.T) {
+	st := newTestStore(t)
+	st.add("thomas", []recordedPlay{play("sunrise", 0, "The Early Birds"), on(play("lullaby", 0, "Dusk"), date(12, 31, 23))})
+	tests := []struct {
+		name          string
+		target        string
+		expectedHTML  bool
+		expectedPlays int
+	}{
+		{name: "should answer the report as json", target: "/stats/report?year=2021", expectedPlays: 2},
+		{name: "should count the year in the time zone", target: "/stats/report?year=2021&tz=Europe/Paris&format=json", expectedPlays: 1},
+		{name: "should answer the report as a page", target: "/stats/report?year=2021&format=html", expectedHTML: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			reportHandler(st)(rr, historyRequest("GET", tt.target, "thomas", &mockSpotifyClient{}))
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if tt.expectedHTML {
+				if got := rr.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+					t.Errorf("handler returned wrong content type: got %q", got)
+				}
+				if !strings.Contains(rr.Body.String(), "Your 2021 in music") {
+					t.Errorf("handler returned unexpected page: %s", rr.Body.String())
+				}
+				return
+			}
+			var got models.Report
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.Year != 2021 || got.Plays != tt.expectedPlays {
+				t.Errorf("handler returned unexpected body: got %+v want %d plays", got, tt.expectedPlays)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"common/eventbus"
+	"common/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// maxRecentlyPlayed is the number of recently played tracks spotify answers at most
+	maxRecentlyPlayed = 50
+	// maxArtists is the number of artists spotify looks up at once
+	maxArtists = 50
+)
+
+// recorder pulls the recently played tracks of the registered users into the store
+// Spotify only keeps the last 50 plays so they are pulled often enough to not miss any
+// The registrations are kept in the store along with the last access token of each user so they survive a restart
+// The access tokens of spotify expire after an hour, the gateway publishes the new ones of the users on the bus
+type recorder struct {
+	store     *store
+	newClient func(token string) spotifyClient
+
+	mu sync.Mutex
+	// tokens are the access tokens of the registered users, empty while waiting for a new one
+	tokens map[string]string
+}
+
+// newRecorder creates a recorder without registered users
+func newRecorder(store *store, newClient func(token string) spotifyClient) *recorder {
+	return &recorder{store: store, newClient: newClient, tokens: map[string]string{}}
+}
+
+// restore registers again the users kept in the store, e.g. after a restart
+func (rec *recorder) restore() error {
+	registrations, err := rec.store.registrations()
+	if err != nil {
+		return err
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for userID, token := range registrations {
+		rec.tokens[userID] = token
+	}
+	return nil
+}
+
+// register records the plays of the user with the access token, a registered user gets its token updated
+func (rec *recorder) register(userID, token string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.store.saveRegistration(userID, token); err != nil {
+		return err
+	}
+	rec.tokens[userID] = token
+	return nil
+}
+
+// unregister stops recording the plays of the user
+func (rec *recorder) unregister(userID string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.store.deleteRegistration(userID); err != nil {
+		return err
+	}
+	delete(rec.tokens, userID)
+	return nil
+}
+
+// refresh gives its new access token to the registered user, the tokens of the other users are ignored
+func (rec *recorder) refresh(validated eventbus.TokenValidated) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	current, ok := rec.tokens[validated.UserID]
+	if !ok || current == validated.Token {
+		return
+	}
+	if err := rec.store.saveRegistration(validated.UserID, validated.Token); err != nil {
+		log.WithError(err).WithField("user", validated.UserID).Error("recorder: could not save the new access token")
+		return
+	}
+	rec.tokens[validated.UserID] = validated.Token
+}
+
+// expire drops the access token rejected by spotify, the user stays registered until the gateway validates a new one
+// A token refreshed in the meantime is kept
+func (rec *recorder) expire(userID, token string) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if current, ok := rec.tokens[userID]; !ok || current != token {
+		return nil
+	}
+	if err := rec.store.saveRegistration(userID, ""); err != nil {
+		return err
+	}
+	rec.tokens[userID] = ""
+	return nil
+}
+
+// users returns the registered users with a valid access token along with it
+func (rec *recorder) users() map[string]string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	users := make(map[string]string, len(rec.tokens))
+	for userID, token := range rec.tokens {
+		if token != "" {
+			users[userID] = token
+		}
+	}
+	return users
+}
+
+// recordUser pulls the plays of the user made after the last one recorded and returns how many were added
+// The albums of the tracks and the genres of their artists are looked up along the way
+func (rec *recorder) recordUser(userID, token string) (int, error) {
+	last, err := rec.store.lastPlayed(userID)
+	if err != nil {
+		return 0, err
+	}
+	opt := &spotify.RecentlyPlayedOptions{Limit: maxRecentlyPlayed}
+	if !last.IsZero() {
+		opt.AfterEpochMs = toMillis(last)
+	}
+	client := rec.newClient(token)
+	items, err := client.PlayerRecentlyPlayedOpt(opt)
+	if err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	plays, err := lookUpAlbums(client, items)
+	if err != nil {
+		return 0, err
+	}
+	if err := rec.lookUpGenres(client, plays); err != nil {
+		return 0, err
+	}
+	return rec.store.add(userID, plays)
+}
+
+// lookUpAlbums returns the plays of the recently played tracks with their album
+// Spotify does not give the album of the recently played tracks, the tracks are looked up at once
+func lookUpAlbums(client spotifyClient, items []spotify.RecentlyPlayedItem) ([]recordedPlay, error) {
+	var trackIDs []spotify.ID
+	seen := map[spotify.ID]bool{}
+	for _, item := range items {
+		if id := item.Track.ID; id != "" && !seen[id] {
+			seen[id] = true
+			trackIDs = append(trackIDs, id)
+		}
+	}
+	albums := map[spotify.ID]spotify.SimpleAlbum{}
+	if len(trackIDs) > 0 {
+		tracks, err := client.GetTracks(trackIDs...)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tracks {
+			if t != nil {
+				albums[t.ID] = t.Album
+			}
+		}
+	}
+
+	plays := make([]recordedPlay, 0, len(items))
+	for _, item := range items {
+		p := recordedPlay{Play: models.ReducePlay(item)}
+		for _, a := range item.Track.Artists {
+			p.ArtistIDs = append(p.ArtistIDs, a.ID)
+		}
+		if album, ok := albums[item.Track.ID]; ok {
+			p.AlbumID, p.AlbumName = album.ID, album.Name
+		}
+		plays = append(plays, p)
+	}
+	return plays, nil
+}
+
+// lookUpGenres keeps the genres of the artists of the plays not looked up yet
+func (rec *recorder) lookUpGenres(client spotifyClient, plays []recordedPlay) error {
+	var artistIDs []spotify.ID
+	seen := map[spotify.ID]bool{}
+	for _, p := range plays {
+		for _, id := range p.ArtistIDs {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				artistIDs = append(artistIDs, id)
+			}
+		}
+	}
+	unknown, err := rec.store.unknownArtists(artistIDs)
+	if err != nil {
+		return err
+	}
+	genres := map[spotify.ID][]string{}
+	for start := 0; start < len(unknown); start += maxArtists {
+		end := start + maxArtists
+		if end > len(unknown) {
+			end = len(unknown)
+		}
+		artists, err := client.GetArtists(unknown[start:end]...)
+		if err != nil {
+			return err
+		}
+		for _, a := range artists {
+			if a != nil {
+				genres[a.ID] = a.Genres
+			}
+		}
+	}
+	return rec.store.saveGenres(genres)
+}
+
+// record pulls the plays of every registered user
+// The users whose access token is rejected are skipped until the gateway validates a new one
+func (rec *recorder) record() {
+	for userID, token := range rec.users() {
+		rec.recordAndLog(userID, token)
+	}
+}
+
+// recordAndLog records the plays of the user and logs the outcome, the access token rejected by spotify is dropped
+func (rec *recorder) recordAndLog(userID, token string) {
+	added, err := rec.recordUser(userID, token)
+	var serr spotify.Error
+	if errors.As(err, &serr) && serr.Status == http.StatusUnauthorized {
+		log.WithField("user", userID).Warn("recorder: access token rejected, waiting for a new one")
+		if err := rec.expire(userID, token); err != nil {
+			log.WithError(err).WithField("user", userID).Error("recorder: could not drop the access token")
+		}
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("user", userID).Error("recorder: could not record plays")
+		return
+	}
+	log.WithField("user", userID).WithField("added", added).Debug("recorder: plays recorded")
+}
+
+// trackChanged records the plays of the registered user right away, the previous track was just played
+// so the history does not wait for the next interval; the plays already recorded are ignored by the store
+func (rec *recorder) trackChanged(change eventbus.TrackChanged) {
+	rec.mu.Lock()
+	token := rec.tokens[change.UserID]
+	rec.mu.Unlock()
+	if token == "" {
+		return
+	}
+	rec.recordAndLog(change.UserID, token)
+}
+
+// run records the plays every interval until the context is done
+func (rec *recorder) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rec.record()
+		}
+	}
+}
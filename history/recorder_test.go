@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/eventbus"
+	"github.com/zmb3/spotify"
+)
+
+type mockSpotifyClient struct {
+	err     error
+	user    spotify.PrivateUser
+	played  []spotify.RecentlyPlayedItem
+	opt     *spotify.RecentlyPlayedOptions
+	calls   int
+	genres  map[spotify.ID][]string
+	artists [][]spotify.ID
+}
+
+func (c *mockSpotifyClient) CurrentUser() (*spotify.PrivateUser, error) {
+	return &c.user, c.err
+}
+
+func (c *mockSpotifyClient) PlayerRecentlyPlayedOpt(opt *spotify.RecentlyPlayedOptions) ([]spotify.RecentlyPlayedItem, error) {
+	c.calls++
+	c.opt = opt
+	var items []spotify.RecentlyPlayedItem
+	for _, item := range c.played {
+		if toMillis(item.PlayedAt) > opt.AfterEpochMs {
+			items = append(items, item)
+		}
+	}
+	return items, c.err
+}
+
+// GetTracks returns the tracks of the morning album, the unknown track is null
+func (c *mockSpotifyClient) GetTracks(ids ...spotify.ID) ([]*spotify.FullTrack, error) {
+	var tracks []*spotify.FullTrack
+	for _, id := range ids {
+		if id == "unknown" {
+			tracks = append(tracks, nil)
+			continue
+		}
+		tracks = append(tracks, &spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: id}, Album: spotify.SimpleAlbum{ID: "morning", Name: "Morning"}})
+	}
+	return tracks, c.err
+}
+
+// GetArtists returns the artists with their genres and records the IDs looked up
+func (c *mockSpotifyClient) GetArtists(ids ...spotify.ID) ([]*spotify.FullArtist, error) {
+	c.artists = append(c.artists, ids)
+	var artists []*spotify.FullArtist
+	for _, id := range ids {
+		artists = append(artists, &spotify.FullArtist{SimpleArtist: spotify.SimpleArtist{ID: id}, Genres: c.genres[id]})
+	}
+	return artists, c.err
+}
+
+// recentlyPlayed creates a spotify play of the track at the minute
+func recentlyPlayed(id string, minute int) spotify.RecentlyPlayedItem {
+	return spotify.RecentlyPlayedItem{
+		Track:    spotify.SimpleTrack{Name: id, ID: spotify.ID(id), URI: spotify.URI("spotify:track:" + id), Artists: []spotify.SimpleArtist{{Name: "The Early Birds", ID: "the-early-birds"}}},
+		PlayedAt: at(minute),
+	}
+}
+
+func Test_recorder_recordUser(t *testing.T) {
+	st := newTestStore(t)
+	client := &mockSpotifyClient{played: []spotify.RecentlyPlayedItem{recentlyPlayed("coffee", 3), recentlyPlayed("sunrise", 0)}}
+	rec := newRecorder(st, func(token string) spotifyClient { return client })
+
+	added, err := rec.recordUser("thomas", "token")
+	if err != nil || added != 2 {
+		t.Fatalf("recordUser() = %v, %v, want 2 plays added", added, err)
+	}
+	if client.opt.AfterEpochMs != 0 || client.opt.Limit != maxRecentlyPlayed {
+		t.Errorf("recordUser() without plays sent %+v, want every recent play", client.opt)
+	}
+
+	client.played = append([]spotify.RecentlyPlayedItem{recentlyPlayed("commute", 7)}, client.played...)
+	added, err = rec.recordUser("thomas", "token")
+	if err != nil || added != 1 {
+		t.Fatalf("recordUser() = %v, %v, want 1 play added", added, err)
+	}
+	if client.opt.AfterEpochMs != toMillis(at(3)) {
+		t.Errorf("recordUser() sent after %v, want the last recorded play %v", client.opt.AfterEpochMs, toMillis(at(3)))
+	}
+	if history, err := st.list("thomas", historyQuery{limit: 10}); err != nil || history.Total != 3 {
+		t.Errorf("list() after recording = %+v, %v", history, err)
+	}
+}
+
+func Test_recorder_recordUser_lookUp(t *testing.T) {
+	st := newTestStore(t)
+	unknown := recentlyPlayed("unknown", 5)
+	unknown.Track.Artists = []spotify.SimpleArtist{{Name: "Dusk", ID: "dusk"}, {Name: "The Early Birds", ID: "the-early-birds"}}
+	client := &mockSpotifyClient{
+		played: []spotify.RecentlyPlayedItem{unknown, recentlyPlayed("coffee", 3), recentlyPlayed("sunrise", 0)},
+		genres: map[spotify.ID][]string{"the-early-birds": {"indie", "folk"}},
+	}
+	rec := newRecorder(st, func(token string) spotifyClient { return client })
+	if _, err := rec.recordUser("thomas", "token"); err != nil {
+		t.Fatalf("recordUser() error = %v", err)
+	}
+
+	plays, err := st.statPlays("thomas", time.Time{}, time.Time{})
+	if err != nil || len(plays) != 3 {
+		t.Fatalf("statPlays() after recording = %+v, %v", plays, err)
+	}
+	for _, p := range plays {
+		if album := p.AlbumID; (p.ID == "unknown") != (album == "") {
+			t.Errorf("recordUser() recorded %s with album %q", p.ID, album)
+		}
+	}
+	if want := [][]spotify.ID{{"dusk", "the-early-birds"}}; !reflect.DeepEqual(client.artists, want) {
+		t.Errorf("recordUser() looked up the artists %v, want %v", client.artists, want)
+	}
+	if genres, err := st.genres("thomas"); err != nil || !reflect.DeepEqual(genres["the-early-birds"], []string{"indie", "folk"}) {
+		t.Errorf("genres() after recording = %v, %v", genres, err)
+	}
+
+	client.played = append([]spotify.RecentlyPlayedItem{recentlyPlayed("commute", 7)}, client.played...)
+	if _, err := rec.recordUser("thomas", "token"); err != nil {
+		t.Fatalf("recordUser() error = %v", err)
+	}
+	if len(client.artists) != 1 {
+		t.Errorf("recordUser() looked up the known artists again: %v", client.artists)
+	}
+}
+
+func Test_recorder_record(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		expectedToken string
+	}{
+		{name: "should keep recording the user", expectedToken: "new"},
+		{name: "should keep the user when spotify fails", err: errors.New("boom"), expectedToken: "new"},
+		{name: "should keep the user waiting for a new token when its token is rejected", err: spotify.Error{Status: http.StatusUnauthorized, Message: "The access token expired"}, expectedToken: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{err: tt.err, played: []spotify.RecentlyPlayedItem{recentlyPlayed("sunrise", 0)}}
+			var tokens []string
+			st := newTestStore(t)
+			rec := newRecorder(st, func(token string) spotifyClient {
+				tokens = append(tokens, token)
+				return client
+			})
+			rec.register("thomas", "old")
+			rec.register("thomas", "new")
+
+			rec.record()
+			if len(tokens) != 1 || tokens[0] != "new" {
+				t.Errorf("record() used tokens %v, want the last registered one", tokens)
+			}
+			if token, ok := rec.tokens["thomas"]; !ok || token != tt.expectedToken {
+				t.Errorf("token of the user after record() = %q, %v, want %q", token, ok, tt.expectedToken)
+			}
+			if registrations, err := st.registrations(); err != nil || registrations["thomas"] != tt.expectedToken {
+				t.Errorf("registrations() after record() = %v, %v, want the token %q", registrations, err, tt.expectedToken)
+			}
+			if _, ok := rec.users()["thomas"]; ok != (tt.expectedToken != "") {
+				t.Errorf("user recorded after record() = %v, want %v", ok, tt.expectedToken != "")
+			}
+		})
+	}
+}
+
+func Test_recorder_refresh(t *testing.T) {
+	st := newTestStore(t)
+	rec := newRecorder(st, func(token string) spotifyClient { return &mockSpotifyClient{} })
+	rec.register("thomas", "old")
+	rec.expire("thomas", "old")
+	if _, ok := rec.users()["thomas"]; ok {
+		t.Fatal("the user whose token expired is still recorded")
+	}
+
+	rec.refresh(eventbus.TokenValidated{UserID: "thomas", Token: "new"})
+	rec.refresh(eventbus.TokenValidated{UserID: "early-riser", Token: "token"})
+	if got, want := rec.users(), map[string]string{"thomas": "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("users() after refresh() = %v, want %v", got, want)
+	}
+	if got, err := st.registrations(); err != nil || !reflect.DeepEqual(got, map[string]string{"thomas": "new"}) {
+		t.Errorf("registrations() after refresh() = %v, %v, want only the new token of the registered user", got, err)
+	}
+
+	// a token refreshed while the old one was being rejected is kept
+	rec.expire("thomas", "old")
+	if token := rec.users()["thomas"]; token != "new" {
+		t.Errorf("expire() of an old token dropped the new one, token = %q", token)
+	}
+}
+
+func Test_recorder_restore(t *testing.T) {
+	st := newTestStore(t)
+	rec := newRecorder(st, func(token string) spotifyClient { return &mockSpotifyClient{} })
+	rec.register("thomas", "token")
+	rec.register("early-riser", "token")
+	rec.unregister("early-riser")
+
+	restarted := newRecorder(st, func(token string) spotifyClient { return &mockSpotifyClient{} })
+	if err := restarted.restore(); err != nil {
+		t.Fatalf("restore() error = %v", err)
+	}
+	if got, want := restarted.users(), map[string]string{"thomas": "token"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("users() after restore() = %v, want %v", got, want)
+	}
+}
+
+func Test_recorder_run(t *testing.T) {
+	client := &mockSpotifyClient{}
+	rec := newRecorder(newTestStore(t), func(token string) spotifyClient { return client })
+	rec.register("thomas", "token")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rec.run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run() did not return once the context was done")
+	}
+	if client.calls < 2 {
+		t.Errorf("run() recorded %d times, want at least 2", client.calls)
+	}
+}
+
+func Test_recorder_trackChanged(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        string
+		expectedCalls int
+	}{
+		{name: "should record the plays of the registered user right away", userID: "thomas", expectedCalls: 1},
+		{name: "should ignore the track changes of the other users", userID: "early-riser", expectedCalls: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{played: []spotify.RecentlyPlayedItem{recentlyPlayed("sunrise", 0)}}
+			rec := newRecorder(newTestStore(t), func(token string) spotifyClient { return client })
+			rec.register("thomas", "token")
+
+			rec.trackChanged(eventbus.TrackChanged{UserID: tt.userID, PreviousTrackID: "sunrise"})
+			if client.calls != tt.expectedCalls {
+				t.Errorf("trackChanged() pulled the recently played %d times, want %d", client.calls, tt.expectedCalls)
+			}
+		})
+	}
+}
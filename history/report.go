@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"common/models"
+)
+
+// weekdays are the names of the days of the hour of week heatmap, the week starts on monday
+var weekdays = [7]string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// reportTemplate is the page of a report, it is self-contained so it can be saved and shared
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": percent,
+	"weekday": func(i int) string { return weekdays[i] },
+	"join":    func(names []string) string { return strings.Join(names, ", ") },
+	"section": func(title string, items []models.TopItem) topSection { return topSection{Title: title, Items: items} },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Your {{.Year}} in music</title>
+<style>
+body { font-family: sans-serif; background: #121212; color: #fff; max-width: 960px; margin: 0 auto; padding: 2em; }
+h1 { color: #1db954; }
+section { margin: 2em 0; }
+.numbers { display: flex; flex-wrap: wrap; gap: 2em; }
+.number strong { display: block; font-size: 2em; color: #1db954; }
+.bar { display: flex; align-items: center; gap: 1em; margin: .2em 0; }
+.bar span { width: 5em; }
+.bar div { background: #1db954; height: 1em; }
+table { border-collapse: collapse; }
+td { width: 1.4em; height: 1.4em; padding: 0; }
+th { font-weight: normal; font-size: .8em; padding-right: .5em; }
+.muted { color: #b3b3b3; }
+</style>
+</head>
+<body>
+<h1>Your {{.Year}} in music</h1>
+<section class="numbers">
+<div class="number"><strong>{{.Minutes}}</strong>minutes</div>
+<div class="number"><strong>{{.Plays}}</strong>plays</div>
+<div class="number"><strong>{{.Tracks}}</strong>tracks</div>
+<div class="number"><strong>{{.Artists}}</strong>artists</div>
+<div class="number"><strong>{{.LongestStreak.Days}}</strong>days in a row</div>
+</section>
+{{template "top" section "Top tracks" .TopTracks}}
+{{template "top" section "Top artists" .TopArtists}}
+{{template "top" section "Top albums" .TopAlbums}}
+{{template "top" section "Top genres" .TopGenres}}
+{{if .TopDay.Date}}<section>
+<h2>Your top day</h2>
+<p>{{.TopDay.Date}}, with {{.TopDay.Minutes}} minutes of music.</p>
+</section>{{end}}
+<section>
+<h2>Minutes per month</h2>
+{{$max := .MaxMonth}}{{range .Months}}<div class="bar"><span>{{.Month}}</span><div style="width: {{percent .Minutes $max}}%"></div>{{.Minutes}}</div>
+{{end}}</section>
+<section>
+<h2>When you listen</h2>
+<table>
+{{$maxHour := .MaxHour}}{{range $day, $hours := .HourOfWeek}}<tr><th>{{weekday $day}}</th>{{range $hour, $minutes := $hours}}<td title="{{$hour}}h: {{$minutes}} minutes" style="background: rgba(29, 185, 84, {{percent $minutes $maxHour}}%)"></td>{{end}}</tr>
+{{end}}</table>
+</section>
+<section>
+<h2>Discovery</h2>
+<p>{{.Discovery.NewArtists}} of your {{.Discovery.Artists}} artists were new to you this year.</p>
+</section>
+<p class="muted">The minutes are estimated from the duration of the tracks played.</p>
+</body>
+</html>
+{{define "top"}}{{if .Items}}<section>
+<h2>{{.Title}}</h2>
+<ol>
+{{range .Items}}<li>{{.Name}}{{if .ArtistsName}} <span class="muted">by {{join .ArtistsName}}</span>{{end}} <span class="muted">{{.Plays}} plays</span></li>
+{{end}}</ol>
+</section>{{end}}{{end}}`))
+
+// reportPage is the data of the page of a report
+type reportPage struct {
+	models.Report
+	MaxMonth int
+	MaxHour  int
+}
+
+// topSection is a ranking shown in the page of a report
+type topSection struct {
+	Title string
+	Items []models.TopItem
+}
+
+// percent returns the share of value in max as a percentage, it is 0 when max is
+func percent(value, max int) int {
+	if max == 0 {
+		return 0
+	}
+	return value * 100 / max
+}
+
+// renderReport writes the page of the report
+func renderReport(w io.Writer, report models.Report) error {
+	page := reportPage{Report: report}
+	for _, m := range report.Months {
+		if m.Minutes > page.MaxMonth {
+			page.MaxMonth = m.Minutes
+		}
+	}
+	for _, hours := range report.HourOfWeek {
+		for _, minutes := range hours {
+			if minutes > page.MaxHour {
+				page.MaxHour = minutes
+			}
+		}
+	}
+	if err := reportTemplate.Execute(w, page); err != nil {
+		return fmt.Errorf("could not render the report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"common/models"
+)
+
+func Test_renderReport(t *testing.T) {
+	summary := models.Report{
+		Year:       2021,
+		Minutes:    42,
+		TopTracks:  []models.TopItem{{Name: "Sunrise", ArtistsName: []string{"The Early Birds", "Dusk"}, Plays: 12}},
+		TopArtists: []models.TopItem{{Name: "<script>alert(1)</script>", Plays: 3}},
+		TopDay:     models.DayMinutes{Date: "2021-01-02", Minutes: 30},
+		Months:     []models.MonthMinutes{{Month: "2021-01", Minutes: 40}, {Month: "2021-02", Minutes: 10}},
+	}
+	summary.HourOfWeek[5][8] = 30
+
+	var page bytes.Buffer
+	if err := renderReport(&page, summary); err != nil {
+		t.Fatalf("renderReport() error = %v", err)
+	}
+	got := page.String()
+	for _, want := range []string{
+		"<title>Your 2021 in music</title>",
+		"<strong>42</strong>minutes",
+		"Sunrise <span class=\"muted\">by The Early Birds, Dusk</span>",
+		"&lt;script&gt;alert(1)&lt;/script&gt;",
+		"2021-01-02, with 30 minutes",
+		"width: 25%",
+		"rgba(29, 185, 84, 100%)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("renderReport() page does not contain %q", want)
+		}
+	}
+	if strings.Contains(got, "Top albums") {
+		t.Errorf("renderReport() page shows a ranking without items")
+	}
+}
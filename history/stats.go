@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// defaultTop is the number of items of a ranking when none is asked
+	defaultTop = 10
+	// reportTop is the number of items of the rankings of a report
+	reportTop = 5
+	// dayLayout is the format of the days of the statistics
+	dayLayout = "2006-01-02"
+	// monthLayout is the format of the months of the statistics
+	monthLayout = "2006-01"
+)
+
+// topTypes are the types of items that can be ranked
+var topTypes = map[string]bool{"tracks": true, "artists": true, "albums": true, "genres": true}
+
+// statsQuery is the window and the time zone of the statistics, the zero times do not bound the window
+type statsQuery struct {
+	// from is the first time included
+	from time.Time
+	// to is the first time excluded
+	to time.Time
+	// loc is the time zone of the days, UTC when none is asked
+	loc *time.Location
+	// topType and limit are only used by the rankings
+	topType string
+	limit   int
+}
+
+// statsQueryFromRequest reads the from, to, tz, type and limit query parameters
+// from and to are RFC 3339 times, tz is an IANA time zone, e.g. Europe/Paris
+func statsQueryFromRequest(r *http.Request) (statsQuery, error) {
+	query := r.URL.Query()
+	q := statsQuery{loc: time.UTC, topType: query.Get("type"), limit: defaultTop}
+	for name, field := range map[string]*time.Time{"from": &q.from, "to": &q.to} {
+		if raw := query.Get(name); raw != "" {
+			value, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return statsQuery{}, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*field = value
+		}
+	}
+	if !q.from.IsZero() && !q.to.IsZero() && !q.from.Before(q.to) {
+		return statsQuery{}, errors.New("from must be before to")
+	}
+	if tz := query.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return statsQuery{}, fmt.Errorf("invalid tz %q", tz)
+		}
+		q.loc = loc
+	}
+	if q.topType != "" && !topTypes[q.topType] {
+		return statsQuery{}, fmt.Errorf("invalid type %q", q.topType)
+	}
+	if raw := query.Get("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxLimit {
+			return statsQuery{}, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		q.limit = value
+	}
+	return q, nil
+}
+
+// artistKey identifies an artist, by its name when its ID was not recorded
+func artistKey(id spotify.ID, name string) string {
+	if id == "" {
+		return "name:" + name
+	}
+	return string(id)
+}
+
+// minutes returns the number of minutes of a duration in milliseconds, rounded to the nearest
+// The listening time is estimated from the duration of the tracks played, spotify does not tell when a track was skipped
+func minutes(ms int) int {
+	return (ms + 30000) / 60000
+}
+
+// day returns the day of the time in the time zone, as a date in UTC so the days can be counted
+func day(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// topEntry counts the plays of a ranked item
+type topEntry struct {
+	item models.TopItem
+	ms   int
+}
+
+// top ranks the items of the type by their plays, then by their listening time and their name
+// Every genre of the artists of a track counts the play once
+func top(plays []recordedPlay, genres map[spotify.ID][]string, topType string, limit int) models.Top {
+	entries := map[string]*topEntry{}
+	count := func(key string, item models.TopItem, duration int) {
+		e, ok := entries[key]
+		if !ok {
+			e = &topEntry{item: item}
+			entries[key] = e
+		}
+		e.item.Plays++
+		e.ms += duration
+	}
+	for _, p := range plays {
+		switch topType {
+		case "tracks":
+			count(string(p.ID), models.TopItem{Name: p.Name, ID: string(p.ID), ArtistsName: p.ArtistsName}, p.Duration)
+		case "albums":
+			if p.AlbumID != "" {
+				count(string(p.AlbumID), models.TopItem{Name: p.AlbumName, ID: string(p.AlbumID), ArtistsName: p.ArtistsName}, p.Duration)
+			}
+		case "artists":
+			for i, name := range p.ArtistsName {
+				id := p.artistID(i)
+				count(artistKey(id, name), models.TopItem{Name: name, ID: string(id)}, p.Duration)
+			}
+		case "genres":
+			counted := map[string]bool{}
+			for _, id := range p.ArtistIDs {
+				for _, genre := range genres[id] {
+					if !counted[genre] {
+						counted[genre] = true
+						count(genre, models.TopItem{Name: genre}, p.Duration)
+					}
+				}
+			}
+		}
+	}
+
+	ranked := make([]*topEntry, 0, len(entries))
+	for _, e := range entries {
+		ranked = append(ranked, e)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.item.Plays != b.item.Plays {
+			return a.item.Plays > b.item.Plays
+		}
+		if a.ms != b.ms {
+			return a.ms > b.ms
+		}
+		return a.item.Name < b.item.Name
+	})
+	result := models.Top{Type: topType, Items: []models.TopItem{}}
+	for _, e := range ranked {
+		if len(result.Items) == limit {
+			break
+		}
+		e.item.Minutes = minutes(e.ms)
+		result.Items = append(result.Items, e.item)
+	}
+	return result
+}
+
+// listeningMinutes sums the listening time of the plays per day and per hour of the week in the time zone
+func listeningMinutes(plays []recordedPlay, loc *time.Location) models.ListeningMinutes {
+	var total int
+	var hours [7][24]int
+	var days []time.Time
+	perDay := map[time.Time]int{}
+	for _, p := range plays {
+		t := p.PlayedAt.In(loc)
+		d := day(t, loc)
+		if _, ok := perDay[d]; !ok {
+			days = append(days, d)
+		}
+		perDay[d] += p.Duration
+		// the week starts on monday
+		hours[(t.Weekday()+6)%7][t.Hour()] += p.Duration
+		total += p.Duration
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	result := models.ListeningMinutes{Total: minutes(total), Days: []models.DayMinutes{}}
+	for _, d := range days {
+		result.Days = append(result.Days, models.DayMinutes{Date: d.Format(dayLayout), Minutes: minutes(perDay[d])})
+	}
+	for weekday := range hours {
+		for hour, ms := range hours[weekday] {
+			result.HourOfWeek[weekday][hour] = minutes(ms)
+		}
+	}
+	return result
+}
+
+// runs returns the runs of consecutive days with plays in the time zone, the oldest first
+func runs(times []time.Time, loc *time.Location) []models.Streak {
+	var result []models.Streak
+	var last time.Time
+	for _, t := range times {
+		d := day(t, loc)
+		switch {
+		case len(result) > 0 && d.Equal(last):
+			continue
+		case len(result) > 0 && d.Equal(last.AddDate(0, 0, 1)):
+			result[len(result)-1].Days++
+			result[len(result)-1].To = d.Format(dayLayout)
+		default:
+			result = append(result, models.Streak{Days: 1, From: d.Format(dayLayout), To: d.Format(dayLayout)})
+		}
+		last = d
+	}
+	return result
+}
+
+// longest returns the longest run, the oldest one when several are as long
+func longest(runs []models.Streak) models.Streak {
+	var result models.Streak
+	for _, r := range runs {
+		if r.Days > result.Days {
+			result = r
+		}
+	}
+	return result
+}
+
+// streaks returns the current and the longest runs of listening days of the plays sorted from the oldest
+// The current run is still running when its last day is today or yesterday
+func streaks(times []time.Time, loc *time.Location, now time.Time) models.Streaks {
+	all := runs(times, loc)
+	result := models.Streaks{Longest: longest(all)}
+	if len(all) == 0 {
+		return result
+	}
+	today := day(now, loc)
+	last := all[len(all)-1]
+	if end := last.To; end == today.Format(dayLayout) || end == today.AddDate(0, 0, -1).Format(dayLayout) {
+		result.Current = last
+	}
+	return result
+}
+
+// discovery counts per month the artists played and the new ones, played for the first time during that month
+// An artist is new to the window when it was first played from the start of the window, every artist is new without start
+// The first plays are the ones recorded, the listening before the registration to the recorder is unknown
+func discovery(plays []recordedPlay, first map[string]time.Time, loc *time.Location, from time.Time) models.Discovery {
+	var months []string
+	artists := map[string]map[string]bool{}
+	newArtists := map[string]map[string]bool{}
+	all := map[string]bool{}
+	allNew := map[string]bool{}
+	for _, p := range plays {
+		month := p.PlayedAt.In(loc).Format(monthLayout)
+		if _, ok := artists[month]; !ok {
+			months = append(months, month)
+			artists[month] = map[string]bool{}
+			newArtists[month] = map[string]bool{}
+		}
+		for i, name := range p.ArtistsName {
+			key := artistKey(p.artistID(i), name)
+			artists[month][key] = true
+			all[key] = true
+			firstPlayed, ok := first[key]
+			if !ok {
+				continue
+			}
+			if firstPlayed.In(loc).Format(monthLayout) == month {
+				newArtists[month][key] = true
+			}
+			if !firstPlayed.Before(from) {
+				allNew[key] = true
+			}
+		}
+	}
+	sort.Strings(months)
+
+	result := models.Discovery{Months: []models.DiscoveryMonth{}, Artists: len(all), NewArtists: len(allNew), Rate: rate(len(allNew), len(all))}
+	for _, month := range months {
+		result.Months = append(result.Months, models.DiscoveryMonth{
+			Month:      month,
+			Artists:    len(artists[month]),
+			NewArtists: len(newArtists[month]),
+			Rate:       rate(len(newArtists[month]), len(artists[month])),
+		})
+	}
+	return result
+}
+
+// rate returns the share of part in total rounded to the hundredth, it is 0 when total is
+func rate(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(total)*100) / 100
+}
+
+// report summarizes the plays of the year in the time zone
+func report(year int, plays []recordedPlay, genres map[spotify.ID][]string, first map[string]time.Time, loc *time.Location) models.Report {
+	minutesOf := listeningMinutes(plays, loc)
+	result := models.Report{
+		Year:       year,
+		Plays:      len(plays),
+		Minutes:    minutesOf.Total,
+		TopTracks:  top(plays, genres, "tracks", reportTop).Items,
+		TopArtists: top(plays, genres, "artists", reportTop).Items,
+		TopAlbums:  top(plays, genres, "albums", reportTop).Items,
+		TopGenres:  top(plays, genres, "genres", reportTop).Items,
+		HourOfWeek: minutesOf.HourOfWeek,
+		Discovery:  discovery(plays, first, loc, time.Date(year, 1, 1, 0, 0, 0, 0, loc)),
+	}
+
+	tracks := map[spotify.ID]bool{}
+	var times []time.Time
+	monthMs := make([]int, 12)
+	for _, p := range plays {
+		tracks[p.ID] = true
+		times = append(times, p.PlayedAt)
+		monthMs[p.PlayedAt.In(loc).Month()-1] += p.Duration
+	}
+	result.Tracks = len(tracks)
+	result.Artists = result.Discovery.Artists
+	result.LongestStreak = longest(runs(times, loc))
+	for i, ms := range monthMs {
+		month := time.Date(year, time.Month(i+1), 1, 0, 0, 0, 0, time.UTC)
+		result.Months = append(result.Months, models.MonthMinutes{Month: month.Format(monthLayout), Minutes: minutes(ms)})
+	}
+	for _, d := range minutesOf.Days {
+		if d.Minutes > result.TopDay.Minutes {
+			result.TopDay = d
+		}
+	}
+	return result
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+// on returns the play at the time
+func on(p recordedPlay, t time.Time) recordedPlay {
+	p.PlayedAt = t
+	return p
+}
+
+// date returns the time of the day of 2021 at the hour in UTC
+func date(month time.Month, day, hour int) time.Time {
+	return time.Date(2021, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+// names returns the names of the ranked items
+func names(items []models.TopItem) []string {
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func Test_statsQueryFromRequest(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	tests := []struct {
+		name      string
+		target    string
+		want      statsQuery
+		expectErr string
+	}{
+		{name: "should use the defaults", target: "/stats/top", want: statsQuery{loc: time.UTC, limit: defaultTop}},
+		{
+			name:   "should read the window, the time zone and the ranking",
+			target: "/stats/top?type=artists&from=2021-01-02T08:00:00Z&to=2021-01-02T09:00:00Z&tz=Europe/Paris&limit=3",
+			want:   statsQuery{from: at(0), to: at(60), loc: paris, topType: "artists", limit: 3},
+		},
+		{name: "should reject an invalid time", target: "/stats/minutes?to=tomorrow", expectErr: `invalid to "tomorrow"`},
+		{name: "should reject an empty window", target: "/stats/minutes?from=2021-01-02T08:00:00Z&to=2021-01-01T08:00:00Z", expectErr: "from must be before to"},
+		{name: "should reject an unknown time zone", target: "/stats/minutes?tz=Mars/Olympus", expectErr: `invalid tz "Mars/Olympus"`},
+		{name: "should reject an unknown type", target: "/stats/top?type=podcasts", expectErr: `invalid type "podcasts"`},
+		{name: "should reject a too large ranking", target: "/stats/top?type=tracks&limit=51", expectErr: "limit must be between 1 and 50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := statsQueryFromRequest(httptest.NewRequest("GET", tt.target, nil))
+			if tt.expectErr != "" {
+				if err == nil || err.Error() != tt.expectErr {
+					t.Errorf("statsQueryFromRequest() error = %v, want %v", err, tt.expectErr)
+				}
+				return
+			}
+			if err != nil || !got.from.Equal(tt.want.from) || !got.to.Equal(tt.want.to) || got.loc.String() != tt.want.loc.String() || got.topType != tt.want.topType || got.limit != tt.want.limit {
+				t.Errorf("statsQueryFromRequest() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_top(t *testing.T) {
+	lullaby := play("lullaby", 20, "Dusk")
+	lullaby.AlbumID, lullaby.AlbumName, lullaby.Duration = "night", "Night", 600000
+	plays := []recordedPlay{
+		play("sunrise", 0, "The Early Birds"),
+		play("coffee", 3, "The Early Birds", "Dusk"),
+		play("sunrise", 6, "The Early Birds"),
+		lullaby,
+		// a play recorded before the IDs of the artists were kept
+		{Play: models.Play{Name: "commute", ID: "commute", ArtistsName: []string{"Traffic"}, Duration: 240000}},
+	}
+	genres := map[spotify.ID][]string{"the-early-birds": {"indie", "folk"}, "dusk": {"ambient", "folk"}}
+
+	tests := []struct {
+		name     string
+		topType  string
+		limit    int
+		expected []models.TopItem
+	}{
+		{
+			name:    "should rank the tracks by their plays, then by their minutes",
+			topType: "tracks",
+			limit:   10,
+			expected: []models.TopItem{
+				{Name: "sunrise", ID: "sunrise", ArtistsName: []string{"The Early Birds"}, Plays: 2, Minutes: 6},
+				{Name: "lullaby", ID: "lullaby", ArtistsName: []string{"Dusk"}, Plays: 1, Minutes: 10},
+				{Name: "commute", ID: "commute", ArtistsName: []string{"Traffic"}, Plays: 1, Minutes: 4},
+				{Name: "coffee", ID: "coffee", ArtistsName: []string{"The Early Birds", "Dusk"}, Plays: 1, Minutes: 3},
+			},
+		},
+		{
+			name:    "should count every artist of a track",
+			topType: "artists",
+			limit:   10,
+			expected: []models.TopItem{
+				{Name: "The Early Birds", ID: "the-early-birds", Plays: 3, Minutes: 9},
+				{Name: "Dusk", ID: "dusk", Plays: 2, Minutes: 13},
+				{Name: "Traffic", Plays: 1, Minutes: 4},
+			},
+		},
+		{
+			name:    "should skip the plays without album",
+			topType: "albums",
+			limit:   10,
+			expected: []models.TopItem{
+				{Name: "Morning", ID: "morning", ArtistsName: []string{"The Early Birds"}, Plays: 3, Minutes: 9},
+				{Name: "Night", ID: "night", ArtistsName: []string{"Dusk"}, Plays: 1, Minutes: 10},
+			},
+		},
+		{
+			name:    "should count a genre once per play",
+			topType: "genres",
+			limit:   10,
+			expected: []models.TopItem{
+				{Name: "folk", Plays: 4, Minutes: 19},
+				{Name: "indie", Plays: 3, Minutes: 9},
+				{Name: "ambient", Plays: 2, Minutes: 13},
+			},
+		},
+		{
+			name:     "should keep the most played",
+			topType:  "artists",
+			limit:    1,
+			expected: []models.TopItem{{Name: "The Early Birds", ID: "the-early-birds", Plays: 3, Minutes: 9}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := top(plays, genres, tt.topType, tt.limit)
+			if got.Type != tt.topType || !reflect.DeepEqual(got.Items, tt.expected) {
+				t.Errorf("top() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+
+	if got := top(nil, nil, "tracks", 10); got.Items == nil || len(got.Items) != 0 {
+		t.Errorf("top() without plays = %+v, want no items", got)
+	}
+}
+
+func Test_listeningMinutes(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	plays := []recordedPlay{
+		// saturday 2nd of january at 23:30 in UTC, already sunday in Paris
+		on(play("lullaby", 0), time.Date(2021, 1, 2, 23, 30, 0, 0, time.UTC)),
+		on(play("sunrise", 0), date(1, 4, 8)),
+		on(play("coffee", 0), date(1, 4, 8).Add(3*time.Minute)),
+	}
+
+	got := listeningMinutes(plays, time.UTC)
+	if got.Total != 9 || !reflect.DeepEqual(got.Days, []models.DayMinutes{{Date: "2021-01-02", Minutes: 3}, {Date: "2021-01-04", Minutes: 6}}) {
+		t.Errorf("listeningMinutes() in UTC = %d %+v", got.Total, got.Days)
+	}
+	if got.HourOfWeek[5][23] != 3 || got.HourOfWeek[0][8] != 6 {
+		t.Errorf("listeningMinutes() in UTC hour of week = %v, want saturday 23h and monday 8am", got.HourOfWeek)
+	}
+
+	got = listeningMinutes(plays, paris)
+	if !reflect.DeepEqual(got.Days, []models.DayMinutes{{Date: "2021-01-03", Minutes: 3}, {Date: "2021-01-04", Minutes: 6}}) {
+		t.Errorf("listeningMinutes() in Paris = %+v", got.Days)
+	}
+	if got.HourOfWeek[6][0] != 3 || got.HourOfWeek[0][9] != 6 {
+		t.Errorf("listeningMinutes() in Paris hour of week = %v, want sunday midnight and monday 9am", got.HourOfWeek)
+	}
+
+	if got := listeningMinutes(nil, time.UTC); got.Total != 0 || got.Days == nil || len(got.Days) != 0 {
+		t.Errorf("listeningMinutes() without plays = %+v", got)
+	}
+}
+
+func Test_streaks(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	times := []time.Time{date(1, 1, 8), date(1, 2, 8), date(1, 2, 9), date(1, 3, 8), date(1, 10, 8), date(1, 11, 23)}
+	tests := []struct {
+		name     string
+		times    []time.Time
+		loc      *time.Location
+		now      time.Time
+		expected models.Streaks
+	}{
+		{
+			name:  "should find the current and the longest streaks",
+			times: times,
+			loc:   time.UTC,
+			now:   date(1, 11, 23),
+			expected: models.Streaks{
+				Current: models.Streak{Days: 2, From: "2021-01-10", To: "2021-01-11"},
+				Longest: models.Streak{Days: 3, From: "2021-01-01", To: "2021-01-03"},
+			},
+		},
+		{
+			name:  "should keep the streak running until the end of the next day",
+			times: times,
+			loc:   time.UTC,
+			now:   date(1, 12, 22),
+			expected: models.Streaks{
+				Current: models.Streak{Days: 2, From: "2021-01-10", To: "2021-01-11"},
+				Longest: models.Streak{Days: 3, From: "2021-01-01", To: "2021-01-03"},
+			},
+		},
+		{
+			name:     "should end the streak after a day without plays",
+			times:    times,
+			loc:      time.UTC,
+			now:      date(1, 13, 8),
+			expected: models.Streaks{Longest: models.Streak{Days: 3, From: "2021-01-01", To: "2021-01-03"}},
+		},
+		{
+			name:  "should count the days in the time zone",
+			times: times,
+			loc:   paris,
+			now:   date(1, 13, 8),
+			expected: models.Streaks{
+				Current: models.Streak{Days: 1, From: "2021-01-12", To: "2021-01-12"},
+				Longest: models.Streak{Days: 3, From: "2021-01-01", To: "2021-01-03"},
+			},
+		},
+		{name: "should have no streak without plays", loc: time.UTC, now: date(1, 1, 8), expected: models.Streaks{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := streaks(tt.times, tt.loc, tt.now); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("streaks() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_discovery(t *testing.T) {
+	plays := []recordedPlay{
+		on(play("coffee", 0, "The Early Birds", "Dusk"), date(1, 20, 8)),
+		on(play("commute", 0, "Traffic"), date(2, 1, 8)),
+		on(play("sunrise", 0, "The Early Birds"), date(2, 2, 8)),
+	}
+	first := map[string]time.Time{"the-early-birds": date(1, 2, 8), "dusk": date(1, 20, 8), "traffic": date(2, 1, 8)}
+
+	got := discovery(plays, first, time.UTC, date(1, 15, 0))
+	want := models.Discovery{
+		Months: []models.DiscoveryMonth{
+			{Month: "2021-01", Artists: 2, NewArtists: 2, Rate: 1},
+			{Month: "2021-02", Artists: 2, NewArtists: 1, Rate: 0.5},
+		},
+		Artists:    3,
+		NewArtists: 2,
+		Rate:       0.67,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discovery() = %+v, want %+v", got, want)
+	}
+
+	if got := discovery(nil, first, time.UTC, time.Time{}); got.Months == nil || got.Artists != 0 || got.Rate != 0 {
+		t.Errorf("discovery() without plays = %+v", got)
+	}
+}
+
+func Test_report(t *testing.T) {
+	plays := []recordedPlay{
+		on(play("sunrise", 0, "The Early Birds"), date(1, 1, 8)),
+		on(play("coffee", 0, "The Early Birds", "Dusk"), date(1, 2, 8)),
+		on(play("sunrise", 0, "The Early Birds"), date(1, 2, 9)),
+		on(play("lullaby", 0, "Dusk"), date(3, 5, 22)),
+	}
+	first := map[string]time.Time{"the-early-birds": date(1, 1, 8), "dusk": date(1, 2, 8)}
+	genres := map[spotify.ID][]string{"dusk": {"ambient"}}
+
+	got := report(2021, plays, genres, first, time.UTC)
+	if got.Year != 2021 || got.Plays != 4 || got.Minutes != 12 || got.Tracks != 3 || got.Artists != 2 {
+		t.Errorf("report() totals = %+v", got)
+	}
+	if tracks := names(got.TopTracks); !reflect.DeepEqual(tracks, []string{"sunrise", "coffee", "lullaby"}) {
+		t.Errorf("report() top tracks = %v", tracks)
+	}
+	if genres := names(got.TopGenres); !reflect.DeepEqual(genres, []string{"ambient"}) {
+		t.Errorf("report() top genres = %v", genres)
+	}
+	if got.TopDay != (models.DayMinutes{Date: "2021-01-02", Minutes: 6}) {
+		t.Errorf("report() top day = %+v", got.TopDay)
+	}
+	if len(got.Months) != 12 || got.Months[0] != (models.MonthMinutes{Month: "2021-01", Minutes: 9}) || got.Months[1].Minutes != 0 || got.Months[2].Minutes != 3 {
+		t.Errorf("report() months = %+v", got.Months)
+	}
+	if got.LongestStreak != (models.Streak{Days: 2, From: "2021-01-01", To: "2021-01-02"}) {
+		t.Errorf("report() longest streak = %+v", got.LongestStreak)
+	}
+	if got.Discovery.NewArtists != 2 || len(got.Discovery.Months) != 2 {
+		t.Errorf("report() discovery = %+v", got.Discovery)
+	}
+}
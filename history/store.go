@@ -0,0 +1,423 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"common/models"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/zmb3/spotify"
+)
+
+// migrations update the schema of the database, the user_version of sqlite is the number of migrations applied
+// A play is identified by its user and the time it was played at, the genres are kept per artist for every user
+// The registered users are kept with their last access token, empty once rejected by spotify
+var migrations = []string{`
+CREATE TABLE IF NOT EXISTS plays (
+	user_id     TEXT    NOT NULL,
+	played_at   INTEGER NOT NULL,
+	track_id    TEXT    NOT NULL,
+	name        TEXT    NOT NULL,
+	uri         TEXT    NOT NULL,
+	duration    INTEGER NOT NULL,
+	context_uri TEXT    NOT NULL,
+	PRIMARY KEY (user_id, played_at)
+);
+CREATE TABLE IF NOT EXISTS play_artists (
+	user_id   TEXT    NOT NULL,
+	played_at INTEGER NOT NULL,
+	position  INTEGER NOT NULL,
+	name      TEXT    NOT NULL,
+	PRIMARY KEY (user_id, played_at, position)
+);
+CREATE INDEX IF NOT EXISTS play_artists_name ON play_artists (user_id, name COLLATE NOCASE);
+`, `
+ALTER TABLE plays ADD COLUMN album_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE plays ADD COLUMN album_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE play_artists ADD COLUMN artist_id TEXT NOT NULL DEFAULT '';
+CREATE TABLE artists (
+	artist_id TEXT NOT NULL PRIMARY KEY,
+	genres    TEXT NOT NULL
+);
+`, `
+CREATE TABLE registrations (
+	user_id TEXT NOT NULL PRIMARY KEY,
+	token   TEXT NOT NULL
+);
+`}
+
+// recordedPlay is a play along with the IDs of its album and artists, ArtistIDs follows ArtistsName
+// The IDs are empty for the plays recorded before they were kept
+type recordedPlay struct {
+	models.Play
+	AlbumID   spotify.ID
+	ArtistIDs []spotify.ID
+}
+
+// artistID returns the ID of the artist at the position, it is empty when not recorded
+func (p recordedPlay) artistID(position int) spotify.ID {
+	if position < len(p.ArtistIDs) {
+		return p.ArtistIDs[position]
+	}
+	return ""
+}
+
+// store keeps the plays of the users in a sqlite database
+type store struct {
+	db *sql.DB
+}
+
+// openStore opens the sqlite database at path, creating it or migrating its tables when needed
+func openStore(path string) (*store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite has a single writer, one connection avoids the busy errors between the recorder and the requests
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate the tables: %w", err)
+	}
+	return &store{db: db}, nil
+}
+
+// migrate applies the migrations not applied yet, each one in its own transaction
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the database
+func (s *store) close() error {
+	return s.db.Close()
+}
+
+// ping checks the database can be queried
+func (s *store) ping() error {
+	return s.db.Ping()
+}
+
+// toMillis returns the unix time of t in milliseconds, the precision of the plays of spotify
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis returns the time of a unix time in milliseconds
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// lastPlayed returns the time of the most recent play of the user, it is zero when there is none
+func (s *store) lastPlayed(userID string) (time.Time, error) {
+	var last sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(played_at) FROM plays WHERE user_id = ?`, userID).Scan(&last); err != nil {
+		return time.Time{}, err
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	return fromMillis(last.Int64), nil
+}
+
+// add adds the plays of the user and returns how many were new, the plays already kept are ignored
+func (s *store) add(userID string, plays []recordedPlay) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	added := 0
+	for _, p := range plays {
+		res, err := tx.Exec(`INSERT OR IGNORE INTO plays (user_id, played_at, track_id, name, uri, duration, context_uri, album_id, album_name) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, toMillis(p.PlayedAt), p.ID, p.Name, p.URI, p.Duration, p.ContextURI, p.AlbumID, p.AlbumName)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		added++
+		for i, artist := range p.ArtistsName {
+			if _, err := tx.Exec(`INSERT INTO play_artists (user_id, played_at, position, name, artist_id) VALUES (?, ?, ?, ?, ?)`,
+				userID, toMillis(p.PlayedAt), i, artist, p.artistID(i)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return added, tx.Commit()
+}
+
+// historyQuery filters and pages the plays of a user, the zero values do not filter
+type historyQuery struct {
+	// from is the first time included
+	from time.Time
+	// to is the first time excluded
+	to time.Time
+	// artist is the name of one of the artists of the track, case insensitive
+	artist string
+	limit  int
+	offset int
+}
+
+// list returns the page of the plays of the user matching the query, the most recent first
+func (s *store) list(userID string, q historyQuery) (models.History, error) {
+	filter, args := between(userID, q.from, q.to)
+	if q.artist != "" {
+		filter += " AND EXISTS (SELECT 1 FROM play_artists a WHERE a.user_id = p.user_id AND a.played_at = p.played_at AND a.name = ? COLLATE NOCASE)"
+		args = append(args, q.artist)
+	}
+
+	history := models.History{Items: []models.Play{}}
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM plays p WHERE `+filter, args...).Scan(&history.Total); err != nil {
+		return models.History{}, err
+	}
+
+	rows, err := s.db.Query(`SELECT p.played_at, p.track_id, p.name, p.album_name, p.uri, p.duration, p.context_uri FROM plays p WHERE `+filter+
+		` ORDER BY p.played_at DESC LIMIT ? OFFSET ?`, append(args, q.limit, q.offset)...)
+	if err != nil {
+		return models.History{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var playedAt int64
+		var p models.Play
+		if err := rows.Scan(&playedAt, &p.ID, &p.Name, &p.AlbumName, &p.URI, &p.Duration, &p.ContextURI); err != nil {
+			return models.History{}, err
+		}
+		p.PlayedAt = fromMillis(playedAt)
+		history.Items = append(history.Items, p)
+	}
+	if err := rows.Err(); err != nil {
+		return models.History{}, err
+	}
+	if len(history.Items) == 0 {
+		return history, nil
+	}
+	return history, s.addArtists(userID, history.Items)
+}
+
+// addArtists fills the artists of the plays, sorted from the most recent
+func (s *store) addArtists(userID string, plays []models.Play) error {
+	rows, err := s.db.Query(`SELECT played_at, name FROM play_artists WHERE user_id = ? AND played_at BETWEEN ? AND ? ORDER BY played_at, position`,
+		userID, toMillis(plays[len(plays)-1].PlayedAt), toMillis(plays[0].PlayedAt))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	artists := map[int64][]string{}
+	for rows.Next() {
+		var playedAt int64
+		var name string
+		if err := rows.Scan(&playedAt, &name); err != nil {
+			return err
+		}
+		artists[playedAt] = append(artists[playedAt], name)
+	}
+	for i := range plays {
+		plays[i].ArtistsName = artists[toMillis(plays[i].PlayedAt)]
+	}
+	return rows.Err()
+}
+
+// between returns the filter of the plays of the user in the window, the zero times do not filter
+func between(userID string, from, to time.Time) (string, []interface{}) {
+	where := []string{"p.user_id = ?"}
+	args := []interface{}{userID}
+	if !from.IsZero() {
+		where = append(where, "p.played_at >= ?")
+		args = append(args, toMillis(from))
+	}
+	if !to.IsZero() {
+		where = append(where, "p.played_at < ?")
+		args = append(args, toMillis(to))
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// statPlays returns the plays of the user from the included time to the excluded one, the oldest first
+func (s *store) statPlays(userID string, from, to time.Time) ([]recordedPlay, error) {
+	filter, args := between(userID, from, to)
+	rows, err := s.db.Query(`SELECT p.played_at, p.track_id, p.name, p.duration, p.album_id, p.album_name, a.artist_id, a.name FROM plays p
+		LEFT JOIN play_artists a ON a.user_id = p.user_id AND a.played_at = p.played_at
+		WHERE `+filter+` ORDER BY p.played_at, a.position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plays := []recordedPlay{}
+	for rows.Next() {
+		var playedAt int64
+		var p recordedPlay
+		var artistID, artist sql.NullString
+		if err := rows.Scan(&playedAt, &p.ID, &p.Name, &p.Duration, &p.AlbumID, &p.AlbumName, &artistID, &artist); err != nil {
+			return nil, err
+		}
+		p.PlayedAt = fromMillis(playedAt)
+		if n := len(plays); n == 0 || !plays[n-1].PlayedAt.Equal(p.PlayedAt) {
+			plays = append(plays, p)
+		}
+		if artist.Valid {
+			last := &plays[len(plays)-1]
+			last.ArtistsName = append(last.ArtistsName, artist.String)
+			last.ArtistIDs = append(last.ArtistIDs, spotify.ID(artistID.String))
+		}
+	}
+	return plays, rows.Err()
+}
+
+// firstPlayed returns the time each artist was first played by the user, by artist key
+func (s *store) firstPlayed(userID string) (map[string]time.Time, error) {
+	rows, err := s.db.Query(`SELECT artist_id, name, MIN(played_at) FROM play_artists WHERE user_id = ? GROUP BY artist_id, name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	first := map[string]time.Time{}
+	for rows.Next() {
+		var artistID spotify.ID
+		var name string
+		var playedAt int64
+		if err := rows.Scan(&artistID, &name, &playedAt); err != nil {
+			return nil, err
+		}
+		key, at := artistKey(artistID, name), fromMillis(playedAt)
+		if known, ok := first[key]; !ok || at.Before(known) {
+			first[key] = at
+		}
+	}
+	return first, rows.Err()
+}
+
+// genres returns the genres of the artists played by the user, by artist ID
+func (s *store) genres(userID string) (map[spotify.ID][]string, error) {
+	rows, err := s.db.Query(`SELECT artist_id, genres FROM artists WHERE artist_id IN (SELECT artist_id FROM play_artists WHERE user_id = ?)`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	genres := map[spotify.ID][]string{}
+	for rows.Next() {
+		var artistID spotify.ID
+		var raw string
+		if err := rows.Scan(&artistID, &raw); err != nil {
+			return nil, err
+		}
+		var g []string
+		if err := json.Unmarshal([]byte(raw), &g); err != nil {
+			return nil, fmt.Errorf("invalid genres of artist %s: %w", artistID, err)
+		}
+		genres[artistID] = g
+	}
+	return genres, rows.Err()
+}
+
+// unknownArtists returns the artists whose genres are not kept yet
+func (s *store) unknownArtists(artistIDs []spotify.ID) ([]spotify.ID, error) {
+	var unknown []spotify.ID
+	for _, id := range artistIDs {
+		var found int
+		err := s.db.QueryRow(`SELECT COUNT(*) FROM artists WHERE artist_id = ?`, id).Scan(&found)
+		if err != nil {
+			return nil, err
+		}
+		if found == 0 {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown, nil
+}
+
+// saveGenres keeps the genres of the artists, replacing the ones already kept
+func (s *store) saveGenres(genres map[spotify.ID][]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, g := range genres {
+		if g == nil {
+			g = []string{}
+		}
+		raw, err := json.Marshal(g)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO artists (artist_id, genres) VALUES (?, ?)`, id, string(raw)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// playTimes returns the times of every play of the user, the oldest first
+func (s *store) playTimes(userID string) ([]time.Time, error) {
+	rows, err := s.db.Query(`SELECT played_at FROM plays WHERE user_id = ? ORDER BY played_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var times []time.Time
+	for rows.Next() {
+		var playedAt int64
+		if err := rows.Scan(&playedAt); err != nil {
+			return nil, err
+		}
+		times = append(times, fromMillis(playedAt))
+	}
+	return times, rows.Err()
+}
+
+// saveRegistration keeps the user registered with its access token, replacing the token kept
+func (s *store) saveRegistration(userID, token string) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO registrations (user_id, token) VALUES (?, ?)`, userID, token)
+	return err
+}
+
+// deleteRegistration removes the registration of the user, it does nothing when the user is not registered
+func (s *store) deleteRegistration(userID string) error {
+	_, err := s.db.Exec(`DELETE FROM registrations WHERE user_id = ?`, userID)
+	return err
+}
+
+// registrations returns the registered users with their access tokens
+func (s *store) registrations() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT user_id, token FROM registrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	registrations := map[string]string{}
+	for rows.Next() {
+		var userID, token string
+		if err := rows.Scan(&userID, &token); err != nil {
+			return nil, err
+		}
+		registrations[userID] = token
+	}
+	return registrations, rows.Err()
+}
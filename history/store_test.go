@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+// newTestStore opens a store in a temporary directory
+func newTestStore(t *testing.T) *store {
+	st, err := openStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.close() })
+	return st
+}
+
+// at returns the time of the minute of the 2nd of january 2021 at 8am
+func at(minute int) time.Time {
+	return time.Date(2021, 1, 2, 8, minute, 0, 0, time.UTC)
+}
+
+// play creates a play of the track of the morning album at the minute, the artist IDs are made from their names
+func play(id string, minute int, artists ...string) recordedPlay {
+	var artistIDs []spotify.ID
+	for _, name := range artists {
+		artistIDs = append(artistIDs, spotify.ID(strings.ToLower(strings.ReplaceAll(name, " ", "-"))))
+	}
+	return recordedPlay{
+		Play: models.Play{
+			Name:        id,
+			ArtistsName: artists,
+			AlbumName:   "Morning",
+			ID:          spotify.ID(id),
+			URI:         spotify.URI("spotify:track:" + id),
+			Duration:    180000,
+			ContextURI:  "spotify:playlist:morning",
+			PlayedAt:    at(minute),
+		},
+		AlbumID:   "morning",
+		ArtistIDs: artistIDs,
+	}
+}
+
+// ids returns the ids of the plays
+func ids(plays []models.Play) []spotify.ID {
+	ids := []spotify.ID{}
+	for _, p := range plays {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func Test_store_add(t *testing.T) {
+	st := newTestStore(t)
+
+	if last, err := st.lastPlayed("thomas"); err != nil || !last.IsZero() {
+		t.Errorf("lastPlayed() without plays = %v, %v", last, err)
+	}
+	added, err := st.add("thomas", []recordedPlay{play("sunrise", 0, "The Early Birds"), play("coffee", 3, "The Early Birds", "Dusk")})
+	if err != nil || added != 2 {
+		t.Fatalf("add() = %v, %v, want 2 plays added", added, err)
+	}
+	added, err = st.add("thomas", []recordedPlay{play("coffee", 3, "The Early Birds", "Dusk"), play("commute", 7, "Traffic")})
+	if err != nil || added != 1 {
+		t.Fatalf("add() with a play already kept = %v, %v, want 1 play added", added, err)
+	}
+	if added, err := st.add("alice", []recordedPlay{play("sunrise", 0)}); err != nil || added != 1 {
+		t.Fatalf("add() of another user = %v, %v, want 1 play added", added, err)
+	}
+	if last, err := st.lastPlayed("thomas"); err != nil || !last.Equal(at(7)) {
+		t.Errorf("lastPlayed() = %v, %v, want %v", last, err, at(7))
+	}
+
+	history, err := st.list("thomas", historyQuery{limit: 10})
+	if err != nil {
+		t.Fatalf("list() error = %v", err)
+	}
+	want := []models.Play{play("commute", 7, "Traffic").Play, play("coffee", 3, "The Early Birds", "Dusk").Play, play("sunrise", 0, "The Early Birds").Play}
+	if history.Total != 3 || !reflect.DeepEqual(history.Items, want) {
+		t.Errorf("list() = %+v, want %+v", history, want)
+	}
+}
+
+func Test_store_list(t *testing.T) {
+	st := newTestStore(t)
+	if _, err := st.add("thomas", []recordedPlay{
+		play("sunrise", 0, "The Early Birds"),
+		play("coffee", 3, "The Early Birds", "Dusk"),
+		play("commute", 7, "Traffic"),
+		play("sunset", 10, "Dusk"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		userID        string
+		query         historyQuery
+		expectedIDs   []spotify.ID
+		expectedTotal int
+	}{
+		{name: "should list the plays from the most recent", userID: "thomas", query: historyQuery{limit: 20}, expectedIDs: []spotify.ID{"sunset", "commute", "coffee", "sunrise"}, expectedTotal: 4},
+		{name: "should page the plays", userID: "thomas", query: historyQuery{limit: 2, offset: 1}, expectedIDs: []spotify.ID{"commute", "coffee"}, expectedTotal: 4},
+		{name: "should include from and exclude to", userID: "thomas", query: historyQuery{from: at(3), to: at(10), limit: 20}, expectedIDs: []spotify.ID{"commute", "coffee"}, expectedTotal: 2},
+		{name: "should filter by any artist of the track", userID: "thomas", query: historyQuery{artist: "dusk", limit: 20}, expectedIDs: []spotify.ID{"sunset", "coffee"}, expectedTotal: 2},
+		{name: "should not match a part of the artist name", userID: "thomas", query: historyQuery{artist: "Dus", limit: 20}, expectedIDs: []spotify.ID{}, expectedTotal: 0},
+		{name: "should combine the filters", userID: "thomas", query: historyQuery{artist: "The Early Birds", from: at(1), limit: 20}, expectedIDs: []spotify.ID{"coffee"}, expectedTotal: 1},
+		{name: "should not list the plays of other users", userID: "alice", query: historyQuery{limit: 20}, expectedIDs: []spotify.ID{}, expectedTotal: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := st.list(tt.userID, tt.query)
+			if err != nil {
+				t.Fatalf("list() error = %v", err)
+			}
+			if got := ids(history.Items); !reflect.DeepEqual(got, tt.expectedIDs) || history.Total != tt.expectedTotal {
+				t.Errorf("list() = %v (%d), want %v (%d)", got, history.Total, tt.expectedIDs, tt.expectedTotal)
+			}
+		})
+	}
+}
+
+func Test_openStore_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.add("thomas", []recordedPlay{play("sunrise", 0)})
+	st.close()
+
+	st, err = openStore(path)
+	if err != nil {
+		t.Fatalf("openStore() of an existing database error = %v", err)
+	}
+	defer st.close()
+	if last, err := st.lastPlayed("thomas"); err != nil || !last.Equal(at(0)) {
+		t.Errorf("lastPlayed() after reopening = %v, %v", last, err)
+	}
+}
+
+func Test_openStore_migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a database of the first version holds plays without album nor artist IDs
+	if _, err := db.Exec(migrations[0] + `PRAGMA user_version = 1;
+		INSERT INTO plays (user_id, played_at, track_id, name, uri, duration, context_uri) VALUES ('thomas', 1609574400000, 'sunrise', 'Sunrise', 'spotify:track:sunrise', 180000, '');
+		INSERT INTO play_artists (user_id, played_at, position, name) VALUES ('thomas', 1609574400000, 0, 'The Early Birds');`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	st, err := openStore(path)
+	if err != nil {
+		t.Fatalf("openStore() of a database of the first version error = %v", err)
+	}
+	defer st.close()
+	var version int
+	if err := st.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != len(migrations) {
+		t.Errorf("user_version after openStore() = %v, %v, want %d", version, err, len(migrations))
+	}
+	plays, err := st.statPlays("thomas", time.Time{}, time.Time{})
+	want := []recordedPlay{{Play: models.Play{Name: "Sunrise", ArtistsName: []string{"The Early Birds"}, ID: "sunrise", Duration: 180000, PlayedAt: at(0)}, ArtistIDs: []spotify.ID{""}}}
+	if err != nil || !reflect.DeepEqual(plays, want) {
+		t.Errorf("statPlays() of the migrated plays = %+v, %v, want %+v", plays, err, want)
+	}
+}
+
+func Test_store_statPlays(t *testing.T) {
+	st := newTestStore(t)
+	if _, err := st.add("thomas", []recordedPlay{play("sunrise", 0, "The Early Birds"), play("coffee", 3, "The Early Birds", "Dusk"), play("lullaby", 10)}); err != nil {
+		t.Fatal(err)
+	}
+
+	plays, err := st.statPlays("thomas", at(0), at(10))
+	want := []recordedPlay{play("sunrise", 0, "The Early Birds"), play("coffee", 3, "The Early Birds", "Dusk")}
+	for i := range want {
+		want[i].URI, want[i].ContextURI = "", ""
+	}
+	if err != nil || !reflect.DeepEqual(plays, want) {
+		t.Errorf("statPlays() = %+v, %v, want %+v", plays, err, want)
+	}
+	if plays, err := st.statPlays("thomas", at(10), time.Time{}); err != nil || len(plays) != 1 || plays[0].ArtistsName != nil {
+		t.Errorf("statPlays() of a play without artists = %+v, %v", plays, err)
+	}
+
+	first, err := st.firstPlayed("thomas")
+	wantFirst := map[string]time.Time{"the-early-birds": at(0), "dusk": at(3)}
+	if err != nil || !reflect.DeepEqual(first, wantFirst) {
+		t.Errorf("firstPlayed() = %v, %v, want %v", first, err, wantFirst)
+	}
+	if times, err := st.playTimes("thomas"); err != nil || !reflect.DeepEqual(times, []time.Time{at(0), at(3), at(10)}) {
+		t.Errorf("playTimes() = %v, %v", times, err)
+	}
+}
+
+func Test_store_genres(t *testing.T) {
+	st := newTestStore(t)
+	if _, err := st.add("thomas", []recordedPlay{play("coffee", 3, "The Early Birds", "Dusk")}); err != nil {
+		t.Fatal(err)
+	}
+	if unknown, err := st.unknownArtists([]spotify.ID{"the-early-birds", "dusk"}); err != nil || len(unknown) != 2 {
+		t.Errorf("unknownArtists() before saving = %v, %v, want both artists", unknown, err)
+	}
+	if err := st.saveGenres(map[spotify.ID][]string{"the-early-birds": {"indie", "folk"}, "dusk": nil, "traffic": {"rock"}}); err != nil {
+		t.Fatal(err)
+	}
+	if unknown, err := st.unknownArtists([]spotify.ID{"the-early-birds", "dusk", "traffic", "anonymous"}); err != nil || !reflect.DeepEqual(unknown, []spotify.ID{"anonymous"}) {
+		t.Errorf("unknownArtists() = %v, %v, want [anonymous]", unknown, err)
+	}
+
+	genres, err := st.genres("thomas")
+	want := map[spotify.ID][]string{"the-early-birds": {"indie", "folk"}, "dusk": {}}
+	if err != nil || !reflect.DeepEqual(genres, want) {
+		t.Errorf("genres() = %v, %v, want the genres of the artists played %v", genres, err, want)
+	}
+}
+
+func Test_store_registrations(t *testing.T) {
+	st := newTestStore(t)
+	for _, r := range []struct{ userID, token string }{{"thomas", "old"}, {"thomas", "new"}, {"alice", "token"}, {"bob", ""}} {
+		if err := st.saveRegistration(r.userID, r.token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.deleteRegistration("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.deleteRegistration("unknown"); err != nil {
+		t.Errorf("deleteRegistration() of an unknown user error = %v", err)
+	}
+
+	got, err := st.registrations()
+	want := map[string]string{"thomas": "new", "bob": ""}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("registrations() = %v, %v, want %v", got, err, want)
+	}
+}
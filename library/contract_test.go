@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"common/openapi"
+	"github.com/zmb3/spotify"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	tracks := spotify.SavedTrackPage{Tracks: []spotify.SavedTrack{{AddedAt: "2021-01-02T08:00:00Z", FullTrack: sunrise}}}
+	albums := spotify.SavedAlbumPage{Albums: []spotify.SavedAlbum{{AddedAt: "2021-01-02T08:00:00Z", FullAlbum: spotify.FullAlbum{SimpleAlbum: spotify.SimpleAlbum{Name: "Dawn", ID: "dawn", URI: "spotify:album:dawn"}}}}}
+	artists := spotify.FullArtistCursorPage{Artists: []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "Dusk", ID: "dusk", URI: "spotify:artist:dusk"}}}}
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		handler http.HandlerFunc
+		client  *mockSpotifyClient
+	}{
+		{name: "should document the saved tracks", method: "GET", target: "/library/tracks?limit=10", handler: savedTracksHandler, client: &mockSpotifyClient{tracks: tracks}},
+		{name: "should document no saved tracks", method: "GET", target: "/library/tracks", handler: savedTracksHandler, client: &mockSpotifyClient{}},
+		{name: "should document the saved albums", method: "GET", target: "/library/albums?offset=10", handler: savedAlbumsHandler, client: &mockSpotifyClient{albums: albums}},
+		{name: "should document the followed artists", method: "GET", target: "/library/artists?after=birds", handler: followedArtistsHandler, client: &mockSpotifyClient{artists: artists}},
+		{name: "should document saving tracks", method: "PUT", target: "/library/tracks", body: `{"ids":["sunrise"]}`, handler: modifyTracksHandler, client: &mockSpotifyClient{}},
+		{name: "should document removing tracks", method: "DELETE", target: "/library/tracks", body: `{"ids":["sunrise"]}`, handler: modifyTracksHandler, client: &mockSpotifyClient{}},
+		{name: "should document the saved tracks check", method: "GET", target: "/library/tracks/contains?ids=sunrise,coffee", handler: containsTracksHandler, client: &mockSpotifyClient{saved: map[spotify.ID]bool{"sunrise": true}}},
+		{name: "should document liking the current track", method: "PUT", target: "/library/tracks/current", handler: likeCurrentTrackHandler, client: &mockSpotifyClient{player: spotify.CurrentlyPlaying{Item: &sunrise, Playing: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := libraryRequest(tt.method, tt.target, tt.body, tt.client)
+			rr := httptest.NewRecorder()
+			spec.Middleware(tt.handler).ServeHTTP(rr, r)
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse(tt.method, r.URL.Path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_invalidRequest(t *testing.T) {
+	spec := openapi.MustLoad()
+	accepted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: "GET", target: "/library/tracks?limit=0"},
+		{method: "GET", target: "/library/albums?offset=-1"},
+		{method: "PUT", target: "/library/tracks", body: `{"ids":[]}`},
+		{method: "DELETE", target: "/library/tracks", body: `{"tracks":["sunrise"]}`},
+		{method: "GET", target: "/library/tracks/contains?ids=sun%20rise"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		spec.Middleware(accepted).ServeHTTP(rr, libraryRequest(tt.method, tt.target, tt.body, &mockSpotifyClient{}))
+		if res := rr.Code; res != http.StatusBadRequest {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v", tt.method, tt.target, res, http.StatusBadRequest)
+		}
+	}
+}
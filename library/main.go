@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/config"
+	"common/health"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// Key type of spotify client context
+type key int
+
+// CLIENT_CONTEXT is the key used for the spotify client context
+var CLIENT_CONTEXT = key(1)
+
+const (
+	// maxLimit is the largest page of the library spotify answers
+	maxLimit = 50
+	// batchSize is the number of tracks spotify saves, removes or checks in a single call
+	batchSize = 50
+	// maxIDs is the number of tracks a single request can save, remove or check
+	maxIDs = 500
+)
+
+// errInvalidTracks is returned when spotify rejects the ids of the tracks
+var errInvalidTracks = errors.New("invalid tracks")
+
+// errNothingPlaying is returned when spotify has no music currently playing
+var errNothingPlaying = errors.New("nothing is playing")
+
+// spotifyClient interface of spotify client
+type spotifyClient interface {
+	CurrentUsersTracksOpt(opt *spotify.Options) (*spotify.SavedTrackPage, error)
+	CurrentUsersAlbumsOpt(opt *spotify.Options) (*spotify.SavedAlbumPage, error)
+	CurrentUsersFollowedArtistsOpt(limit int, after string) (*spotify.FullArtistCursorPage, error)
+	AddTracksToLibrary(ids ...spotify.ID) error
+	RemoveTracksFromLibrary(ids ...spotify.ID) error
+	UserHasTracks(ids ...spotify.ID) ([]bool, error)
+	PlayerCurrentlyPlaying() (*spotify.CurrentlyPlaying, error)
+}
+
+// queryInt reads a positive integer query parameter, it is 0 when missing
+func queryInt(r *http.Request, name string, max int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 || max > 0 && value > max {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return value, nil
+}
+
+// pageOptions reads the limit and offset query parameters, spotify uses its defaults for the missing ones
+func pageOptions(r *http.Request) (*spotify.Options, error) {
+	limit, err := queryInt(r, "limit", maxLimit)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	opt := &spotify.Options{}
+	if limit > 0 {
+		opt.Limit = &limit
+	}
+	if offset > 0 {
+		opt.Offset = &offset
+	}
+	return opt, nil
+}
+
+// tracksRequest is the body of the requests saving or removing tracks
+type tracksRequest struct {
+	IDs []spotify.ID `json:"ids"`
+}
+
+// checkIDs checks the number of ids and that none of them would break the comma separated list sent to spotify
+func checkIDs(ids []spotify.ID) error {
+	if len(ids) == 0 || len(ids) > maxIDs {
+		return fmt.Errorf("between 1 and %d ids are expected, got %d", maxIDs, len(ids))
+	}
+	for _, id := range ids {
+		if id == "" || strings.ContainsAny(string(id), ",?&/") {
+			return fmt.Errorf("invalid id %q", id)
+		}
+	}
+	return nil
+}
+
+// inBatches calls spotify for each batch of ids
+// The batches already sent are not rolled back when one fails
+func inBatches(ids []spotify.ID, call func(ids ...spotify.ID) error) error {
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := call(ids[start:end]...); err != nil {
+			return spotifyError(err)
+		}
+	}
+	return nil
+}
+
+// spotifyError maps the ids rejected by spotify to errInvalidTracks
+func spotifyError(err error) error {
+	var serr spotify.Error
+	if errors.As(err, &serr) && serr.Status == http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", errInvalidTracks, serr.Message)
+	}
+	return err
+}
+
+// savedTracksHandler is the handler to get a page of the saved tracks
+func savedTracksHandler(w http.ResponseWriter, r *http.Request) {
+	opt, err := pageOptions(r)
+	if err != nil {
+		log.WithError(err).Error("savedTracksHandler: could not read page")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	page, err := client.CurrentUsersTracksOpt(opt)
+	if err != nil {
+		log.WithError(err).Error("savedTracksHandler: could not get saved tracks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.ReduceSavedTracks(page))
+}
+
+// savedAlbumsHandler is the handler to get a page of the saved albums
+func savedAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	opt, err := pageOptions(r)
+	if err != nil {
+		log.WithError(err).Error("savedAlbumsHandler: could not read page")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	page, err := client.CurrentUsersAlbumsOpt(opt)
+	if err != nil {
+		log.WithError(err).Error("savedAlbumsHandler: could not get saved albums")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.ReduceSavedAlbums(page))
+}
+
+// followedArtistsHandler is the handler to get a page of the followed artists
+// The artists are paged with the after cursor given by the previous page instead of an offset
+func followedArtistsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", maxLimit)
+	if err != nil {
+		log.WithError(err).Error("followedArtistsHandler: could not read page")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = -1
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	page, err := client.CurrentUsersFollowedArtistsOpt(limit, r.URL.Query().Get("after"))
+	if err != nil {
+		log.WithError(err).Error("followedArtistsHandler: could not get followed artists")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.ReduceFollowedArtists(page))
+}
+
+// modifyTracksHandler is the handler to save the tracks of the body with PUT or to remove them with DELETE
+// Spotify takes at most 50 tracks at once so they are sent in batches
+func modifyTracksHandler(w http.ResponseWriter, r *http.Request) {
+	var body tracksRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		log.WithError(err).Error("modifyTracksHandler: could not decode tracks request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := checkIDs(body.IDs); err != nil {
+		log.WithError(err).Error("modifyTracksHandler: invalid tracks request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	modify := client.AddTracksToLibrary
+	if r.Method == http.MethodDelete {
+		modify = client.RemoveTracksFromLibrary
+	}
+	err := inBatches(body.IDs, modify)
+	if errors.Is(err, errInvalidTracks) {
+		log.WithError(err).Warn("modifyTracksHandler: spotify rejected the tracks")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("modifyTracksHandler: could not modify saved tracks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// containsTracksHandler is the handler telling for each id of the ids query parameter if the track is saved
+func containsTracksHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []spotify.ID
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, spotify.ID(id))
+		}
+	}
+	if err := checkIDs(ids); err != nil {
+		log.WithError(err).Error("containsTracksHandler: invalid ids")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	contains := map[spotify.ID]bool{}
+	err := inBatches(ids, func(batch ...spotify.ID) error {
+		saved, err := client.UserHasTracks(batch...)
+		if err != nil {
+			return err
+		}
+		for i, id := range batch {
+			contains[id] = i < len(saved) && saved[i]
+		}
+		return nil
+	})
+	if errors.Is(err, errInvalidTracks) {
+		log.WithError(err).Warn("containsTracksHandler: spotify rejected the tracks")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("containsTracksHandler: could not check saved tracks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(contains)
+}
+
+// likeCurrentTrack saves the track currently playing and returns it
+func likeCurrentTrack(client spotifyClient) (models.Track, error) {
+	current, err := client.PlayerCurrentlyPlaying()
+	if err != nil {
+		return models.Track{}, err
+	}
+	if current.Item == nil {
+		return models.Track{}, errNothingPlaying
+	}
+	player := models.ReducePlayer(current)
+	if err := client.AddTracksToLibrary(player.ID); err != nil {
+		return models.Track{}, err
+	}
+	return models.ReduceTrack(*current.Item), nil
+}
+
+// likeCurrentTrackHandler is the handler saving the track currently playing
+func likeCurrentTrackHandler(w http.ResponseWriter, r *http.Request) {
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	track, err := likeCurrentTrack(client)
+	if errors.Is(err, errNothingPlaying) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("likeCurrentTrackHandler: could not save current track")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(track)
+}
+
+// tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := r.Header.Get("Authorization")
+		client := factory.Client(bearer)
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("library", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.HandleFunc("/library/tracks", savedTracksHandler).Methods("GET")
+	r.HandleFunc("/library/tracks", modifyTracksHandler).Methods("PUT", "DELETE")
+	r.HandleFunc("/library/tracks/contains", containsTracksHandler).Methods("GET")
+	r.HandleFunc("/library/tracks/current", likeCurrentTrackHandler).Methods("PUT")
+	r.HandleFunc("/library/albums", savedAlbumsHandler).Methods("GET")
+	r.HandleFunc("/library/artists", followedArtistsHandler).Methods("GET")
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.Run(contextedMux, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+type mockSpotifyClient struct {
+	err     error
+	tracks  spotify.SavedTrackPage
+	albums  spotify.SavedAlbumPage
+	artists spotify.FullArtistCursorPage
+	saved   map[spotify.ID]bool
+	player  spotify.CurrentlyPlaying
+	opt     *spotify.Options
+	limit   int
+	after   string
+	batches [][]spotify.ID
+	added   []spotify.ID
+	removed []spotify.ID
+}
+
+func (c *mockSpotifyClient) CurrentUsersTracksOpt(opt *spotify.Options) (*spotify.SavedTrackPage, error) {
+	c.opt = opt
+	return &c.tracks, c.err
+}
+
+func (c *mockSpotifyClient) CurrentUsersAlbumsOpt(opt *spotify.Options) (*spotify.SavedAlbumPage, error) {
+	c.opt = opt
+	return &c.albums, c.err
+}
+
+func (c *mockSpotifyClient) CurrentUsersFollowedArtistsOpt(limit int, after string) (*spotify.FullArtistCursorPage, error) {
+	c.limit, c.after = limit, after
+	return &c.artists, c.err
+}
+
+func (c *mockSpotifyClient) AddTracksToLibrary(ids ...spotify.ID) error {
+	c.batches = append(c.batches, ids)
+	c.added = append(c.added, ids...)
+	return c.err
+}
+
+func (c *mockSpotifyClient) RemoveTracksFromLibrary(ids ...spotify.ID) error {
+	c.batches = append(c.batches, ids)
+	c.removed = append(c.removed, ids...)
+	return c.err
+}
+
+func (c *mockSpotifyClient) UserHasTracks(ids ...spotify.ID) ([]bool, error) {
+	c.batches = append(c.batches, ids)
+	var contains []bool
+	for _, id := range ids {
+		contains = append(contains, c.saved[id])
+	}
+	return contains, c.err
+}
+
+func (c *mockSpotifyClient) PlayerCurrentlyPlaying() (*spotify.CurrentlyPlaying, error) {
+	return &c.player, c.err
+}
+
+// sunrise is the track saved in the library of the mock
+var sunrise = spotify.FullTrack{
+	SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise", URI: "spotify:track:sunrise", Artists: []spotify.SimpleArtist{{Name: "The Early Birds"}}},
+	Album:       spotify.SimpleAlbum{Name: "Dawn"},
+}
+
+// libraryRequest creates a request sent with the spotify client
+func libraryRequest(method, target, body string, client spotifyClient) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	return r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+}
+
+// ids returns n track ids
+func ids(n int) []spotify.ID {
+	var ids []spotify.ID
+	for i := 0; i < n; i++ {
+		ids = append(ids, spotify.ID(fmt.Sprintf("track%d", i)))
+	}
+	return ids
+}
+
+func Test_savedTracksHandler(t *testing.T) {
+	page := spotify.SavedTrackPage{Tracks: []spotify.SavedTrack{{AddedAt: "2021-01-02T08:00:00Z", FullTrack: sunrise}}}
+	page.Total = 1
+	tests := []struct {
+		name         string
+		target       string
+		err          error
+		expectedCode int
+		expectedOpt  *spotify.Options
+	}{
+		{name: "should get the saved tracks", target: "/library/tracks", expectedCode: http.StatusOK, expectedOpt: &spotify.Options{}},
+		{name: "should get a page of the saved tracks", target: "/library/tracks?limit=10&offset=20", expectedCode: http.StatusOK, expectedOpt: &spotify.Options{Limit: intPtr(10), Offset: intPtr(20)}},
+		{name: "should reject a too large page", target: "/library/tracks?limit=100", expectedCode: http.StatusBadRequest},
+		{name: "should reject an invalid offset", target: "/library/tracks?offset=first", expectedCode: http.StatusBadRequest},
+		{name: "should fail when spotify fails", target: "/library/tracks", err: errors.New("boom"), expectedCode: http.StatusInternalServerError, expectedOpt: &spotify.Options{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{tracks: page, err: tt.err}
+			rr := httptest.NewRecorder()
+			savedTracksHandler(rr, libraryRequest("GET", tt.target, "", client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if !reflect.DeepEqual(client.opt, tt.expectedOpt) {
+				t.Errorf("handler sent wrong options: got %+v want %+v", client.opt, tt.expectedOpt)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var got models.SavedTracks
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.Total != 1 || got.Items[0].ID != "sunrise" || got.Items[0].AddedAt != "2021-01-02T08:00:00Z" {
+				t.Errorf("handler returned unexpected body: %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func Test_savedAlbumsHandler(t *testing.T) {
+	page := spotify.SavedAlbumPage{Albums: []spotify.SavedAlbum{{AddedAt: "2021-01-02T08:00:00Z", FullAlbum: spotify.FullAlbum{SimpleAlbum: spotify.SimpleAlbum{Name: "Dawn", ID: "dawn"}}}}}
+	page.Total = 1
+	client := &mockSpotifyClient{albums: page}
+	rr := httptest.NewRecorder()
+	savedAlbumsHandler(rr, libraryRequest("GET", "/library/albums?limit=5", "", client))
+	if res := rr.Code; res != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+	}
+	var got models.SavedAlbums
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.Total != 1 || got.Items[0].Name != "Dawn" || *client.opt.Limit != 5 {
+		t.Errorf("handler returned unexpected body: %+v, %v", got, err)
+	}
+}
+
+func Test_followedArtistsHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		target        string
+		expectedCode  int
+		expectedLimit int
+		expectedAfter string
+	}{
+		{name: "should get the first page with the default size", target: "/library/artists", expectedCode: http.StatusOK, expectedLimit: -1},
+		{name: "should get the page after the cursor", target: "/library/artists?limit=1&after=birds", expectedCode: http.StatusOK, expectedLimit: 1, expectedAfter: "birds"},
+		{name: "should reject a too large page", target: "/library/artists?limit=51", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{artists: spotify.FullArtistCursorPage{Artists: []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "Dusk", ID: "dusk"}}}}}
+			rr := httptest.NewRecorder()
+			followedArtistsHandler(rr, libraryRequest("GET", tt.target, "", client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if client.limit != tt.expectedLimit || client.after != tt.expectedAfter {
+				t.Errorf("handler sent wrong page: got %v %q want %v %q", client.limit, client.after, tt.expectedLimit, tt.expectedAfter)
+			}
+		})
+	}
+}
+
+func Test_modifyTracksHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		body            string
+		err             error
+		expectedCode    int
+		expectedBatches []int
+	}{
+		{name: "should save the tracks", method: "PUT", body: `{"ids":["sunrise","coffee"]}`, expectedCode: http.StatusOK, expectedBatches: []int{2}},
+		{name: "should remove the tracks", method: "DELETE", body: `{"ids":["sunrise"]}`, expectedCode: http.StatusOK, expectedBatches: []int{1}},
+		{name: "should save the tracks in batches of 50", method: "PUT", body: idsBody(120), expectedCode: http.StatusOK, expectedBatches: []int{50, 50, 20}},
+		{name: "should reject too many tracks", method: "PUT", body: idsBody(501), expectedCode: http.StatusBadRequest},
+		{name: "should reject no tracks", method: "PUT", body: `{"ids":[]}`, expectedCode: http.StatusBadRequest},
+		{name: "should reject an id breaking the list", method: "PUT", body: `{"ids":["sunrise,coffee"]}`, expectedCode: http.StatusBadRequest},
+		{name: "should reject an invalid body", method: "PUT", body: `{"ids":`, expectedCode: http.StatusBadRequest},
+		{name: "should reject the tracks rejected by spotify", method: "PUT", body: `{"ids":["unknown"]}`, err: spotify.Error{Status: http.StatusBadRequest, Message: "invalid id"}, expectedCode: http.StatusBadRequest, expectedBatches: []int{1}},
+		{name: "should fail when spotify fails", method: "DELETE", body: `{"ids":["sunrise"]}`, err: errors.New("boom"), expectedCode: http.StatusInternalServerError, expectedBatches: []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{err: tt.err}
+			rr := httptest.NewRecorder()
+			modifyTracksHandler(rr, libraryRequest(tt.method, "/library/tracks", tt.body, client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			var batches []int
+			for _, b := range client.batches {
+				batches = append(batches, len(b))
+			}
+			if !reflect.DeepEqual(batches, tt.expectedBatches) {
+				t.Errorf("handler sent wrong batches: got %v want %v", batches, tt.expectedBatches)
+			}
+			if tt.method == "DELETE" && len(client.added) > 0 || tt.method == "PUT" && len(client.removed) > 0 {
+				t.Errorf("handler called the wrong spotify endpoint: added %v removed %v", client.added, client.removed)
+			}
+		})
+	}
+}
+
+func Test_containsTracksHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		expectedCode int
+		expected     map[spotify.ID]bool
+	}{
+		{name: "should tell which tracks are saved", target: "/library/tracks/contains?ids=sunrise,coffee", expectedCode: http.StatusOK, expected: map[spotify.ID]bool{"sunrise": true, "coffee": false}},
+		{name: "should require ids", target: "/library/tracks/contains", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{saved: map[spotify.ID]bool{"sunrise": true}}
+			rr := httptest.NewRecorder()
+			containsTracksHandler(rr, libraryRequest("GET", tt.target, "", client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var got map[spotify.ID]bool
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("handler returned unexpected body: got %v want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_containsTracksHandler_batches(t *testing.T) {
+	all := ids(60)
+	var query []string
+	for _, id := range all {
+		query = append(query, string(id))
+	}
+	client := &mockSpotifyClient{saved: map[spotify.ID]bool{all[55]: true}}
+	rr := httptest.NewRecorder()
+	containsTracksHandler(rr, libraryRequest("GET", "/library/tracks/contains?ids="+strings.Join(query, ","), "", client))
+	if res := rr.Code; res != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+	}
+	var got map[spotify.ID]bool
+	json.NewDecoder(rr.Body).Decode(&got)
+	if len(client.batches) != 2 || len(client.batches[0]) != 50 || !got[all[55]] || got[all[0]] {
+		t.Errorf("handler checked wrong batches: %v, got %v", client.batches, got)
+	}
+}
+
+func Test_likeCurrentTrackHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		player        spotify.CurrentlyPlaying
+		err           error
+		expectedCode  int
+		expectedSaved []spotify.ID
+	}{
+		{name: "should save the current track", player: spotify.CurrentlyPlaying{Item: &sunrise, Playing: true}, expectedCode: http.StatusOK, expectedSaved: []spotify.ID{"sunrise"}},
+		{name: "should not save anything when nothing is playing", expectedCode: http.StatusNotFound},
+		{name: "should fail when spotify fails", err: errors.New("boom"), expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{player: tt.player, err: tt.err}
+			rr := httptest.NewRecorder()
+			likeCurrentTrackHandler(rr, libraryRequest("PUT", "/library/tracks/current", "", client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if !reflect.DeepEqual(client.added, tt.expectedSaved) {
+				t.Errorf("handler saved wrong tracks: got %v want %v", client.added, tt.expectedSaved)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var got models.Track
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.Name != "Sunrise" {
+				t.Errorf("handler returned unexpected body: %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+// idsBody returns the body of a request with n tracks
+func idsBody(n int) string {
+	body, _ := json.Marshal(tracksRequest{IDs: ids(n)})
+	return string(body)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+
+	"common/jsonfile"
+)
+
+// scrobbleAccount is the scrobbling account registered by a user, Token is the user token of listenbrainz or the session key of lastfm
+// SpotifyToken is the last access token of the user, empty once rejected by spotify until the gateway validates a new one
+type scrobbleAccount struct {
+	Token        string `json:"token"`
+	SpotifyToken string `json:"spotify_token,omitempty"`
+}
+
+// accountStore keeps the scrobbling account of each user in a json file
+// The file is rewritten on every change
+type accountStore struct {
+	path string
+
+	mu       sync.Mutex
+	accounts map[string]scrobbleAccount
+}
+
+// openAccountStore loads the accounts kept in the file at path, there is none when the file does not exist
+func openAccountStore(path string) (*accountStore, error) {
+	s := &accountStore{path: path, accounts: map[string]scrobbleAccount{}}
+	if err := jsonfile.Read(path, &s.accounts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// get returns the account of the user
+func (s *accountStore) get(userID string) (scrobbleAccount, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account, ok := s.accounts[userID]
+	return account, ok
+}
+
+// update changes the account of the user in place, an account is created when there is none
+func (s *accountStore) update(userID string, change func(*scrobbleAccount)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := make(map[string]scrobbleAccount, len(s.accounts)+1)
+	for id, account := range s.accounts {
+		accounts[id] = account
+	}
+	account := accounts[userID]
+	change(&account)
+	accounts[userID] = account
+	if err := jsonfile.Write(s.path, accounts); err != nil {
+		return err
+	}
+	s.accounts = accounts
+	return nil
+}
+
+// remove deletes the account of the user
+func (s *accountStore) remove(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[userID]; !ok {
+		return nil
+	}
+	accounts := make(map[string]scrobbleAccount, len(s.accounts))
+	for id, account := range s.accounts {
+		if id != userID {
+			accounts[id] = account
+		}
+	}
+	if err := jsonfile.Write(s.path, accounts); err != nil {
+		return err
+	}
+	s.accounts = accounts
+	return nil
+}
+
+// spotifyTokens returns the users with an account and a valid access token along with it
+func (s *accountStore) spotifyTokens() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := map[string]string{}
+	for userID, account := range s.accounts {
+		if account.SpotifyToken != "" {
+			tokens[userID] = account.SpotifyToken
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_accountStore_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrobble-accounts.json")
+	s, err := openAccountStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.update("thomas", func(account *scrobbleAccount) {
+		account.Token, account.SpotifyToken = "thomas-scrobble", "thomas-spotify"
+	})
+	s.update("alice", func(account *scrobbleAccount) { account.Token = "alice-scrobble" })
+	s.update("bob", func(account *scrobbleAccount) { account.Token = "bob-scrobble" })
+	s.remove("bob")
+
+	s, err = openAccountStore(path)
+	if err != nil {
+		t.Fatalf("openAccountStore() of existing accounts error = %v", err)
+	}
+	want := map[string]scrobbleAccount{"thomas": {Token: "thomas-scrobble", SpotifyToken: "thomas-spotify"}, "alice": {Token: "alice-scrobble"}}
+	if !reflect.DeepEqual(s.accounts, want) {
+		t.Errorf("openAccountStore() = %+v, want %+v", s.accounts, want)
+	}
+	if tokens := s.spotifyTokens(); !reflect.DeepEqual(tokens, map[string]string{"thomas": "thomas-spotify"}) {
+		t.Errorf("spotifyTokens() = %v, want the access token of thomas only", tokens)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("the store left temporary files %v", matches)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"common/openapi"
+	"github.com/zmb3/spotify"
+)
+
+// playing is a player currently playing a music
+var playing = spotify.CurrentlyPlaying{
+	Playing:  true,
+	Progress: 42,
+	Item: &spotify.FullTrack{
+		Album:       spotify.SimpleAlbum{Name: "album", ReleaseDate: "2020-12-15", ReleaseDatePrecision: "day"},
+		SimpleTrack: spotify.SimpleTrack{Name: "test", ID: "id", Duration: 1000, Artists: []spotify.SimpleArtist{{Name: "artist name"}}},
+	},
+}
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	scrobbles := newTestScrobbler(t, &recordingScrobbleAPI{}, newTestQueue(t))
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		handler      http.HandlerFunc
+		player       spotify.CurrentlyPlaying
+		devices      []spotify.PlayerDevice
+		expectedCode int
+	}{
+		{name: "should document the player", method: "GET", path: "/player", handler: playerHandler, player: playing, expectedCode: http.StatusOK},
+		{name: "should document the player without artists", method: "GET", path: "/player", handler: playerHandler, player: spotify.CurrentlyPlaying{Item: &spotify.FullTrack{}}, expectedCode: http.StatusOK},
+		{name: "should document nothing playing", method: "GET", path: "/player", handler: playerHandler, expectedCode: http.StatusNoContent},
+		{name: "should document play", method: "POST", path: "/player/play", body: `{"uri":"spotify:playlist:morning"}`, handler: playMusicHandler, expectedCode: http.StatusOK},
+		{name: "should document an invalid play request", method: "POST", path: "/player/play", body: `{"uri":`, handler: playMusicHandler, expectedCode: http.StatusBadRequest},
+		{name: "should document pause", method: "POST", path: "/player/pause", body: `{}`, handler: pauseMusicHandler, expectedCode: http.StatusOK},
+		{name: "should document next", method: "POST", path: "/player/next", body: `{}`, handler: nextMusicHandler, expectedCode: http.StatusOK},
+		{name: "should document prev", method: "POST", path: "/player/prev", body: `{}`, handler: prevMusicHandler, expectedCode: http.StatusOK},
+		{name: "should document the devices", method: "GET", path: "/player/devices", handler: devicesHandler, devices: []spotify.PlayerDevice{{ID: "id", Name: "speaker", Type: "Speaker", Volume: 50}}, expectedCode: http.StatusOK},
+		{name: "should document the recommendations", method: "GET", path: "/recommendations?seed=player&limit=10&target_energy=0.8&target_popularity=60", handler: recommendationsHandler, player: playing, expectedCode: http.StatusOK},
+		{name: "should document the recommendations without seed", method: "GET", path: "/recommendations", handler: recommendationsHandler, expectedCode: http.StatusNotFound},
+		{name: "should document an invalid recommendations request", method: "GET", path: "/recommendations?target_energy=2", handler: recommendationsHandler, expectedCode: http.StatusBadRequest},
+		{name: "should document a radio without recommendations", method: "POST", path: "/player/radio", body: `{"seed":"top","mode":"playlist","targets":{"valence":0.9}}`, handler: radioHandler(nil, nil), expectedCode: http.StatusNotFound},
+		{name: "should document an invalid radio request", method: "POST", path: "/player/radio", body: `{"mode":"shuffle"}`, handler: radioHandler(nil, nil), expectedCode: http.StatusBadRequest},
+		{name: "should document the registration of a scrobbling account", method: "POST", path: "/player/scrobbling", body: `{"token":"thomas-scrobble"}`, handler: scrobblingHandler(scrobbles, "listenbrainz"), expectedCode: http.StatusOK},
+		{name: "should document an invalid scrobbling request", method: "POST", path: "/player/scrobbling", body: `{"token":""}`, handler: scrobblingHandler(scrobbles, "listenbrainz"), expectedCode: http.StatusBadRequest},
+		{name: "should document the scrobbling", method: "GET", path: "/player/scrobbling", handler: getScrobblingHandler(scrobbles, "listenbrainz"), expectedCode: http.StatusOK},
+		{name: "should document the scrobbling stopped", method: "DELETE", path: "/player/scrobbling", handler: unscrobblingHandler(scrobbles), expectedCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, &mockSpotifyClient{player: tt.player, devices: tt.devices}))
+			rr := httptest.NewRecorder()
+			spec.Middleware(tt.handler).ServeHTTP(rr, req)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if err := spec.ValidateResponse(tt.method, req.URL.Path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"common/models"
+	"common/server"
+	log "github.com/sirupsen/logrus"
+)
+
+// seekTolerance is the gap between the progress of the player and the expected one seen as a seek
+const seekTolerance = 3 * time.Second
+
+// eventsRetry is the reconnection delay given to the listeners of the event stream
+const eventsRetry = 3 * time.Second
+
+// eventsOptions are the settings of the player event stream
+type eventsOptions struct {
+	// interval is how often spotify is polled
+	interval time.Duration
+	// heartbeat is how often a comment is written so the proxies keep the stream open
+	heartbeat time.Duration
+}
+
+// playerChanged tells if the player changed in a way the listeners cannot guess
+// The progress only changes when it jumps (seek), the listeners advance it themselves while playing
+func playerChanged(prev models.Player, elapsed time.Duration, next models.Player) bool {
+	expected := prev.Progress
+	if prev.IsPlaying {
+		expected += int(elapsed / time.Millisecond)
+	}
+	if gap := time.Duration(next.Progress-expected) * time.Millisecond; gap > seekTolerance || gap < -seekTolerance {
+		return true
+	}
+	prev.Progress, next.Progress = 0, 0
+	return !reflect.DeepEqual(prev, next)
+}
+
+// writeEvent writes a server sent event with its json data
+func writeEvent(w io.Writer, name string, data interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, content)
+	return err
+}
+
+// streamPlayer polls spotify and writes the changes of the player on w until the context is done
+// The current state is written first: a player event, or nothing_playing; an error event is written once when spotify fails
+func streamPlayer(ctx context.Context, w io.Writer, flush func(), client spotifyClient, opts eventsOptions) {
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry/time.Millisecond)
+
+	var state string
+	var last models.Player
+	var lastAt time.Time
+	poll := func() error {
+		player, err := getPlayer(client)
+		now := time.Now()
+		switch {
+		case errors.Is(err, errNothingPlaying):
+			if state == "nothing_playing" {
+				return nil
+			}
+			state = "nothing_playing"
+			return writeEvent(w, state, struct{}{})
+		case err != nil:
+			log.WithError(err).Error("streamPlayer: could not get player")
+			if state == "error" {
+				return nil
+			}
+			state = "error"
+			return writeEvent(w, state, map[string]string{"message": "could not get the player"})
+		}
+		if state == "player" && !playerChanged(last, now.Sub(lastAt), player) {
+			return nil
+		}
+		state, last, lastAt = "player", player, now
+		return writeEvent(w, state, player)
+	}
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(opts.heartbeat)
+	defer heartbeat.Stop()
+	shuttingDown := server.ShuttingDown(ctx)
+	if err := poll(); err != nil {
+		return
+	}
+	flush()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shuttingDown:
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := poll(); err != nil {
+				return
+			}
+		}
+		flush()
+	}
+}
+
+// eventsHandler is the handler of the player event stream (server sent events)
+// Spotify does not push the changes of the player, so it is polled for each stream and only the changes are sent
+func eventsHandler(opts eventsOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Error("eventsHandler: the response cannot be streamed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		streamPlayer(r.Context(), w, flusher.Flush, client, opts)
+	}
+}
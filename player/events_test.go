@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+// sequenceSpotifyClient returns the players of the sequence one per call, the last one is then repeated
+type sequenceSpotifyClient struct {
+	mockSpotifyClient
+	mu      sync.Mutex
+	players []*spotify.CurrentlyPlaying
+	errs    []error
+	calls   int
+}
+
+func (c *sequenceSpotifyClient) PlayerCurrentlyPlaying() (*spotify.CurrentlyPlaying, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.calls
+	if i >= len(c.players) {
+		i = len(c.players) - 1
+	}
+	c.calls++
+	var err error
+	if i < len(c.errs) {
+		err = c.errs[i]
+	}
+	return c.players[i], err
+}
+
+// currentlyPlaying creates a spotify player playing the track
+func currentlyPlaying(id string, playing bool, progress int) *spotify.CurrentlyPlaying {
+	return &spotify.CurrentlyPlaying{
+		Playing:  playing,
+		Progress: progress,
+		Item:     &spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: spotify.ID(id), Name: id, Duration: 180000}},
+	}
+}
+
+func Test_playerChanged(t *testing.T) {
+	playing := models.Player{IsPlaying: true, ID: "sunrise", Progress: 10000}
+	tests := []struct {
+		name     string
+		prev     models.Player
+		elapsed  time.Duration
+		next     models.Player
+		expected bool
+	}{
+		{
+			name:    "should not change while the track plays",
+			prev:    playing,
+			elapsed: 2 * time.Second,
+			next:    models.Player{IsPlaying: true, ID: "sunrise", Progress: 12100},
+		},
+		{
+			name:    "should not change while paused",
+			prev:    models.Player{ID: "sunrise", Progress: 10000},
+			elapsed: time.Minute,
+			next:    models.Player{ID: "sunrise", Progress: 10000},
+		},
+		{
+			name:     "should change on a new track",
+			prev:     playing,
+			elapsed:  2 * time.Second,
+			next:     models.Player{IsPlaying: true, ID: "coffee", Progress: 1000},
+			expected: true,
+		},
+		{
+			name:     "should change on pause",
+			prev:     playing,
+			elapsed:  2 * time.Second,
+			next:     models.Player{ID: "sunrise", Progress: 12000},
+			expected: true,
+		},
+		{
+			name:     "should change on seek",
+			prev:     playing,
+			elapsed:  2 * time.Second,
+			next:     models.Player{IsPlaying: true, ID: "sunrise", Progress: 60000},
+			expected: true,
+		},
+		{
+			name:     "should change when the track restarts",
+			prev:     playing,
+			elapsed:  2 * time.Second,
+			next:     models.Player{IsPlaying: true, ID: "sunrise", Progress: 0},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := playerChanged(tt.prev, tt.elapsed, tt.next); got != tt.expected {
+				t.Errorf("playerChanged() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// readEvents reads the names of the first n events of the stream, the comments are named by their text
+func readEvents(t *testing.T, body *bufio.Reader, n int) []string {
+	t.Helper()
+	var events []string
+	for len(events) < n {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("could not read the stream after %v: %v", events, err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, ": "):
+			events = append(events, strings.TrimPrefix(line, ": "))
+		}
+	}
+	return events
+}
+
+func Test_eventsHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   *sequenceSpotifyClient
+		opts     eventsOptions
+		expected []string
+	}{
+		{
+			name: "should only send the changes of the player",
+			client: &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{
+				currentlyPlaying("sunrise", true, 1000),
+				currentlyPlaying("sunrise", true, 1000),
+				currentlyPlaying("sunrise", false, 1000),
+				currentlyPlaying("coffee", true, 0),
+				{},
+			}},
+			opts:     eventsOptions{interval: 5 * time.Millisecond, heartbeat: time.Hour},
+			expected: []string{"player", "player", "player", "nothing_playing"},
+		},
+		{
+			name: "should send an error once until spotify recovers",
+			client: &sequenceSpotifyClient{
+				players: []*spotify.CurrentlyPlaying{{}, nil, nil, currentlyPlaying("sunrise", true, 0)},
+				errs:    []error{nil, errors.New("spotify is down"), errors.New("spotify is down")},
+			},
+			opts:     eventsOptions{interval: 5 * time.Millisecond, heartbeat: time.Hour},
+			expected: []string{"nothing_playing", "error", "player"},
+		},
+		{
+			name:     "should send heartbeats",
+			client:   &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{currentlyPlaying("sunrise", false, 0)}},
+			opts:     eventsOptions{interval: time.Hour, heartbeat: 5 * time.Millisecond},
+			expected: []string{"player", "heartbeat", "heartbeat"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := eventsHandler(tt.opts)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler(w, r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, tt.client)))
+			}))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("handler returned wrong content type: got %v want %v", ct, "text/event-stream")
+			}
+			body := bufio.NewReader(resp.Body)
+			if retry, _ := body.ReadString('\n'); retry != "retry: 3000\n" {
+				t.Errorf("handler returned unexpected retry: %q", retry)
+			}
+			if got := readEvents(t, body, len(tt.expected)); strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("handler returned unexpected events: got %v want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_streamPlayer_stopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client := &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{currentlyPlaying("sunrise", true, 0)}}
+		streamPlayer(ctx, &strings.Builder{}, func() {}, client, eventsOptions{interval: time.Millisecond, heartbeat: time.Millisecond})
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("streamPlayer did not stop with its context")
+	}
+}
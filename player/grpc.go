@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"common/pb/playerpb"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// playerServer implements the grpc player service with the same logic as the http handlers
+type playerServer struct {
+	playerpb.UnimplementedPlayerServiceServer
+}
+
+// GetPlayer returns the current player
+func (s *playerServer) GetPlayer(ctx context.Context, req *playerpb.GetPlayerRequest) (*playerpb.Player, error) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	player, err := getPlayer(client)
+	if errors.Is(err, errNothingPlaying) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		log.WithError(err).Error("GetPlayer: could not get player currently playing")
+		return nil, status.Error(codes.Internal, "could not get player currently playing")
+	}
+	return playerpb.FromModel(player), nil
+}
+
+// Play plays a music, the current one when no uri is given
+func (s *playerServer) Play(ctx context.Context, req *playerpb.PlayRequest) (*playerpb.PlayResponse, error) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	if err := playMusic(client, spotify.URI(req.GetUri())); err != nil {
+		log.WithError(err).Error("Play: could not play music")
+		return nil, status.Error(codes.Internal, "could not play music")
+	}
+	return &playerpb.PlayResponse{}, nil
+}
+
+// Pause pauses the music
+func (s *playerServer) Pause(ctx context.Context, req *playerpb.PauseRequest) (*playerpb.PauseResponse, error) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	if err := client.Pause(); err != nil {
+		log.WithError(err).Error("Pause: could not pause music")
+		return nil, status.Error(codes.Internal, "could not pause music")
+	}
+	return &playerpb.PauseResponse{}, nil
+}
+
+// Next goes to the next music
+func (s *playerServer) Next(ctx context.Context, req *playerpb.NextRequest) (*playerpb.NextResponse, error) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	if err := client.Next(); err != nil {
+		log.WithError(err).Error("Next: could not play next music")
+		return nil, status.Error(codes.Internal, "could not play next music")
+	}
+	return &playerpb.NextResponse{}, nil
+}
+
+// Previous goes to the previous music
+func (s *playerServer) Previous(ctx context.Context, req *playerpb.PreviousRequest) (*playerpb.PreviousResponse, error) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	if err := client.Previous(); err != nil {
+		log.WithError(err).Error("Previous: could not play previous music")
+		return nil, status.Error(codes.Internal, "could not play previous music")
+	}
+	return &playerpb.PreviousResponse{}, nil
+}
+
+// tokenInterceptor will retrieve the token from the authorization metadata and add the spotify client in the call context
+func tokenInterceptor(newClient func(token string) spotifyClient) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var bearer string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			bearer = md.Get("authorization")[0]
+		}
+		return handler(context.WithValue(ctx, CLIENT_CONTEXT, newClient(bearer)), req)
+	}
+}
+
+// newGRPCServer creates the grpc server of the player service along with the grpc health service
+func newGRPCServer(newClient func(token string) spotifyClient) *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(tokenInterceptor(newClient)))
+	playerpb.RegisterPlayerServiceServer(srv, &playerServer{})
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	return srv
+}
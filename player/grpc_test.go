@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"common/models"
+	"common/pb/playerpb"
+	"github.com/zmb3/spotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCServer serves the grpc API in memory, every call uses the given client
+// The tokens received are appended to tokens
+func startGRPCServer(t *testing.T, client spotifyClient, tokens *[]string) playerpb.PlayerServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(func(token string) spotifyClient {
+		*tokens = append(*tokens, token)
+		return client
+	})
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return ln.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return playerpb.NewPlayerServiceClient(conn)
+}
+
+// grpcCode returns the grpc code matching the http status of the REST API
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusNoContent:
+		return codes.NotFound
+	default:
+		return codes.Internal
+	}
+}
+
+func Test_grpcParity_getPlayer(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *mockSpotifyClient
+	}{
+		{
+			name: "should get the same player",
+			client: &mockSpotifyClient{player: spotify.CurrentlyPlaying{
+				Playing:  true,
+				Progress: 42,
+				Item: &spotify.FullTrack{
+					Album: spotify.SimpleAlbum{Name: "album", ReleaseDate: "2020-12-15", ReleaseDatePrecision: "day"},
+					SimpleTrack: spotify.SimpleTrack{
+						Name:     "test",
+						ID:       "id",
+						Duration: 1000,
+						Artists:  []spotify.SimpleArtist{{Name: "artist name"}, {Name: "thomas"}},
+					},
+				},
+			}},
+		},
+		{
+			name:   "should both report nothing playing",
+			client: &mockSpotifyClient{},
+		},
+		{
+			name:   "should both fail on spotify api call",
+			client: &mockSpotifyClient{err: errors.New("could not fetch player")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/player", nil)
+			rr := httptest.NewRecorder()
+			playerHandler(rr, req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, tt.client)))
+			var restPlayer models.Player
+			if rr.Code == http.StatusOK {
+				if err := json.NewDecoder(rr.Body).Decode(&restPlayer); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var tokens []string
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+			grpcPlayer, err := startGRPCServer(t, tt.client, &tokens).GetPlayer(ctx, &playerpb.GetPlayerRequest{})
+			if code := status.Code(err); code != grpcCode(rr.Code) {
+				t.Fatalf("grpc returned wrong code: got %v want %v (http %v)", code, grpcCode(rr.Code), rr.Code)
+			}
+			if !reflect.DeepEqual(tokens, []string{"Bearer token"}) {
+				t.Errorf("grpc client was not created with the token: got %v", tokens)
+			}
+			if err == nil && !reflect.DeepEqual(grpcPlayer.ToModel(), restPlayer) {
+				t.Errorf("grpc returned a different player: got %+v want %+v", grpcPlayer.ToModel(), restPlayer)
+			}
+		})
+	}
+}
+
+func Test_grpcParity_controls(t *testing.T) {
+	type call struct {
+		handler http.HandlerFunc
+		body    string
+		grpc    func(c playerpb.PlayerServiceClient) error
+	}
+	calls := map[string]call{
+		"play": {playMusicHandler, `{"uri":"spotify:playlist:morning"}`, func(c playerpb.PlayerServiceClient) error {
+			_, err := c.Play(context.Background(), &playerpb.PlayRequest{Uri: "spotify:playlist:morning"})
+			return err
+		}},
+		"pause": {pauseMusicHandler, "", func(c playerpb.PlayerServiceClient) error {
+			_, err := c.Pause(context.Background(), &playerpb.PauseRequest{})
+			return err
+		}},
+		"next": {nextMusicHandler, "", func(c playerpb.PlayerServiceClient) error {
+			_, err := c.Next(context.Background(), &playerpb.NextRequest{})
+			return err
+		}},
+		"prev": {prevMusicHandler, "", func(c playerpb.PlayerServiceClient) error {
+			_, err := c.Previous(context.Background(), &playerpb.PreviousRequest{})
+			return err
+		}},
+	}
+	for name, c := range calls {
+		for _, spotifyErr := range []error{nil, errors.New("spotify error")} {
+			t.Run(name, func(t *testing.T) {
+				client := &mockSpotifyClient{err: spotifyErr}
+				req := httptest.NewRequest(http.MethodPost, "/player/"+name, strings.NewReader(c.body))
+				rr := httptest.NewRecorder()
+				c.handler(rr, req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, client)))
+
+				var tokens []string
+				err := c.grpc(startGRPCServer(t, client, &tokens))
+				if code := status.Code(err); code != grpcCode(rr.Code) {
+					t.Errorf("grpc returned wrong code: got %v want %v (http %v)", code, grpcCode(rr.Code), rr.Code)
+				}
+			})
+		}
+	}
+}
+
+func Test_playMusic(t *testing.T) {
+	client := &playRecorder{}
+	if err := playMusic(client, "spotify:playlist:morning"); err != nil {
+		t.Fatal(err)
+	}
+	if client.opt.PlaybackContext == nil || *client.opt.PlaybackContext != "spotify:playlist:morning" {
+		t.Errorf("playMusic did not play the context: got %+v", client.opt)
+	}
+	if err := playMusic(client, ""); err != nil {
+		t.Fatal(err)
+	}
+	if client.opt.PlaybackContext != nil {
+		t.Errorf("playMusic should resume without context: got %v", *client.opt.PlaybackContext)
+	}
+	if err := playMusic(client, "spotify:track:sunrise"); err != nil {
+		t.Fatal(err)
+	}
+	if client.opt.PlaybackContext != nil || len(client.opt.URIs) != 1 || client.opt.URIs[0] != "spotify:track:sunrise" {
+		t.Errorf("playMusic should play the track on its own: got %+v", client.opt)
+	}
+}
+
+// playRecorder records the options of the last play
+type playRecorder struct {
+	mockSpotifyClient
+	opt *spotify.PlayOptions
+}
+
+func (c *playRecorder) PlayOpt(opt *spotify.PlayOptions) error {
+	c.opt = opt
+	return nil
+}
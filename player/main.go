@@ -3,14 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"common/config"
+	"common/eventbus"
+	"common/health"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"common/webhooks"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify"
-	"golang.org/x/oauth2"
 )
 
 // Key type of spotify client context
@@ -26,51 +36,50 @@ type spotifyClient interface {
 	Pause() error
 	Next() error
 	Previous() error
+	PlayerDevices() ([]spotify.PlayerDevice, error)
+	GetRecommendations(seeds spotify.Seeds, trackAttributes *spotify.TrackAttributes, opt *spotify.Options) (*spotify.Recommendations, error)
+	GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error)
+	CurrentUsersTopTracksOpt(opt *spotify.Options) (*spotify.FullTrackPage, error)
+	CurrentUser() (*spotify.PrivateUser, error)
+	CreatePlaylistForUser(userID, playlistName, description string, public bool) (*spotify.FullPlaylist, error)
+	AddTracksToPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) (string, error)
 }
 
-// player is a simplified structure of a player
-type player struct {
-	IsPlaying   bool       `json:"is_playing"`
-	AlbumName   string     `json:"album_name"`
-	ArtistsName []string   `json:"artists_name"`
-	MusicName   string     `json:"music_name"`
-	ID          spotify.ID `json:"ID"`
-	ReleaseDate time.Time  `json:"release_date"`
-	Progress    int        `json:"progress"`
-	Duration    int        `json:"duration"`
-}
-
-// reducePlayer will reduce the spotify player to a simplified one
-func reducePlayer(playerResp *spotify.CurrentlyPlaying) player {
-	var artists []string
+// errNothingPlaying is returned when spotify has no music currently playing
+var errNothingPlaying = errors.New("nothing is playing")
 
-	for _, p := range playerResp.Item.Artists {
-		artists = append(artists, p.Name)
+// getPlayer returns the current player, it is shared by the http and the grpc APIs
+func getPlayer(client spotifyClient) (models.Player, error) {
+	player, err := client.PlayerCurrentlyPlaying()
+	if err != nil {
+		return models.Player{}, err
 	}
-
-	return player{
-		IsPlaying:   playerResp.Playing,
-		AlbumName:   playerResp.Item.Album.Name,
-		ArtistsName: artists,
-		MusicName:   playerResp.Item.Name,
-		ID:          playerResp.Item.ID,
-		ReleaseDate: playerResp.Item.Album.ReleaseDateTime(),
-		Progress:    playerResp.Progress,
-		Duration:    playerResp.Item.Duration,
+	if player.Item == nil {
+		return models.Player{}, errNothingPlaying
 	}
+	return models.ReducePlayer(player), nil
+}
+
+// playMusic plays the given track or context, or the current music when the uri is empty
+func playMusic(client spotifyClient, uri spotify.URI) error {
+	return client.PlayOpt(models.PlayOptions(uri))
 }
 
 // playerHandler is the handler to get the current player
+// It answers 204 when nothing is playing
 func playerHandler(w http.ResponseWriter, r *http.Request) {
 	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
-	player, err := client.PlayerCurrentlyPlaying()
+	reducedPlayer, err := getPlayer(client)
+	if errors.Is(err, errNothingPlaying) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
 		log.WithError(err).Error("playerHandler: could not get player currently playing")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	reducedPlayer := reducePlayer(player)
 	json.NewEncoder(w).Encode(reducedPlayer)
 }
 
@@ -86,15 +95,11 @@ func playMusicHandler(w http.ResponseWriter, r *http.Request) {
 	var playInfo playInfoRequest
 	if err := json.NewDecoder(r.Body).Decode(&playInfo); err != nil {
 		log.WithError(err).Error("playMusicHandler: could not get decode play music request")
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
-	playOptions := spotify.PlayOptions{}
-	if len(playInfo.URI) > 0 {
-		playOptions.PlaybackContext = &playInfo.URI
-	}
-	if err := client.PlayOpt(&playOptions); err != nil {
+	if err := playMusic(client, playInfo.URI); err != nil {
 		log.WithError(err).Error("playMusicHandler: could not get play music")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -131,32 +136,110 @@ func prevMusicHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// devicesHandler is the handler to get the devices able to play music
+func devicesHandler(w http.ResponseWriter, r *http.Request) {
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	devices, err := client.PlayerDevices()
+	if err != nil {
+		log.WithError(err).Error("devicesHandler: could not get devices")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(models.ReduceDevices(devices))
+}
+
 // tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
-func tokenMiddleware(next http.Handler) http.Handler {
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bearer := r.Header.Get("Authorization")
-		token := new(oauth2.Token)
-		token.AccessToken = bearer
-		client := spotify.Authenticator{}.NewClient(token)
+		client := factory.Client(bearer)
 		ctx := r.Context()
-		ctx = context.WithValue(ctx, CLIENT_CONTEXT, &client)
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
 func main() {
+	cfg, err := config.Parse("player", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+	newClient := func(token string) spotifyClient { return factory.Client(token) }
+
+	publisher := webhooks.NewPublisher(cfg.Webhooks.URL, cfg.Webhooks.Token)
+	bus, err := eventbus.Open(cfg.EventBus.URL)
+	if err != nil {
+		log.WithError(err).Fatal("could not open event bus")
+	}
+	defer bus.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	var scrobbles *scrobbler
+	if api := newScrobbleAPI(cfg.Scrobble); api != nil {
+		queue, err := openScrobbleQueue(cfg.Scrobble.QueuePath)
+		if err != nil {
+			log.WithError(err).Fatal("could not open scrobble queue")
+		}
+		accounts, err := openAccountStore(cfg.Scrobble.AccountsPath)
+		if err != nil {
+			log.WithError(err).Fatal("could not open scrobbling accounts")
+		}
+		scrobbles = newScrobbler(api, queue, accounts)
+		go scrobbles.run(ctx, cfg.Scrobble.RetryInterval)
+	}
+	tracks := newTracker(newClient, publisher, bus, scrobbles)
+	if _, err := eventbus.OnTokenValidated(bus, tracks.refresh); err != nil {
+		log.WithError(err).Fatal("could not subscribe to validated tokens")
+	}
+	go tracks.run(ctx, cfg.Player.TrackInterval)
+
 	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
 	r.HandleFunc("/player", playerHandler).Methods("GET")
 	r.HandleFunc("/player/play", playMusicHandler).Methods("POST")
 	r.HandleFunc("/player/pause", pauseMusicHandler).Methods("POST")
 	r.HandleFunc("/player/next", nextMusicHandler).Methods("POST")
 	r.HandleFunc("/player/prev", prevMusicHandler).Methods("POST")
-
-	corsWrapper := cors.New(cors.Options{
-		AllowedMethods: []string{"GET", "POST"},
-		AllowedHeaders: []string{"Content-Type", "Origin", "Accept", "*"},
-	})
-
-	contextedMux := tokenMiddleware(r)
-	log.Fatal(http.ListenAndServe(":8080", corsWrapper.Handler(contextedMux)))
+	r.HandleFunc("/player/devices", devicesHandler).Methods("GET")
+	r.HandleFunc("/player/radio", radioHandler(publisher, bus)).Methods("POST")
+	r.HandleFunc("/player/scrobbling", scrobblingHandler(scrobbles, cfg.Scrobble.API)).Methods("POST")
+	r.HandleFunc("/player/scrobbling", getScrobblingHandler(scrobbles, cfg.Scrobble.API)).Methods("GET")
+	r.HandleFunc("/player/scrobbling", unscrobblingHandler(scrobbles)).Methods("DELETE")
+	r.HandleFunc("/recommendations", recommendationsHandler).Methods("GET")
+	r.HandleFunc("/player/events", eventsHandler(eventsOptions{
+		interval:  cfg.Player.EventsInterval,
+		heartbeat: cfg.Player.EventsHeartbeat,
+	})).Methods("GET")
+
+	grpcServer := newGRPCServer(newClient)
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.RunWithGRPC(contextedMux, cfg.HTTP, grpcServer, cfg.GRPC.Addr); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
 }
@@ -5,22 +5,16 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
-	"reflect"
 	"strings"
 	"testing"
-	"time"
 
 	"github.com/zmb3/spotify"
 )
 
-func getTimeFromString(str string) time.Time {
-	date, _ := time.Parse(spotify.DateLayout, str)
-	return date
-}
-
 type mockSpotifyClient struct {
-	err    error
-	player spotify.CurrentlyPlaying
+	err     error
+	player  spotify.CurrentlyPlaying
+	devices []spotify.PlayerDevice
 }
 
 func (c *mockSpotifyClient) PlayerCurrentlyPlaying() (*spotify.CurrentlyPlaying, error) {
@@ -40,6 +34,27 @@ func (c *mockSpotifyClient) Next() error {
 func (c *mockSpotifyClient) Previous() error {
 	return c.err
 }
+func (c *mockSpotifyClient) PlayerDevices() ([]spotify.PlayerDevice, error) {
+	return c.devices, c.err
+}
+func (c *mockSpotifyClient) GetRecommendations(seeds spotify.Seeds, trackAttributes *spotify.TrackAttributes, opt *spotify.Options) (*spotify.Recommendations, error) {
+	return &spotify.Recommendations{}, c.err
+}
+func (c *mockSpotifyClient) GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error) {
+	return &spotify.PlaylistTrackPage{}, c.err
+}
+func (c *mockSpotifyClient) CurrentUsersTopTracksOpt(opt *spotify.Options) (*spotify.FullTrackPage, error) {
+	return &spotify.FullTrackPage{}, c.err
+}
+func (c *mockSpotifyClient) CurrentUser() (*spotify.PrivateUser, error) {
+	return &spotify.PrivateUser{}, c.err
+}
+func (c *mockSpotifyClient) CreatePlaylistForUser(userID, playlistName, description string, public bool) (*spotify.FullPlaylist, error) {
+	return &spotify.FullPlaylist{}, c.err
+}
+func (c *mockSpotifyClient) AddTracksToPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) (string, error) {
+	return "", c.err
+}
 
 func getRequestMock(err error, player spotify.CurrentlyPlaying, withBody bool) *http.Request {
 	r := httptest.NewRequest(http.MethodPost, "/", nil)
@@ -54,62 +69,6 @@ func getRequestMock(err error, player spotify.CurrentlyPlaying, withBody bool) *
 	return r.WithContext(ctx)
 }
 
-func Test_reducePlayer(t *testing.T) {
-	type args struct {
-		playerResp *spotify.CurrentlyPlaying
-	}
-	tests := []struct {
-		name string
-		args args
-		want player
-	}{
-		{
-			name: "",
-			args: args{
-				&spotify.CurrentlyPlaying{
-					Playing: true,
-					Item: &spotify.FullTrack{
-						Album: spotify.SimpleAlbum{
-							Name:                 "album",
-							ReleaseDate:          "2020-12-15",
-							ReleaseDatePrecision: "day",
-						},
-						SimpleTrack: spotify.SimpleTrack{
-							Name: "test",
-							ID:   "id",
-							Artists: []spotify.SimpleArtist{
-								{
-									Name: "artist name",
-								},
-								{
-									Name: "thomas",
-								},
-							},
-						},
-					},
-				},
-			},
-			want: player{
-				IsPlaying:   true,
-				AlbumName:   "album",
-				ArtistsName: []string{"artist name", "thomas"},
-				MusicName:   "test",
-				ID:          "id",
-				ReleaseDate: getTimeFromString("2020-12-15"),
-				Duration:    0,
-				Progress:    0,
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := reducePlayer(tt.args.playerResp); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("reducePlayer() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func Test_playerHandler(t *testing.T) {
 	type args struct {
 		req *http.Request
@@ -149,6 +108,14 @@ func Test_playerHandler(t *testing.T) {
 			expectedCode: http.StatusOK,
 			expectedBody: `{"is_playing":true,"album_name":"album","artists_name":["artist name","thomas"],"music_name":"test","ID":"id","release_date":"2020-12-15T00:00:00Z","progress":0,"duration":0}`,
 		},
+		{
+			name: "should answer no content when nothing is playing",
+			args: args{
+				req: getRequestMock(nil, spotify.CurrentlyPlaying{}, false),
+			},
+			expectedCode: http.StatusNoContent,
+			expectedBody: ``,
+		},
 		{
 			name: "should error on spotify api call",
 			args: args{
@@ -194,7 +161,7 @@ func Test_playMusicHandler(t *testing.T) {
 		{
 			name:         "should error decoding body",
 			args:         args{req: getRequestMock(errors.New("could not start the music"), spotify.CurrentlyPlaying{}, false)},
-			expectedCode: http.StatusInternalServerError,
+			expectedCode: http.StatusBadRequest,
 		},
 		{
 			name:         "should error on spotify api call",
@@ -309,3 +276,48 @@ func Test_prevMusicHandler(t *testing.T) {
 		})
 	}
 }
+
+func Test_devicesHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		client       *mockSpotifyClient
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "should get the devices",
+			client:       &mockSpotifyClient{devices: []spotify.PlayerDevice{{ID: "id", Active: true, Name: "speaker", Type: "Speaker", Volume: 50}}},
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"id":"id","name":"speaker","type":"Speaker","is_active":true,"volume":50}]`,
+		},
+		{
+			name:         "should get an empty list without devices",
+			client:       &mockSpotifyClient{},
+			expectedCode: http.StatusOK,
+			expectedBody: `[]`,
+		},
+		{
+			name:         "should error on spotify api call",
+			client:       &mockSpotifyClient{err: errors.New("could not get devices")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/player/devices", nil)
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, tt.client))
+			rr := httptest.NewRecorder()
+			devicesHandler(rr, r)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, tt.expectedCode)
+			}
+			if tt.expectedCode < 500 {
+				if strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+					t.Errorf("handler returned unexpected body: got %v want %v",
+						strings.TrimSpace(rr.Body.String()), tt.expectedBody)
+				}
+			}
+		})
+	}
+}
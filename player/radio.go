@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/eventbus"
+	"common/models"
+	"common/webhooks"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// defaultRecommendations is the number of tracks recommended when no limit is given
+	defaultRecommendations = 20
+	// maxRecommendations is the largest number of tracks spotify recommends at once
+	maxRecommendations = 100
+	// radioPlaylistName is the name of the playlists created by the radio
+	radioPlaylistName = "Radio"
+)
+
+var (
+	// errNoSeed is returned when the playlist or the top tracks have no track to recommend from
+	errNoSeed = errors.New("no seed track")
+	// errNoRecommendation is returned when spotify recommends no track, the radio is not started
+	errNoRecommendation = errors.New("no track recommended")
+	// errUnknownPlaylist is returned when the playlist of the seeds does not exist
+	errUnknownPlaylist = errors.New("unknown playlist")
+)
+
+// tunable is a target audio feature of the recommended tracks with its range
+type tunable struct {
+	min, max float64
+	set      func(ta *spotify.TrackAttributes, value float64) *spotify.TrackAttributes
+}
+
+// tunables are the target audio features accepted, by name
+var tunables = map[string]tunable{
+	"acousticness":     {0, 1, (*spotify.TrackAttributes).TargetAcousticness},
+	"danceability":     {0, 1, (*spotify.TrackAttributes).TargetDanceability},
+	"energy":           {0, 1, (*spotify.TrackAttributes).TargetEnergy},
+	"instrumentalness": {0, 1, (*spotify.TrackAttributes).TargetInstrumentalness},
+	"liveness":         {0, 1, (*spotify.TrackAttributes).TargetLiveness},
+	"speechiness":      {0, 1, (*spotify.TrackAttributes).TargetSpeechiness},
+	"valence":          {0, 1, (*spotify.TrackAttributes).TargetValence},
+	"tempo":            {0, 250, (*spotify.TrackAttributes).TargetTempo},
+	"popularity": {0, 100, func(ta *spotify.TrackAttributes, value float64) *spotify.TrackAttributes {
+		return ta.TargetPopularity(int(value))
+	}},
+}
+
+// recommendationRequest tells where the seeds of the recommendations come from and the audio features targeted
+// The seeds are the track playing for player, the first tracks of the playlist for playlist and the top tracks of the user for top
+type recommendationRequest struct {
+	Seed       string             `json:"seed"`
+	PlaylistID spotify.ID         `json:"playlist_id"`
+	Limit      int                `json:"limit"`
+	Targets    map[string]float64 `json:"targets"`
+}
+
+// radioRequest is the body of POST /player/radio, the recommendations are played as a queue of tracks or as a new playlist
+type radioRequest struct {
+	recommendationRequest
+	Mode string `json:"mode"`
+}
+
+// validate checks the request and sets the defaults of the missing settings
+func (req *recommendationRequest) validate() error {
+	switch req.Seed {
+	case "":
+		req.Seed = "player"
+	case "player", "top":
+	case "playlist":
+		if req.PlaylistID == "" || strings.ContainsAny(string(req.PlaylistID), ",?&/") {
+			return fmt.Errorf("invalid playlist_id %q", req.PlaylistID)
+		}
+	default:
+		return fmt.Errorf("invalid seed %q", req.Seed)
+	}
+	if req.Seed != "playlist" && req.PlaylistID != "" {
+		return fmt.Errorf("playlist_id is only expected with the playlist seed")
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultRecommendations
+	}
+	if req.Limit < 1 || req.Limit > maxRecommendations {
+		return fmt.Errorf("invalid limit %d", req.Limit)
+	}
+	for name, value := range req.Targets {
+		t, ok := tunables[name]
+		if !ok {
+			return fmt.Errorf("unknown target %q", name)
+		}
+		if value < t.min || value > t.max {
+			return fmt.Errorf("target %s %v is not between %v and %v", name, value, t.min, t.max)
+		}
+	}
+	return nil
+}
+
+// trackAttributes returns the targets of the request as spotify track attributes, nil without target
+func (req *recommendationRequest) trackAttributes() *spotify.TrackAttributes {
+	if len(req.Targets) == 0 {
+		return nil
+	}
+	ta := spotify.NewTrackAttributes()
+	for name, value := range req.Targets {
+		ta = tunables[name].set(ta, value)
+	}
+	return ta
+}
+
+// parseRecommendationRequest reads the request from the query: seed, playlist_id, limit and a target_{name} per audio feature
+func parseRecommendationRequest(r *http.Request) (recommendationRequest, error) {
+	query := r.URL.Query()
+	req := recommendationRequest{Seed: query.Get("seed"), PlaylistID: spotify.ID(query.Get("playlist_id"))}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil {
+			return req, fmt.Errorf("invalid limit %q", raw)
+		}
+		req.Limit = limit
+	}
+	for param := range query {
+		if !strings.HasPrefix(param, "target_") {
+			continue
+		}
+		value, err := strconv.ParseFloat(query.Get(param), 64)
+		if err != nil {
+			return req, fmt.Errorf("invalid %s %q", param, query.Get(param))
+		}
+		if req.Targets == nil {
+			req.Targets = map[string]float64{}
+		}
+		req.Targets[strings.TrimPrefix(param, "target_")] = value
+	}
+	return req, req.validate()
+}
+
+// seedTracks returns the tracks the recommendations are made from, spotify takes up to 5 seeds
+func seedTracks(client spotifyClient, req recommendationRequest) ([]spotify.ID, error) {
+	limit := spotify.MaxNumberOfSeeds
+	var ids []spotify.ID
+	switch req.Seed {
+	case "player":
+		player, err := getPlayer(client)
+		if err != nil {
+			return nil, err
+		}
+		return []spotify.ID{player.ID}, nil
+	case "playlist":
+		page, err := client.GetPlaylistTracksOpt(req.PlaylistID, &spotify.Options{Limit: &limit}, "")
+		var serr spotify.Error
+		if errors.As(err, &serr) && serr.Status == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", errUnknownPlaylist, req.PlaylistID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page.Tracks {
+			ids = append(ids, t.Track.ID)
+		}
+	case "top":
+		page, err := client.CurrentUsersTopTracksOpt(&spotify.Options{Limit: &limit})
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page.Tracks {
+			ids = append(ids, t.ID)
+		}
+	}
+	// the local files of a playlist have no ID
+	seeds := []spotify.ID{}
+	for _, id := range ids {
+		if id != "" && len(seeds) < limit {
+			seeds = append(seeds, id)
+		}
+	}
+	if len(seeds) == 0 {
+		return nil, errNoSeed
+	}
+	return seeds, nil
+}
+
+// recommend returns the tracks recommended by spotify for the request
+func recommend(client spotifyClient, req recommendationRequest) (models.Recommendations, error) {
+	seeds, err := seedTracks(client, req)
+	if err != nil {
+		return models.Recommendations{}, err
+	}
+	limit := req.Limit
+	recommendations, err := client.GetRecommendations(spotify.Seeds{Tracks: seeds}, req.trackAttributes(), &spotify.Options{Limit: &limit})
+	if err != nil {
+		return models.Recommendations{}, err
+	}
+	return models.ReduceRecommendations(req.Seed, seeds, recommendations), nil
+}
+
+// startRadio plays the recommended tracks
+// The queue mode plays them as a list of tracks, the playlist mode saves them in a new private playlist of the user and plays it
+// The playlist created is published to the webhooks of the user and on the bus
+func startRadio(client spotifyClient, publisher *webhooks.Publisher, bus eventbus.Bus, req radioRequest) (models.Radio, error) {
+	recommendations, err := recommend(client, req.recommendationRequest)
+	if err != nil {
+		return models.Radio{}, err
+	}
+	if len(recommendations.Tracks) == 0 {
+		return models.Radio{}, errNoRecommendation
+	}
+	radio := models.Radio{Mode: req.Mode, Recommendations: recommendations}
+	ids := make([]spotify.ID, 0, len(recommendations.Tracks))
+	uris := make([]spotify.URI, 0, len(recommendations.Tracks))
+	for _, t := range recommendations.Tracks {
+		ids = append(ids, t.ID)
+		uris = append(uris, t.URI)
+	}
+
+	if req.Mode == "queue" {
+		return radio, client.PlayOpt(&spotify.PlayOptions{URIs: uris})
+	}
+	user, err := client.CurrentUser()
+	if err != nil {
+		return models.Radio{}, err
+	}
+	description := fmt.Sprintf("Tracks recommended from the %s seed, it can be deleted once listened to", req.Seed)
+	playlist, err := client.CreatePlaylistForUser(user.ID, radioPlaylistName, description, false)
+	if err != nil {
+		return models.Radio{}, err
+	}
+	snapshot, err := client.AddTracksToPlaylist(playlist.ID, ids...)
+	if err != nil {
+		return models.Radio{}, err
+	}
+	update := models.PlaylistUpdate{PlaylistID: playlist.ID, SnapshotID: snapshot, Change: models.PlaylistCreated, TrackIDs: ids}
+	publisher.Go(models.PlaylistUpdatedEvent, user.ID, update)
+	eventbus.Go(bus, eventbus.PlaylistMutated{UserID: user.ID, PlaylistUpdate: update, OccurredAt: time.Now().UTC()})
+	item := models.ReducePlaylistItem(playlist.SimplePlaylist)
+	radio.Playlist = &item
+	return radio, client.PlayOpt(&spotify.PlayOptions{PlaybackContext: &playlist.URI})
+}
+
+// recommendationStatus returns the status answered for the errors of the recommendations
+func recommendationStatus(err error) int {
+	if errors.Is(err, errNothingPlaying) || errors.Is(err, errNoSeed) || errors.Is(err, errUnknownPlaylist) || errors.Is(err, errNoRecommendation) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// recommendationsHandler is the handler to get tracks recommended from the player, a playlist or the top tracks
+func recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRecommendationRequest(r)
+	if err != nil {
+		log.WithError(err).Error("recommendationsHandler: invalid recommendations request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+	recommendations, err := recommend(client, req)
+	if status := recommendationStatus(err); err != nil {
+		log.WithError(err).Error("recommendationsHandler: could not get recommendations")
+		w.WriteHeader(status)
+		return
+	}
+	json.NewEncoder(w).Encode(recommendations)
+}
+
+// radioHandler is the handler starting a radio of recommended tracks
+func radioHandler(publisher *webhooks.Publisher, bus eventbus.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req radioRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Error("radioHandler: could not decode radio request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Mode == "" {
+			req.Mode = "queue"
+		}
+		if err := req.validate(); err != nil || req.Mode != "queue" && req.Mode != "playlist" {
+			log.WithError(err).WithField("mode", req.Mode).Error("radioHandler: invalid radio request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		radio, err := startRadio(client, publisher, bus, req)
+		if status := recommendationStatus(err); err != nil {
+			log.WithError(err).Error("radioHandler: could not start radio")
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(radio)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+// radioSpotifyClient recommends its tracks and records the seeds, the playlists created and the tracks played
+type radioSpotifyClient struct {
+	mockSpotifyClient
+	playlistTracks  []spotify.ID
+	topTracks       []spotify.ID
+	recommended     []spotify.ID
+	seeds           spotify.Seeds
+	attributes      *spotify.TrackAttributes
+	limit           int
+	playlistErr     error
+	recommendErr    error
+	created         []spotify.ID
+	played          *spotify.PlayOptions
+	createdPlaylist spotify.FullPlaylist
+}
+
+func (c *radioSpotifyClient) GetRecommendations(seeds spotify.Seeds, trackAttributes *spotify.TrackAttributes, opt *spotify.Options) (*spotify.Recommendations, error) {
+	c.seeds, c.attributes, c.limit = seeds, trackAttributes, *opt.Limit
+	recommendations := &spotify.Recommendations{}
+	for _, id := range c.recommended {
+		recommendations.Tracks = append(recommendations.Tracks, spotify.SimpleTrack{ID: id, Name: string(id), URI: spotify.URI("spotify:track:" + id)})
+	}
+	return recommendations, c.recommendErr
+}
+
+func (c *radioSpotifyClient) GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error) {
+	if c.playlistErr != nil {
+		return nil, c.playlistErr
+	}
+	page := &spotify.PlaylistTrackPage{}
+	for _, id := range c.playlistTracks {
+		page.Tracks = append(page.Tracks, spotify.PlaylistTrack{Track: spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: id}}})
+	}
+	return page, nil
+}
+
+func (c *radioSpotifyClient) CurrentUsersTopTracksOpt(opt *spotify.Options) (*spotify.FullTrackPage, error) {
+	page := &spotify.FullTrackPage{}
+	for _, id := range c.topTracks {
+		page.Tracks = append(page.Tracks, spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{ID: id}})
+	}
+	return page, nil
+}
+
+func (c *radioSpotifyClient) CurrentUser() (*spotify.PrivateUser, error) {
+	return &spotify.PrivateUser{User: spotify.User{ID: "thomas"}}, nil
+}
+
+func (c *radioSpotifyClient) CreatePlaylistForUser(userID, playlistName, description string, public bool) (*spotify.FullPlaylist, error) {
+	c.createdPlaylist = spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{ID: "radio", URI: "spotify:playlist:radio", Name: playlistName, Owner: spotify.User{ID: userID, DisplayName: "Thomas"}}}
+	return &c.createdPlaylist, nil
+}
+
+func (c *radioSpotifyClient) AddTracksToPlaylist(playlistID spotify.ID, trackIDs ...spotify.ID) (string, error) {
+	c.created = append(c.created, trackIDs...)
+	return "snapshot", nil
+}
+
+func (c *radioSpotifyClient) PlayOpt(opt *spotify.PlayOptions) error {
+	c.played = opt
+	return nil
+}
+
+// withClient returns the request with the spotify client in its context
+func withClient(r *http.Request, client spotifyClient) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+}
+
+func Test_recommendationRequest_validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         recommendationRequest
+		expected    recommendationRequest
+		expectedErr bool
+	}{
+		{
+			name:     "should default to 20 tracks recommended from the player",
+			expected: recommendationRequest{Seed: "player", Limit: 20},
+		},
+		{
+			name:     "should accept the targets in their range",
+			req:      recommendationRequest{Seed: "top", Limit: 100, Targets: map[string]float64{"energy": 1, "tempo": 120, "popularity": 0}},
+			expected: recommendationRequest{Seed: "top", Limit: 100, Targets: map[string]float64{"energy": 1, "tempo": 120, "popularity": 0}},
+		},
+		{
+			name:     "should recommend from a playlist",
+			req:      recommendationRequest{Seed: "playlist", PlaylistID: "morning"},
+			expected: recommendationRequest{Seed: "playlist", PlaylistID: "morning", Limit: 20},
+		},
+		{name: "should error on an unknown seed", req: recommendationRequest{Seed: "album"}, expectedErr: true},
+		{name: "should error on a playlist seed without playlist", req: recommendationRequest{Seed: "playlist"}, expectedErr: true},
+		{name: "should error on a playlist without playlist seed", req: recommendationRequest{Seed: "top", PlaylistID: "morning"}, expectedErr: true},
+		{name: "should error on a limit too large", req: recommendationRequest{Limit: 101}, expectedErr: true},
+		{name: "should error on an unknown target", req: recommendationRequest{Targets: map[string]float64{"loudness": -5}}, expectedErr: true},
+		{name: "should error on a target out of its range", req: recommendationRequest{Targets: map[string]float64{"energy": 1.5}}, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("validate() error = %v, expectedErr %v", err, tt.expectedErr)
+			}
+			if !tt.expectedErr && !reflect.DeepEqual(tt.req, tt.expected) {
+				t.Errorf("validate() set %+v, want %+v", tt.req, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_recommendationsHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		client        *radioSpotifyClient
+		expectedCode  int
+		expectedSeeds []spotify.ID
+		expectedBody  string
+	}{
+		{
+			name:          "should recommend from the track playing",
+			query:         "limit=2",
+			client:        &radioSpotifyClient{mockSpotifyClient: mockSpotifyClient{player: *currentlyPlaying("sunset", true, 0)}, recommended: []spotify.ID{"lullaby", "sunrise"}},
+			expectedCode:  http.StatusOK,
+			expectedSeeds: []spotify.ID{"sunset"},
+			expectedBody:  `{"seed":"player","seed_tracks":["sunset"],"tracks":[{"name":"lullaby","artists_name":null,"album_name":"","ID":"lullaby","uri":"spotify:track:lullaby","duration":0},{"name":"sunrise","artists_name":null,"album_name":"","ID":"sunrise","uri":"spotify:track:sunrise","duration":0}]}`,
+		},
+		{
+			name:          "should recommend from the first tracks of a playlist",
+			query:         "seed=playlist&playlist_id=morning",
+			client:        &radioSpotifyClient{playlistTracks: []spotify.ID{"sunrise", "", "coffee", "commute", "a", "b", "c"}},
+			expectedCode:  http.StatusOK,
+			expectedSeeds: []spotify.ID{"sunrise", "coffee", "commute", "a", "b"},
+			expectedBody:  `{"seed":"playlist","seed_tracks":["sunrise","coffee","commute","a","b"],"tracks":[]}`,
+		},
+		{
+			name:          "should recommend from the top tracks",
+			query:         "seed=top",
+			client:        &radioSpotifyClient{topTracks: []spotify.ID{"coffee"}},
+			expectedCode:  http.StatusOK,
+			expectedSeeds: []spotify.ID{"coffee"},
+			expectedBody:  `{"seed":"top","seed_tracks":["coffee"],"tracks":[]}`,
+		},
+		{
+			name:         "should answer not found when nothing is playing",
+			client:       &radioSpotifyClient{},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should answer not found without top tracks",
+			query:        "seed=top",
+			client:       &radioSpotifyClient{},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should answer not found on an unknown playlist",
+			query:        "seed=playlist&playlist_id=unknown",
+			client:       &radioSpotifyClient{playlistErr: spotify.Error{Status: http.StatusNotFound, Message: "Not found."}},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should error on an invalid target",
+			query:        "target_energy=loud",
+			client:       &radioSpotifyClient{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should error on spotify api call",
+			query:        "seed=top",
+			client:       &radioSpotifyClient{topTracks: []spotify.ID{"coffee"}, recommendErr: errors.New("could not get recommendations")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			recommendationsHandler(rr, withClient(httptest.NewRequest(http.MethodGet, "/recommendations?"+tt.query, nil), tt.client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			if !reflect.DeepEqual(tt.client.seeds.Tracks, tt.expectedSeeds) {
+				t.Errorf("handler recommended from %v, want %v", tt.client.seeds.Tracks, tt.expectedSeeds)
+			}
+			if body := strings.TrimSpace(rr.Body.String()); body != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", body, tt.expectedBody)
+			}
+		})
+	}
+}
+
+func Test_recommendationsHandler_targets(t *testing.T) {
+	client := &radioSpotifyClient{topTracks: []spotify.ID{"coffee"}}
+	rr := httptest.NewRecorder()
+	recommendationsHandler(rr, withClient(httptest.NewRequest(http.MethodGet, "/recommendations?seed=top&limit=5&target_energy=0.8&target_popularity=60", nil), client))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	want := spotify.NewTrackAttributes().TargetEnergy(0.8).TargetPopularity(60)
+	if client.limit != 5 || !reflect.DeepEqual(client.attributes, want) {
+		t.Errorf("handler asked %d tracks with %+v, want 5 with %+v", client.limit, client.attributes, want)
+	}
+}
+
+func Test_radioHandler(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		client           *radioSpotifyClient
+		expectedCode     int
+		expectedPlayed   *spotify.PlayOptions
+		expectedCreated  []spotify.ID
+		expectedPlaylist *models.PlaylistItem
+	}{
+		{
+			name:           "should play the recommended tracks as a queue",
+			body:           `{"seed": "top"}`,
+			client:         &radioSpotifyClient{topTracks: []spotify.ID{"coffee"}, recommended: []spotify.ID{"sunrise", "commute"}},
+			expectedCode:   http.StatusOK,
+			expectedPlayed: &spotify.PlayOptions{URIs: []spotify.URI{"spotify:track:sunrise", "spotify:track:commute"}},
+		},
+		{
+			name:             "should play the recommended tracks in a new playlist",
+			body:             `{"seed": "top", "mode": "playlist", "targets": {"valence": 0.9}}`,
+			client:           &radioSpotifyClient{topTracks: []spotify.ID{"coffee"}, recommended: []spotify.ID{"sunrise", "commute"}},
+			expectedCode:     http.StatusOK,
+			expectedPlayed:   &spotify.PlayOptions{PlaybackContext: func() *spotify.URI { uri := spotify.URI("spotify:playlist:radio"); return &uri }()},
+			expectedCreated:  []spotify.ID{"sunrise", "commute"},
+			expectedPlaylist: &models.PlaylistItem{Name: "Radio", OwnerName: "Thomas", ID: "radio", URI: "spotify:playlist:radio"},
+		},
+		{
+			name:         "should answer not found when nothing is recommended",
+			body:         `{"seed": "top"}`,
+			client:       &radioSpotifyClient{topTracks: []spotify.ID{"coffee"}},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should error on an unknown mode",
+			body:         `{"mode": "shuffle"}`,
+			client:       &radioSpotifyClient{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should error decoding body",
+			body:         `{"seed":`,
+			client:       &radioSpotifyClient{},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			radioHandler(nil, nil)(rr, withClient(httptest.NewRequest(http.MethodPost, "/player/radio", strings.NewReader(tt.body)), tt.client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if !reflect.DeepEqual(tt.client.played, tt.expectedPlayed) {
+				t.Errorf("handler played %+v, want %+v", tt.client.played, tt.expectedPlayed)
+			}
+			if !reflect.DeepEqual(tt.client.created, tt.expectedCreated) {
+				t.Errorf("handler added %v to the playlist, want %v", tt.client.created, tt.expectedCreated)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var radio models.Radio
+			if err := json.NewDecoder(rr.Body).Decode(&radio); err != nil {
+				t.Fatal(err)
+			}
+			if len(radio.Tracks) != len(tt.client.recommended) || !reflect.DeepEqual(radio.Playlist, tt.expectedPlaylist) {
+				t.Errorf("handler returned %+v, want the tracks recommended and the playlist %+v", radio, tt.expectedPlaylist)
+			}
+		})
+	}
+}
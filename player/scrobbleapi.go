@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/config"
+)
+
+const (
+	// listenBrainzURL is the root of the official listenbrainz API
+	listenBrainzURL = "https://api.listenbrainz.org"
+	// lastFMURL is the root of the official lastfm API
+	lastFMURL = "https://ws.audioscrobbler.com/2.0/"
+	// scrobbleTimeout bounds each call to the scrobbling API, the player is polled meanwhile
+	scrobbleTimeout = 10 * time.Second
+)
+
+// newScrobbleAPI returns the scrobbling API of the configuration, it is nil when the scrobbling is disabled
+func newScrobbleAPI(cfg config.Scrobble) scrobbleAPI {
+	client := &http.Client{Timeout: scrobbleTimeout}
+	switch cfg.API {
+	case "listenbrainz":
+		root := cfg.URL
+		if root == "" {
+			root = listenBrainzURL
+		}
+		return &listenBrainz{url: strings.TrimSuffix(root, "/") + "/1/submit-listens", client: client}
+	case "lastfm":
+		root := cfg.URL
+		if root == "" {
+			root = lastFMURL
+		}
+		return &lastFM{url: root, apiKey: cfg.APIKey, secret: cfg.APISecret, client: client}
+	}
+	return nil
+}
+
+// listenBrainz submits the listens to a listenbrainz compatible API with the user token of each user
+type listenBrainz struct {
+	url    string
+	client *http.Client
+}
+
+// listenBrainzListen is a listen of the listenbrainz API, ListenedAt is not set for the track playing now
+type listenBrainzListen struct {
+	ListenedAt    int64 `json:"listened_at,omitempty"`
+	TrackMetadata struct {
+		ArtistName     string                 `json:"artist_name"`
+		TrackName      string                 `json:"track_name"`
+		ReleaseName    string                 `json:"release_name,omitempty"`
+		AdditionalInfo map[string]interface{} `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+// newListenBrainzListen returns the listen of the scrobble
+func newListenBrainzListen(s scrobble) listenBrainzListen {
+	var listen listenBrainzListen
+	listen.TrackMetadata.ArtistName = strings.Join(s.ArtistsName, ", ")
+	listen.TrackMetadata.TrackName = s.Track
+	listen.TrackMetadata.ReleaseName = s.Album
+	listen.TrackMetadata.AdditionalInfo = map[string]interface{}{
+		"artist_names":      s.ArtistsName,
+		"duration_ms":       s.Duration,
+		"spotify_id":        "https://open.spotify.com/track/" + string(s.TrackID),
+		"submission_client": "spotify-microservices",
+	}
+	return listen
+}
+
+// nowPlaying submits the track as playing_now
+func (lb *listenBrainz) nowPlaying(token string, s scrobble) error {
+	return lb.send(token, "playing_now", newListenBrainzListen(s))
+}
+
+// submit submits the track as a single listen
+func (lb *listenBrainz) submit(token string, s scrobble) error {
+	listen := newListenBrainzListen(s)
+	listen.ListenedAt = s.ListenedAt.Unix()
+	return lb.send(token, "single", listen)
+}
+
+// send posts the listen with the user token, listenbrainz answers 400 to the invalid ones
+func (lb *listenBrainz) send(token, listenType string, listen listenBrainzListen) error {
+	body, err := json.Marshal(map[string]interface{}{"listen_type": listenType, "payload": []listenBrainzListen{listen}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, lb.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := lb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: listenbrainz: %s", errScrobbleRejected, content)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("listenbrainz: status %d: %s", resp.StatusCode, content)
+	}
+	return nil
+}
+
+// lastFM scrobbles the tracks to a lastfm compatible API with the session key of each user
+type lastFM struct {
+	url    string
+	apiKey string
+	secret string
+	client *http.Client
+}
+
+// lastFMInvalidParameters is the error code of lastfm for a request that must not be sent again
+const lastFMInvalidParameters = 6
+
+// nowPlaying updates the track playing now
+func (fm *lastFM) nowPlaying(sessionKey string, s scrobble) error {
+	return fm.call(sessionKey, "track.updateNowPlaying", lastFMTrack(s))
+}
+
+// submit scrobbles the track with the time it started playing
+func (fm *lastFM) submit(sessionKey string, s scrobble) error {
+	params := lastFMTrack(s)
+	params.Set("timestamp", strconv.FormatInt(s.ListenedAt.Unix(), 10))
+	return fm.call(sessionKey, "track.scrobble", params)
+}
+
+// lastFMTrack returns the parameters of the track of the scrobble, lastfm takes a single artist
+func lastFMTrack(s scrobble) url.Values {
+	params := url.Values{}
+	if len(s.ArtistsName) > 0 {
+		params.Set("artist", s.ArtistsName[0])
+	}
+	params.Set("track", s.Track)
+	if s.Album != "" {
+		params.Set("album", s.Album)
+	}
+	params.Set("duration", strconv.Itoa(s.Duration/1000))
+	return params
+}
+
+// call posts the method signed with the session key, lastfm answers its errors with a code in the json body
+func (fm *lastFM) call(sessionKey, method string, params url.Values) error {
+	params.Set("method", method)
+	params.Set("api_key", fm.apiKey)
+	params.Set("sk", sessionKey)
+	params.Set("api_sig", lastFMSignature(params, fm.secret))
+	params.Set("format", "json")
+	resp, err := fm.client.PostForm(fm.url, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(content, &answer)
+	switch {
+	case answer.Error == lastFMInvalidParameters:
+		return fmt.Errorf("%w: lastfm: %s", errScrobbleRejected, answer.Message)
+	case answer.Error != 0:
+		return fmt.Errorf("lastfm: error %d: %s", answer.Error, answer.Message)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("lastfm: status %d: %s", resp.StatusCode, content)
+	}
+	return nil
+}
+
+// lastFMSignature signs the parameters: the md5 of the names and values sorted by name followed by the secret
+func lastFMSignature(params url.Values, secret string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var signed strings.Builder
+	for _, name := range names {
+		signed.WriteString(name + params.Get(name))
+	}
+	signed.WriteString(secret)
+	return fmt.Sprintf("%x", md5.Sum([]byte(signed.String())))
+}
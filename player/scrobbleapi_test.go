@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/config"
+)
+
+// sunriseScrobble is the scrobble submitted to the stand-in APIs
+var sunriseScrobble = scrobble{
+	Track:       "Sunrise",
+	ArtistsName: []string{"The Early Birds", "Dusk"},
+	Album:       "Morning",
+	TrackID:     "sunrise",
+	Duration:    180000,
+	ListenedAt:  time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC),
+}
+
+func Test_newScrobbleAPI(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Scrobble
+		expected scrobbleAPI
+	}{
+		{
+			name: "should not scrobble without api",
+		},
+		{
+			name:     "should default to the official listenbrainz API",
+			cfg:      config.Scrobble{API: "listenbrainz"},
+			expected: &listenBrainz{url: "https://api.listenbrainz.org/1/submit-listens"},
+		},
+		{
+			name:     "should submit to a compatible listenbrainz API",
+			cfg:      config.Scrobble{API: "listenbrainz", URL: "http://maloja:42010/apis/listenbrainz/"},
+			expected: &listenBrainz{url: "http://maloja:42010/apis/listenbrainz/1/submit-listens"},
+		},
+		{
+			name:     "should default to the official lastfm API",
+			cfg:      config.Scrobble{API: "lastfm", APIKey: "key", APISecret: "secret"},
+			expected: &lastFM{url: "https://ws.audioscrobbler.com/2.0/", apiKey: "key", secret: "secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newScrobbleAPI(tt.cfg)
+			switch api := got.(type) {
+			case *listenBrainz:
+				api.client = nil
+			case *lastFM:
+				api.client = nil
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("newScrobbleAPI() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_listenBrainz(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		playingNow       bool
+		expectedType     string
+		expectedRejected bool
+		expectedErr      bool
+	}{
+		{name: "should submit a single listen", status: http.StatusOK, expectedType: "single"},
+		{name: "should submit the track playing now", status: http.StatusOK, playingNow: true, expectedType: "playing_now"},
+		{name: "should reject the invalid listens", status: http.StatusBadRequest, expectedType: "single", expectedRejected: true, expectedErr: true},
+		{name: "should retry on server errors", status: http.StatusServiceUnavailable, expectedType: "single", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				ListenType string                   `json:"listen_type"`
+				Payload    []map[string]interface{} `json:"payload"`
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret-token" {
+					t.Errorf("listenbrainz called at %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+				}
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"status": "ok"}`))
+			}))
+			defer server.Close()
+			api := newScrobbleAPI(config.Scrobble{API: "listenbrainz", URL: server.URL})
+
+			var err error
+			if tt.playingNow {
+				err = api.nowPlaying("secret-token", sunriseScrobble)
+			} else {
+				err = api.submit("secret-token", sunriseScrobble)
+			}
+			if (err != nil) != tt.expectedErr || errors.Is(err, errScrobbleRejected) != tt.expectedRejected {
+				t.Fatalf("listenbrainz error = %v, expectedErr %v, expectedRejected %v", err, tt.expectedErr, tt.expectedRejected)
+			}
+			if got.ListenType != tt.expectedType || len(got.Payload) != 1 {
+				t.Fatalf("listenbrainz got %+v, want a %s listen", got, tt.expectedType)
+			}
+			metadata := got.Payload[0]["track_metadata"].(map[string]interface{})
+			if metadata["track_name"] != "Sunrise" || metadata["artist_name"] != "The Early Birds, Dusk" || metadata["release_name"] != "Morning" {
+				t.Errorf("listenbrainz got metadata %v", metadata)
+			}
+			if listenedAt, ok := got.Payload[0]["listened_at"]; ok == tt.playingNow || (ok && listenedAt != float64(sunriseScrobble.ListenedAt.Unix())) {
+				t.Errorf("listenbrainz got listened_at %v for a %s listen", listenedAt, tt.expectedType)
+			}
+		})
+	}
+}
+
+func Test_lastFM(t *testing.T) {
+	tests := []struct {
+		name             string
+		answer           string
+		playingNow       bool
+		expectedMethod   string
+		expectedRejected bool
+		expectedErr      bool
+	}{
+		{name: "should scrobble the track", answer: `{"scrobbles": {}}`, expectedMethod: "track.scrobble"},
+		{name: "should update the track playing now", answer: `{"nowplaying": {}}`, playingNow: true, expectedMethod: "track.updateNowPlaying"},
+		{name: "should reject the invalid parameters", answer: `{"error": 6, "message": "Invalid parameters"}`, expectedMethod: "track.scrobble", expectedRejected: true, expectedErr: true},
+		{name: "should retry when the service is offline", answer: `{"error": 11, "message": "Service Offline"}`, expectedMethod: "track.scrobble", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				got = r.PostForm
+				w.Write([]byte(tt.answer))
+			}))
+			defer server.Close()
+			api := newScrobbleAPI(config.Scrobble{API: "lastfm", URL: server.URL, APIKey: "key", APISecret: "secret"})
+
+			var err error
+			if tt.playingNow {
+				err = api.nowPlaying("session", sunriseScrobble)
+			} else {
+				err = api.submit("session", sunriseScrobble)
+			}
+			if (err != nil) != tt.expectedErr || errors.Is(err, errScrobbleRejected) != tt.expectedRejected {
+				t.Fatalf("lastfm error = %v, expectedErr %v, expectedRejected %v", err, tt.expectedErr, tt.expectedRejected)
+			}
+			if got.Get("method") != tt.expectedMethod || got.Get("api_key") != "key" || got.Get("sk") != "session" || got.Get("format") != "json" {
+				t.Errorf("lastfm got %v, want %s signed with the session", got, tt.expectedMethod)
+			}
+			if got.Get("artist") != "The Early Birds" || got.Get("track") != "Sunrise" || got.Get("album") != "Morning" || got.Get("duration") != "180" {
+				t.Errorf("lastfm got track %v", got)
+			}
+			if timestamp := got.Get("timestamp"); (timestamp == "") != tt.playingNow {
+				t.Errorf("lastfm got timestamp %q for %s", timestamp, tt.expectedMethod)
+			}
+			signature := got.Get("api_sig")
+			got.Del("api_sig")
+			if want := lastFMSignature(got, "secret"); signature != want {
+				t.Errorf("lastfm got api_sig %s, want %s", signature, want)
+			}
+		})
+	}
+}
+
+func Test_lastFMSignature(t *testing.T) {
+	params := url.Values{"method": {"auth.getSession"}, "api_key": {"key"}, "token": {"token"}, "format": {"json"}}
+	// md5 of "api_keykeymethodauth.getSessiontokentokensecret"
+	if got, want := lastFMSignature(params, "secret"), "9ac306496295a8866c4a8673395540eb"; got != want {
+		t.Errorf("lastFMSignature() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"common/eventbus"
+	"common/identity"
+	"common/jsonfile"
+	"common/models"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// scrobbleMinDuration is the duration of the shortest track scrobbled
+	scrobbleMinDuration = 30 * time.Second
+	// scrobbleMaxPlayed is the listening time after which a track is scrobbled even when not played for half its duration
+	scrobbleMaxPlayed = 4 * time.Minute
+)
+
+// errScrobbleRejected is returned when the scrobbling API rejects a scrobble, it is not submitted again
+var errScrobbleRejected = errors.New("scrobble rejected")
+
+// scrobble is a track listened to by the user, ListenedAt is when it started playing
+type scrobble struct {
+	UserID      string     `json:"user_id"`
+	Track       string     `json:"track"`
+	ArtistsName []string   `json:"artists_name"`
+	Album       string     `json:"album"`
+	TrackID     spotify.ID `json:"track_id"`
+	Duration    int        `json:"duration"`
+	ListenedAt  time.Time  `json:"listened_at"`
+}
+
+// scrobbleAPI submits the tracks listened to a listenbrainz or lastfm compatible API
+// The token is the one of the account of the user: the user token of listenbrainz or the session key of lastfm
+type scrobbleAPI interface {
+	// nowPlaying tells the track started playing, it is not kept
+	nowPlaying(token string, s scrobble) error
+	// submit scrobbles the track, errScrobbleRejected is wrapped when it must not be submitted again
+	submit(token string, s scrobble) error
+}
+
+// listening follows the track played by a user between the polls of the player
+type listening struct {
+	player models.Player
+	seenAt time.Time
+	// startedAt is when the track started playing, estimated from its progress when first seen
+	startedAt time.Time
+	// played is the time the track was listened to, the seeks and the pauses are not counted
+	played    time.Duration
+	announced bool
+	scrobbled bool
+}
+
+// threshold returns the listening time after which the track is scrobbled, half its duration or 4 minutes
+// The tracks shorter than 30 seconds are never scrobbled
+func threshold(duration time.Duration) (time.Duration, bool) {
+	if duration < scrobbleMinDuration {
+		return 0, false
+	}
+	if duration/2 < scrobbleMaxPlayed {
+		return duration / 2, true
+	}
+	return scrobbleMaxPlayed, true
+}
+
+// restarted tells if the track of the player is a new listening of the one followed: another track, or the same one played again from its start
+func (l *listening) restarted(player models.Player) bool {
+	if l == nil || l.player.ID != player.ID {
+		return true
+	}
+	rewind := time.Duration(l.player.Progress-player.Progress) * time.Millisecond
+	return rewind > seekTolerance && time.Duration(player.Progress)*time.Millisecond < seekTolerance
+}
+
+// newScrobble returns the scrobble of the track followed for the user
+func (l *listening) newScrobble(userID string) scrobble {
+	return scrobble{
+		UserID:      userID,
+		Track:       l.player.MusicName,
+		ArtistsName: l.player.ArtistsName,
+		Album:       l.player.AlbumName,
+		TrackID:     l.player.ID,
+		Duration:    l.player.Duration,
+		ListenedAt:  l.startedAt,
+	}
+}
+
+// scrobbler detects the tracks listened to by the users with a scrobbling account from the players polled by the tracker, and submits them to the account of each user
+// The accounts are kept on disk with the last access token of the users, so the tracker polls them again after a restart
+// The scrobbles are queued on disk first so the ones the API did not accept are submitted again later
+type scrobbler struct {
+	api      scrobbleAPI
+	queue    *scrobbleQueue
+	accounts *accountStore
+
+	mu         sync.Mutex
+	listenings map[string]*listening
+	// flushing serializes the submissions of the queue so a scrobble is not submitted twice
+	flushing sync.Mutex
+}
+
+// newScrobbler creates a scrobbler submitting to the api, the queue keeps the scrobbles not submitted yet
+func newScrobbler(api scrobbleAPI, queue *scrobbleQueue, accounts *accountStore) *scrobbler {
+	return &scrobbler{api: api, queue: queue, accounts: accounts, listenings: map[string]*listening{}}
+}
+
+// register scrobbles the tracks of the user to its account, the tracker polls its player with the access token
+// A registered user gets its account and its access token replaced
+func (s *scrobbler) register(userID, token, spotifyToken string) error {
+	return s.accounts.update(userID, func(account *scrobbleAccount) {
+		account.Token, account.SpotifyToken = token, spotifyToken
+	})
+}
+
+// unregister stops scrobbling the tracks of the user, the scrobbles not submitted yet are dropped at the next flush
+func (s *scrobbler) unregister(userID string) error {
+	if err := s.accounts.remove(userID); err != nil {
+		return err
+	}
+	s.stop(userID)
+	return nil
+}
+
+// registered tells if the tracks of the user are scrobbled
+func (s *scrobbler) registered(userID string) bool {
+	_, ok := s.accounts.get(userID)
+	return ok
+}
+
+// refresh gives its new access token to the registered user, the tokens of the other users are ignored
+func (s *scrobbler) refresh(validated eventbus.TokenValidated) {
+	if account, ok := s.accounts.get(validated.UserID); !ok || account.SpotifyToken == validated.Token {
+		return
+	}
+	err := s.accounts.update(validated.UserID, func(account *scrobbleAccount) {
+		account.SpotifyToken = validated.Token
+	})
+	if err != nil {
+		log.WithError(err).WithField("user", validated.UserID).Error("scrobbler: could not save the new access token")
+	}
+}
+
+// expire drops the access token rejected by spotify, the player of the user is polled again once the gateway validates a new one
+// A token refreshed in the meantime is kept
+func (s *scrobbler) expire(userID, token string) error {
+	if _, ok := s.accounts.get(userID); !ok {
+		return nil
+	}
+	return s.accounts.update(userID, func(account *scrobbleAccount) {
+		if account.SpotifyToken == token {
+			account.SpotifyToken = ""
+		}
+	})
+}
+
+// observe follows the player of the user polled at now
+// The listening time only grows while the track plays, by the time elapsed between the polls and at most by the progress made
+func (s *scrobbler) observe(userID string, player models.Player, now time.Time) {
+	s.mu.Lock()
+	l := s.listenings[userID]
+	if l.restarted(player) {
+		l = &listening{player: player, seenAt: now, startedAt: now.Add(-time.Duration(player.Progress) * time.Millisecond)}
+		s.listenings[userID] = l
+	} else {
+		if l.player.IsPlaying && player.IsPlaying {
+			elapsed := now.Sub(l.seenAt)
+			if progress := time.Duration(player.Progress-l.player.Progress) * time.Millisecond; progress < elapsed {
+				elapsed = progress
+			}
+			if elapsed > 0 {
+				l.played += elapsed
+			}
+		}
+		l.player, l.seenAt = player, now
+	}
+	announce := player.IsPlaying && !l.announced
+	l.announced = l.announced || announce
+	limit, ok := threshold(time.Duration(player.Duration) * time.Millisecond)
+	done := ok && !l.scrobbled && l.played >= limit
+	l.scrobbled = l.scrobbled || done
+	listened := l.newScrobble(userID)
+	s.mu.Unlock()
+
+	if account, ok := s.accounts.get(userID); ok && announce {
+		if err := s.api.nowPlaying(account.Token, listened); err != nil {
+			log.WithError(err).WithField("track", listened.TrackID).Warn("scrobbler: could not update now playing")
+		}
+	}
+	if done {
+		if err := s.queue.add(listened); err != nil {
+			log.WithError(err).WithField("track", listened.TrackID).Error("scrobbler: could not queue scrobble")
+			return
+		}
+		s.flush()
+	}
+}
+
+// stop forgets the track followed for the user, e.g. when nothing is playing anymore
+func (s *scrobbler) stop(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listenings, userID)
+}
+
+// flush submits the queued scrobbles in order with the account of their user
+// A failure keeps the scrobbles of the user for the next flush, the ones of the other users are still submitted
+// The rejected scrobbles and the ones of the users without account anymore are dropped
+func (s *scrobbler) flush() {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+	failed := map[string]bool{}
+	for _, next := range s.queue.all() {
+		if failed[next.UserID] {
+			continue
+		}
+		account, ok := s.accounts.get(next.UserID)
+		if !ok {
+			log.WithField("user", next.UserID).WithField("track", next.TrackID).Warn("scrobbler: no scrobbling account, scrobble dropped")
+		} else if err := s.api.submit(account.Token, next); errors.Is(err, errScrobbleRejected) {
+			log.WithError(err).WithField("track", next.TrackID).Warn("scrobbler: scrobble rejected, dropped")
+		} else if err != nil {
+			log.WithError(err).WithField("user", next.UserID).Warn("scrobbler: could not submit scrobbles, will retry")
+			failed[next.UserID] = true
+			continue
+		}
+		if err := s.queue.remove(next); err != nil {
+			log.WithError(err).Error("scrobbler: could not update the queue")
+			return
+		}
+	}
+}
+
+// run submits the queued scrobbles again every retryInterval until the context is done
+func (s *scrobbler) run(ctx context.Context, retryInterval time.Duration) {
+	retries := time.NewTicker(retryInterval)
+	defer retries.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retries.C:
+			s.flush()
+		}
+	}
+}
+
+// scrobbleQueue keeps the scrobbles not submitted yet in a json file, the oldest first
+// The file is rewritten on every change, it stays small as long as the API is reachable
+type scrobbleQueue struct {
+	path string
+
+	mu    sync.Mutex
+	items []scrobble
+}
+
+// openScrobbleQueue loads the scrobbles queued in the file at path, the queue is empty when the file does not exist
+func openScrobbleQueue(path string) (*scrobbleQueue, error) {
+	q := &scrobbleQueue{path: path, items: []scrobble{}}
+	if err := jsonfile.Read(path, &q.items); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// add queues the scrobble after the others
+func (q *scrobbleQueue) add(s scrobble) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items = append(q.items, s)
+	return jsonfile.Write(q.path, q.items)
+}
+
+// all returns the scrobbles queued, the oldest first
+func (q *scrobbleQueue) all() []scrobble {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]scrobble{}, q.items...)
+}
+
+// remove removes the scrobble queued for the same user, track and listening time
+func (q *scrobbleQueue) remove(s scrobble) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, item := range q.items {
+		if item.UserID == s.UserID && item.TrackID == s.TrackID && item.ListenedAt.Equal(s.ListenedAt) {
+			q.items = append(q.items[:i:i], q.items[i+1:]...)
+			return jsonfile.Write(q.path, q.items)
+		}
+	}
+	return nil
+}
+
+// len returns the number of scrobbles queued
+func (q *scrobbleQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// errScrobblingDisabled is returned when registering an account while no scrobbling API is configured
+var errScrobblingDisabled = errors.New("the scrobbling is disabled")
+
+// scrobblingRequest is the body of POST /player/scrobbling, the token of the account of the user
+// It is the user token of listenbrainz or the session key of lastfm
+type scrobblingRequest struct {
+	Token string `json:"token"`
+}
+
+// scrobblingHandler is the handler registering the account of the user, its player is then polled by the tracker with its access token
+// api is the name of the scrobbling API, the scrobbler is nil when the scrobbling is disabled
+func scrobblingHandler(s *scrobbler, api string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req scrobblingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Error("scrobblingHandler: could not decode scrobbling request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s == nil {
+			log.WithError(errScrobblingDisabled).Error("scrobblingHandler: invalid scrobbling request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Token == "" {
+			log.Error("scrobblingHandler: the token must not be empty")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("scrobblingHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := s.register(id, req.Token, r.Header.Get("Authorization")); err != nil {
+			log.WithError(err).Error("scrobblingHandler: could not save account")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(models.Scrobbling{API: api, Registered: true})
+	}
+}
+
+// getScrobblingHandler is the handler telling if the tracks of the user are scrobbled
+func getScrobblingHandler(s *scrobbler, api string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("getScrobblingHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(models.Scrobbling{API: api, Registered: s != nil && s.registered(id)})
+	}
+}
+
+// unscrobblingHandler is the handler to stop scrobbling the tracks of the user, its account is forgotten
+func unscrobblingHandler(s *scrobbler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s == nil {
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		id, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("unscrobblingHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := s.unregister(id); err != nil {
+			log.WithError(err).Error("unscrobblingHandler: could not remove account")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
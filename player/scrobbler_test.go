@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"common/eventbus"
+	"common/identity"
+	"common/models"
+	"github.com/zmb3/spotify"
+)
+
+// recordingScrobbleAPI records the tracks submitted and the tokens they were submitted with, the errors of the submissions are taken in order
+type recordingScrobbleAPI struct {
+	mu        sync.Mutex
+	playing   []spotify.ID
+	submitted []spotify.ID
+	tokens    []string
+	errs      []error
+}
+
+func (api *recordingScrobbleAPI) nowPlaying(token string, s scrobble) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.playing = append(api.playing, s.TrackID)
+	return nil
+}
+
+func (api *recordingScrobbleAPI) submit(token string, s scrobble) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.errs) > 0 {
+		err := api.errs[0]
+		api.errs = api.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	api.submitted = append(api.submitted, s.TrackID)
+	api.tokens = append(api.tokens, token)
+	return nil
+}
+
+// newTestQueue opens a queue in a temporary directory
+func newTestQueue(t *testing.T) *scrobbleQueue {
+	q, err := openScrobbleQueue(filepath.Join(t.TempDir(), "scrobbles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// newTestScrobbler creates a scrobbler with the accounts of thomas and alice in a temporary directory
+func newTestScrobbler(t *testing.T, api scrobbleAPI, q *scrobbleQueue) *scrobbler {
+	accounts, err := openAccountStore(filepath.Join(t.TempDir(), "scrobble-accounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newScrobbler(api, q, accounts)
+	for _, user := range []string{"thomas", "alice"} {
+		if err := s.register(user, user+"-scrobble", user+"-spotify"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// poll is a player seen by the scrobbler, after seconds from the start of the test
+type poll struct {
+	seconds int
+	player  models.Player
+}
+
+// playingAt returns the player of the track of 3 minutes at the progress in seconds
+func playingAt(id string, isPlaying bool, progress int) models.Player {
+	return models.Player{IsPlaying: isPlaying, ID: spotify.ID(id), MusicName: id, ArtistsName: []string{"The Early Birds"}, Progress: progress * 1000, Duration: 180000}
+}
+
+func Test_threshold(t *testing.T) {
+	tests := []struct {
+		duration   time.Duration
+		expected   time.Duration
+		expectedOk bool
+	}{
+		{duration: 3 * time.Minute, expected: 90 * time.Second, expectedOk: true},
+		{duration: 20 * time.Minute, expected: 4 * time.Minute, expectedOk: true},
+		{duration: 20 * time.Second, expectedOk: false},
+	}
+	for _, tt := range tests {
+		if got, ok := threshold(tt.duration); got != tt.expected || ok != tt.expectedOk {
+			t.Errorf("threshold(%v) = %v, %v, want %v, %v", tt.duration, got, ok, tt.expected, tt.expectedOk)
+		}
+	}
+}
+
+func Test_scrobbler_observe(t *testing.T) {
+	tests := []struct {
+		name              string
+		polls             []poll
+		expectedPlaying   []spotify.ID
+		expectedSubmitted []spotify.ID
+	}{
+		{
+			name:              "should scrobble a track played for half its duration",
+			polls:             []poll{{0, playingAt("sunrise", true, 0)}, {60, playingAt("sunrise", true, 60)}, {90, playingAt("sunrise", true, 90)}, {120, playingAt("sunrise", true, 120)}},
+			expectedPlaying:   []spotify.ID{"sunrise"},
+			expectedSubmitted: []spotify.ID{"sunrise"},
+		},
+		{
+			name:            "should not scrobble a track skipped before half its duration",
+			polls:           []poll{{0, playingAt("sunrise", true, 0)}, {60, playingAt("sunrise", true, 60)}, {62, playingAt("coffee", true, 1)}},
+			expectedPlaying: []spotify.ID{"sunrise", "coffee"},
+		},
+		{
+			name:            "should not count a seek as listened",
+			polls:           []poll{{0, playingAt("sunrise", true, 0)}, {10, playingAt("sunrise", true, 170)}, {15, playingAt("sunrise", true, 175)}},
+			expectedPlaying: []spotify.ID{"sunrise"},
+		},
+		{
+			name:            "should not count the time paused",
+			polls:           []poll{{0, playingAt("sunrise", true, 0)}, {60, playingAt("sunrise", false, 60)}, {600, playingAt("sunrise", true, 60)}, {620, playingAt("sunrise", true, 80)}},
+			expectedPlaying: []spotify.ID{"sunrise"},
+		},
+		{
+			name:              "should not count the time between the polls beyond the progress",
+			polls:             []poll{{0, playingAt("sunrise", true, 0)}, {3600, playingAt("sunrise", true, 30)}, {3660, playingAt("sunrise", true, 90)}},
+			expectedPlaying:   []spotify.ID{"sunrise"},
+			expectedSubmitted: []spotify.ID{"sunrise"},
+		},
+		{
+			name:              "should scrobble a track played again from its start",
+			polls:             []poll{{0, playingAt("sunrise", true, 0)}, {100, playingAt("sunrise", true, 100)}, {180, playingAt("sunrise", true, 0)}, {280, playingAt("sunrise", true, 100)}},
+			expectedPlaying:   []spotify.ID{"sunrise", "sunrise"},
+			expectedSubmitted: []spotify.ID{"sunrise", "sunrise"},
+		},
+		{
+			name:            "should announce a paused track once it plays",
+			polls:           []poll{{0, playingAt("sunrise", false, 0)}, {60, playingAt("sunrise", false, 0)}, {61, playingAt("sunrise", true, 1)}},
+			expectedPlaying: []spotify.ID{"sunrise"},
+		},
+		{
+			name:            "should not scrobble the short tracks",
+			polls:           []poll{{0, models.Player{IsPlaying: true, ID: "jingle", Duration: 20000}}, {20, models.Player{IsPlaying: true, ID: "jingle", Progress: 20000, Duration: 20000}}},
+			expectedPlaying: []spotify.ID{"jingle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &recordingScrobbleAPI{}
+			s := newTestScrobbler(t, api, newTestQueue(t))
+			start := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+			for _, p := range tt.polls {
+				s.observe("thomas", p.player, start.Add(time.Duration(p.seconds)*time.Second))
+			}
+			if !reflect.DeepEqual(api.playing, tt.expectedPlaying) {
+				t.Errorf("observe() announced %v, want %v", api.playing, tt.expectedPlaying)
+			}
+			if !reflect.DeepEqual(api.submitted, tt.expectedSubmitted) {
+				t.Errorf("observe() submitted %v, want %v", api.submitted, tt.expectedSubmitted)
+			}
+		})
+	}
+}
+
+func Test_scrobbler_observe_users(t *testing.T) {
+	api := &recordingScrobbleAPI{}
+	s := newTestScrobbler(t, api, newTestQueue(t))
+	start := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+
+	s.observe("thomas", playingAt("sunrise", true, 0), start)
+	s.observe("alice", playingAt("sunrise", true, 0), start)
+	s.observe("thomas", playingAt("sunrise", true, 60), start.Add(60*time.Second))
+	s.observe("alice", playingAt("sunrise", true, 100), start.Add(100*time.Second))
+	if !reflect.DeepEqual(api.submitted, []spotify.ID{"sunrise"}) || !reflect.DeepEqual(api.tokens, []string{"alice-scrobble"}) {
+		t.Errorf("observe() submitted %v with %v, want the track of alice to her account only", api.submitted, api.tokens)
+	}
+
+	s.stop("thomas")
+	s.observe("thomas", playingAt("sunrise", true, 60), start.Add(200*time.Second))
+	if !reflect.DeepEqual(api.playing, []spotify.ID{"sunrise", "sunrise", "sunrise"}) {
+		t.Errorf("observe() after stop() announced %v, want the track again", api.playing)
+	}
+}
+
+func Test_scrobbler_observe_listenedAt(t *testing.T) {
+	q := newTestQueue(t)
+	s := newTestScrobbler(t, &recordingScrobbleAPI{errs: []error{errors.New("offline")}}, q)
+	start := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	s.observe("thomas", playingAt("sunrise", true, 10), start)
+	s.observe("thomas", playingAt("sunrise", true, 100), start.Add(90*time.Second))
+
+	queued := q.all()
+	want := []scrobble{{UserID: "thomas", Track: "sunrise", ArtistsName: []string{"The Early Birds"}, TrackID: "sunrise", Duration: 180000, ListenedAt: start.Add(-10 * time.Second)}}
+	if !reflect.DeepEqual(queued, want) {
+		t.Errorf("observe() queued %+v, want %+v", queued, want)
+	}
+}
+
+func Test_scrobbler_refresh(t *testing.T) {
+	s := newTestScrobbler(t, &recordingScrobbleAPI{}, newTestQueue(t))
+	if err := s.expire("thomas", "thomas-spotify"); err != nil {
+		t.Fatal(err)
+	}
+	s.refresh(eventbus.TokenValidated{UserID: "thomas", Token: "renewed"})
+	s.refresh(eventbus.TokenValidated{UserID: "bob", Token: "bob-spotify"})
+	// a stale token rejected after a refresh does not drop the new one
+	s.expire("alice", "stale")
+
+	want := map[string]string{"thomas": "renewed", "alice": "alice-spotify"}
+	if tokens := s.accounts.spotifyTokens(); !reflect.DeepEqual(tokens, want) {
+		t.Errorf("spotifyTokens() = %v, want %v", tokens, want)
+	}
+	if s.registered("bob") {
+		t.Errorf("refresh() registered bob, want the tokens of the users without account ignored")
+	}
+}
+
+func Test_scrobbler_unregister(t *testing.T) {
+	api := &recordingScrobbleAPI{errs: []error{errors.New("offline")}}
+	q := newTestQueue(t)
+	s := newTestScrobbler(t, api, q)
+	start := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	s.observe("thomas", playingAt("sunrise", true, 0), start)
+	s.observe("thomas", playingAt("sunrise", true, 90), start.Add(90*time.Second))
+	s.observe("alice", playingAt("coffee", true, 0), start)
+
+	if err := s.unregister("thomas"); err != nil {
+		t.Fatal(err)
+	}
+	if s.registered("thomas") {
+		t.Errorf("registered() after unregister() = true, want false")
+	}
+	if _, ok := s.listenings["thomas"]; ok || len(s.listenings) != 1 {
+		t.Errorf("unregister() kept the listenings %v, want the one of alice only", s.listenings)
+	}
+	s.flush()
+	if len(api.submitted) != 0 || len(q.all()) != 0 {
+		t.Errorf("flush() submitted %v and kept %v, want the scrobble of thomas dropped", api.submitted, q.all())
+	}
+}
+
+func Test_scrobbler_flush(t *testing.T) {
+	q := newTestQueue(t)
+	for _, s := range []scrobble{{UserID: "thomas", TrackID: "sunrise"}, {UserID: "thomas", TrackID: "coffee"}, {UserID: "alice", TrackID: "commute"}, {UserID: "thomas", TrackID: "evening"}} {
+		if err := q.add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &recordingScrobbleAPI{errs: []error{nil, errors.New("offline")}}
+	s := newTestScrobbler(t, api, q)
+
+	s.flush()
+	if !reflect.DeepEqual(api.submitted, []spotify.ID{"sunrise", "commute"}) || !reflect.DeepEqual(api.tokens, []string{"thomas-scrobble", "alice-scrobble"}) || len(q.all()) != 2 {
+		t.Fatalf("flush() with the account of thomas failing submitted %v with %v and kept %d, want the scrobbles of alice submitted", api.submitted, api.tokens, len(q.all()))
+	}
+
+	api.errs = []error{errScrobbleRejected}
+	s.flush()
+	if !reflect.DeepEqual(api.submitted, []spotify.ID{"sunrise", "commute", "evening"}) || len(q.all()) != 0 {
+		t.Errorf("flush() submitted %v and kept %d, want the rejected scrobble dropped", api.submitted, len(q.all()))
+	}
+}
+
+func Test_scrobbleQueue_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scrobbles.json")
+	q, err := openScrobbleQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenedAt := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	sunrise := scrobble{UserID: "thomas", Track: "Sunrise", TrackID: "sunrise", ListenedAt: listenedAt}
+	q.add(sunrise)
+	q.add(scrobble{UserID: "thomas", Track: "Coffee", TrackID: "coffee", ListenedAt: listenedAt.Add(3 * time.Minute)})
+	q.remove(sunrise)
+
+	q, err = openScrobbleQueue(path)
+	if err != nil {
+		t.Fatalf("openScrobbleQueue() of an existing queue error = %v", err)
+	}
+	if left := q.all(); len(left) != 1 || left[0].UserID != "thomas" || left[0].TrackID != "coffee" || !left[0].ListenedAt.Equal(listenedAt.Add(3*time.Minute)) {
+		t.Errorf("openScrobbleQueue() = %+v, want the scrobble left", left)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("the queue left temporary files %v", matches)
+	}
+}
+
+func Test_scrobbler_run(t *testing.T) {
+	q := newTestQueue(t)
+	q.add(scrobble{UserID: "thomas", TrackID: "sunrise"})
+	api := &recordingScrobbleAPI{errs: []error{errors.New("offline")}}
+	s := newTestScrobbler(t, api, q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run() did not return once the context was done")
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if !reflect.DeepEqual(api.submitted, []spotify.ID{"sunrise"}) || len(q.all()) != 0 {
+		t.Errorf("run() submitted %v, want the queued scrobble once the API is back", api.submitted)
+	}
+}
+
+func Test_scrobblingHandler(t *testing.T) {
+	tests := []struct {
+		name               string
+		enabled            bool
+		body               string
+		expectedCode       int
+		expectedRegistered bool
+	}{
+		{name: "should register the account of the user", enabled: true, body: `{"token":"bob-scrobble"}`, expectedCode: http.StatusOK, expectedRegistered: true},
+		{name: "should refuse an empty token", enabled: true, body: `{"token":""}`, expectedCode: http.StatusBadRequest},
+		{name: "should refuse an invalid request", enabled: true, body: `{"token":`, expectedCode: http.StatusBadRequest},
+		{name: "should refuse when the scrobbling is disabled", body: `{"token":"bob-scrobble"}`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s *scrobbler
+			if tt.enabled {
+				s = newTestScrobbler(t, &recordingScrobbleAPI{}, newTestQueue(t))
+			}
+			req := httptest.NewRequest(http.MethodPost, "/player/scrobbling", strings.NewReader(tt.body))
+			req.Header.Set(identity.Header, "bob")
+			req.Header.Set("Authorization", "bob-spotify")
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, &mockSpotifyClient{}))
+			rr := httptest.NewRecorder()
+			scrobblingHandler(s, "listenbrainz").ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if s == nil {
+				return
+			}
+			if account, ok := s.accounts.get("bob"); ok != tt.expectedRegistered || (ok && account != (scrobbleAccount{Token: "bob-scrobble", SpotifyToken: "bob-spotify"})) {
+				t.Errorf("handler saved the account %+v, %v, want registered %v", account, ok, tt.expectedRegistered)
+			}
+		})
+	}
+}
+
+func Test_getScrobblingHandler(t *testing.T) {
+	s := newTestScrobbler(t, &recordingScrobbleAPI{}, newTestQueue(t))
+	tests := []struct {
+		name      string
+		scrobbler *scrobbler
+		api       string
+		user      string
+		expected  models.Scrobbling
+	}{
+		{name: "should tell the user is registered", scrobbler: s, api: "lastfm", user: "thomas", expected: models.Scrobbling{API: "lastfm", Registered: true}},
+		{name: "should tell the user is not registered", scrobbler: s, api: "lastfm", user: "bob", expected: models.Scrobbling{API: "lastfm"}},
+		{name: "should tell the scrobbling is disabled", user: "thomas", expected: models.Scrobbling{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/player/scrobbling", nil)
+			req.Header.Set(identity.Header, tt.user)
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, &mockSpotifyClient{}))
+			rr := httptest.NewRecorder()
+			getScrobblingHandler(tt.scrobbler, tt.api).ServeHTTP(rr, req)
+
+			var got models.Scrobbling
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got != tt.expected {
+				t.Errorf("handler returned %+v, %v, want %+v", got, err, tt.expected)
+			}
+		})
+	}
+}
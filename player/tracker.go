@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"common/eventbus"
+	"common/models"
+	"common/webhooks"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// tracker polls in the background the player of the users known to the service and follows the track of each one
+// The users are the ones whose access token the gateway validated, and the ones with a scrobbling account
+// A track change is published once to the webhooks of the user and on the bus whatever the event streams open, the players are given to the scrobbler
+type tracker struct {
+	newClient func(token string) spotifyClient
+	// publisher notifies the webhooks of the user when the track changes, nil disables the notifications
+	publisher *webhooks.Publisher
+	// bus is where the track changes are published for the other services, nil disables the events
+	bus eventbus.Bus
+	// scrobbles follows the tracks of the users with an account, nil when the scrobbling is disabled
+	scrobbles *scrobbler
+
+	mu     sync.Mutex
+	tokens map[string]string
+	// last is the player last seen for each user, the track changed when the one polled has another track
+	last map[string]models.Player
+}
+
+// newTracker creates a tracker polling the players with the clients created by newClient for the access tokens of the users
+func newTracker(newClient func(token string) spotifyClient, publisher *webhooks.Publisher, bus eventbus.Bus, scrobbles *scrobbler) *tracker {
+	return &tracker{newClient: newClient, publisher: publisher, bus: bus, scrobbles: scrobbles, tokens: map[string]string{}, last: map[string]models.Player{}}
+}
+
+// refresh follows the player of the user with the access token validated by the gateway, the scrobbling account gets it as well
+func (t *tracker) refresh(validated eventbus.TokenValidated) {
+	t.mu.Lock()
+	t.tokens[validated.UserID] = validated.Token
+	t.mu.Unlock()
+	if t.scrobbles != nil {
+		t.scrobbles.refresh(validated)
+	}
+}
+
+// expire drops the access token rejected by spotify, the player of the user is polled again once the gateway validates a new one
+// A token refreshed in the meantime is kept
+func (t *tracker) expire(userID, token string) error {
+	t.mu.Lock()
+	if t.tokens[userID] == token {
+		delete(t.tokens, userID)
+	}
+	t.mu.Unlock()
+	if t.scrobbles == nil {
+		return nil
+	}
+	return t.scrobbles.expire(userID, token)
+}
+
+// users returns the users with a valid access token along with it, the one validated by the gateway is preferred to the one of the scrobbling account
+func (t *tracker) users() map[string]string {
+	users := map[string]string{}
+	if t.scrobbles != nil {
+		for userID, token := range t.scrobbles.accounts.spotifyTokens() {
+			users[userID] = token
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for userID, token := range t.tokens {
+		users[userID] = token
+	}
+	return users
+}
+
+// poll follows the player of every user with a valid access token
+func (t *tracker) poll() {
+	for userID, token := range t.users() {
+		player, err := getPlayer(t.newClient(token))
+		var spotifyErr spotify.Error
+		switch {
+		case errors.Is(err, errNothingPlaying):
+			t.forget(userID)
+		case errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusUnauthorized:
+			log.WithField("user", userID).Warn("tracker: access token rejected, waiting for a new one")
+			t.forget(userID)
+			if err := t.expire(userID, token); err != nil {
+				log.WithError(err).WithField("user", userID).Error("tracker: could not drop the access token")
+			}
+		case err != nil:
+			log.WithError(err).WithField("user", userID).Error("tracker: could not get player")
+		default:
+			t.observe(userID, player, time.Now())
+		}
+	}
+}
+
+// observe publishes the track change of the user from the player last seen and gives the player to the scrobbler
+// The first track seen, e.g. once something plays again, is not a change
+func (t *tracker) observe(userID string, player models.Player, now time.Time) {
+	t.mu.Lock()
+	last, seen := t.last[userID]
+	t.last[userID] = player
+	t.mu.Unlock()
+	if seen && last.ID != player.ID {
+		t.publisher.Go(models.TrackChangedEvent, userID, player)
+		eventbus.Go(t.bus, eventbus.TrackChanged{UserID: userID, PreviousTrackID: last.ID, Player: player, OccurredAt: now.UTC()})
+	}
+	if t.scrobbles != nil && t.scrobbles.registered(userID) {
+		t.scrobbles.observe(userID, player, now)
+	}
+}
+
+// forget drops the player last seen for the user, e.g. when nothing is playing anymore
+func (t *tracker) forget(userID string) {
+	t.mu.Lock()
+	delete(t.last, userID)
+	t.mu.Unlock()
+	if t.scrobbles != nil {
+		t.scrobbles.stop(userID)
+	}
+}
+
+// run polls the players every interval until the context is done
+func (t *tracker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.poll()
+		}
+	}
+}
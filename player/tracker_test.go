@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/eventbus"
+	"common/models"
+	"common/webhooks"
+	"github.com/zmb3/spotify"
+)
+
+// newTestWebhooks starts a webhooks service receiving the events published, along with its publisher
+func newTestWebhooks(t *testing.T) (*webhooks.Publisher, <-chan models.Event) {
+	published := make(chan models.Event, 10)
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event models.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		published <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(hooks.Close)
+	return webhooks.NewPublisher(hooks.URL, "services"), published
+}
+
+func Test_tracker_poll_publishesTrackChanges(t *testing.T) {
+	publisher, published := newTestWebhooks(t)
+	bus := eventbus.NewMemory()
+	defer bus.Close()
+	changes := make(chan eventbus.TrackChanged, 10)
+	eventbus.OnTrackChanged(bus, func(change eventbus.TrackChanged) { changes <- change })
+	thomas := &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{
+		currentlyPlaying("sunrise", true, 0),
+		currentlyPlaying("sunrise", false, 0),
+		currentlyPlaying("coffee", true, 0),
+		currentlyPlaying("coffee", true, 10),
+		{},
+		currentlyPlaying("commute", true, 0),
+	}}
+	tr := newTracker(func(token string) spotifyClient { return thomas }, publisher, bus, nil)
+	tr.refresh(eventbus.TokenValidated{UserID: "thomas", Token: "thomas-spotify"})
+
+	// a single change whatever the polls, nothing is published for the track played after nothing was playing
+	for range thomas.players {
+		tr.poll()
+	}
+	select {
+	case event := <-published:
+		var player models.Player
+		json.Unmarshal(event.Data, &player)
+		if event.Event != models.TrackChangedEvent || event.UserID != "thomas" || player.ID != "coffee" {
+			t.Errorf("published %+v, want the track change of thomas to coffee", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the track change was not published")
+	}
+	select {
+	case change := <-changes:
+		if change.UserID != "thomas" || change.PreviousTrackID != "sunrise" || change.Player.ID != "coffee" {
+			t.Errorf("bus got %+v, want the track change of thomas from sunrise to coffee", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the track change was not published on the bus")
+	}
+	select {
+	case event := <-published:
+		t.Errorf("published %+v, want a single track change", event)
+	case change := <-changes:
+		t.Errorf("bus got %+v, want a single track change", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_tracker_poll(t *testing.T) {
+	api := &recordingScrobbleAPI{}
+	thomas := &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{currentlyPlaying("sunrise", true, 0), {}, currentlyPlaying("sunrise", true, 0)}}
+	alice := &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{nil}, errs: []error{spotify.Error{Message: "The access token expired", Status: http.StatusUnauthorized}}}
+	bob := &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{currentlyPlaying("commute", true, 0)}}
+	clients := map[string]spotifyClient{"thomas-spotify": thomas, "alice-spotify": alice, "bob-spotify": bob}
+	s := newTestScrobbler(t, api, newTestQueue(t))
+	tr := newTracker(func(token string) spotifyClient { return clients[token] }, nil, nil, s)
+	tr.refresh(eventbus.TokenValidated{UserID: "bob", Token: "bob-spotify"})
+
+	tr.poll()
+	if users := tr.users(); !reflect.DeepEqual(users, map[string]string{"thomas": "thomas-spotify", "bob": "bob-spotify"}) {
+		t.Errorf("poll() kept the access tokens %v, want the rejected one of alice dropped", users)
+	}
+	// nothing playing forgets the track, it is announced again once played; bob has no account so nothing is scrobbled for him
+	tr.poll()
+	tr.poll()
+	if !reflect.DeepEqual(api.playing, []spotify.ID{"sunrise", "sunrise"}) {
+		t.Errorf("poll() announced %v, want the track of thomas twice", api.playing)
+	}
+	if alice.calls != 1 || bob.calls != 3 {
+		t.Errorf("poll() polled alice %d times and bob %d times, want to wait for a new access token of alice", alice.calls, bob.calls)
+	}
+
+	tr.refresh(eventbus.TokenValidated{UserID: "alice", Token: "alice-spotify"})
+	tr.poll()
+	if alice.calls != 2 {
+		t.Errorf("poll() polled alice %d times after refresh(), want her player polled again", alice.calls)
+	}
+}
+
+func Test_tracker_run(t *testing.T) {
+	thomas := &sequenceSpotifyClient{players: []*spotify.CurrentlyPlaying{currentlyPlaying("coffee", true, 0)}}
+	tr := newTracker(func(token string) spotifyClient { return thomas }, nil, nil, nil)
+	tr.refresh(eventbus.TokenValidated{UserID: "thomas", Token: "thomas-spotify"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tr.run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("run() did not return once the context was done")
+	}
+	thomas.mu.Lock()
+	defer thomas.mu.Unlock()
+	if thomas.calls < 2 {
+		t.Errorf("run() polled %d times, want the player polled without any stream open", thomas.calls)
+	}
+}
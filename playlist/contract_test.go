@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"common/openapi"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	tests := []struct {
+		name     string
+		playlist spotify.SimplePlaylistPage
+	}{
+		{name: "should document no playlists"},
+		{
+			name: "should document the playlists",
+			playlist: spotify.SimplePlaylistPage{Playlists: []spotify.SimplePlaylist{
+				{Name: "Morning", ID: "morning", URI: "spotify:playlist:morning", Owner: spotify.User{DisplayName: "Thomas"}, Images: []spotify.Image{{URL: "http://image"}}},
+				{Name: "Evening", ID: "evening"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/playlist", nil)
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, &mockSpotifyClient{playlist: tt.playlist}))
+			rr := httptest.NewRecorder()
+			spec.Middleware(http.HandlerFunc(playlistHandler)).ServeHTTP(rr, req)
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse("GET", "/playlist", rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_playlist(t *testing.T) {
+	spec := openapi.MustLoad()
+	r := mux.NewRouter()
+	r.HandleFunc("/playlist/{playlistID}", playlistFromHandler(newFeatureCache(10)))
+
+	req := httptest.NewRequest("GET", "/playlist/morning?limit=1&features=true", nil)
+	req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, morningPlaylist()))
+	rr := httptest.NewRecorder()
+	spec.Middleware(r).ServeHTTP(rr, req)
+	if res := rr.Code; res != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+	}
+	if err := spec.ValidateResponse("GET", "/playlist/morning", rr.Code, rr.Body.Bytes()); err != nil {
+		t.Errorf("handler response does not match the specification: %v", err)
+	}
+}
+
+func Test_contract_features(t *testing.T) {
+	spec := openapi.MustLoad()
+	r := mux.NewRouter()
+	cache := newFeatureCache(10)
+	r.HandleFunc("/tracks/features", batchFeaturesHandler(cache)).Methods("POST")
+	r.HandleFunc("/tracks/{trackID}/features", featuresHandler(cache)).Methods("GET")
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "should document the features of a track", method: "GET", path: "/tracks/sunrise/features"},
+		{name: "should document the features of several tracks", method: "POST", path: "/tracks/features", body: `{"ids": ["sunrise", "unknown"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, morningPlaylist()))
+			rr := httptest.NewRecorder()
+			spec.Middleware(r).ServeHTTP(rr, req)
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse(tt.method, tt.path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_sort(t *testing.T) {
+	spec := openapi.MustLoad()
+	r := mux.NewRouter()
+	r.HandleFunc("/playlist/{playlistID}/sort", sortHandler(newFeatureCache(10), nil, nil)).Methods("POST")
+	for _, body := range []string{`{"by": "smooth"}`, `{"by": "title", "order": "desc", "apply": true, "snapshot_id": "snapshot-1"}`} {
+		t.Run(body, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/playlist/mix/sort", strings.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, unsortedPlaylist()))
+			rr := httptest.NewRecorder()
+			spec.Middleware(r).ServeHTTP(rr, req)
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse("POST", "/playlist/mix/sort", rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
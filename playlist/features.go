@@ -0,0 +1,235 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"common/models"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+const (
+	// featuresBatchSize is the largest number of tracks spotify gives the audio features of at once
+	featuresBatchSize = 100
+	// maxFeatureIDs is the largest number of tracks a batch request asks the audio features of
+	maxFeatureIDs = 500
+)
+
+// featureCache keeps the audio features of the tracks, they never change so only the least recently used are evicted
+type featureCache struct {
+	size int
+
+	mu       sync.Mutex
+	order    *list.List
+	features map[spotify.ID]*list.Element
+}
+
+// newFeatureCache creates a cache of the audio features of up to size tracks
+func newFeatureCache(size int) *featureCache {
+	return &featureCache{
+		size:     size,
+		order:    list.New(),
+		features: map[spotify.ID]*list.Element{},
+	}
+}
+
+// get returns the audio features of the track when cached
+func (c *featureCache) get(id spotify.ID) (models.TrackFeatures, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.features[id]
+	if !ok {
+		return models.TrackFeatures{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(models.TrackFeatures), true
+}
+
+// add caches the audio features of a track, the least recently used are removed once the cache is full
+func (c *featureCache) add(features models.TrackFeatures) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.features[features.ID]; ok {
+		element.Value = features
+		c.order.MoveToFront(element)
+		return
+	}
+	c.features[features.ID] = c.order.PushFront(features)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.features, oldest.Value.(models.TrackFeatures).ID)
+	}
+}
+
+// trackFeatures returns the audio features of the tracks in the order of the ids, nil for the tracks spotify does not know
+// Only the tracks missing from the cache are asked to spotify, by batches of its limit
+func trackFeatures(client spotifyClient, cache *featureCache, ids []spotify.ID) ([]*models.TrackFeatures, error) {
+	found := map[spotify.ID]*models.TrackFeatures{}
+	var missing []spotify.ID
+	for _, id := range ids {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		if features, ok := cache.get(id); ok {
+			found[id] = &features
+			continue
+		}
+		found[id] = nil
+		missing = append(missing, id)
+	}
+
+	for start := 0; start < len(missing); start += featuresBatchSize {
+		end := start + featuresBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batch, err := client.GetAudioFeatures(missing[start:end]...)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range batch {
+			if f == nil {
+				continue
+			}
+			features := models.ReduceAudioFeatures(*f)
+			cache.add(features)
+			found[features.ID] = &features
+		}
+	}
+
+	items := make([]*models.TrackFeatures, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, found[id])
+	}
+	return items, nil
+}
+
+// attachFeatures sets the audio features of the tracks of the playlist, the local files have no ID and no features
+func attachFeatures(client spotifyClient, cache *featureCache, playlist *models.Playlist) error {
+	var ids []spotify.ID
+	for _, t := range playlist.Tracks {
+		if t.ID != "" {
+			ids = append(ids, t.ID)
+		}
+	}
+	items, err := trackFeatures(client, cache, ids)
+	if err != nil {
+		return err
+	}
+	byID := map[spotify.ID]*models.TrackFeatures{}
+	for _, f := range items {
+		if f != nil {
+			byID[f.ID] = f
+		}
+	}
+	for i := range playlist.Tracks {
+		playlist.Tracks[i].Features = byID[playlist.Tracks[i].ID]
+	}
+	return nil
+}
+
+// validTrackID tells if the ID can be joined in the ids of a spotify request
+func validTrackID(id spotify.ID) bool {
+	return id != "" && !strings.ContainsAny(string(id), ",?&/")
+}
+
+// featuresRequest is the body of POST /tracks/features
+type featuresRequest struct {
+	IDs []spotify.ID `json:"ids"`
+}
+
+// validate checks the number of tracks asked and their IDs
+func (req featuresRequest) validate() error {
+	if len(req.IDs) == 0 || len(req.IDs) > maxFeatureIDs {
+		return fmt.Errorf("%d ids asked, between 1 and %d expected", len(req.IDs), maxFeatureIDs)
+	}
+	for _, id := range req.IDs {
+		if !validTrackID(id) {
+			return fmt.Errorf("invalid id %q", id)
+		}
+	}
+	return nil
+}
+
+// featuresStatus returns the status answered for the errors of spotify, it rejects the malformed IDs
+func featuresStatus(err error) int {
+	var serr spotify.Error
+	if errors.As(err, &serr) && serr.Status == http.StatusBadRequest {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// featuresHandler is the handler to get the audio features of a track from its spotify ID
+func featuresHandler(cache *featureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trackID := spotify.ID(mux.Vars(r)["trackID"])
+		if !validTrackID(trackID) {
+			log.WithField("trackID", trackID).Error("featuresHandler: invalid track ID")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		items, err := trackFeatures(client, cache, []spotify.ID{trackID})
+		if err != nil {
+			log.WithField("trackID", trackID).WithError(err).Error("featuresHandler: could not get audio features")
+			w.WriteHeader(featuresStatus(err))
+			return
+		}
+		if items[0] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(items[0])
+	}
+}
+
+// batchFeaturesHandler is the handler to get the audio features of several tracks at once
+// The items are in the order of the ids, null for the tracks spotify does not know
+func batchFeaturesHandler(cache *featureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req featuresRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Error("batchFeaturesHandler: could not decode features request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			log.WithError(err).Error("batchFeaturesHandler: invalid features request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		items, err := trackFeatures(client, cache, req.IDs)
+		if err != nil {
+			log.WithError(err).Error("batchFeaturesHandler: could not get audio features")
+			w.WriteHeader(featuresStatus(err))
+			return
+		}
+
+		json.NewEncoder(w).Encode(models.TracksFeatures{Items: items})
+	}
+}
+
+// featuresFromQuery reads the optional features query parameter asking the audio features of the tracks
+func featuresFromQuery(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("features")
+	if raw == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid features %q", raw)
+	}
+	return enabled, nil
+}
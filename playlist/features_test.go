@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"common/models"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+func Test_featureCache(t *testing.T) {
+	cache := newFeatureCache(2)
+	cache.add(models.TrackFeatures{ID: "sunrise", Tempo: 120})
+	cache.add(models.TrackFeatures{ID: "coffee", Tempo: 90})
+	// reading sunrise makes coffee the least recently used
+	if got, ok := cache.get("sunrise"); !ok || got.Tempo != 120 {
+		t.Fatalf("get() = %+v, %v, want the features of sunrise", got, ok)
+	}
+	cache.add(models.TrackFeatures{ID: "commute", Tempo: 140})
+
+	for id, expected := range map[spotify.ID]bool{"sunrise": true, "coffee": false, "commute": true} {
+		if _, ok := cache.get(id); ok != expected {
+			t.Errorf("get(%s) cached = %v, want %v", id, ok, expected)
+		}
+	}
+}
+
+func Test_trackFeatures(t *testing.T) {
+	client := &mockSpotifyClient{features: map[spotify.ID]spotify.AudioFeatures{}}
+	var ids []spotify.ID
+	for i := 0; i < 250; i++ {
+		id := spotify.ID(fmt.Sprintf("track%d", i))
+		client.features[id] = spotify.AudioFeatures{ID: id, Tempo: float32(i)}
+		ids = append(ids, id)
+	}
+	cache := newFeatureCache(1000)
+	cache.add(models.TrackFeatures{ID: "track0", Tempo: 0})
+
+	got, err := trackFeatures(client, cache, append(ids, "unknown", "track1"))
+	if err != nil {
+		t.Fatalf("trackFeatures() error = %v", err)
+	}
+	var batches []int
+	for _, call := range client.featureCalls {
+		batches = append(batches, len(call))
+	}
+	// track0 is cached, the duplicate of track1 is asked once
+	if !reflect.DeepEqual(batches, []int{100, 100, 50}) {
+		t.Errorf("trackFeatures() asked spotify batches of %v, want 100, 100 and 50", batches)
+	}
+	if len(got) != 252 || got[42].ID != "track42" || got[42].Tempo != 42 || got[250] != nil || got[251].ID != "track1" {
+		t.Errorf("trackFeatures() = %d items, want them in the order of the ids with null for the unknown track", len(got))
+	}
+
+	client.featureCalls = nil
+	if _, err := trackFeatures(client, cache, ids[:10]); err != nil || len(client.featureCalls) != 0 {
+		t.Errorf("trackFeatures() of cached tracks called spotify %v, %v", client.featureCalls, err)
+	}
+}
+
+func Test_attachFeatures(t *testing.T) {
+	client := morningPlaylist()
+	playlist := models.Playlist{Tracks: []models.Track{{ID: "sunrise"}, {Name: "local file"}, {ID: "unknown"}}}
+	if err := attachFeatures(client, newFeatureCache(10), &playlist); err != nil {
+		t.Fatalf("attachFeatures() error = %v", err)
+	}
+	if !reflect.DeepEqual(client.featureCalls, [][]spotify.ID{{"sunrise", "unknown"}}) {
+		t.Errorf("attachFeatures() asked %v, want the tracks with an ID", client.featureCalls)
+	}
+	if f := playlist.Tracks[0].Features; f == nil || f.Tempo != 120 || playlist.Tracks[1].Features != nil || playlist.Tracks[2].Features != nil {
+		t.Errorf("attachFeatures() = %+v, want the features of sunrise only", playlist.Tracks)
+	}
+}
+
+func Test_featuresHandler(t *testing.T) {
+	failing := morningPlaylist()
+	failing.err = errors.New("could not get audio features")
+	invalid := morningPlaylist()
+	invalid.err = spotify.Error{Status: http.StatusBadRequest, Message: "invalid request"}
+	tests := []struct {
+		name         string
+		client       *mockSpotifyClient
+		id           string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "should get the audio features of the track",
+			client:       morningPlaylist(),
+			id:           "sunrise",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"ID":"sunrise","tempo":120,"key":2,"mode":1,"energy":0.5,"danceability":0.25,"valence":0.75}`,
+		},
+		{
+			name:         "should not find an unknown track",
+			client:       morningPlaylist(),
+			id:           "unknown",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should reject an ID spotify does not accept",
+			client:       invalid,
+			id:           "sun rise",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should reject an ID joining several tracks",
+			client:       morningPlaylist(),
+			id:           "sunrise,coffee",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should error on spotify api call",
+			client:       failing,
+			id:           "sunrise",
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/tracks/features", nil)
+			r = mux.SetURLVars(r, map[string]string{"trackID": tt.id})
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, tt.client))
+			rr := httptest.NewRecorder()
+			featuresHandler(newFeatureCache(10))(rr, r)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, tt.expectedCode)
+			}
+			if tt.expectedBody != "" && strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					strings.TrimSpace(rr.Body.String()), tt.expectedBody)
+			}
+		})
+	}
+}
+
+func Test_batchFeaturesHandler(t *testing.T) {
+	failing := morningPlaylist()
+	failing.err = errors.New("could not get audio features")
+	tooMany := `{"ids": ["track"` + strings.Repeat(`, "track"`, maxFeatureIDs) + `]}`
+	tests := []struct {
+		name         string
+		client       *mockSpotifyClient
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "should get the audio features in the order of the ids",
+			client:       morningPlaylist(),
+			body:         `{"ids": ["unknown", "sunrise"]}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"items":[null,{"ID":"sunrise","tempo":120,"key":2,"mode":1,"energy":0.5,"danceability":0.25,"valence":0.75}]}`,
+		},
+		{
+			name:         "should error decoding body",
+			client:       morningPlaylist(),
+			body:         `{"ids": "sunrise"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should reject a request without ids",
+			client:       morningPlaylist(),
+			body:         `{"ids": []}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should reject too many ids",
+			client:       morningPlaylist(),
+			body:         tooMany,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should reject an empty id",
+			client:       morningPlaylist(),
+			body:         `{"ids": ["sunrise", ""]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should error on spotify api call",
+			client:       failing,
+			body:         `{"ids": ["sunrise"]}`,
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/tracks/features", strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, tt.client))
+			rr := httptest.NewRecorder()
+			batchFeaturesHandler(newFeatureCache(10))(rr, r)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, tt.expectedCode)
+			}
+			if tt.expectedBody != "" && strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					strings.TrimSpace(rr.Body.String()), tt.expectedBody)
+			}
+		})
+	}
+}
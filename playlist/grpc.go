@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+
+	"common/pb/playlistpb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// playlistServer implements the grpc playlist service with the same logic as the http handlers
+type playlistServer struct {
+	playlistpb.UnimplementedPlaylistServiceServer
+}
+
+// ListPlaylists returns a page of the current user playlists
+func (s *playlistServer) ListPlaylists(ctx context.Context, req *playlistpb.ListPlaylistsRequest) (*playlistpb.ListPlaylistsResponse, error) {
+	if req.GetLimit() < 0 || req.GetLimit() > 50 || req.GetOffset() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be between 0 and 50 and offset positive")
+	}
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	playlists, err := listPlaylists(client, page{limit: int(req.GetLimit()), offset: int(req.GetOffset())})
+	if err != nil {
+		log.WithError(err).Error("ListPlaylists: could not get user playlists")
+		return nil, status.Error(codes.Internal, "could not get user playlists")
+	}
+
+	resp := &playlistpb.ListPlaylistsResponse{}
+	for _, p := range playlists {
+		resp.Playlists = append(resp.Playlists, playlistpb.FromModel(p))
+	}
+	return resp, nil
+}
+
+// tokenInterceptor will retrieve the token from the authorization metadata and add the spotify client in the call context
+func tokenInterceptor(newClient func(token string) spotifyClient) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var bearer string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			bearer = md.Get("authorization")[0]
+		}
+		return handler(context.WithValue(ctx, CLIENT_CONTEXT, newClient(bearer)), req)
+	}
+}
+
+// newGRPCServer creates the grpc server of the playlist service along with the grpc health service
+func newGRPCServer(newClient func(token string) spotifyClient) *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(tokenInterceptor(newClient)))
+	playlistpb.RegisterPlaylistServiceServer(srv, &playlistServer{})
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	return srv
+}
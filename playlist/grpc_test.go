@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"common/models"
+	"common/pb/playlistpb"
+	"github.com/zmb3/spotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCServer serves the grpc API in memory, every call uses the given client
+// The tokens received are appended to tokens
+func startGRPCServer(t *testing.T, client spotifyClient, tokens *[]string) playlistpb.PlaylistServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(func(token string) spotifyClient {
+		*tokens = append(*tokens, token)
+		return client
+	})
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return ln.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return playlistpb.NewPlaylistServiceClient(conn)
+}
+
+func Test_grpcParity_listPlaylists(t *testing.T) {
+	tests := []struct {
+		name         string
+		client       *mockSpotifyClient
+		expectedCode codes.Code
+	}{
+		{
+			name:         "should get the same empty playlists",
+			client:       &mockSpotifyClient{},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "should get the same playlists",
+			client: &mockSpotifyClient{playlist: spotify.SimplePlaylistPage{
+				Playlists: []spotify.SimplePlaylist{
+					{
+						Name:   "Morning",
+						ID:     "morning",
+						URI:    "spotify:playlist:morning",
+						Owner:  spotify.User{DisplayName: "Thomas"},
+						Images: []spotify.Image{{URL: "http://image"}},
+					},
+					{
+						Name: "Evening",
+						ID:   "evening",
+					},
+				},
+			}},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "should both fail on spotify api call",
+			client:       &mockSpotifyClient{err: errors.New("could not get playlists")},
+			expectedCode: codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/playlist", nil)
+			rr := httptest.NewRecorder()
+			playlistHandler(rr, req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, tt.client)))
+			var restPlaylists []models.PlaylistItem
+			if rr.Code == http.StatusOK {
+				if err := json.NewDecoder(rr.Body).Decode(&restPlaylists); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var tokens []string
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+			resp, err := startGRPCServer(t, tt.client, &tokens).ListPlaylists(ctx, &playlistpb.ListPlaylistsRequest{})
+			if code := status.Code(err); code != tt.expectedCode || (code == codes.OK) != (rr.Code == http.StatusOK) {
+				t.Fatalf("grpc returned wrong code: got %v want %v (http %v)", code, tt.expectedCode, rr.Code)
+			}
+			if !reflect.DeepEqual(tokens, []string{"Bearer token"}) {
+				t.Errorf("grpc client was not created with the token: got %v", tokens)
+			}
+			if err != nil {
+				return
+			}
+			grpcPlaylists := []models.PlaylistItem{}
+			for _, p := range resp.GetPlaylists() {
+				grpcPlaylists = append(grpcPlaylists, p.ToModel())
+			}
+			if !reflect.DeepEqual(grpcPlaylists, restPlaylists) {
+				t.Errorf("grpc returned different playlists: got %+v want %+v", grpcPlaylists, restPlaylists)
+			}
+		})
+	}
+}
@@ -3,13 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
+	"common/config"
+	"common/eventbus"
+	"common/health"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"common/webhooks"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify"
-	"golang.org/x/oauth2"
 )
 
 // Key type of spotify client context
@@ -20,74 +31,196 @@ var CLIENT_CONTEXT = key(1)
 
 // spotifyClient interface of spotify client
 type spotifyClient interface {
-	CurrentUsersPlaylists() (*spotify.SimplePlaylistPage, error)
+	CurrentUsersPlaylistsOpt(opt *spotify.Options) (*spotify.SimplePlaylistPage, error)
+	GetPlaylist(playlistID spotify.ID) (*spotify.FullPlaylist, error)
+	GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error)
+	GetAudioFeatures(ids ...spotify.ID) ([]*spotify.AudioFeatures, error)
+	ReorderPlaylistTracks(playlistID spotify.ID, opt spotify.PlaylistReorderOptions) (snapshotID string, err error)
 }
 
-// playlistItem is a simplified structure of a playlist item
-type playlistItem struct {
-	Image     string      `json:"image"`
-	Name      string      `json:"name"`
-	OwnerName string      `json:"owner_name"`
-	ID        spotify.ID  `json:"ID"`
-	URI       spotify.URI `json:"uri"`
+// errPlaylistNotFound is returned when spotify does not know the playlist
+var errPlaylistNotFound = errors.New("playlist not found")
+
+// page is the window of playlists asked, spotify uses its defaults for the zero values
+type page struct {
+	limit  int
+	offset int
+}
+
+// options returns the spotify options of the page
+func (p page) options() *spotify.Options {
+	opt := &spotify.Options{}
+	if p.limit > 0 {
+		opt.Limit = &p.limit
+	}
+	if p.offset > 0 {
+		opt.Offset = &p.offset
+	}
+	return opt
+}
+
+// listPlaylists returns a page of the current user playlists, it is shared by the http and the grpc APIs
+func listPlaylists(client spotifyClient, p page) ([]models.PlaylistItem, error) {
+	playlists, err := client.CurrentUsersPlaylistsOpt(p.options())
+	if err != nil {
+		return nil, err
+	}
+	return models.ReducePlaylist(playlists), nil
 }
 
-// reducePlaylist will reduce the spotify playlist to a simplified one
-func reducePlaylist(playlistResp *spotify.SimplePlaylistPage) []playlistItem {
-	playlist := []playlistItem{}
+// fetchPlaylist returns the spotify playlist, errPlaylistNotFound when spotify does not know it
+func fetchPlaylist(client spotifyClient, id spotify.ID) (*spotify.FullPlaylist, error) {
+	playlist, err := client.GetPlaylist(id)
+	var serr spotify.Error
+	if errors.As(err, &serr) && serr.Status == http.StatusNotFound {
+		return nil, errPlaylistNotFound
+	}
+	return playlist, err
+}
 
-	for _, item := range playlistResp.Playlists {
-		var image string
-		if len(item.Images) > 0 {
-			image = item.Images[0].URL
+// getPlaylist returns a playlist with a page of its tracks
+func getPlaylist(client spotifyClient, id spotify.ID, p page) (models.Playlist, error) {
+	playlist, err := fetchPlaylist(client, id)
+	if err != nil {
+		return models.Playlist{}, err
+	}
+	tracks, err := client.GetPlaylistTracksOpt(id, p.options(), "")
+	if err != nil {
+		return models.Playlist{}, err
+	}
+	return models.ReducePlaylistTracks(playlist, tracks), nil
+}
+
+// pageFromQuery reads the optional limit and offset query parameters
+func pageFromQuery(r *http.Request) (page, error) {
+	var p page
+	for name, field := range map[string]*int{"limit": &p.limit, "offset": &p.offset} {
+		if raw := r.URL.Query().Get(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				return page{}, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*field = value
 		}
-		playlist = append(playlist, playlistItem{
-			Image:     image,
-			Name:      item.Name,
-			OwnerName: item.Owner.DisplayName,
-			ID:        item.ID,
-			URI:       item.URI,
-		})
-	}
-	return playlist
+	}
+	return p, nil
 }
 
 // playlistHandler is the handler to get the current user playlists
+// The page is chosen with the limit and offset query parameters
 func playlistHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := pageFromQuery(r)
+	if err != nil {
+		log.WithError(err).Error("playlistHandler: could not read page")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
-	playlists, err := client.CurrentUsersPlaylists()
+	reducedPlaylist, err := listPlaylists(client, p)
 	if err != nil {
 		log.WithError(err).Error("playlistHandler: could not get user playlists")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	reducedPlaylist := reducePlaylist(playlists)
 	json.NewEncoder(w).Encode(reducedPlaylist)
 }
 
+// playlistFromHandler is the handler to get a playlist from its spotify ID with a page of its tracks
+// The page of tracks is chosen with the limit and offset query parameters, features=true attaches the audio features of the tracks
+func playlistFromHandler(features *featureCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playlistID := mux.Vars(r)["playlistID"]
+		p, err := pageFromQuery(r)
+		if err != nil {
+			log.WithError(err).Error("playlistFromHandler: could not read page")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		withFeatures, err := featuresFromQuery(r)
+		if err != nil {
+			log.WithError(err).Error("playlistFromHandler: could not read features")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		playlist, err := getPlaylist(client, spotify.ID(playlistID), p)
+		if errors.Is(err, errPlaylistNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == nil && withFeatures {
+			err = attachFeatures(client, features, &playlist)
+		}
+		if err != nil {
+			log.WithField("playlistID", playlistID).WithError(err).Error("playlistFromHandler: could not get playlist")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(playlist)
+	}
+}
+
 // tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
-func tokenMiddleware(next http.Handler) http.Handler {
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bearer := r.Header.Get("Authorization")
-		token := new(oauth2.Token)
-		token.AccessToken = bearer
-		client := spotify.Authenticator{}.NewClient(token)
+		client := factory.Client(bearer)
 		ctx := r.Context()
-		ctx = context.WithValue(ctx, CLIENT_CONTEXT, &client)
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
 func main() {
+	cfg, err := config.Parse("playlist", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+	features := newFeatureCache(cfg.Playlist.FeaturesCacheSize)
+	publisher := webhooks.NewPublisher(cfg.Webhooks.URL, cfg.Webhooks.Token)
+	bus, err := eventbus.Open(cfg.EventBus.URL)
+	if err != nil {
+		log.WithError(err).Fatal("could not open event bus")
+	}
+	defer bus.Close()
+
 	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
 	r.HandleFunc("/playlist", playlistHandler).Methods("GET")
+	r.HandleFunc("/playlist/{playlistID}", playlistFromHandler(features)).Methods("GET")
+	r.HandleFunc("/playlist/{playlistID}/sort", sortHandler(features, publisher, bus)).Methods("POST")
+	r.HandleFunc("/tracks/features", batchFeaturesHandler(features)).Methods("POST")
+	r.HandleFunc("/tracks/{trackID}/features", featuresHandler(features)).Methods("GET")
 
-	corsWrapper := cors.New(cors.Options{
-		AllowedMethods: []string{"GET", "POST"},
-		AllowedHeaders: []string{"Content-Type", "Origin", "Accept", "*"},
-	})
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
 
-	contextedMux := tokenMiddleware(r)
-	log.Fatal(http.ListenAndServe(":8080", corsWrapper.Handler(contextedMux)))
+	grpcServer := newGRPCServer(func(token string) spotifyClient { return factory.Client(token) })
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.RunWithGRPC(contextedMux, cfg.HTTP, grpcServer, cfg.GRPC.Addr); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
 }
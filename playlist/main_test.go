@@ -3,24 +3,90 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/zmb3/spotify"
 )
 
 type mockSpotifyClient struct {
 	err      error
 	playlist spotify.SimplePlaylistPage
+	full     *spotify.FullPlaylist
+	tracks   spotify.PlaylistTrackPage
+	opt      *spotify.Options
+	features map[spotify.ID]spotify.AudioFeatures
+	// featureCalls are the ids of each call to GetAudioFeatures
+	featureCalls [][]spotify.ID
+	reorders     []spotify.PlaylistReorderOptions
 }
 
-func (c *mockSpotifyClient) CurrentUsersPlaylists() (*spotify.SimplePlaylistPage, error) {
+func (c *mockSpotifyClient) CurrentUsersPlaylistsOpt(opt *spotify.Options) (*spotify.SimplePlaylistPage, error) {
+	c.opt = opt
 	return &c.playlist, c.err
 }
 
+func (c *mockSpotifyClient) GetPlaylist(playlistID spotify.ID) (*spotify.FullPlaylist, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.full == nil || c.full.ID != playlistID {
+		return nil, spotify.Error{Status: http.StatusNotFound, Message: "Not found."}
+	}
+	return c.full, nil
+}
+
+// GetPlaylistTracksOpt answers the tracks from the offset, the total is kept so a playlist can be larger than its tracks
+func (c *mockSpotifyClient) GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error) {
+	c.opt = opt
+	page := c.tracks
+	if opt != nil && opt.Offset != nil {
+		page.Tracks = page.Tracks[min(*opt.Offset, len(page.Tracks)):]
+	}
+	if opt != nil && opt.Limit != nil {
+		page.Tracks = page.Tracks[:min(*opt.Limit, len(page.Tracks))]
+	}
+	return &page, c.err
+}
+
+// ReorderPlaylistTracks moves the track in the tracks of the playlist
+func (c *mockSpotifyClient) ReorderPlaylistTracks(playlistID spotify.ID, opt spotify.PlaylistReorderOptions) (string, error) {
+	c.reorders = append(c.reorders, opt)
+	if c.err != nil {
+		return "", c.err
+	}
+	tracks := append([]spotify.PlaylistTrack{}, c.tracks.Tracks...)
+	moved := tracks[opt.RangeStart]
+	tracks = append(tracks[:opt.RangeStart], tracks[opt.RangeStart+1:]...)
+	before := opt.InsertBefore
+	if before > opt.RangeStart {
+		before--
+	}
+	c.tracks.Tracks = append(tracks[:before], append([]spotify.PlaylistTrack{moved}, tracks[before:]...)...)
+	return fmt.Sprintf("snapshot-%d", len(c.reorders)+1), nil
+}
+
+func (c *mockSpotifyClient) GetAudioFeatures(ids ...spotify.ID) ([]*spotify.AudioFeatures, error) {
+	c.featureCalls = append(c.featureCalls, ids)
+	if c.err != nil {
+		return nil, c.err
+	}
+	features := make([]*spotify.AudioFeatures, 0, len(ids))
+	for _, id := range ids {
+		if f, ok := c.features[id]; ok {
+			features = append(features, &f)
+		} else {
+			features = append(features, nil)
+		}
+	}
+	return features, nil
+}
+
 func getRequestMock(err error, playlist spotify.SimplePlaylistPage) *http.Request {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	ctx := r.Context()
@@ -31,104 +97,6 @@ func getRequestMock(err error, playlist spotify.SimplePlaylistPage) *http.Reques
 	return r.WithContext(ctx)
 }
 
-func Test_reducePlaylist(t *testing.T) {
-	type args struct {
-		playlistResp *spotify.SimplePlaylistPage
-	}
-	tests := []struct {
-		name string
-		args args
-		want []playlistItem
-	}{
-		{
-			name: "should handle empty playlists",
-			args: args{
-				playlistResp: &spotify.SimplePlaylistPage{
-					Playlists: []spotify.SimplePlaylist{},
-				},
-			},
-			want: []playlistItem{},
-		},
-		{
-			name: "should get all playlists",
-			args: args{
-				playlistResp: &spotify.SimplePlaylistPage{
-					Playlists: []spotify.SimplePlaylist{
-						{
-							Name: "test-name",
-							ID:   "ID",
-							URI:  "uri:...",
-							Owner: spotify.User{
-								DisplayName: "Thomas",
-							},
-						},
-					},
-				},
-			},
-			want: []playlistItem{
-				{
-					Name:      "test-name",
-					OwnerName: "Thomas",
-					ID:        "ID",
-					URI:       "uri:...",
-				},
-			},
-		},
-		{
-			name: "should get all the playlists with images",
-			args: args{
-				playlistResp: &spotify.SimplePlaylistPage{
-					Playlists: []spotify.SimplePlaylist{
-						{
-							Name: "test-name",
-							ID:   "ID",
-							URI:  "uri:...",
-							Owner: spotify.User{
-								DisplayName: "Thomas",
-							},
-						},
-						{
-							Name: "test-name-2",
-							ID:   "ID-2",
-							URI:  "uri:2",
-							Owner: spotify.User{
-								DisplayName: "Thomas2",
-							},
-							Images: []spotify.Image{
-								{
-									URL: "http://...",
-								},
-							},
-						},
-					},
-				},
-			},
-			want: []playlistItem{
-				{
-					Name:      "test-name",
-					OwnerName: "Thomas",
-					ID:        "ID",
-					URI:       "uri:...",
-				},
-				{
-					Name:      "test-name-2",
-					OwnerName: "Thomas2",
-					ID:        "ID-2",
-					URI:       "uri:2",
-					Image:     "http://...",
-				},
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := reducePlaylist(tt.args.playlistResp); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("reducePlaylist() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func Test_playlistHandler(t *testing.T) {
 	type args struct {
 		req *http.Request
@@ -208,3 +176,161 @@ func Test_playlistHandler(t *testing.T) {
 		})
 	}
 }
+
+func Test_playlistHandler_page(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		expectedCode   int
+		expectedLimit  *int
+		expectedOffset *int
+	}{
+		{
+			name:         "should use spotify defaults without page",
+			query:        "",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:           "should ask the page to spotify",
+			query:          "?limit=10&offset=20",
+			expectedCode:   http.StatusOK,
+			expectedLimit:  intPtr(10),
+			expectedOffset: intPtr(20),
+		},
+		{
+			name:         "should error on invalid limit",
+			query:        "?limit=ten",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockSpotifyClient{}
+			r := httptest.NewRequest(http.MethodGet, "/playlist"+tt.query, nil)
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+			rr := httptest.NewRecorder()
+			playlistHandler(rr, r)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			if !reflect.DeepEqual(client.opt.Limit, tt.expectedLimit) || !reflect.DeepEqual(client.opt.Offset, tt.expectedOffset) {
+				t.Errorf("unexpected page asked to spotify: got %+v", client.opt)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// morningPlaylist is a playlist with one track out of two
+func morningPlaylist() *mockSpotifyClient {
+	client := &mockSpotifyClient{
+		full: &spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{Name: "Morning", ID: "morning", URI: "spotify:playlist:morning", Owner: spotify.User{DisplayName: "Thomas"}, SnapshotID: "snapshot-1"}},
+		tracks: spotify.PlaylistTrackPage{Tracks: []spotify.PlaylistTrack{{Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise", URI: "spotify:track:sunrise", Duration: 1000, Artists: []spotify.SimpleArtist{{Name: "The Early Birds"}}},
+			Album:       spotify.SimpleAlbum{Name: "Dawn"},
+		}}}},
+		features: map[spotify.ID]spotify.AudioFeatures{
+			"sunrise": {ID: "sunrise", Tempo: 120, Key: 2, Mode: 1, Energy: 0.5, Danceability: 0.25, Valence: 0.75},
+		},
+	}
+	client.tracks.Total = 2
+	return client
+}
+
+func Test_playlistFromHandler(t *testing.T) {
+	failing := morningPlaylist()
+	failing.err = errors.New("could not get playlist")
+	tests := []struct {
+		name           string
+		client         *mockSpotifyClient
+		id             string
+		query          string
+		expectedCode   int
+		expectedBody   string
+		expectedOffset *int
+	}{
+		{
+			name:         "should get the playlist with its tracks",
+			client:       morningPlaylist(),
+			id:           "morning",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"image":"","name":"Morning","owner_name":"Thomas","ID":"morning","uri":"spotify:playlist:morning","tracks":[{"name":"Sunrise","artists_name":["The Early Birds"],"album_name":"Dawn","ID":"sunrise","uri":"spotify:track:sunrise","duration":1000}],"total":2}`,
+		},
+		{
+			name:           "should forward the page of tracks to spotify",
+			client:         morningPlaylist(),
+			id:             "morning",
+			query:          "?limit=1&offset=1",
+			expectedCode:   http.StatusOK,
+			expectedOffset: intPtr(1),
+		},
+		{
+			name:         "should error on an invalid page",
+			client:       morningPlaylist(),
+			id:           "morning",
+			query:        "?offset=first",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should attach the audio features of the tracks",
+			client:       morningPlaylist(),
+			id:           "morning",
+			query:        "?features=true",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"image":"","name":"Morning","owner_name":"Thomas","ID":"morning","uri":"spotify:playlist:morning","tracks":[{"name":"Sunrise","artists_name":["The Early Birds"],"album_name":"Dawn","ID":"sunrise","uri":"spotify:track:sunrise","duration":1000,"features":{"ID":"sunrise","tempo":120,"key":2,"mode":1,"energy":0.5,"danceability":0.25,"valence":0.75}}],"total":2}`,
+		},
+		{
+			name:         "should error on an invalid features parameter",
+			client:       morningPlaylist(),
+			id:           "morning",
+			query:        "?features=maybe",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should not find an unknown playlist",
+			client:       morningPlaylist(),
+			id:           "unknown",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should error on spotify api call",
+			client:       failing,
+			id:           "morning",
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/playlist/"+tt.id+tt.query, nil)
+			r = mux.SetURLVars(r, map[string]string{"playlistID": tt.id})
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, tt.client))
+			rr := httptest.NewRecorder()
+			playlistFromHandler(newFeatureCache(10))(rr, r)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, tt.expectedCode)
+			}
+			if tt.expectedBody != "" && strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					strings.TrimSpace(rr.Body.String()), tt.expectedBody)
+			}
+			if tt.expectedOffset != nil && (tt.client.opt == nil || tt.client.opt.Offset == nil || *tt.client.opt.Offset != *tt.expectedOffset) {
+				t.Errorf("handler sent unexpected options to spotify: %+v", tt.client.opt)
+			}
+		})
+	}
+}
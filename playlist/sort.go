@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"common/eventbus"
+	"common/identity"
+	"common/models"
+	"common/webhooks"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// errSnapshotChanged is returned when the playlist changed since the snapshot previewed
+var errSnapshotChanged = errors.New("playlist changed since the snapshot")
+
+// sortItem is a track of the playlist being sorted, position is its position in the playlist
+type sortItem struct {
+	position int
+	track    spotify.PlaylistTrack
+	features *models.TrackFeatures
+}
+
+// sortKey orders the tracks of a playlist, the keys of the audio features put the tracks without features last
+type sortKey struct {
+	features bool
+	less     func(a, b sortItem) bool
+}
+
+// sortKeys are the keys a playlist is sorted by, smooth is ordered by transitions instead of a comparison
+var sortKeys = map[string]sortKey{
+	"added_at":     {less: func(a, b sortItem) bool { return a.track.AddedAt < b.track.AddedAt }},
+	"release_date": {less: func(a, b sortItem) bool { return a.track.Track.Album.ReleaseDate < b.track.Track.Album.ReleaseDate }},
+	"artist":       {less: func(a, b sortItem) bool { return lessText(firstArtist(a.track.Track), firstArtist(b.track.Track)) }},
+	"album":        {less: func(a, b sortItem) bool { return lessText(a.track.Track.Album.Name, b.track.Track.Album.Name) }},
+	"title":        {less: func(a, b sortItem) bool { return lessText(a.track.Track.Name, b.track.Track.Name) }},
+	"duration":     {less: func(a, b sortItem) bool { return a.track.Track.Duration < b.track.Track.Duration }},
+	"popularity":   {less: func(a, b sortItem) bool { return a.track.Track.Popularity < b.track.Track.Popularity }},
+	"tempo":        {features: true, less: func(a, b sortItem) bool { return a.features.Tempo < b.features.Tempo }},
+	"energy":       {features: true, less: func(a, b sortItem) bool { return a.features.Energy < b.features.Energy }},
+	"smooth":       {features: true},
+}
+
+// lessText compares the names without their case
+func lessText(a, b string) bool {
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+// firstArtist returns the name of the main artist of the track
+func firstArtist(track spotify.FullTrack) string {
+	if len(track.Artists) == 0 {
+		return ""
+	}
+	return track.Artists[0].Name
+}
+
+// sortRequest is the body of POST /playlist/{playlistID}/sort
+// The moves are only previewed unless apply is set, they are then applied if the playlist is still at the snapshot given
+type sortRequest struct {
+	By         string `json:"by"`
+	Order      string `json:"order"`
+	Apply      bool   `json:"apply"`
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// validate checks the request and sets the default order
+func (req *sortRequest) validate() error {
+	if _, ok := sortKeys[req.By]; !ok {
+		return fmt.Errorf("invalid by %q", req.By)
+	}
+	switch req.Order {
+	case "":
+		req.Order = "asc"
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("invalid order %q", req.Order)
+	}
+	if req.By == "smooth" && req.Order == "desc" {
+		return fmt.Errorf("the smooth order has no direction")
+	}
+	return nil
+}
+
+// fifths returns the position of the key on the circle of fifths, a minor key shares the position of its relative major
+func fifths(f *models.TrackFeatures) int {
+	key := f.Key
+	if f.Mode == 0 {
+		key = (key + 3) % 12
+	}
+	return key * 7 % 12
+}
+
+// transition returns how abrupt going from a track to the next one sounds
+// A step on the circle of fifths weighs like 10 bpm, changing the mode like half a step
+func transition(a, b *models.TrackFeatures) float64 {
+	cost := math.Abs(a.Tempo-b.Tempo) / 10
+	if a.Key < 0 || b.Key < 0 {
+		return cost + 3
+	}
+	steps := fifths(a) - fifths(b)
+	if steps < 0 {
+		steps = -steps
+	}
+	if steps > 6 {
+		steps = 12 - steps
+	}
+	cost += float64(steps)
+	if a.Mode != b.Mode {
+		cost += 0.5
+	}
+	return cost
+}
+
+// smoothOrder orders the tracks from the slowest one, always going to the closest track left
+// It is a greedy approximation, the tracks without features end the playlist in their order
+func smoothOrder(items []sortItem) []sortItem {
+	var left, without []sortItem
+	for _, item := range items {
+		if item.features == nil {
+			without = append(without, item)
+		} else {
+			left = append(left, item)
+		}
+	}
+	ordered := make([]sortItem, 0, len(items))
+	next := 0
+	for i, item := range left {
+		if item.features.Tempo < left[next].features.Tempo {
+			next = i
+		}
+	}
+	for len(left) > 0 {
+		current := left[next]
+		ordered = append(ordered, current)
+		left = append(left[:next:next], left[next+1:]...)
+		next = 0
+		for i, item := range left {
+			if transition(current.features, item.features) < transition(current.features, left[next].features) {
+				next = i
+			}
+		}
+	}
+	return append(ordered, without...)
+}
+
+// sortItems returns the tracks in the order of the key, the equal tracks keep their order
+func sortItems(items []sortItem, by string, desc bool) []sortItem {
+	key := sortKeys[by]
+	if by == "smooth" {
+		return smoothOrder(items)
+	}
+	sorted := append([]sortItem{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if key.features && (a.features == nil || b.features == nil) {
+			return a.features != nil && b.features == nil
+		}
+		if desc {
+			return key.less(b, a)
+		}
+		return key.less(a, b)
+	})
+	return sorted
+}
+
+// reorderMoves returns the moves of single tracks reordering the playlist, order gives the current position of each sorted track
+// The tracks of the longest run already in the sorted order stay in place, so the number of moves is minimal
+func reorderMoves(order []int) []models.PlaylistMove {
+	// current holds the sorted position of the tracks, in their current order
+	current := make([]int, len(order))
+	for sorted, position := range order {
+		current[position] = sorted
+	}
+	kept := longestIncreasing(current)
+
+	moves := []models.PlaylistMove{}
+	for sorted := range order {
+		if kept[sorted] {
+			continue
+		}
+		from := indexOf(current, sorted)
+		before := 0
+		if sorted > 0 {
+			before = indexOf(current, sorted-1) + 1
+		}
+		if before == from {
+			continue
+		}
+		moves = append(moves, models.PlaylistMove{RangeStart: from, InsertBefore: before})
+		current = append(current[:from:from], current[from+1:]...)
+		if before > from {
+			before--
+		}
+		current = append(current[:before], append([]int{sorted}, current[before:]...)...)
+	}
+	return moves
+}
+
+// longestIncreasing returns the values of a longest increasing subsequence
+func longestIncreasing(values []int) map[int]bool {
+	// tails[k] is the index of the smallest value ending an increasing subsequence of length k+1
+	var tails []int
+	previous := make([]int, len(values))
+	for i, v := range values {
+		k := sort.Search(len(tails), func(k int) bool { return values[tails[k]] >= v })
+		previous[i] = -1
+		if k > 0 {
+			previous[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	kept := map[int]bool{}
+	if len(tails) == 0 {
+		return kept
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = previous[i] {
+		kept[values[i]] = true
+	}
+	return kept
+}
+
+// indexOf returns the index of the value in the values
+func indexOf(values []int, value int) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// playlistTracks returns every track of the playlist, spotify gives them by pages of 100
+func playlistTracks(client spotifyClient, id spotify.ID) ([]spotify.PlaylistTrack, error) {
+	limit := 100
+	var tracks []spotify.PlaylistTrack
+	for {
+		offset := len(tracks)
+		page, err := client.GetPlaylistTracksOpt(id, &spotify.Options{Limit: &limit, Offset: &offset}, "")
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, page.Tracks...)
+		if len(page.Tracks) == 0 || len(tracks) >= page.Total {
+			return tracks, nil
+		}
+	}
+}
+
+// sortPlaylist sorts every track of the playlist and applies the moves when asked
+// Each move is applied on the snapshot left by the previous one, so the positions match the ones computed
+func sortPlaylist(client spotifyClient, cache *featureCache, id spotify.ID, req sortRequest) (models.SortedPlaylist, error) {
+	playlist, err := fetchPlaylist(client, id)
+	if err != nil {
+		return models.SortedPlaylist{}, err
+	}
+	if req.SnapshotID != "" && req.SnapshotID != playlist.SnapshotID {
+		return models.SortedPlaylist{}, fmt.Errorf("%w: %s is at %s", errSnapshotChanged, id, playlist.SnapshotID)
+	}
+	tracks, err := playlistTracks(client, id)
+	if err != nil {
+		return models.SortedPlaylist{}, err
+	}
+	items := make([]sortItem, 0, len(tracks))
+	var ids []spotify.ID
+	for i, t := range tracks {
+		items = append(items, sortItem{position: i, track: t})
+		if t.Track.ID != "" {
+			ids = append(ids, t.Track.ID)
+		}
+	}
+	if sortKeys[req.By].features {
+		features, err := trackFeatures(client, cache, ids)
+		if err != nil {
+			return models.SortedPlaylist{}, err
+		}
+		byID := map[spotify.ID]*models.TrackFeatures{}
+		for _, f := range features {
+			if f != nil {
+				byID[f.ID] = f
+			}
+		}
+		for i := range items {
+			items[i].features = byID[items[i].track.Track.ID]
+		}
+	}
+
+	sorted := models.SortedPlaylist{
+		PlaylistItem: models.ReducePlaylistItem(playlist.SimplePlaylist),
+		SnapshotID:   playlist.SnapshotID,
+		By:           req.By,
+		Order:        req.Order,
+		Tracks:       []models.Track{},
+	}
+	var order []int
+	for _, item := range sortItems(items, req.By, req.Order == "desc") {
+		track := models.ReduceTrack(item.track.Track)
+		track.Features = item.features
+		sorted.Tracks = append(sorted.Tracks, track)
+		order = append(order, item.position)
+	}
+	sorted.Moves = reorderMoves(order)
+	if !req.Apply {
+		return sorted, nil
+	}
+	for _, move := range sorted.Moves {
+		sorted.SnapshotID, err = client.ReorderPlaylistTracks(id, spotify.PlaylistReorderOptions{
+			RangeStart:   move.RangeStart,
+			RangeLength:  1,
+			InsertBefore: move.InsertBefore,
+			SnapshotID:   sorted.SnapshotID,
+		})
+		if err != nil {
+			return models.SortedPlaylist{}, err
+		}
+	}
+	sorted.Applied = true
+	return sorted, nil
+}
+
+// sortHandler is the handler to sort a playlist by its metadata or the audio features of its tracks
+// The moves are previewed by default, apply reorders the playlist and publishes it to the webhooks of the user sent by the gateway and on the bus
+func sortHandler(features *featureCache, publisher *webhooks.Publisher, bus eventbus.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		playlistID := mux.Vars(r)["playlistID"]
+		var req sortRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Error("sortHandler: could not decode sort request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			log.WithError(err).Error("sortHandler: invalid sort request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		sorted, err := sortPlaylist(client, features, spotify.ID(playlistID), req)
+		if errors.Is(err, errPlaylistNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errSnapshotChanged) {
+			log.WithError(err).Error("sortHandler: playlist changed since the preview")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.WithField("playlistID", playlistID).WithError(err).Error("sortHandler: could not sort playlist")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if user := r.Header.Get(identity.Header); user != "" && sorted.Applied && len(sorted.Moves) > 0 {
+			update := models.PlaylistUpdate{PlaylistID: spotify.ID(playlistID), SnapshotID: sorted.SnapshotID, Change: models.PlaylistReordered}
+			publisher.Go(models.PlaylistUpdatedEvent, user, update)
+			eventbus.Go(bus, eventbus.PlaylistMutated{UserID: user, PlaylistUpdate: update, OccurredAt: time.Now().UTC()})
+		}
+
+		json.NewEncoder(w).Encode(sorted)
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"common/eventbus"
+	"common/identity"
+	"common/models"
+	"common/webhooks"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+// unsortedPlaylist is a playlist of four tracks, the local file has no audio features
+func unsortedPlaylist() *mockSpotifyClient {
+	client := &mockSpotifyClient{
+		full: &spotify.FullPlaylist{SimplePlaylist: spotify.SimplePlaylist{Name: "Mix", ID: "mix", SnapshotID: "snapshot-1"}},
+		features: map[spotify.ID]spotify.AudioFeatures{
+			"delta":   {ID: "delta", Tempo: 128, Key: 0, Mode: 1, Energy: 0.9},
+			"alpha":   {ID: "alpha", Tempo: 90, Key: 7, Mode: 1, Energy: 0.2},
+			"charlie": {ID: "charlie", Tempo: 124, Key: 9, Mode: 0, Energy: 0.7},
+		},
+	}
+	for _, t := range []struct {
+		id, name, addedAt string
+		duration          int
+	}{
+		{"delta", "Delta", "2021-01-04T00:00:00Z", 200000},
+		{"alpha", "alpha", "2021-01-01T00:00:00Z", 300000},
+		{"", "Bravo", "2021-01-03T00:00:00Z", 100000},
+		{"charlie", "Charlie", "2021-01-02T00:00:00Z", 250000},
+	} {
+		client.tracks.Tracks = append(client.tracks.Tracks, spotify.PlaylistTrack{AddedAt: t.addedAt, Track: spotify.FullTrack{
+			SimpleTrack: spotify.SimpleTrack{ID: spotify.ID(t.id), Name: t.name, Duration: t.duration},
+		}})
+	}
+	client.tracks.Total = len(client.tracks.Tracks)
+	return client
+}
+
+// names returns the names of the tracks of the playlist, in order
+func names(tracks []spotify.PlaylistTrack) []string {
+	var got []string
+	for _, t := range tracks {
+		got = append(got, t.Track.Name)
+	}
+	return got
+}
+
+func Test_sortRequest_validate(t *testing.T) {
+	tests := []struct {
+		req         sortRequest
+		expectedErr bool
+	}{
+		{req: sortRequest{By: "title"}},
+		{req: sortRequest{By: "tempo", Order: "desc"}},
+		{req: sortRequest{By: "smooth"}},
+		{req: sortRequest{By: "smooth", Order: "desc"}, expectedErr: true},
+		{req: sortRequest{By: "title", Order: "random"}, expectedErr: true},
+		{req: sortRequest{By: "color"}, expectedErr: true},
+		{req: sortRequest{}, expectedErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.req.validate(); (err != nil) != tt.expectedErr {
+			t.Errorf("validate(%+v) error = %v, expectedErr %v", tt.req, err, tt.expectedErr)
+		}
+	}
+}
+
+func Test_sortItems(t *testing.T) {
+	client := unsortedPlaylist()
+	var items []sortItem
+	for i, track := range client.tracks.Tracks {
+		item := sortItem{position: i, track: track}
+		if f, ok := client.features[track.Track.ID]; ok {
+			features := models.ReduceAudioFeatures(f)
+			item.features = &features
+		}
+		items = append(items, item)
+	}
+	tests := []struct {
+		by       string
+		desc     bool
+		expected []int
+	}{
+		{by: "title", expected: []int{1, 2, 3, 0}},
+		{by: "added_at", expected: []int{1, 3, 2, 0}},
+		{by: "duration", desc: true, expected: []int{1, 3, 0, 2}},
+		{by: "tempo", expected: []int{1, 3, 0, 2}},
+		{by: "energy", desc: true, expected: []int{0, 3, 1, 2}},
+		// from the slowest track to the closest one left
+		{by: "smooth", expected: []int{1, 0, 3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			var got []int
+			for _, item := range sortItems(items, tt.by, tt.desc) {
+				got = append(got, item.position)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("sortItems(%s) = %v, want %v", tt.by, got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_transition(t *testing.T) {
+	cMajor := &models.TrackFeatures{Tempo: 120, Key: 0, Mode: 1}
+	aMinor := &models.TrackFeatures{Tempo: 120, Key: 9, Mode: 0}
+	gMajor := &models.TrackFeatures{Tempo: 120, Key: 7, Mode: 1}
+	fSharpMajor := &models.TrackFeatures{Tempo: 140, Key: 6, Mode: 1}
+	tests := []struct {
+		name     string
+		a, b     *models.TrackFeatures
+		expected float64
+	}{
+		{name: "same key", a: cMajor, b: cMajor, expected: 0},
+		{name: "relative minor", a: cMajor, b: aMinor, expected: 0.5},
+		{name: "next fifth", a: cMajor, b: gMajor, expected: 1},
+		{name: "opposite key and faster", a: cMajor, b: fSharpMajor, expected: 8},
+		{name: "unknown key", a: cMajor, b: &models.TrackFeatures{Tempo: 120, Key: -1}, expected: 3},
+	}
+	for _, tt := range tests {
+		if got := transition(tt.a, tt.b); got != tt.expected {
+			t.Errorf("transition() of %s = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func Test_reorderMoves(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    []int
+		expected []models.PlaylistMove
+	}{
+		{name: "should not move a sorted playlist", order: []int{0, 1, 2, 3}, expected: []models.PlaylistMove{}},
+		{name: "should move the last track first", order: []int{3, 0, 1, 2}, expected: []models.PlaylistMove{{RangeStart: 3, InsertBefore: 0}}},
+		{name: "should move the first track last", order: []int{1, 2, 3, 0}, expected: []models.PlaylistMove{{RangeStart: 0, InsertBefore: 4}}},
+		{name: "should reverse with a move per track but one", order: []int{2, 1, 0}, expected: []models.PlaylistMove{{RangeStart: 1, InsertBefore: 3}, {RangeStart: 0, InsertBefore: 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reorderMoves(tt.order); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("reorderMoves(%v) = %v, want %v", tt.order, got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_reorderMoves_random(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for n := 0; n < 60; n++ {
+		order := r.Perm(n)
+		playlist := make([]int, n)
+		for sorted, position := range order {
+			playlist[position] = sorted
+		}
+		moves := reorderMoves(order)
+		for _, move := range moves {
+			moved := playlist[move.RangeStart]
+			playlist = append(playlist[:move.RangeStart], playlist[move.RangeStart+1:]...)
+			before := move.InsertBefore
+			if before > move.RangeStart {
+				before--
+			}
+			playlist = append(playlist[:before], append([]int{moved}, playlist[before:]...)...)
+		}
+		if !sort.IntsAreSorted(playlist) {
+			t.Fatalf("reorderMoves(%v) left %v", order, playlist)
+		}
+		current := make([]int, n)
+		for sorted, position := range order {
+			current[position] = sorted
+		}
+		if want := n - len(longestIncreasing(current)); len(moves) != want {
+			t.Errorf("reorderMoves(%v) made %d moves, want %d", order, len(moves), want)
+		}
+	}
+}
+
+func Test_playlistTracks(t *testing.T) {
+	client := &mockSpotifyClient{}
+	for i := 0; i < 250; i++ {
+		client.tracks.Tracks = append(client.tracks.Tracks, spotify.PlaylistTrack{Track: spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{Duration: i}}})
+	}
+	client.tracks.Total = 250
+	tracks, err := playlistTracks(client, "long")
+	if err != nil || len(tracks) != 250 || tracks[249].Track.Duration != 249 {
+		t.Errorf("playlistTracks() = %d tracks, %v, want every page", len(tracks), err)
+	}
+}
+
+func Test_sortHandler(t *testing.T) {
+	failing := unsortedPlaylist()
+	failing.err = errors.New("could not get playlist")
+	tests := []struct {
+		name             string
+		client           *mockSpotifyClient
+		id               string
+		body             string
+		expectedCode     int
+		expectedTracks   []string
+		expectedReorders int
+	}{
+		{
+			name:           "should preview the sorted playlist",
+			client:         unsortedPlaylist(),
+			id:             "mix",
+			body:           `{"by": "title"}`,
+			expectedCode:   http.StatusOK,
+			expectedTracks: []string{"Delta", "alpha", "Bravo", "Charlie"},
+		},
+		{
+			name:             "should apply the moves",
+			client:           unsortedPlaylist(),
+			id:               "mix",
+			body:             `{"by": "title", "apply": true, "snapshot_id": "snapshot-1"}`,
+			expectedCode:     http.StatusOK,
+			expectedTracks:   []string{"alpha", "Bravo", "Charlie", "Delta"},
+			expectedReorders: 1,
+		},
+		{
+			name:           "should not apply the moves to a playlist changed since the preview",
+			client:         unsortedPlaylist(),
+			id:             "mix",
+			body:           `{"by": "title", "apply": true, "snapshot_id": "snapshot-0"}`,
+			expectedCode:   http.StatusConflict,
+			expectedTracks: []string{"Delta", "alpha", "Bravo", "Charlie"},
+		},
+		{
+			name:         "should error on an unknown key",
+			client:       unsortedPlaylist(),
+			id:           "mix",
+			body:         `{"by": "color"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should error decoding body",
+			client:       unsortedPlaylist(),
+			id:           "mix",
+			body:         `{"by": 1}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should not find an unknown playlist",
+			client:       unsortedPlaylist(),
+			id:           "unknown",
+			body:         `{"by": "title"}`,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "should error on spotify api call",
+			client:       failing,
+			id:           "mix",
+			body:         `{"by": "title"}`,
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/playlist/"+tt.id+"/sort", strings.NewReader(tt.body))
+			r = mux.SetURLVars(r, map[string]string{"playlistID": tt.id})
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, tt.client))
+			rr := httptest.NewRecorder()
+			sortHandler(newFeatureCache(10), nil, nil)(rr, r)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					res, tt.expectedCode)
+			}
+			if tt.expectedTracks != nil && !reflect.DeepEqual(names(tt.client.tracks.Tracks), tt.expectedTracks) {
+				t.Errorf("handler left the playlist %v, want %v", names(tt.client.tracks.Tracks), tt.expectedTracks)
+			}
+			if len(tt.client.reorders) != tt.expectedReorders {
+				t.Errorf("handler reordered %d times, want %d", len(tt.client.reorders), tt.expectedReorders)
+			}
+		})
+	}
+}
+
+func Test_sortPlaylist_snapshots(t *testing.T) {
+	client := unsortedPlaylist()
+	sorted, err := sortPlaylist(client, newFeatureCache(10), "mix", sortRequest{By: "title", Order: "desc", Apply: true})
+	if err != nil {
+		t.Fatalf("sortPlaylist() error = %v", err)
+	}
+	if got := names(client.tracks.Tracks); !reflect.DeepEqual(got, []string{"Delta", "Charlie", "Bravo", "alpha"}) {
+		t.Errorf("sortPlaylist() left the playlist %v", got)
+	}
+	if len(client.reorders) != 2 {
+		t.Fatalf("sortPlaylist() reordered %d times, want 2", len(client.reorders))
+	}
+	// each move is made on the snapshot left by the previous one
+	for i, reorder := range client.reorders {
+		if want := fmt.Sprintf("snapshot-%d", i+1); reorder.SnapshotID != want || reorder.RangeLength != 1 {
+			t.Errorf("reorder %d = %+v, want a single track on %s", i, reorder, want)
+		}
+	}
+	if !sorted.Applied || sorted.SnapshotID != "snapshot-3" {
+		t.Errorf("sortPlaylist() = applied %v at %s, want the last snapshot", sorted.Applied, sorted.SnapshotID)
+	}
+}
+
+func Test_sortHandler_publishes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		userID   string
+		expected int
+	}{
+		{name: "should publish the playlist reordered", body: `{"by": "title", "apply": true}`, userID: "thomas", expected: 1},
+		{name: "should not publish a preview", body: `{"by": "title"}`, userID: "thomas"},
+		{name: "should not publish without the user of the gateway", body: `{"by": "title", "apply": true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			published := make(chan models.Event, 10)
+			hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var event models.Event
+				json.NewDecoder(r.Body).Decode(&event)
+				published <- event
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer hooks.Close()
+
+			r := httptest.NewRequest(http.MethodPost, "/playlist/mix/sort", strings.NewReader(tt.body))
+			if tt.userID != "" {
+				r.Header.Set(identity.Header, tt.userID)
+			}
+			r = mux.SetURLVars(r, map[string]string{"playlistID": "mix"})
+			r = r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, unsortedPlaylist()))
+			bus := eventbus.NewMemory()
+			defer bus.Close()
+			mutations := make(chan eventbus.PlaylistMutated, 10)
+			eventbus.OnPlaylistMutated(bus, func(mutation eventbus.PlaylistMutated) { mutations <- mutation })
+			rr := httptest.NewRecorder()
+			sortHandler(newFeatureCache(10), webhooks.NewPublisher(hooks.URL, "services"), bus)(rr, r)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			if tt.expected == 0 {
+				select {
+				case event := <-published:
+					t.Errorf("published %+v, want nothing", event)
+				case mutation := <-mutations:
+					t.Errorf("bus got %+v, want nothing", mutation)
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+			select {
+			case event := <-published:
+				var update models.PlaylistUpdate
+				json.Unmarshal(event.Data, &update)
+				if event.Event != models.PlaylistUpdatedEvent || event.UserID != tt.userID || update.PlaylistID != "mix" || update.Change != models.PlaylistReordered || update.SnapshotID != "snapshot-2" {
+					t.Errorf("published %+v with %+v, want the playlist reordered", event, update)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the playlist reordered was not published")
+			}
+			select {
+			case mutation := <-mutations:
+				if mutation.UserID != tt.userID || mutation.PlaylistID != "mix" || mutation.Change != models.PlaylistReordered || mutation.SnapshotID != "snapshot-2" {
+					t.Errorf("bus got %+v, want the playlist reordered", mutation)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the playlist reordered was not published on the bus")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"common/models"
+)
+
+// cachedResult is a search result with its expiration
+type cachedResult struct {
+	result  models.SearchResult
+	expires time.Time
+}
+
+// resultCache keeps the search results for a while, the catalog rarely changes
+type resultCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+// newResultCache creates a cache of up to size results kept for the ttl, a zero ttl disables it
+func newResultCache(ttl time.Duration, size int) *resultCache {
+	return &resultCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		results: map[string]cachedResult{},
+	}
+}
+
+// get returns the result of the key while it is fresh
+func (c *resultCache) get(key string) (models.SearchResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.results[key]
+	if !ok || !c.now().Before(cached.expires) {
+		return models.SearchResult{}, false
+	}
+	return cached.result, true
+}
+
+// add caches the result of the key
+// Once the cache is full the expired results are removed, then the one expiring first
+func (c *resultCache) add(key string, result models.SearchResult) {
+	if c.ttl <= 0 {
+		return
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.results[key]; !ok && len(c.results) >= c.size {
+		var oldest string
+		for k, cached := range c.results {
+			if !now.Before(cached.expires) {
+				delete(c.results, k)
+			} else if oldest == "" || cached.expires.Before(c.results[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.results) >= c.size {
+			delete(c.results, oldest)
+		}
+	}
+	c.results[key] = cachedResult{result: result, expires: now.Add(c.ttl)}
+}
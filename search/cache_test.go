@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"common/models"
+)
+
+func Test_resultCache(t *testing.T) {
+	now := time.Now()
+	c := newResultCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+	result := models.SearchResult{Shows: &models.ShowResults{Total: 1}}
+
+	c.add("a", result)
+	if got, ok := c.get("a"); !ok || got.Shows.Total != 1 {
+		t.Errorf("the result was not cached")
+	}
+	now = now.Add(time.Second)
+	c.add("b", result)
+	c.add("c", result)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("the result expiring first should be removed once the cache is full")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Errorf("the other results should be kept")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.get("b"); ok {
+		t.Errorf("an expired result should not be answered")
+	}
+	c.add("d", result)
+	c.add("e", result)
+	if len(c.results) != 2 {
+		t.Errorf("the expired results should be removed, got %v results", len(c.results))
+	}
+}
+
+func Test_resultCache_disabled(t *testing.T) {
+	c := newResultCache(0, 10)
+	c.add("a", models.SearchResult{})
+	if _, ok := c.get("a"); ok {
+		t.Errorf("nothing should be cached without ttl")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/openapi"
+	"github.com/zmb3/spotify"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	full := sunriseResults()
+	full.result.Albums.Albums = []spotify.SimpleAlbum{{Name: "Dawn", ID: "dawn", URI: "spotify:album:dawn", Images: []spotify.Image{{URL: "http://dawn"}}}}
+	full.result.Artists.Artists = []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{Name: "The Early Birds", ID: "birds", URI: "spotify:artist:birds"}}}
+	full.result.Playlists.Playlists = []spotify.SimplePlaylist{{Name: "Morning", ID: "morning", URI: "spotify:playlist:morning"}}
+	tests := []struct {
+		name   string
+		target string
+		client *mockSpotifyClient
+	}{
+		{name: "should document the results of every type", target: "/search?q=sunrise", client: full},
+		{name: "should document the results of some types", target: "/search?q=sunrise&type=track,show&limit=5&market=FR", client: sunriseResults()},
+		{name: "should document no results", target: "/search?q=sunrise&type=album", client: &mockSpotifyClient{result: spotify.SearchResult{Albums: &spotify.SimpleAlbumPage{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			spec.Middleware(searchHandler(newResultCache(time.Minute, 10))).ServeHTTP(rr, searchRequest(tt.target, "token", tt.client))
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse("GET", "/search", rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_invalidSearch(t *testing.T) {
+	spec := openapi.MustLoad()
+	for _, target := range []string{"/search", "/search?q=sunrise&type=song", "/search?q=sunrise&limit=100", "/search?q=sunrise&market=france"} {
+		rr := httptest.NewRecorder()
+		spec.Middleware(searchHandler(newResultCache(time.Minute, 10))).ServeHTTP(rr, searchRequest(target, "token", sunriseResults()))
+		if res := rr.Code; res != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", target, res, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/config"
+	"common/health"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// Key type of spotify client context
+type key int
+
+// CLIENT_CONTEXT is the key used for the spotify client context
+var CLIENT_CONTEXT = key(1)
+
+const (
+	// maxLimit is the largest number of results of each type spotify answers
+	maxLimit = 50
+	// maxOffset is the index of the last result spotify gives access to
+	maxOffset = 1000
+)
+
+// searchTypes are the types that can be searched, in the order of the results
+var searchTypes = []string{"track", "album", "artist", "playlist", "show"}
+
+// libraryTypes are the search types of the spotify library, the shows are searched on their own
+var libraryTypes = map[string]spotify.SearchType{
+	"track":    spotify.SearchTypeTrack,
+	"album":    spotify.SearchTypeAlbum,
+	"artist":   spotify.SearchTypeArtist,
+	"playlist": spotify.SearchTypePlaylist,
+}
+
+// marketPattern matches an ISO 3166-1 alpha-2 country code or the market of the user
+var marketPattern = regexp.MustCompile(`^([A-Z]{2}|` + spotify.MarketFromToken + `)$`)
+
+// errInvalidSearch is returned when spotify rejects the query
+var errInvalidSearch = errors.New("invalid search")
+
+// spotifyClient interface of spotify client
+type spotifyClient interface {
+	SearchOpt(query string, t spotify.SearchType, opt *spotify.Options) (*spotify.SearchResult, error)
+	SearchShowsOpt(query string, opt *spotify.Options) (*showPage, error)
+}
+
+// searchQuery is a search of the catalog, spotify uses its defaults for the zero values
+type searchQuery struct {
+	text   string
+	types  []string
+	limit  int
+	offset int
+	market string
+}
+
+// has tells if the type is searched
+func (q searchQuery) has(searchType string) bool {
+	for _, t := range q.types {
+		if t == searchType {
+			return true
+		}
+	}
+	return false
+}
+
+// options returns the spotify options of the search
+func (q searchQuery) options() *spotify.Options {
+	opt := &spotify.Options{}
+	if q.limit > 0 {
+		opt.Limit = &q.limit
+	}
+	if q.offset > 0 {
+		opt.Offset = &q.offset
+	}
+	if q.market != "" {
+		opt.Country = &q.market
+	}
+	return opt
+}
+
+// cacheKey identifies the results of the search
+// The results in the market of the user depend on the token, a hash of it is part of the key
+func (q searchQuery) cacheKey(token string) string {
+	key := fmt.Sprintf("%s|%s|%d|%d|%s", strings.Join(q.types, ","), q.market, q.limit, q.offset, q.text)
+	if q.market == spotify.MarketFromToken {
+		key = fmt.Sprintf("%x|%s", sha256.Sum256([]byte(token)), key)
+	}
+	return key
+}
+
+// searchQueryFromRequest reads the q, type, limit, offset and market query parameters
+// Every type is searched when none is given
+func searchQueryFromRequest(r *http.Request) (searchQuery, error) {
+	query := r.URL.Query()
+	q := searchQuery{text: strings.TrimSpace(query.Get("q")), market: query.Get("market")}
+	if q.text == "" {
+		return searchQuery{}, errors.New("missing q")
+	}
+
+	asked := map[string]bool{}
+	for _, t := range strings.Split(query.Get("type"), ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if _, ok := libraryTypes[t]; !ok && t != "show" {
+			return searchQuery{}, fmt.Errorf("invalid type %q", t)
+		}
+		asked[t] = true
+	}
+	for _, t := range searchTypes {
+		if len(asked) == 0 || asked[t] {
+			q.types = append(q.types, t)
+		}
+	}
+
+	for name, field := range map[string]*int{"limit": &q.limit, "offset": &q.offset} {
+		if raw := query.Get(name); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				return searchQuery{}, fmt.Errorf("invalid %s %q", name, raw)
+			}
+			*field = value
+		}
+	}
+	if q.limit > maxLimit || q.offset > maxOffset {
+		return searchQuery{}, fmt.Errorf("limit must be up to %d and offset up to %d", maxLimit, maxOffset)
+	}
+	if q.market != "" && !marketPattern.MatchString(q.market) {
+		return searchQuery{}, fmt.Errorf("invalid market %q", q.market)
+	}
+	return q, nil
+}
+
+// search searches the catalog, the shows are searched apart from the types known by the spotify library
+func search(client spotifyClient, q searchQuery) (models.SearchResult, error) {
+	var t spotify.SearchType
+	for _, name := range q.types {
+		t |= libraryTypes[name]
+	}
+
+	var result models.SearchResult
+	if t != 0 {
+		found, err := client.SearchOpt(q.text, t, q.options())
+		if err != nil {
+			return models.SearchResult{}, spotifyError(err)
+		}
+		result = models.ReduceSearchResult(found)
+	}
+	if q.has("show") {
+		shows, err := client.SearchShowsOpt(q.text, q.options())
+		if err != nil {
+			return models.SearchResult{}, spotifyError(err)
+		}
+		result.Shows = models.ReduceShows(shows.Shows, shows.Total)
+	}
+	return result, nil
+}
+
+// spotifyError maps the queries rejected by spotify to errInvalidSearch
+func spotifyError(err error) error {
+	var serr spotify.Error
+	if errors.As(err, &serr) && serr.Status == http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", errInvalidSearch, serr.Message)
+	}
+	return err
+}
+
+// searchHandler is the handler to search the catalog
+// The results are answered from the cache while they are fresh, the X-Cache header tells if it was a HIT or a MISS
+func searchHandler(cache *resultCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := searchQueryFromRequest(r)
+		if err != nil {
+			log.WithError(err).Error("searchHandler: could not read search")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		key := q.cacheKey(r.Header.Get("Authorization"))
+		if result, ok := cache.get(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		result, err := search(client, q)
+		if errors.Is(err, errInvalidSearch) {
+			log.WithError(err).Warn("searchHandler: spotify rejected the search")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.WithError(err).Error("searchHandler: could not search")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		cache.add(key, result)
+		w.Header().Set("X-Cache", "MISS")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := r.Header.Get("Authorization")
+		client := newAPIClient(factory, bearer)
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("search", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+	cache := newResultCache(cfg.Search.CacheTTL, cfg.Search.CacheSize)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.HandleFunc("/search", searchHandler(cache)).Methods("GET")
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.Run(contextedMux, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+type mockSpotifyClient struct {
+	err      error
+	result   spotify.SearchResult
+	shows    showPage
+	searched []spotify.SearchType
+	opt      *spotify.Options
+	calls    int
+}
+
+func (c *mockSpotifyClient) SearchOpt(query string, t spotify.SearchType, opt *spotify.Options) (*spotify.SearchResult, error) {
+	c.calls++
+	c.searched = append(c.searched, t)
+	c.opt = opt
+	return &c.result, c.err
+}
+
+func (c *mockSpotifyClient) SearchShowsOpt(query string, opt *spotify.Options) (*showPage, error) {
+	c.calls++
+	c.opt = opt
+	return &c.shows, c.err
+}
+
+// sunriseResults is a search finding a track and a show
+func sunriseResults() *mockSpotifyClient {
+	tracks := &spotify.FullTrackPage{Tracks: []spotify.FullTrack{{
+		SimpleTrack: spotify.SimpleTrack{Name: "Sunrise", ID: "sunrise", URI: "spotify:track:sunrise", Artists: []spotify.SimpleArtist{{Name: "The Early Birds"}}},
+		Album:       spotify.SimpleAlbum{Name: "Dawn"},
+	}}}
+	tracks.Total = 1
+	return &mockSpotifyClient{
+		result: spotify.SearchResult{Tracks: tracks, Albums: &spotify.SimpleAlbumPage{}, Artists: &spotify.FullArtistPage{}, Playlists: &spotify.SimplePlaylistPage{}},
+		shows:  showPage{Shows: []spotify.SimpleShow{{Name: "Sunrise News", Publisher: "Radio", ID: "news", URI: "spotify:show:news"}}, Total: 1},
+	}
+}
+
+// searchRequest creates a search request sent with the token and the spotify client
+func searchRequest(target, token string, client spotifyClient) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("Authorization", token)
+	return r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+}
+
+func Test_searchQueryFromRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		want      searchQuery
+		expectErr string
+	}{
+		{
+			name:   "should search every type by default",
+			target: "/search?q=sunrise",
+			want:   searchQuery{text: "sunrise", types: []string{"track", "album", "artist", "playlist", "show"}},
+		},
+		{
+			name:   "should read the types, the page and the market",
+			target: "/search?q=%20sunrise%20&type=show,track&limit=10&offset=20&market=FR",
+			want:   searchQuery{text: "sunrise", types: []string{"track", "show"}, limit: 10, offset: 20, market: "FR"},
+		},
+		{
+			name:   "should accept the market of the user",
+			target: "/search?q=sunrise&type=album&market=from_token",
+			want:   searchQuery{text: "sunrise", types: []string{"album"}, market: "from_token"},
+		},
+		{name: "should require a query", target: "/search?q=%20", expectErr: "missing q"},
+		{name: "should reject an unknown type", target: "/search?q=sunrise&type=track,episode", expectErr: `invalid type "episode"`},
+		{name: "should reject an invalid limit", target: "/search?q=sunrise&limit=ten", expectErr: `invalid limit "ten"`},
+		{name: "should reject a too large limit", target: "/search?q=sunrise&limit=51", expectErr: "limit must be up to 50"},
+		{name: "should reject a too large offset", target: "/search?q=sunrise&offset=1001", expectErr: "offset up to 1000"},
+		{name: "should reject an invalid market", target: "/search?q=sunrise&market=france", expectErr: `invalid market "france"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := searchQueryFromRequest(httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Fatalf("searchQueryFromRequest() error = %v, want %v", err, tt.expectErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchQueryFromRequest() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_searchHandler(t *testing.T) {
+	tests := []struct {
+		name             string
+		target           string
+		client           *mockSpotifyClient
+		expectedCode     int
+		expectedBody     string
+		expectedSearched []spotify.SearchType
+	}{
+		{
+			name:             "should search every type with a link playing the results",
+			target:           "/search?q=sunrise",
+			client:           sunriseResults(),
+			expectedCode:     http.StatusOK,
+			expectedBody:     `{"tracks":{"items":[{"name":"Sunrise","artists_name":["The Early Birds"],"album_name":"Dawn","ID":"sunrise","uri":"spotify:track:sunrise","duration":0,"play":{"method":"POST","href":"/player/play","body":{"uri":"spotify:track:sunrise"}}}],"total":1},"albums":{"items":[],"total":0},"artists":{"items":[],"total":0},"playlists":{"items":[],"total":0},"shows":{"items":[{"name":"Sunrise News","publisher":"Radio","ID":"news","uri":"spotify:show:news","image":"","play":{"method":"POST","href":"/player/play","body":{"uri":"spotify:show:news"}}}],"total":1}}`,
+			expectedSearched: []spotify.SearchType{spotify.SearchTypeTrack | spotify.SearchTypeAlbum | spotify.SearchTypeArtist | spotify.SearchTypePlaylist},
+		},
+		{
+			name:         "should only search the shows",
+			target:       "/search?q=sunrise&type=show",
+			client:       sunriseResults(),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"shows":{"items":[{"name":"Sunrise News","publisher":"Radio","ID":"news","uri":"spotify:show:news","image":"","play":{"method":"POST","href":"/player/play","body":{"uri":"spotify:show:news"}}}],"total":1}}`,
+		},
+		{
+			name:         "should reject an invalid search",
+			target:       "/search?q=sunrise&type=song",
+			client:       sunriseResults(),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should answer 400 when spotify rejects the query",
+			target:       "/search?q=sunrise",
+			client:       &mockSpotifyClient{err: spotify.Error{Status: http.StatusBadRequest, Message: "invalid query"}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "should answer 500 when spotify fails",
+			target:       "/search?q=sunrise",
+			client:       &mockSpotifyClient{err: errors.New("spotify is down")},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			searchHandler(newResultCache(time.Minute, 10)).ServeHTTP(rr, searchRequest(tt.target, "token", tt.client))
+			if res := rr.Code; res != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if res := strings.TrimSpace(rr.Body.String()); res != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", res, tt.expectedBody)
+			}
+			if tt.expectedSearched != nil && !reflect.DeepEqual(tt.client.searched, tt.expectedSearched) {
+				t.Errorf("unexpected searched types: got %v want %v", tt.client.searched, tt.expectedSearched)
+			}
+		})
+	}
+}
+
+func Test_searchHandler_cache(t *testing.T) {
+	client := sunriseResults()
+	handler := searchHandler(newResultCache(time.Minute, 10))
+	steps := []struct {
+		name          string
+		target        string
+		token         string
+		expectedCache string
+		expectedCalls int
+	}{
+		{name: "should search spotify", target: "/search?q=sunrise&type=track", token: "thomas", expectedCache: "MISS", expectedCalls: 1},
+		{name: "should answer the same search from the cache", target: "/search?q=sunrise&type=track", token: "alice", expectedCache: "HIT", expectedCalls: 1},
+		{name: "should search spotify for another page", target: "/search?q=sunrise&type=track&offset=1", token: "alice", expectedCache: "MISS", expectedCalls: 2},
+		{name: "should search spotify in the market of the user", target: "/search?q=sunrise&type=track&market=from_token", token: "thomas", expectedCache: "MISS", expectedCalls: 3},
+		{name: "should not share the market of a user", target: "/search?q=sunrise&type=track&market=from_token", token: "alice", expectedCache: "MISS", expectedCalls: 4},
+		{name: "should answer the market of the user from the cache", target: "/search?q=sunrise&type=track&market=from_token", token: "thomas", expectedCache: "HIT", expectedCalls: 4},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, searchRequest(step.target, step.token, client))
+			if res := rr.Code; res != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if res := rr.Header().Get("X-Cache"); res != step.expectedCache {
+				t.Errorf("handler returned wrong X-Cache: got %v want %v", res, step.expectedCache)
+			}
+			if client.calls != step.expectedCalls {
+				t.Errorf("unexpected spotify calls: got %v want %v", client.calls, step.expectedCalls)
+			}
+		})
+	}
+}
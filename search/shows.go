@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"common/spotifyapi"
+	"github.com/zmb3/spotify"
+)
+
+// showPage is a page of the shows found, the spotify library does not search them
+type showPage struct {
+	Shows []spotify.SimpleShow `json:"items"`
+	Total int                  `json:"total"`
+}
+
+// apiClient is the spotify client completed with the search of the shows
+type apiClient struct {
+	*spotifyapi.APIClient
+}
+
+// newAPIClient creates the client authenticated with the access token
+func newAPIClient(factory *spotifyapi.Factory, token string) *apiClient {
+	return &apiClient{factory.APIClient(token)}
+}
+
+// SearchShowsOpt searches the shows the same way SearchOpt searches the other types
+func (c *apiClient) SearchShowsOpt(query string, opt *spotify.Options) (*showPage, error) {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("type", "show")
+	if opt != nil {
+		if opt.Limit != nil {
+			v.Set("limit", strconv.Itoa(*opt.Limit))
+		}
+		if opt.Offset != nil {
+			v.Set("offset", strconv.Itoa(*opt.Offset))
+		}
+		if opt.Country != nil {
+			v.Set("market", *opt.Country)
+		}
+	}
+	var result struct {
+		Shows showPage `json:"shows"`
+	}
+	if err := c.Get(context.Background(), "search", v, &result); err != nil {
+		return nil, err
+	}
+	return &result.Shows, nil
+}
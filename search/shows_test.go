@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"common/spotifyapi"
+	"github.com/zmb3/spotify"
+)
+
+func Test_apiClient_SearchShowsOpt(t *testing.T) {
+	var query string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if r.URL.Path != "/v1/search" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"status":401,"message":"Invalid access token"}}`))
+			return
+		}
+		w.Write([]byte(`{"shows":{"items":[{"id":"news","name":"Sunrise News","publisher":"Radio","uri":"spotify:show:news"}],"total":7}}`))
+	}))
+	defer api.Close()
+	factory, err := spotifyapi.NewFactory(api.URL + "/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit, market := 5, "FR"
+	shows, err := newAPIClient(factory, "token").SearchShowsOpt("sunrise news", &spotify.Options{Limit: &limit, Country: &market})
+	if err != nil {
+		t.Fatalf("SearchShowsOpt() error = %v", err)
+	}
+	if query != "limit=5&market=FR&q=sunrise+news&type=show" {
+		t.Errorf("unexpected query: %v", query)
+	}
+	if shows.Total != 7 || len(shows.Shows) != 1 || shows.Shows[0].Publisher != "Radio" {
+		t.Errorf("SearchShowsOpt() = %+v", shows)
+	}
+
+	_, err = newAPIClient(factory, "expired").SearchShowsOpt("sunrise", nil)
+	if serr, ok := err.(spotify.Error); !ok || serr.Status != http.StatusUnauthorized || serr.Message != "Invalid access token" {
+		t.Errorf("SearchShowsOpt() error = %v, want the spotify error", err)
+	}
+}
@@ -0,0 +1,15 @@
+module spotctl
+
+go 1.16
+
+require (
+	common v0.0.0
+	github.com/charmbracelet/bubbletea v0.20.0
+	github.com/charmbracelet/lipgloss v0.5.0
+	github.com/spf13/cobra v1.2.1
+	github.com/zmb3/spotify v1.1.2
+	golang.org/x/oauth2 v0.0.0-20210402161424-2e8d93401602
+	golang.org/x/sys v0.10.0 // indirect
+)
+
+replace common => ../common
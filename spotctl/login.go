@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+// scopes are the spotify scopes asked by the login, the ones used by the API
+var scopes = []string{
+	"playlist-read-private",
+	"playlist-read-collaborative",
+	"user-read-private",
+	"user-read-email",
+	"user-library-read",
+	"user-library-modify",
+	"user-follow-read",
+	"user-read-playback-state",
+	"user-modify-playback-state",
+	"user-read-currently-playing",
+	"user-read-recently-played",
+}
+
+// oauthConfig returns the oauth2 configuration of the spotify accounts service
+// The login uses PKCE so no client secret is needed
+func oauthConfig(clientID, accountsURL, redirectURL string) *oauth2.Config {
+	accountsURL = strings.TrimSuffix(accountsURL, "/")
+	return &oauth2.Config{
+		ClientID:    clientID,
+		RedirectURL: redirectURL,
+		Scopes:      scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   accountsURL + "/authorize",
+			TokenURL:  accountsURL + "/api/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+// randomString returns a random url safe string of n bytes of entropy
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge returns the S256 challenge of the verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// openBrowser opens the url in the browser of the user
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+// loginOptions are the flags of the login command
+type loginOptions struct {
+	clientID    string
+	accountsURL string
+	port        int
+	timeout     time.Duration
+}
+
+// callback is the result of the redirection of the browser to the local server
+type callback struct {
+	code string
+	err  error
+}
+
+// login runs the authorization code flow with PKCE, the browser is redirected to a local server receiving the code
+func (a *app) login(ctx context.Context, opts loginOptions) (*oauth2.Token, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", opts.port))
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for the login callback: %w", err)
+	}
+	redirectURL := fmt.Sprintf("http://%s/callback", ln.Addr().String())
+	cfg := oauthConfig(opts.clientID, opts.accountsURL, redirectURL)
+
+	callbacks := make(chan callback, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		var result callback
+		switch {
+		case query.Get("state") != state:
+			result.err = fmt.Errorf("the login callback has an unexpected state")
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("spotify refused the login: %s", query.Get("error"))
+		default:
+			result.code = query.Get("code")
+		}
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Logged in, you can close this window and go back to spotctl.")
+		}
+		select {
+		case callbacks <- result:
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	authURL := cfg.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+	)
+	fmt.Fprintf(a.errOut, "Opening the spotify login page, if it does not open visit:\n%s\n", authURL)
+	if err := a.openBrowser(authURL); err != nil {
+		fmt.Fprintf(a.errOut, "could not open the browser: %v\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("the login was not completed: %w", ctx.Err())
+	case result := <-callbacks:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return cfg.Exchange(ctx, result.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+}
+
+// newLoginCmd creates the login command, storing the token in the config directory
+func newLoginCmd(a *app) *cobra.Command {
+	opts := loginOptions{
+		clientID:    envOr("SPOTIFY_CLIENT_ID", ""),
+		accountsURL: "https://accounts.spotify.com",
+		port:        8888,
+		timeout:     5 * time.Minute,
+	}
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in to spotify in the browser and store the token",
+		Long: "Log in to spotify in the browser and store the token in the config directory.\n" +
+			"The redirect URI http://127.0.0.1:<port>/callback must be allowed in the settings of the spotify application.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.clientID == "" {
+				return fmt.Errorf("the spotify client ID is required, use --client-id or SPOTIFY_CLIENT_ID")
+			}
+			token, err := a.login(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+			store := a.store()
+			if err := store.save(&savedToken{ClientID: opts.clientID, AccountsURL: opts.accountsURL, Token: token}); err != nil {
+				return fmt.Errorf("could not store the token: %w", err)
+			}
+			fmt.Fprintf(a.errOut, "Logged in, the token is stored in %s\n", store.path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.clientID, "client-id", opts.clientID, "client ID of the spotify application (SPOTIFY_CLIENT_ID)")
+	cmd.Flags().IntVar(&opts.port, "port", opts.port, "local port receiving the login callback")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", opts.timeout, "time given to complete the login in the browser")
+	cmd.Flags().StringVar(&opts.accountsURL, "accounts-url", opts.accountsURL, "url of the spotify accounts service")
+	cmd.Flags().MarkHidden("accounts-url")
+	return cmd
+}
+
+// newLogoutCmd creates the logout command, removing the stored token
+func newLogoutCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Remove the stored token",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.store().delete()
+		},
+	}
+}
+
+// envOr returns the environment variable or the fallback when it is not set
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeAccounts serves the token endpoint of the spotify accounts service
+type fakeAccounts struct {
+	t         *testing.T
+	challenge string
+	refreshes int
+}
+
+func (f *fakeAccounts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.URL.Path != "/api/token" || r.PostForm.Get("client_id") != "client" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	access := "token"
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		if r.PostForm.Get("code") != "code" || pkceChallenge(r.PostForm.Get("code_verifier")) != f.challenge {
+			f.t.Errorf("unexpected code exchange: %v", r.PostForm)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the first token is already expired so the refresh is tested
+		access = "expired"
+	case "refresh_token":
+		f.refreshes++
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": access, "token_type": "Bearer", "refresh_token": "refresh", "expires_in": 3600})
+}
+
+func Test_login(t *testing.T) {
+	accounts := &fakeAccounts{t: t}
+	accountsSrv := httptest.NewServer(accounts)
+	defer accountsSrv.Close()
+
+	a, out := newTestApp(t, &fakeAPI{})
+	a.openBrowser = func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		if u.Path != "/authorize" || query.Get("client_id") != "client" || query.Get("code_challenge_method") != "S256" {
+			t.Errorf("unexpected login url: %v", authURL)
+		}
+		accounts.challenge = query.Get("code_challenge")
+		// the browser is redirected to the callback once the user accepted
+		go http.Get(query.Get("redirect_uri") + "?code=code&state=" + url.QueryEscape(query.Get("state")))
+		return nil
+	}
+
+	if err := execute(a, "login", "--client-id", "client", "--port", "0", "--accounts-url", accountsSrv.URL); err != nil {
+		t.Fatalf("login error = %v", err)
+	}
+	info, err := os.Stat(a.store().path)
+	if err != nil {
+		t.Fatalf("the token was not stored: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("the token is readable by others: %v", info.Mode())
+	}
+
+	// the stored token is refreshed once rejected and stored again
+	if err := execute(a, "whoami"); err != nil {
+		t.Fatalf("whoami error = %v", err)
+	}
+	if !strings.Contains(out.String(), "thomas") || accounts.refreshes != 1 {
+		t.Errorf("unexpected whoami: %q with %v refreshes", out.String(), accounts.refreshes)
+	}
+	saved, err := a.store().load()
+	if err != nil || saved.Token.AccessToken != "token" || saved.ClientID != "client" {
+		t.Errorf("the refreshed token was not stored: %+v, %v", saved, err)
+	}
+
+	if err := execute(a, "logout"); err != nil {
+		t.Fatalf("logout error = %v", err)
+	}
+	if err := execute(a, "whoami"); err != errNotLoggedIn {
+		t.Errorf("unexpected error after logout: got %v want %v", err, errNotLoggedIn)
+	}
+}
+
+func Test_login_callbackErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       func(state string) string
+		expectedErr string
+	}{
+		{
+			name:        "should reject an unexpected state",
+			query:       func(state string) string { return "code=code&state=other" },
+			expectedErr: "unexpected state",
+		},
+		{
+			name:        "should report a refused login",
+			query:       func(state string) string { return "error=access_denied&state=" + state },
+			expectedErr: "access_denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestApp(t, &fakeAPI{})
+			a.openBrowser = func(authURL string) error {
+				u, _ := url.Parse(authURL)
+				go http.Get(u.Query().Get("redirect_uri") + "?" + tt.query(u.Query().Get("state")))
+				return nil
+			}
+			err := execute(a, "login", "--client-id", "client", "--port", "0", "--accounts-url", "http://127.0.0.1:1")
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("unexpected error: got %v want %v", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func Test_login_timeout(t *testing.T) {
+	a, _ := newTestApp(t, &fakeAPI{})
+	err := execute(a, "login", "--client-id", "client", "--port", "0", "--timeout", "10ms")
+	if err == nil || !strings.Contains(err.Error(), "not completed") {
+		t.Errorf("unexpected error: got %v, want a timeout", err)
+	}
+}
+
+func Test_login_requiresClientID(t *testing.T) {
+	os.Unsetenv("SPOTIFY_CLIENT_ID")
+	a, _ := newTestApp(t, &fakeAPI{})
+	if err := execute(a, "login"); err == nil || !strings.Contains(err.Error(), "client ID") {
+		t.Errorf("unexpected error: got %v, want the client ID to be required", err)
+	}
+}
+
+func Test_tokenStore(t *testing.T) {
+	store := tokenStore{path: t.TempDir() + "/nested/token.json"}
+	saved := &savedToken{ClientID: "client", Token: &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour).Round(time.Second)}}
+	if err := store.save(saved); err != nil {
+		t.Fatalf("save error = %v", err)
+	}
+	loaded, err := store.load()
+	if err != nil || loaded.ClientID != "client" || loaded.Token.AccessToken != "token" || !loaded.Token.Expiry.Equal(saved.Token.Expiry) {
+		t.Errorf("load = %+v, %v", loaded, err)
+	}
+	if err := store.delete(); err != nil {
+		t.Errorf("delete error = %v", err)
+	}
+	if err := store.delete(); err != nil {
+		t.Errorf("delete of a missing token error = %v", err)
+	}
+	if _, err := store.load(); !os.IsNotExist(err) {
+		t.Errorf("load after delete error = %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"common/client"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+// errNotLoggedIn is returned when no token is stored nor given
+var errNotLoggedIn = errors.New("not logged in, run `spotctl login` first")
+
+// app holds the global flags and the dependencies of the commands
+type app struct {
+	out    io.Writer
+	errOut io.Writer
+
+	apiURL    string
+	output    string
+	configDir string
+	token     string
+
+	// openBrowser shows the spotify login page to the user
+	openBrowser func(url string) error
+	clientOpts  client.Options
+}
+
+// newApp creates the app with its defaults, read from the environment when set
+func newApp(out, errOut io.Writer) *app {
+	configDir := os.Getenv("SPOTCTL_CONFIG_DIR")
+	if configDir == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			configDir = filepath.Join(dir, "spotctl")
+		}
+	}
+	apiURL := os.Getenv("SPOTCTL_API")
+	if apiURL == "" {
+		apiURL = "http://127.0.0.1:8080"
+	}
+	return &app{
+		out:         out,
+		errOut:      errOut,
+		apiURL:      apiURL,
+		output:      "table",
+		configDir:   configDir,
+		token:       os.Getenv("SPOTCTL_TOKEN"),
+		openBrowser: openBrowser,
+		clientOpts:  client.DefaultOptions(),
+	}
+}
+
+// client creates the api client, authenticated with the given token or else with the stored one
+// The stored token is refreshed when it expires and saved again
+func (a *app) client() (*client.Client, error) {
+	if a.token != "" {
+		return client.New(a.apiURL, client.StaticToken(a.token), a.clientOpts)
+	}
+	store := a.store()
+	saved, err := store.load()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotLoggedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := oauthConfig(saved.ClientID, saved.AccountsURL, "")
+	tokens := client.OAuth2Token(cfg, saved.Token, func(token *oauth2.Token) {
+		saved.Token = token
+		if err := store.save(saved); err != nil {
+			fmt.Fprintf(a.errOut, "warning: could not store the refreshed token: %v\n", err)
+		}
+	})
+	return client.New(a.apiURL, tokens, a.clientOpts)
+}
+
+// run adapts a command using the api client to cobra
+func (a *app) run(fn func(ctx context.Context, c *client.Client, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		c, err := a.client()
+		if err != nil {
+			return err
+		}
+		if err := fn(cmd.Context(), c, args); err != nil {
+			if client.IsUnauthorized(err) {
+				return fmt.Errorf("the session expired, log in again with `spotctl login`: %w", err)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// newRootCmd creates the spotctl command with all its sub commands
+func newRootCmd(a *app) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "spotctl",
+		Short:         "Control the spotify player and browse the playlists through the spotify-app API",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if a.output != "table" && a.output != "json" {
+				return fmt.Errorf("invalid output %q, must be table or json", a.output)
+			}
+			return nil
+		},
+	}
+	root.SetOut(a.out)
+	root.SetErr(a.errOut)
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.apiURL, "api", a.apiURL, "url of the spotify-app API (SPOTCTL_API)")
+	flags.StringVarP(&a.output, "output", "o", a.output, "output format: table or json")
+	flags.StringVar(&a.configDir, "config-dir", a.configDir, "directory of the stored token (SPOTCTL_CONFIG_DIR)")
+	flags.StringVar(&a.token, "token", a.token, "spotify access token to use instead of the stored one (SPOTCTL_TOKEN)")
+	root.RegisterFlagCompletionFunc("output", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"table", "json"}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	root.AddCommand(
+		newLoginCmd(a),
+		newLogoutCmd(a),
+		newWhoamiCmd(a),
+		newNowCmd(a),
+		newPlayCmd(a),
+		newControlCmd(a, "pause", "Pause the music", "Paused", (*client.Client).Pause),
+		newControlCmd(a, "next", "Skip to the next track", "Skipped to the next track", (*client.Client).Next),
+		newControlCmd(a, "prev", "Go back to the previous track", "Back to the previous track", (*client.Client).Previous),
+		newDevicesCmd(a),
+		newPlaylistsCmd(a),
+		newPlaylistCmd(a),
+		newTUICmd(a),
+	)
+	return root
+}
+
+func main() {
+	a := newApp(os.Stdout, os.Stderr)
+	if err := newRootCmd(a).Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/models"
+)
+
+// fakeAPI serves the routes of the API used by the commands
+type fakeAPI struct {
+	mu        sync.Mutex
+	player    *models.Player
+	playlists []models.PlaylistItem
+	requests  []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	if r.Header.Get("Authorization") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"status":401,"code":"invalid_token","message":"the access token is invalid"}}`))
+		return
+	}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /user":
+		json.NewEncoder(w).Encode(models.User{ID: "thomas", Name: "Thomas"})
+	case "GET /player":
+		if f.player == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(f.player)
+	case "GET /player/events":
+		w.Header().Set("Content-Type", "text/event-stream")
+		if f.player == nil {
+			fmt.Fprint(w, "event: nothing_playing\ndata: {}\n\n")
+			return
+		}
+		data, _ := json.Marshal(f.player)
+		fmt.Fprintf(w, "event: player\ndata: %s\n\n", data)
+	case "POST /player/play", "POST /player/pause", "POST /player/next", "POST /player/prev":
+	case "GET /player/devices":
+		json.NewEncoder(w).Encode([]models.Device{{ID: "device", Name: "Fake speaker", Type: "Speaker", IsActive: true, Volume: 50}})
+	case "GET /playlist":
+		var page client.Page
+		fmt.Sscan(r.URL.Query().Get("limit"), &page.Limit)
+		fmt.Sscan(r.URL.Query().Get("offset"), &page.Offset)
+		end := len(f.playlists)
+		if page.Limit > 0 && page.Offset+page.Limit < end {
+			end = page.Offset + page.Limit
+		}
+		json.NewEncoder(w).Encode(f.playlists[page.Offset:end])
+	case "GET /playlist/morning":
+		json.NewEncoder(w).Encode(models.Playlist{
+			PlaylistItem: models.PlaylistItem{Name: "Morning", OwnerName: "Thomas", ID: "morning", URI: "spotify:playlist:morning"},
+			Tracks:       []models.Track{{Name: "Sunrise", ArtistsName: []string{"The Early Birds"}, AlbumName: "Dawn", ID: "sunrise", Duration: 180000}},
+			Total:        3,
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"status":404,"code":"not_found","message":"no route"}}`))
+	}
+}
+
+// newTestApp creates an app calling the fake api with a temporary config directory
+func newTestApp(t *testing.T, api http.Handler) (*app, *bytes.Buffer) {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	out := &bytes.Buffer{}
+	a := newApp(out, &bytes.Buffer{})
+	a.apiURL = srv.URL
+	a.configDir = t.TempDir()
+	a.token = ""
+	a.openBrowser = func(string) error { return nil }
+	a.clientOpts.RetryWait = time.Millisecond
+	a.clientOpts.MaxRetryWait = time.Millisecond
+	return a, out
+}
+
+// execute runs spotctl with the arguments
+func execute(a *app, args ...string) error {
+	cmd := newRootCmd(a)
+	cmd.SetArgs(args)
+	return cmd.ExecuteContext(context.Background())
+}
+
+func Test_commands(t *testing.T) {
+	playing := &models.Player{IsPlaying: true, MusicName: "Sunrise", ArtistsName: []string{"The Early Birds", "Thomas"}, AlbumName: "Dawn", Progress: 42000, Duration: 180000}
+	playlists := []models.PlaylistItem{
+		{Name: "Morning", OwnerName: "Thomas", ID: "morning", URI: "spotify:playlist:morning"},
+		{Name: "Evening", OwnerName: "Thomas", ID: "evening", URI: "spotify:playlist:evening"},
+	}
+	tests := []struct {
+		name             string
+		args             []string
+		player           *models.Player
+		expectedOutput   string
+		expectedRequests []string
+		expectedErr      string
+	}{
+		{
+			name:           "should show the current user",
+			args:           []string{"whoami"},
+			expectedOutput: "ID      NAME\nthomas  Thomas\n",
+		},
+		{
+			name:           "should show the current user as json",
+			args:           []string{"whoami", "-o", "json"},
+			expectedOutput: "{\n  \"name\": \"Thomas\",\n  \"id\": \"thomas\",\n  \"image\": \"\"\n}\n",
+		},
+		{
+			name:           "should show what is playing",
+			args:           []string{"now"},
+			player:         playing,
+			expectedOutput: "STATUS   TRACK    ARTISTS                  ALBUM  PROGRESS\nplaying  Sunrise  The Early Birds, Thomas  Dawn   0:42 / 3:00\n",
+		},
+		{
+			name:           "should show that nothing is playing",
+			args:           []string{"now"},
+			expectedOutput: "Nothing is playing\n",
+		},
+		{
+			name:           "should show that nothing is playing as json",
+			args:           []string{"now", "--output", "json"},
+			expectedOutput: "null\n",
+		},
+		{
+			name:             "should resume the music",
+			args:             []string{"play"},
+			expectedOutput:   "Playing\n",
+			expectedRequests: []string{"POST /player/play"},
+		},
+		{
+			name:             "should play a playlist",
+			args:             []string{"play", "spotify:playlist:morning"},
+			expectedOutput:   "Playing spotify:playlist:morning\n",
+			expectedRequests: []string{"POST /player/play"},
+		},
+		{
+			name:             "should pause the music",
+			args:             []string{"pause"},
+			expectedOutput:   "Paused\n",
+			expectedRequests: []string{"POST /player/pause"},
+		},
+		{
+			name:             "should skip to the next track",
+			args:             []string{"next"},
+			expectedOutput:   "Skipped to the next track\n",
+			expectedRequests: []string{"POST /player/next"},
+		},
+		{
+			name:             "should go back to the previous track without output in json",
+			args:             []string{"prev", "-o", "json"},
+			expectedOutput:   "",
+			expectedRequests: []string{"POST /player/prev"},
+		},
+		{
+			name:           "should list the devices",
+			args:           []string{"devices"},
+			expectedOutput: "ID      NAME          TYPE     ACTIVE  VOLUME\ndevice  Fake speaker  Speaker  yes     50%\n",
+		},
+		{
+			name:           "should list the playlists",
+			args:           []string{"playlists", "ls"},
+			expectedOutput: "ID       NAME     OWNER   URI\nmorning  Morning  Thomas  spotify:playlist:morning\nevening  Evening  Thomas  spotify:playlist:evening\n",
+		},
+		{
+			name:             "should list a page of playlists",
+			args:             []string{"playlists", "list", "--limit", "1", "--offset", "1"},
+			expectedOutput:   "ID       NAME     OWNER   URI\nevening  Evening  Thomas  spotify:playlist:evening\n",
+			expectedRequests: []string{"GET /playlist?limit=1&offset=1"},
+		},
+		{
+			name:             "should list all the playlists",
+			args:             []string{"playlists", "ls", "--all"},
+			expectedOutput:   "ID       NAME     OWNER   URI\nmorning  Morning  Thomas  spotify:playlist:morning\nevening  Evening  Thomas  spotify:playlist:evening\n",
+			expectedRequests: []string{"GET /playlist?limit=50"},
+		},
+		{
+			name:           "should show a playlist",
+			args:           []string{"playlist", "show", "spotify:playlist:morning"},
+			expectedOutput: "Morning by Thomas, 3 tracks\n\n#  TRACK    ARTISTS          ALBUM  DURATION\n1  Sunrise  The Early Birds  Dawn   3:00\n",
+		},
+		{
+			name:        "should error on an unknown playlist",
+			args:        []string{"playlist", "show", "unknown"},
+			expectedErr: "404 not_found",
+		},
+		{
+			name:        "should error on an invalid output",
+			args:        []string{"whoami", "-o", "yaml"},
+			expectedErr: `invalid output "yaml"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{player: tt.player, playlists: playlists}
+			a, out := newTestApp(t, api)
+			a.token = "token"
+
+			err := execute(a, tt.args...)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("unexpected error: got %v want %v", err, tt.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if out.String() != tt.expectedOutput {
+				t.Errorf("unexpected output: got\n%q\nwant\n%q", out.String(), tt.expectedOutput)
+			}
+			if tt.expectedRequests != nil && strings.Join(api.requests, ",") != strings.Join(tt.expectedRequests, ",") {
+				t.Errorf("unexpected requests: got %v want %v", api.requests, tt.expectedRequests)
+			}
+		})
+	}
+}
+
+func Test_commands_notLoggedIn(t *testing.T) {
+	a, _ := newTestApp(t, &fakeAPI{})
+	if err := execute(a, "whoami"); err != errNotLoggedIn {
+		t.Errorf("unexpected error: got %v want %v", err, errNotLoggedIn)
+	}
+
+	a.token = "expired"
+	if err := execute(a, "whoami"); err == nil || !strings.Contains(err.Error(), "spotctl login") {
+		t.Errorf("unexpected error: got %v, want to be asked to log in again", err)
+	}
+}
+
+func Test_completion(t *testing.T) {
+	a, out := newTestApp(t, &fakeAPI{playlists: []models.PlaylistItem{{Name: "Morning", ID: "morning", URI: "spotify:playlist:morning"}}})
+	a.token = "token"
+
+	if err := execute(a, "completion", "bash"); err != nil || !strings.Contains(out.String(), "spotctl") {
+		t.Errorf("completion bash = %v, %v", out.Len(), err)
+	}
+
+	out.Reset()
+	if err := execute(a, "__complete", "playlist", "show", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "morning\tMorning\n") {
+		t.Errorf("unexpected completions of playlist show: %q", out.String())
+	}
+
+	out.Reset()
+	if err := execute(a, "__complete", "play", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "spotify:playlist:morning\tMorning\n") {
+		t.Errorf("unexpected completions of play: %q", out.String())
+	}
+}
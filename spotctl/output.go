@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// render writes the value as indented json, or as the table written by table
+func (a *app) render(value interface{}, table func(w io.Writer)) error {
+	if a.output == "json" {
+		enc := json.NewEncoder(a.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// message writes a confirmation of an action, only in the table output so the json output stays parsable
+func (a *app) message(format string, args ...interface{}) {
+	if a.output != "json" {
+		fmt.Fprintf(a.out, format+"\n", args...)
+	}
+}
+
+// row writes a tab separated row of a table
+func row(w io.Writer, columns ...interface{}) {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		values[i] = fmt.Sprint(c)
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+}
+
+// formatDuration formats a duration in milliseconds as minutes and seconds
+func formatDuration(ms int) string {
+	seconds := ms / 1000
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
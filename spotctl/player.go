@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"common/client"
+
+	"github.com/spf13/cobra"
+	"github.com/zmb3/spotify"
+)
+
+// newNowCmd creates the command showing what is playing
+func newNowCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "now",
+		Short: "Show what is playing",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			player, err := c.Player(ctx)
+			if errors.Is(err, client.ErrNothingPlaying) {
+				if a.output == "json" {
+					return a.render(nil, nil)
+				}
+				a.message("Nothing is playing")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return a.render(player, func(w io.Writer) {
+				status := "paused"
+				if player.IsPlaying {
+					status = "playing"
+				}
+				row(w, "STATUS", "TRACK", "ARTISTS", "ALBUM", "PROGRESS")
+				row(w, status, player.MusicName, strings.Join(player.ArtistsName, ", "), player.AlbumName,
+					formatDuration(player.Progress)+" / "+formatDuration(player.Duration))
+			})
+		}),
+	}
+}
+
+// newPlayCmd creates the command resuming the music or playing a track / playlist / album
+func newPlayCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "play [uri]",
+		Short: "Resume the music, or play the track / playlist / album of the uri",
+		Args:  cobra.MaximumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return a.completePlaylists(cmd.Context(), func(uri, id string) string { return uri })
+		},
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			var opts client.PlayOptions
+			if len(args) > 0 {
+				opts.URI = spotify.URI(args[0])
+			}
+			if err := c.Play(ctx, opts); err != nil {
+				return err
+			}
+			if opts.URI != "" {
+				a.message("Playing %s", opts.URI)
+			} else {
+				a.message("Playing")
+			}
+			return nil
+		}),
+	}
+}
+
+// newControlCmd creates a command controlling the player without arguments
+func newControlCmd(a *app, use, short, done string, control func(*client.Client, context.Context) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			if err := control(c, ctx); err != nil {
+				return err
+			}
+			a.message(done)
+			return nil
+		}),
+	}
+}
+
+// newDevicesCmd creates the command listing the devices
+func newDevicesCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "devices",
+		Short: "List the devices able to play music",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			devices, err := c.Devices(ctx)
+			if err != nil {
+				return err
+			}
+			return a.render(devices, func(w io.Writer) {
+				row(w, "ID", "NAME", "TYPE", "ACTIVE", "VOLUME")
+				for _, d := range devices {
+					active := "no"
+					if d.IsActive {
+						active = "yes"
+					}
+					row(w, d.ID, d.Name, d.Type, active, fmt.Sprintf("%d%%", d.Volume))
+				}
+			})
+		}),
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"common/client"
+	"common/models"
+
+	"github.com/spf13/cobra"
+)
+
+// maxPlaylistsPage is the largest page of playlists accepted by the API
+const maxPlaylistsPage = 50
+
+// allPlaylists pages through all the playlists of the current user
+func allPlaylists(ctx context.Context, c *client.Client) ([]models.PlaylistItem, error) {
+	playlists := []models.PlaylistItem{}
+	for {
+		page, err := c.Playlists(ctx, client.Page{Limit: maxPlaylistsPage, Offset: len(playlists)})
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, page...)
+		if len(page) < maxPlaylistsPage {
+			return playlists, nil
+		}
+	}
+}
+
+// completePlaylists completes the playlists of the current user, described by their name
+func (a *app) completePlaylists(ctx context.Context, value func(uri, id string) string) ([]string, cobra.ShellCompDirective) {
+	c, err := a.client()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	playlists, err := allPlaylists(ctx, c)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var completions []string
+	for _, p := range playlists {
+		completions = append(completions, value(string(p.URI), string(p.ID))+"\t"+p.Name)
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// newPlaylistsCmd creates the playlists command and its ls sub command
+func newPlaylistsCmd(a *app) *cobra.Command {
+	var page client.Page
+	var all bool
+	ls := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the playlists of the current user",
+		Args:    cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			var playlists []models.PlaylistItem
+			var err error
+			if all {
+				playlists, err = allPlaylists(ctx, c)
+			} else {
+				playlists, err = c.Playlists(ctx, page)
+			}
+			if err != nil {
+				return err
+			}
+			return a.render(playlists, func(w io.Writer) {
+				row(w, "ID", "NAME", "OWNER", "URI")
+				for _, p := range playlists {
+					row(w, p.ID, p.Name, p.OwnerName, p.URI)
+				}
+			})
+		}),
+	}
+	ls.Flags().IntVar(&page.Limit, "limit", 0, "number of playlists to list, up to 50")
+	ls.Flags().IntVar(&page.Offset, "offset", 0, "index of the first playlist to list")
+	ls.Flags().BoolVar(&all, "all", false, "list all the playlists")
+
+	cmd := &cobra.Command{
+		Use:   "playlists",
+		Short: "Browse the playlists of the current user",
+	}
+	cmd.AddCommand(ls)
+	return cmd
+}
+
+// newPlaylistCmd creates the playlist command and its show sub command
+func newPlaylistCmd(a *app) *cobra.Command {
+	var page client.Page
+	show := &cobra.Command{
+		Use:   "show <id|uri>",
+		Short: "Show a playlist and its tracks",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return a.completePlaylists(cmd.Context(), func(uri, id string) string { return id })
+		},
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			id := strings.TrimPrefix(args[0], "spotify:playlist:")
+			playlist, err := c.Playlist(ctx, id, page)
+			if err != nil {
+				return err
+			}
+			return a.render(playlist, func(w io.Writer) {
+				fmt.Fprintf(w, "%s by %s, %d tracks\n\n", playlist.Name, playlist.OwnerName, playlist.Total)
+				row(w, "#", "TRACK", "ARTISTS", "ALBUM", "DURATION")
+				for i, t := range playlist.Tracks {
+					row(w, page.Offset+i+1, t.Name, strings.Join(t.ArtistsName, ", "), t.AlbumName, formatDuration(t.Duration))
+				}
+			})
+		}),
+	}
+	show.Flags().IntVar(&page.Limit, "limit", 0, "number of tracks to show, up to 100")
+	show.Flags().IntVar(&page.Offset, "offset", 0, "index of the first track to show")
+
+	cmd := &cobra.Command{
+		Use:   "playlist",
+		Short: "Browse a playlist",
+	}
+	cmd.AddCommand(show)
+	return cmd
+}
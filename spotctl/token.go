@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/oauth2"
+)
+
+// savedToken is the token stored by the login, along with what is needed to refresh it
+type savedToken struct {
+	ClientID    string        `json:"client_id"`
+	AccountsURL string        `json:"accounts_url"`
+	Token       *oauth2.Token `json:"token"`
+}
+
+// tokenStore stores the token in a file only readable by the user
+type tokenStore struct {
+	path string
+}
+
+// store returns the store of the token in the config directory
+func (a *app) store() tokenStore {
+	return tokenStore{path: filepath.Join(a.configDir, "token.json")}
+}
+
+func (s tokenStore) load() (*savedToken, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var saved savedToken
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (s tokenStore) save(saved *savedToken) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the token is written next to the file then renamed, so a failure never leaves a partial token
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s tokenStore) delete() error {
+	err := os.Remove(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
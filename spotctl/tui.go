@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"common/client"
+	"common/models"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// progressWidth is the number of cells of the progress bar
+	progressWidth = 30
+	// minPlaylistsHeight is the number of playlists shown when the size of the terminal is unknown or small
+	minPlaylistsHeight = 5
+)
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true)
+	faintStyle    = lipgloss.NewStyle().Faint(true)
+	selectedStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("10"))
+	errorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+)
+
+// playerEventMsg is an event of the player event stream
+type playerEventMsg client.PlayerEvent
+
+// eventsClosedMsg tells the player event stream is closed
+type eventsClosedMsg struct{}
+
+// playlistsMsg holds the loaded playlists
+type playlistsMsg struct {
+	playlists []models.PlaylistItem
+	err       error
+}
+
+// actionMsg is the result of a control of the player
+type actionMsg struct {
+	done string
+	err  error
+}
+
+// tickMsg refreshes the progress of the track
+type tickMsg time.Time
+
+// tuiModel is the state of the terminal player
+// The player is only refreshed by the event stream, its progress is interpolated in between
+type tuiModel struct {
+	ctx    context.Context
+	client *client.Client
+	events <-chan client.PlayerEvent
+
+	player *models.Player
+	// received is when the player was received, now is the time shown
+	received time.Time
+	now      time.Time
+
+	playlists []models.PlaylistItem
+	loading   bool
+	cursor    int
+	offset    int
+	height    int
+
+	status string
+	err    error
+	// fatal is the error ending the program, e.g. an expired session
+	fatal error
+}
+
+// newTUIModel creates the terminal player, subscribed to the player event stream until the context is done
+func newTUIModel(ctx context.Context, c *client.Client) tuiModel {
+	now := time.Now()
+	return tuiModel{
+		ctx:      ctx,
+		client:   c,
+		events:   c.PlayerEvents(ctx),
+		received: now,
+		now:      now,
+		loading:  true,
+	}
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return tea.Batch(m.waitForEvent(), m.loadPlaylists(), tick())
+}
+
+// waitForEvent waits for the next event of the player event stream
+func (m tuiModel) waitForEvent() tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-m.events
+		if !ok {
+			return eventsClosedMsg{}
+		}
+		return playerEventMsg(event)
+	}
+}
+
+// loadPlaylists loads all the playlists of the current user
+func (m tuiModel) loadPlaylists() tea.Cmd {
+	return func() tea.Msg {
+		playlists, err := allPlaylists(m.ctx, m.client)
+		return playlistsMsg{playlists: playlists, err: err}
+	}
+}
+
+// control controls the player, its new state comes from the event stream
+func (m tuiModel) control(done string, control func(*client.Client, context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		return actionMsg{done: done, err: control(m.client, m.ctx)}
+	}
+}
+
+// tick refreshes the progress every second
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		m.scroll()
+	case tickMsg:
+		m.now = time.Time(msg)
+		return m, tick()
+	case playerEventMsg:
+		if msg.Err != nil {
+			if client.IsUnauthorized(msg.Err) {
+				return m.fail(msg.Err)
+			}
+			m.err = msg.Err
+			return m, m.waitForEvent()
+		}
+		m.player = msg.Player
+		m.received = time.Now()
+		m.now = m.received
+		m.err = nil
+		return m, m.waitForEvent()
+	case eventsClosedMsg:
+		m.events = nil
+	case playlistsMsg:
+		m.loading = false
+		if msg.err != nil {
+			if client.IsUnauthorized(msg.err) {
+				return m.fail(msg.err)
+			}
+			m.err = msg.err
+			return m, nil
+		}
+		m.playlists = msg.playlists
+		if m.cursor >= len(m.playlists) {
+			m.cursor = 0
+		}
+		m.scroll()
+	case actionMsg:
+		if msg.err != nil {
+			if client.IsUnauthorized(msg.err) {
+				return m.fail(msg.err)
+			}
+			m.err = msg.err
+			return m, nil
+		}
+		m.status = msg.done
+		m.err = nil
+	}
+	return m, nil
+}
+
+// handleKey maps the keys to the controls of the player and the playlists
+func (m tuiModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c", "esc":
+		return m, tea.Quit
+	case " ", "space", "p":
+		if m.player != nil && m.player.IsPlaying {
+			return m, m.control("Paused", (*client.Client).Pause)
+		}
+		return m, m.control("Playing", func(c *client.Client, ctx context.Context) error {
+			return c.Play(ctx, client.PlayOptions{})
+		})
+	case "n":
+		return m, m.control("Skipped to the next track", (*client.Client).Next)
+	case "b":
+		return m, m.control("Back to the previous track", (*client.Client).Previous)
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+			m.scroll()
+		}
+	case "down", "j":
+		if m.cursor < len(m.playlists)-1 {
+			m.cursor++
+			m.scroll()
+		}
+	case "enter":
+		if len(m.playlists) == 0 {
+			return m, nil
+		}
+		playlist := m.playlists[m.cursor]
+		return m, m.control("Playing "+playlist.Name, func(c *client.Client, ctx context.Context) error {
+			return c.Play(ctx, client.PlayOptions{URI: playlist.URI})
+		})
+	case "r":
+		m.loading = true
+		return m, m.loadPlaylists()
+	}
+	return m, nil
+}
+
+// fail ends the program with the error
+func (m tuiModel) fail(err error) (tea.Model, tea.Cmd) {
+	m.fatal = err
+	return m, tea.Quit
+}
+
+// playlistsHeight is the number of playlists fitting in the terminal
+func (m tuiModel) playlistsHeight() int {
+	// the player, the titles, the help and the status take 10 lines
+	if height := m.height - 10; height > minPlaylistsHeight {
+		return height
+	}
+	return minPlaylistsHeight
+}
+
+// scroll keeps the selected playlist visible
+func (m *tuiModel) scroll() {
+	height := m.playlistsHeight()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+height {
+		m.offset = m.cursor - height + 1
+	}
+}
+
+// progress is the progress of the track in milliseconds, interpolated since the player was received
+func (m tuiModel) progress() int {
+	progress := m.player.Progress
+	if m.player.IsPlaying && m.now.After(m.received) {
+		progress += int(m.now.Sub(m.received) / time.Millisecond)
+	}
+	if progress > m.player.Duration {
+		progress = m.player.Duration
+	}
+	return progress
+}
+
+// progressBar draws the progress of the track
+func progressBar(progress, duration int) string {
+	filled := 0
+	if duration > 0 {
+		filled = progress * progressWidth / duration
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", progressWidth-filled)
+}
+
+func (m tuiModel) View() string {
+	var b strings.Builder
+
+	if m.player == nil {
+		b.WriteString(faintStyle.Render("Nothing is playing") + "\n\n\n")
+	} else {
+		icon := "⏸"
+		if m.player.IsPlaying {
+			icon = "▶"
+		}
+		fmt.Fprintf(&b, "%s %s\n", icon, titleStyle.Render(m.player.MusicName))
+		fmt.Fprintf(&b, "  %s · %s\n", strings.Join(m.player.ArtistsName, ", "), m.player.AlbumName)
+		progress := m.progress()
+		fmt.Fprintf(&b, "  %s %s / %s\n", progressBar(progress, m.player.Duration), formatDuration(progress), formatDuration(m.player.Duration))
+	}
+
+	b.WriteString("\n" + titleStyle.Render("Playlists") + "\n")
+	switch {
+	case m.loading && len(m.playlists) == 0:
+		b.WriteString(faintStyle.Render("  Loading…") + "\n")
+	case len(m.playlists) == 0:
+		b.WriteString(faintStyle.Render("  No playlists") + "\n")
+	}
+	end := m.offset + m.playlistsHeight()
+	if end > len(m.playlists) {
+		end = len(m.playlists)
+	}
+	for i := m.offset; i < end; i++ {
+		if i == m.cursor {
+			b.WriteString(selectedStyle.Render("> "+m.playlists[i].Name) + "\n")
+		} else {
+			b.WriteString("  " + m.playlists[i].Name + "\n")
+		}
+	}
+
+	b.WriteString("\n" + faintStyle.Render("space play/pause · n next · b prev · ↑/↓ select · enter play playlist · r reload · q quit") + "\n")
+	switch {
+	case m.err != nil:
+		b.WriteString(errorStyle.Render("Error: "+m.err.Error()) + "\n")
+	case m.events == nil:
+		b.WriteString(errorStyle.Render("The player is not refreshed anymore") + "\n")
+	case m.status != "":
+		b.WriteString(m.status + "\n")
+	}
+	return b.String()
+}
+
+// newTUICmd creates the command opening the terminal player
+func newTUICmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui",
+		Short: "Open the terminal player: see what is playing, control it and start a playlist",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			final, err := tea.NewProgram(newTUIModel(ctx, c), tea.WithAltScreen(), tea.WithOutput(a.out)).StartReturningModel()
+			if err != nil {
+				return err
+			}
+			if m, ok := final.(tuiModel); ok && m.fatal != nil {
+				return m.fatal
+			}
+			return nil
+		}),
+	}
+}
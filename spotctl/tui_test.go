@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"common/client"
+	"common/models"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newTestTUI creates the terminal player calling the fake api
+func newTestTUI(t *testing.T, api *fakeAPI) tuiModel {
+	a, _ := newTestApp(t, api)
+	a.token = "token"
+	c, err := a.client()
+	if err != nil {
+		t.Fatalf("could not create the client: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return newTUIModel(ctx, c)
+}
+
+// update sends the message to the model and then the messages of the commands it returns, except the ticks and the quit
+func update(t *testing.T, m tuiModel, msg tea.Msg) (tuiModel, bool) {
+	t.Helper()
+	next, cmd := m.Update(msg)
+	m = next.(tuiModel)
+	if cmd == nil {
+		return m, false
+	}
+	done := make(chan tea.Msg, 1)
+	go func() { done <- cmd() }()
+	select {
+	case msg := <-done:
+		if _, ok := msg.(tickMsg); ok {
+			return m, false
+		}
+		if fmt.Sprintf("%T", msg) == fmt.Sprintf("%T", tea.Quit()) {
+			return m, true
+		}
+		return update(t, m, msg)
+	case <-time.After(100 * time.Millisecond):
+		// waiting for the next event of the stream
+		return m, false
+	}
+}
+
+func key(k string) tea.KeyMsg {
+	switch k {
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case "down":
+		return tea.KeyMsg{Type: tea.KeyDown}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+}
+
+func Test_tui_controls(t *testing.T) {
+	playing := &models.Player{IsPlaying: true, MusicName: "Sunrise", Duration: 180000}
+	paused := &models.Player{IsPlaying: false, MusicName: "Sunrise", Duration: 180000}
+	tests := []struct {
+		name            string
+		keys            []string
+		player          *models.Player
+		expectedRequest string
+		expectedStatus  string
+	}{
+		{
+			name:            "should pause the music when it is playing",
+			keys:            []string{" "},
+			player:          playing,
+			expectedRequest: "POST /player/pause",
+			expectedStatus:  "Paused",
+		},
+		{
+			name:            "should resume the music when it is paused",
+			keys:            []string{"p"},
+			player:          paused,
+			expectedRequest: "POST /player/play",
+			expectedStatus:  "Playing",
+		},
+		{
+			name:            "should skip to the next track",
+			keys:            []string{"n"},
+			player:          playing,
+			expectedRequest: "POST /player/next",
+			expectedStatus:  "Skipped to the next track",
+		},
+		{
+			name:            "should go back to the previous track",
+			keys:            []string{"b"},
+			player:          playing,
+			expectedRequest: "POST /player/prev",
+			expectedStatus:  "Back to the previous track",
+		},
+		{
+			name:            "should play the selected playlist",
+			keys:            []string{"down", "enter"},
+			expectedRequest: "POST /player/play",
+			expectedStatus:  "Playing Evening",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{player: tt.player}
+			m := newTestTUI(t, api)
+			m.player = tt.player
+			m, _ = update(t, m, playlistsMsg{playlists: []models.PlaylistItem{
+				{Name: "Morning", URI: "spotify:playlist:morning"},
+				{Name: "Evening", URI: "spotify:playlist:evening"},
+			}})
+			for _, k := range tt.keys {
+				m, _ = update(t, m, key(k))
+			}
+
+			api.mu.Lock()
+			requests := strings.Join(api.requests, ",")
+			api.mu.Unlock()
+			if !strings.Contains(requests, tt.expectedRequest) {
+				t.Errorf("unexpected requests: got %v want %v", requests, tt.expectedRequest)
+			}
+			if m.status != tt.expectedStatus {
+				t.Errorf("unexpected status: got %q want %q", m.status, tt.expectedStatus)
+			}
+			if !strings.Contains(m.View(), tt.expectedStatus) {
+				t.Errorf("the status is not shown:\n%v", m.View())
+			}
+		})
+	}
+}
+
+func Test_tui_events(t *testing.T) {
+	api := &fakeAPI{player: &models.Player{IsPlaying: true, MusicName: "Sunrise", ArtistsName: []string{"The Early Birds"}, AlbumName: "Dawn", Progress: 42000, Duration: 180000}}
+	m := newTestTUI(t, api)
+	m, _ = update(t, m, m.waitForEvent()())
+
+	if m.player == nil || m.player.MusicName != "Sunrise" {
+		t.Fatalf("the player was not received from the stream: %+v", m.player)
+	}
+	view := m.View()
+	for _, expected := range []string{"▶", "Sunrise", "The Early Birds · Dawn", "0:42 / 3:00"} {
+		if !strings.Contains(view, expected) {
+			t.Errorf("the view does not show %q:\n%v", expected, view)
+		}
+	}
+
+	m, _ = update(t, m, tickMsg(m.received.Add(2*time.Second)))
+	if !strings.Contains(m.View(), "0:44 / 3:00") {
+		t.Errorf("the progress was not interpolated:\n%v", m.View())
+	}
+	m, _ = update(t, m, tickMsg(m.received.Add(time.Hour)))
+	if !strings.Contains(m.View(), "3:00 / 3:00") {
+		t.Errorf("the progress should stop at the end of the track:\n%v", m.View())
+	}
+
+	m, _ = update(t, m, playerEventMsg{Err: errors.New("client: could not get the player")})
+	if !strings.Contains(m.View(), "Error: client: could not get the player") || m.player == nil {
+		t.Errorf("the error should be shown with the last player:\n%v", m.View())
+	}
+	m, _ = update(t, m, playerEventMsg{})
+	if !strings.Contains(m.View(), "Nothing is playing") || m.err != nil {
+		t.Errorf("the view should show that nothing is playing:\n%v", m.View())
+	}
+}
+
+func Test_tui_playlists(t *testing.T) {
+	var playlists []models.PlaylistItem
+	for i := 0; i < 8; i++ {
+		playlists = append(playlists, models.PlaylistItem{Name: fmt.Sprintf("Playlist %d", i)})
+	}
+	m := newTestTUI(t, &fakeAPI{playlists: playlists})
+	if !strings.Contains(m.View(), "Loading…") {
+		t.Errorf("the view should show the playlists are loading:\n%v", m.View())
+	}
+	m, _ = update(t, m, m.loadPlaylists()())
+	if !strings.Contains(m.View(), "> Playlist 0") || strings.Contains(m.View(), "Playlist 5") {
+		t.Errorf("the view should show the first playlists, the first one selected:\n%v", m.View())
+	}
+
+	for i := 0; i < 6; i++ {
+		m, _ = update(t, m, key("j"))
+	}
+	view := m.View()
+	if !strings.Contains(view, "> Playlist 6") || strings.Contains(view, "Playlist 1\n") {
+		t.Errorf("the view should scroll to the selected playlist:\n%v", view)
+	}
+	m, _ = update(t, m, key("k"))
+	if !strings.Contains(m.View(), "> Playlist 5") {
+		t.Errorf("the view should select the previous playlist:\n%v", m.View())
+	}
+}
+
+func Test_tui_quit(t *testing.T) {
+	tests := []struct {
+		name          string
+		msg           tea.Msg
+		expectedFatal bool
+	}{
+		{
+			name: "should quit on q",
+			msg:  key("q"),
+		},
+		{
+			name: "should quit on ctrl+c",
+			msg:  tea.KeyMsg{Type: tea.KeyCtrlC},
+		},
+		{
+			name:          "should quit when the session expired",
+			msg:           playlistsMsg{err: &client.Error{Status: 401, Code: "invalid_token"}},
+			expectedFatal: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestTUI(t, &fakeAPI{})
+			m, quit := update(t, m, tt.msg)
+			if !quit {
+				t.Errorf("the program should quit")
+			}
+			if (m.fatal != nil) != tt.expectedFatal {
+				t.Errorf("unexpected fatal error: %v", m.fatal)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"common/client"
+
+	"github.com/spf13/cobra"
+)
+
+// newWhoamiCmd creates the command showing the current user
+func newWhoamiCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "whoami",
+		Short: "Show the current user",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, c *client.Client, args []string) error {
+			user, err := c.CurrentUser(ctx)
+			if err != nil {
+				return err
+			}
+			return a.render(user, func(w io.Writer) {
+				row(w, "ID", "NAME")
+				row(w, user.ID, user.Name)
+			})
+		}),
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"common/openapi"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	r := mux.NewRouter()
+	r.HandleFunc("/user", userHandler).Methods("GET")
+	r.HandleFunc("/user/{userID}", userFromHandler).Methods("GET")
+
+	tests := []struct {
+		name         string
+		path         string
+		user         spotify.User
+		expectedCode int
+	}{
+		{name: "should document the current user", path: "/user", user: spotify.User{DisplayName: "Thomas", ID: "thomas", Images: []spotify.Image{{URL: "http://image"}}}, expectedCode: http.StatusOK},
+		{name: "should document a user without image", path: "/user/alice", user: spotify.User{DisplayName: "Alice", ID: "alice"}, expectedCode: http.StatusOK},
+		{name: "should document an invalid user ID", path: "/user/" + strings.Repeat("a", 200), expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req = req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, &mockSpotifyClient{user: tt.user}))
+			rr := httptest.NewRecorder()
+			spec.Middleware(r).ServeHTTP(rr, req)
+			if res := rr.Code; res != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, tt.expectedCode)
+			}
+			if err := spec.ValidateResponse("GET", tt.path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
@@ -3,9 +3,11 @@ module user
 go 1.16
 
 require (
+	common v0.0.0
 	github.com/gorilla/mux v1.8.0
-	github.com/rs/cors v1.7.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/zmb3/spotify v1.1.2
-	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	google.golang.org/grpc v1.43.0
 )
+
+replace common => ../common
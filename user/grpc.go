@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+
+	"common/pb/userpb"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// userServer implements the grpc user service with the same logic as the http handlers
+type userServer struct {
+	userpb.UnimplementedUserServiceServer
+}
+
+// GetCurrentUser returns the current user
+func (s *userServer) GetCurrentUser(ctx context.Context, req *userpb.GetCurrentUserRequest) (*userpb.User, error) {
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	user, err := currentUser(client)
+	if err != nil {
+		log.WithError(err).Error("GetCurrentUser: could not get current user")
+		return nil, status.Error(codes.Internal, "could not get current user")
+	}
+	return userpb.FromModel(user), nil
+}
+
+// GetUser returns the public profile of a user
+func (s *userServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	client := ctx.Value(CLIENT_CONTEXT).(spotifyClient)
+	user, err := userProfile(client, spotify.ID(req.GetId()))
+	if err != nil {
+		log.WithField("userID", req.GetId()).WithError(err).Error("GetUser: could not get user public profile")
+		return nil, status.Error(codes.Internal, "could not get user public profile")
+	}
+	return userpb.FromModel(user), nil
+}
+
+// tokenInterceptor will retrieve the token from the authorization metadata and add the spotify client in the call context
+func tokenInterceptor(newClient func(token string) spotifyClient) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var bearer string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			bearer = md.Get("authorization")[0]
+		}
+		return handler(context.WithValue(ctx, CLIENT_CONTEXT, newClient(bearer)), req)
+	}
+}
+
+// newGRPCServer creates the grpc server of the user service along with the grpc health service
+func newGRPCServer(newClient func(token string) spotifyClient) *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(tokenInterceptor(newClient)))
+	userpb.RegisterUserServiceServer(srv, &userServer{})
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	return srv
+}
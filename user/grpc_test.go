@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"common/models"
+	"common/pb/userpb"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCServer serves the grpc API in memory, every call uses the given client
+// The tokens received are appended to tokens
+func startGRPCServer(t *testing.T, client spotifyClient, tokens *[]string) userpb.UserServiceClient {
+	ln := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(func(token string) spotifyClient {
+		*tokens = append(*tokens, token)
+		return client
+	})
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+		return ln.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUserServiceClient(conn)
+}
+
+func Test_grpcParity(t *testing.T) {
+	thomas := spotify.User{DisplayName: "Thomas", ID: "thomas", Images: []spotify.Image{{URL: "http://image"}}}
+	tests := []struct {
+		name   string
+		client *mockSpotifyClient
+		path   string
+		grpc   func(ctx context.Context, c userpb.UserServiceClient) (*userpb.User, error)
+	}{
+		{
+			name:   "should get the same current user",
+			client: &mockSpotifyClient{user: thomas},
+			path:   "/user",
+			grpc: func(ctx context.Context, c userpb.UserServiceClient) (*userpb.User, error) {
+				return c.GetCurrentUser(ctx, &userpb.GetCurrentUserRequest{})
+			},
+		},
+		{
+			name:   "should both fail getting the current user",
+			client: &mockSpotifyClient{err: errors.New("cannot get user")},
+			path:   "/user",
+			grpc: func(ctx context.Context, c userpb.UserServiceClient) (*userpb.User, error) {
+				return c.GetCurrentUser(ctx, &userpb.GetCurrentUserRequest{})
+			},
+		},
+		{
+			name:   "should get the same user profile",
+			client: &mockSpotifyClient{user: thomas},
+			path:   "/user/thomas",
+			grpc: func(ctx context.Context, c userpb.UserServiceClient) (*userpb.User, error) {
+				return c.GetUser(ctx, &userpb.GetUserRequest{Id: "thomas"})
+			},
+		},
+		{
+			name:   "should both fail getting the user profile",
+			client: &mockSpotifyClient{err: errors.New("cannot get user")},
+			path:   "/user/thomas",
+			grpc: func(ctx context.Context, c userpb.UserServiceClient) (*userpb.User, error) {
+				return c.GetUser(ctx, &userpb.GetUserRequest{Id: "thomas"})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.HandleFunc("/user", userHandler)
+			r.HandleFunc("/user/{userID}", userFromHandler)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), CLIENT_CONTEXT, tt.client)))
+			var restUser models.User
+			if rr.Code == http.StatusOK {
+				if err := json.NewDecoder(rr.Body).Decode(&restUser); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var tokens []string
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+			grpcUser, err := tt.grpc(ctx, startGRPCServer(t, tt.client, &tokens))
+			expectedCode := codes.OK
+			if rr.Code != http.StatusOK {
+				expectedCode = codes.Internal
+			}
+			if code := status.Code(err); code != expectedCode {
+				t.Fatalf("grpc returned wrong code: got %v want %v (http %v)", code, expectedCode, rr.Code)
+			}
+			if !reflect.DeepEqual(tokens, []string{"Bearer token"}) {
+				t.Errorf("grpc client was not created with the token: got %v", tokens)
+			}
+			if err == nil && !reflect.DeepEqual(grpcUser.ToModel(), restUser) {
+				t.Errorf("grpc returned a different user: got %+v want %+v", grpcUser.ToModel(), restUser)
+			}
+		})
+	}
+}
+
+func Test_grpcGetUser_requiresID(t *testing.T) {
+	var tokens []string
+	_, err := startGRPCServer(t, &mockSpotifyClient{}, &tokens).GetUser(context.Background(), &userpb.GetUserRequest{})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("grpc returned wrong code: got %v want %v", code, codes.InvalidArgument)
+	}
+}
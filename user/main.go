@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-
+	"os"
+	"time"
+
+	"common/config"
+	"common/health"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmb3/spotify"
-	"golang.org/x/oauth2"
 )
 
 // Key type of spotify client context
@@ -24,35 +31,34 @@ type spotifyClient interface {
 	CurrentUser() (*spotify.PrivateUser, error)
 }
 
-// User is a simplified structure of a user
-type User struct {
-	Name  string `json:"name"`
-	ID    string `json:"id"`
-	Image string `json:"image"`
+// currentUser returns the current user, it is shared by the http and the grpc APIs
+func currentUser(client spotifyClient) (models.User, error) {
+	user, err := client.CurrentUser()
+	if err != nil {
+		return models.User{}, err
+	}
+	return models.ReduceUser(&user.User), nil
+}
+
+// userProfile returns the public profile of the user
+func userProfile(client spotifyClient, userID spotify.ID) (models.User, error) {
+	user, err := client.GetUsersPublicProfile(userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	return models.ReduceUser(user), nil
 }
 
 // userHandler is the handler to get the current user info
 func userHandler(w http.ResponseWriter, r *http.Request) {
 	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
-	user, err := client.CurrentUser()
+	simplifiedUser, err := currentUser(client)
 	if err != nil {
 		log.WithError(err).Error("userHandler: could not get current user")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: Send critical info to other microservice to keep track of country / product / date of birth
-
-	var image string
-	if len(user.Images) > 0 {
-		image = user.Images[0].URL
-	}
-
-	simplifiedUser := User{
-		Name:  user.DisplayName,
-		ID:    user.ID,
-		Image: image,
-	}
 	json.NewEncoder(w).Encode(simplifiedUser)
 }
 
@@ -61,49 +67,65 @@ func userFromHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	userId := params["userID"]
 	client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
-	user, err := client.GetUsersPublicProfile(spotify.ID(userId))
+	simplifiedUser, err := userProfile(client, spotify.ID(userId))
 	if err != nil {
 		log.WithField("userID", userId).WithError(err).Error("userFromHandler: could not get user public profile")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	var image string
-	if len(user.Images) > 0 {
-		image = user.Images[0].URL
-	}
-
-	simplifiedUser := User{
-		Name:  user.DisplayName,
-		ID:    user.ID,
-		Image: image,
-	}
 	json.NewEncoder(w).Encode(simplifiedUser)
 }
 
 // tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
-func tokenMiddleware(next http.Handler) http.Handler {
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bearer := r.Header.Get("Authorization")
-		token := new(oauth2.Token)
-		token.AccessToken = bearer
-		client := spotify.Authenticator{}.NewClient(token)
+		client := factory.Client(bearer)
 		ctx := r.Context()
-		ctx = context.WithValue(ctx, CLIENT_CONTEXT, &client)
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
 func main() {
+	cfg, err := config.Parse("user", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+
 	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
 	r.HandleFunc("/user", userHandler).Methods("GET")
 	r.HandleFunc("/user/{userID}", userFromHandler).Methods("GET")
 
-	corsWrapper := cors.New(cors.Options{
-		AllowedMethods: []string{"GET", "POST"},
-		AllowedHeaders: []string{"Content-Type", "Origin", "Content-Type", "Accept", "*"},
-	})
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+
+	grpcServer := newGRPCServer(func(token string) spotifyClient { return factory.Client(token) })
 
-	contextedMux := tokenMiddleware(r)
-	log.Fatal(http.ListenAndServe(":8080", corsWrapper.Handler(contextedMux)))
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.RunWithGRPC(contextedMux, cfg.HTTP, grpcServer, cfg.GRPC.Addr); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"common/models"
+	"common/openapi"
+	"github.com/gorilla/mux"
+)
+
+func Test_contract(t *testing.T) {
+	spec := openapi.MustLoad()
+	store := newTestStore(t)
+	store.add(morningHook("http://127.0.0.1:9000/hook"))
+	queued, _ := store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{"event":"playlist_updated"}`), time.Now())
+	dead := queued[0]
+	dead.Status, dead.Attempts, dead.Error = statusDeadLetter, 5, "webhook answered 500"
+	store.record("thomas", dead)
+	store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{"event":"playlist_updated"}`), time.Now())
+
+	r := mux.NewRouter()
+	r.HandleFunc("/webhooks", registerHandler(store, false)).Methods("POST")
+	r.HandleFunc("/webhooks", webhooksHandler(store)).Methods("GET")
+	r.HandleFunc("/webhooks/dead-letters", deadLettersHandler(store)).Methods("GET")
+	r.HandleFunc("/webhooks/{webhookID}/deliveries", deliveriesHandler(store)).Methods("GET")
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "should document the webhook registered", method: "POST", path: "/webhooks", body: `{"url":"https://93.184.215.14/hook","secret":"secret","events":["new_release"]}`},
+		{name: "should document the webhooks", method: "GET", path: "/webhooks"},
+		{name: "should document the dead letters", method: "GET", path: "/webhooks/dead-letters"},
+		{name: "should document the delivery log", method: "GET", path: "/webhooks/morning-hook/deliveries"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := webhooksRequest(tt.method, tt.path, tt.body, "thomas", nil, &mockSpotifyClient{})
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			spec.Middleware(r).ServeHTTP(rr, req)
+			if res := rr.Code; res != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", res, http.StatusOK)
+			}
+			if err := spec.ValidateResponse(tt.method, tt.path, rr.Code, rr.Body.Bytes()); err != nil {
+				t.Errorf("handler response does not match the specification: %v", err)
+			}
+		})
+	}
+}
+
+func Test_contract_invalidRequest(t *testing.T) {
+	spec := openapi.MustLoad()
+	accepted := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, body := range []string{
+		`{"url":"https://example.com/hook","secret":"secret","events":["user_login"]}`,
+		`{"url":"https://example.com/hook","secret":"secret","events":[]}`,
+		`{"url":"https://example.com/hook","events":["new_release"]}`,
+	} {
+		req := httptest.NewRequest("POST", "/webhooks", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		spec.Middleware(accepted).ServeHTTP(rr, req)
+		if res := rr.Code; res != http.StatusBadRequest {
+			t.Errorf("POST /webhooks %s: handler returned wrong status code: got %v want %v", body, res, http.StatusBadRequest)
+		}
+	}
+}
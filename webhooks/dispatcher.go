@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"common/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// deliveryTimeout bounds each attempt of a delivery, a webhook answering later is tried again
+	deliveryTimeout = 10 * time.Second
+	// deliveryWorkers is the number of webhooks delivered at once, a slow webhook only holds its own worker
+	deliveryWorkers = 8
+	// signatureHeader is the header of the signature of the payload: sha256= followed by the hex hmac of the body with the secret
+	signatureHeader = "X-Webhook-Signature"
+	// eventHeader is the header of the event of the payload
+	eventHeader = "X-Webhook-Event"
+	// deliveryHeader is the header of the ID of the delivery, the same on each attempt
+	deliveryHeader = "X-Webhook-Delivery"
+)
+
+// newID returns a random ID for the webhooks, the events and the deliveries
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sign returns the signature of the payload with the secret, sha256= followed by the hex hmac sha256
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// dispatcher posts the pending deliveries to their webhooks
+// The webhooks are delivered concurrently by a bounded pool of workers, the deliveries of a webhook in order by a single one
+// A failed attempt is tried again after the backoff, doubled on each attempt, until maxAttempts is reached and the delivery goes to the dead letters
+type dispatcher struct {
+	store       *webhookStore
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+
+	// wake asks the dispatcher to deliver right away, e.g. once an event is published
+	wake chan struct{}
+	// workers holds a slot for each webhook being delivered
+	workers chan struct{}
+
+	mu sync.Mutex
+	// busy are the webhooks being delivered, their deliveries are left to a later pass so an attempt is not made twice
+	// The value tells a pass left some, the dispatcher is then woken up once the webhook is done
+	busy map[string]bool
+}
+
+// newDispatcher creates a dispatcher of the deliveries of the store
+// The deliveries only reach public addresses unless allowPrivate is set, e.g. in development
+func newDispatcher(store *webhookStore, maxAttempts int, backoff time.Duration, allowPrivate bool) *dispatcher {
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	if !allowPrivate {
+		dialer.Control = dialPublic
+	}
+	return &dispatcher{
+		store:       store,
+		client:      &http.Client{Timeout: deliveryTimeout, Transport: &http.Transport{DialContext: dialer.DialContext}},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		wake:        make(chan struct{}, 1),
+		workers:     make(chan struct{}, deliveryWorkers),
+		busy:        map[string]bool{},
+	}
+}
+
+// notify wakes the dispatcher up, a wake up already pending is enough
+func (d *dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retryDelay returns the delay before the next attempt once the given number of attempts failed
+func (d *dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// post makes an attempt of the delivery, any status other than 2xx is a failure
+// It returns the status answered by the webhook, 0 when it could not be reached
+func (d *dispatcher) post(w webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, sign(w.Secret, delivery.Payload))
+	req.Header.Set(eventHeader, delivery.Event)
+	req.Header.Set(deliveryHeader, delivery.ID)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// the body is drained so the connection is reused, the answer of the webhook is not kept
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// attempt tries the delivery at now and returns it with the outcome
+func (d *dispatcher) attempt(w webhook, delivery models.WebhookDelivery, now time.Time) models.WebhookDelivery {
+	status, err := d.post(w, delivery)
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.ResponseStatus = status
+	delivery.Error = ""
+	delivery.NextAttemptAt = time.Time{}
+	switch {
+	case err == nil:
+		delivery.Status = statusDelivered
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = statusDeadLetter
+		delivery.Error = err.Error()
+	default:
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = now.Add(d.retryDelay(delivery.Attempts))
+	}
+	return delivery
+}
+
+// claim marks the webhook as being delivered, it returns false when it already is
+func (d *dispatcher) claim(webhookID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.busy[webhookID]; ok {
+		d.busy[webhookID] = true
+		return false
+	}
+	d.busy[webhookID] = false
+	return true
+}
+
+// release marks the webhook as delivered, the dispatcher is woken up when a pass left some of its deliveries meanwhile
+func (d *dispatcher) release(webhookID string) {
+	d.mu.Lock()
+	left := d.busy[webhookID]
+	delete(d.busy, webhookID)
+	d.mu.Unlock()
+	if left {
+		d.notify()
+	}
+}
+
+// deliverAll makes an attempt of the deliveries of a webhook in order and records each outcome
+func (d *dispatcher) deliverAll(due []dueDelivery, now time.Time) {
+	for _, next := range due {
+		delivery := d.attempt(next.webhook, next.delivery, now)
+		logger := log.WithField("webhook", delivery.WebhookID).WithField("delivery", delivery.ID)
+		switch delivery.Status {
+		case statusDeadLetter:
+			logger.WithField("attempts", delivery.Attempts).Warn("dispatcher: delivery failed every attempt")
+		case statusPending:
+			logger.WithField("error", delivery.Error).Debug("dispatcher: delivery failed, retrying later")
+		}
+		if err := d.store.record(next.webhook.UserID, delivery); err != nil {
+			logger.WithError(err).Error("dispatcher: could not record delivery")
+		}
+	}
+}
+
+// deliver makes an attempt of every delivery due at now and returns once they are made
+// Each webhook is delivered by a worker of the pool, the ones already being delivered by another pass are skipped
+func (d *dispatcher) deliver(now time.Time) {
+	var webhookIDs []string
+	byWebhook := map[string][]dueDelivery{}
+	for _, due := range d.store.due(now) {
+		id := due.delivery.WebhookID
+		if _, ok := byWebhook[id]; !ok {
+			webhookIDs = append(webhookIDs, id)
+		}
+		byWebhook[id] = append(byWebhook[id], due)
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range webhookIDs {
+		if !d.claim(id) {
+			continue
+		}
+		d.workers <- struct{}{}
+		wg.Add(1)
+		go func(id string, due []dueDelivery) {
+			defer wg.Done()
+			defer func() { <-d.workers }()
+			defer d.release(id)
+			d.deliverAll(due, now)
+		}(id, byWebhook[id])
+	}
+	wg.Wait()
+}
+
+// run delivers the deliveries due every interval, or once woken up, until the context is done
+func (d *dispatcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		// a pass does not wait for the one before, a slow webhook does not hold the others
+		go d.deliver(time.Now())
+	}
+}
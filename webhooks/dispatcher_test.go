@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"common/models"
+)
+
+// receiver is a local webhook answering the statuses in order, the last one once they are all answered
+type receiver struct {
+	statuses   []int
+	bodies     []string
+	signatures []string
+	deliveries []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.bodies = append(rc.bodies, string(body))
+	rc.signatures = append(rc.signatures, r.Header.Get(signatureHeader))
+	rc.deliveries = append(rc.deliveries, r.Header.Get(deliveryHeader))
+	status := rc.statuses[0]
+	if len(rc.statuses) > 1 {
+		rc.statuses = rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func Test_sign(t *testing.T) {
+	// echo -n '{"event":"new_release"}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=1424b29dc04acf52b9cf242e7d5180affda6bb8a5797f4d608188a24f4c90822"
+	if got := sign("secret", []byte(`{"event":"new_release"}`)); got != want {
+		t.Errorf("sign() = %s, want %s", got, want)
+	}
+}
+
+func Test_dispatcher_retryDelay(t *testing.T) {
+	d := newDispatcher(nil, 5, time.Second, true)
+	var got []time.Duration
+	for attempts := 1; attempts <= 4; attempts++ {
+		got = append(got, d.retryDelay(attempts))
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}; !reflect.DeepEqual(got, want) {
+		t.Errorf("retryDelay() = %v, want %v", got, want)
+	}
+}
+
+func Test_dispatcher_deliver(t *testing.T) {
+	start := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantStatus   string
+		wantDead     int
+	}{
+		{name: "should deliver the signed payload", statuses: []int{http.StatusNoContent}, wantAttempts: 1, wantStatus: statusDelivered},
+		{name: "should retry with a backoff until delivered", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, wantAttempts: 3, wantStatus: statusDelivered},
+		{name: "should give up to the dead letters", statuses: []int{http.StatusGone}, wantAttempts: 3, wantStatus: statusDeadLetter, wantDead: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{statuses: tt.statuses}
+			server := httptest.NewServer(rc)
+			defer server.Close()
+			store := newTestStore(t)
+			store.add(morningHook(server.URL))
+			payload := []byte(`{"event":"playlist_updated","user_id":"thomas"}`)
+			queued, _ := store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, payload, start)
+			d := newDispatcher(store, 3, time.Second, true)
+
+			// each attempt is made once the backoff is over, not before
+			now := start
+			for i := 0; i < 5; i++ {
+				d.deliver(now)
+				d.deliver(now.Add(time.Duration(1<<i)*time.Second - time.Millisecond))
+				now = now.Add(time.Duration(1<<i) * time.Second)
+			}
+
+			got, _ := store.deliveries("thomas", "morning-hook")
+			if len(got) != 1 || got[0].Attempts != tt.wantAttempts || got[0].Status != tt.wantStatus || got[0].ResponseStatus != tt.statuses[len(tt.statuses)-1] {
+				t.Fatalf("deliveries() = %+v, want %d attempts ending %s", got, tt.wantAttempts, tt.wantStatus)
+			}
+			if len(rc.bodies) != tt.wantAttempts {
+				t.Fatalf("receiver got %d attempts, want %d", len(rc.bodies), tt.wantAttempts)
+			}
+			for i := range rc.bodies {
+				if rc.bodies[i] != string(payload) || rc.signatures[i] != sign("secret", payload) || rc.deliveries[i] != queued[0].ID {
+					t.Errorf("attempt %d got %s signed %s for %s", i, rc.bodies[i], rc.signatures[i], rc.deliveries[i])
+				}
+			}
+			if dead := store.deadLetters("thomas"); len(dead) != tt.wantDead || (tt.wantDead > 0 && dead[0].Error == "") {
+				t.Errorf("deadLetters() = %+v, want %d", dead, tt.wantDead)
+			}
+		})
+	}
+}
+
+func Test_dispatcher_deliver_concurrently(t *testing.T) {
+	start := time.Date(2021, 1, 2, 8, 0, 0, 0, time.UTC)
+	release, slowCalls := make(chan struct{}), make(chan struct{}, 10)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls <- struct{}{}
+		<-release
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+	store := newTestStore(t)
+	evening := morningHook(fast.URL)
+	evening.ID = "evening-hook"
+	store.add(morningHook(slow.URL))
+	store.add(evening)
+	store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{}`), start)
+	d := newDispatcher(store, 3, time.Second, true)
+
+	done := make(chan struct{})
+	go func() {
+		d.deliver(start)
+		close(done)
+	}()
+	<-slowCalls
+	// the slow webhook does not hold the other one, nor a pass made meanwhile which leaves it to its worker
+	deadline := time.Now().Add(time.Second)
+	for got, _ := store.deliveries("thomas", "evening-hook"); got[0].Status != statusDelivered; got, _ = store.deliveries("thomas", "evening-hook") {
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries() = %+v, want the fast webhook delivered while the slow one answers", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	d.deliver(start)
+	if len(slowCalls) != 0 {
+		t.Errorf("the slow webhook got another attempt while delivered")
+	}
+
+	close(release)
+	<-done
+	if got, _ := store.deliveries("thomas", "morning-hook"); got[0].Status != statusDelivered || got[0].Attempts != 1 {
+		t.Errorf("deliveries() = %+v, want the slow webhook delivered once", got)
+	}
+	if len(d.wake) != 1 {
+		t.Errorf("the dispatcher was not woken up once the slow webhook was delivered")
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"common/config"
+	"common/health"
+	"common/identity"
+	"common/models"
+	"common/openapi"
+	"common/server"
+	"common/spotifyapi"
+	"common/webhooks"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmb3/spotify"
+)
+
+// Key type of spotify client context
+type key int
+
+// CLIENT_CONTEXT is the key used for the spotify client context
+var CLIENT_CONTEXT = key(1)
+
+// spotifyClient interface of spotify client
+type spotifyClient interface {
+	CurrentUser() (*spotify.PrivateUser, error)
+}
+
+// knownEvent tells if the webhooks can be registered for the event
+func knownEvent(event string) bool {
+	for _, e := range models.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookRequest is the body of POST /webhooks
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// validate checks the url, the secret and the events of the webhook, the events asked twice are only kept once
+// The host of the url must resolve to public addresses unless allowPrivate is set, e.g. in development
+func (req *webhookRequest) validate(ctx context.Context, allowPrivate bool) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", req.URL)
+	}
+	if req.Secret == "" {
+		return errors.New("the secret must not be empty")
+	}
+	if len(req.Events) == 0 {
+		return errors.New("the events must not be empty")
+	}
+	seen := map[string]bool{}
+	events := []string{}
+	for _, e := range req.Events {
+		if !knownEvent(e) {
+			return fmt.Errorf("invalid event %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	req.Events = events
+	if allowPrivate {
+		return nil
+	}
+	return checkHost(ctx, u.Hostname())
+}
+
+// registerHandler is the handler to register a webhook of the user for some events
+func registerHandler(store *webhookStore, allowPrivate bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Error("registerHandler: could not decode webhook request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(r.Context(), allowPrivate); err != nil {
+			log.WithError(err).Error("registerHandler: invalid webhook request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		user, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("registerHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		id, err := newID()
+		if err != nil {
+			log.WithError(err).Error("registerHandler: could not create webhook ID")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		hook := webhook{
+			Webhook: models.Webhook{ID: id, URL: req.URL, Events: req.Events, CreatedAt: time.Now().UTC()},
+			UserID:  user,
+			Secret:  req.Secret,
+		}
+		if err := store.add(hook); err != nil {
+			log.WithError(err).Error("registerHandler: could not save webhook")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(hook.Webhook)
+	}
+}
+
+// webhooksHandler is the handler to list the webhooks of the user
+func webhooksHandler(store *webhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		user, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("webhooksHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(store.webhooks(user))
+	}
+}
+
+// unregisterHandler is the handler to remove a webhook of the user with its pending deliveries
+func unregisterHandler(store *webhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhookID := mux.Vars(r)["webhookID"]
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		user, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("unregisterHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		found, err := store.remove(user, webhookID)
+		if err != nil {
+			log.WithField("webhookID", webhookID).WithError(err).Error("unregisterHandler: could not remove webhook")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+// deliveriesHandler is the handler of the delivery log of a webhook of the user, the most recent first
+func deliveriesHandler(store *webhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhookID := mux.Vars(r)["webhookID"]
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		user, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("deliveriesHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		deliveries, ok := store.deliveries(user, webhookID)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// deadLettersHandler is the handler of the deliveries to the webhooks of the user that failed every attempt
+func deadLettersHandler(store *webhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.Context().Value(CLIENT_CONTEXT).(spotifyClient)
+		user, err := identity.UserID(r, client)
+		if err != nil {
+			log.WithError(err).Error("deadLettersHandler: could not get user")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(store.deadLetters(user))
+	}
+}
+
+// publishHandler is the handler of the events published by the services, it is not routed by the gateway
+// The services send the token shared with the webhooks service, the events are refused without it or when none is configured
+// A delivery is queued for each webhook of the user registered for the event, they are posted in the background
+func publishHandler(store *webhookStore, d *dispatcher, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sent := r.Header.Get(webhooks.TokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			log.Warn("publishHandler: event refused without the token of the services")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event models.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			log.WithError(err).Error("publishHandler: could not decode event")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !knownEvent(event.Event) || event.UserID == "" {
+			log.WithField("event", event.Event).Error("publishHandler: invalid event")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id, err := newID()
+		if err != nil {
+			log.WithError(err).Error("publishHandler: could not create event ID")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		now := time.Now().UTC()
+		event.ID = id
+		if event.OccurredAt.IsZero() {
+			event.OccurredAt = now
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.WithError(err).Error("publishHandler: could not encode payload")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		queued, err := store.enqueue(event, payload, now)
+		if err != nil {
+			log.WithError(err).Error("publishHandler: could not queue deliveries")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(queued) > 0 {
+			d.notify()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// tokenMiddleware will retrieve the token from the header and add the spotify client in the request context
+// The clients are created by the factory so they call the configured spotify API
+func tokenMiddleware(factory *spotifyapi.Factory, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := r.Header.Get("Authorization")
+		client := factory.Client(bearer)
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, CLIENT_CONTEXT, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newHealthChecker creates the checker used by the health endpoints
+// Reachability of the spotify api is only checked when enabled in the configuration
+func newHealthChecker(cfg config.Config) *health.Checker {
+	checker := health.New(2 * time.Second)
+	checker.Add("config", func(ctx context.Context) error { return cfg.Validate() })
+	if cfg.Spotify.ReadinessCheck {
+		checker.Add("spotify", health.HTTPCheck(http.DefaultClient, cfg.Spotify.APIURL))
+	}
+	return checker
+}
+
+func main() {
+	cfg, err := config.Parse("webhooks", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Fatal("could not load configuration")
+	}
+	log.SetLevel(cfg.Level())
+	checker := newHealthChecker(cfg)
+
+	store, err := openWebhookStore(cfg.Webhooks.StatePath)
+	if err != nil {
+		log.WithError(err).Fatal("could not open webhook store")
+	}
+	if cfg.Webhooks.Token == "" {
+		log.Warn("webhooks.token is empty, the events of the services are refused")
+	}
+	d := newDispatcher(store, cfg.Webhooks.MaxAttempts, cfg.Webhooks.RetryBackoff, cfg.Webhooks.AllowPrivate)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go d.run(ctx, cfg.Webhooks.RetryBackoff)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/healthz", checker.LivenessHandler).Methods("GET")
+	r.HandleFunc("/readyz", checker.ReadinessHandler).Methods("GET")
+	r.HandleFunc("/webhooks", registerHandler(store, cfg.Webhooks.AllowPrivate)).Methods("POST")
+	r.HandleFunc("/webhooks", webhooksHandler(store)).Methods("GET")
+	r.HandleFunc("/webhooks/dead-letters", deadLettersHandler(store)).Methods("GET")
+	r.HandleFunc("/webhooks/{webhookID}", unregisterHandler(store)).Methods("DELETE")
+	r.HandleFunc("/webhooks/{webhookID}/deliveries", deliveriesHandler(store)).Methods("GET")
+	r.HandleFunc("/events", publishHandler(store, d, cfg.Webhooks.Token)).Methods("POST")
+
+	factory, err := spotifyapi.NewFactory(cfg.Spotify.APIURL)
+	if err != nil {
+		log.WithError(err).Fatal("could not create spotify client factory")
+	}
+
+	contextedMux := tokenMiddleware(factory, openapi.MustLoad().Middleware(r))
+	if err := server.Run(contextedMux, cfg.HTTP); err != nil {
+		log.WithError(err).Fatal("server stopped with an error")
+	}
+}
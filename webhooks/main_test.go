@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"common/identity"
+	"common/models"
+	"common/webhooks"
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify"
+)
+
+type mockSpotifyClient struct {
+	err error
+}
+
+func (c *mockSpotifyClient) CurrentUser() (*spotify.PrivateUser, error) {
+	return &spotify.PrivateUser{User: spotify.User{ID: "thomas"}}, c.err
+}
+
+func newTestStore(t *testing.T) *webhookStore {
+	store, err := openWebhookStore(filepath.Join(t.TempDir(), "webhooks.json"))
+	if err != nil {
+		t.Fatalf("openWebhookStore() error = %v", err)
+	}
+	return store
+}
+
+// morningHook is the webhook of thomas registered for the playlist updates
+func morningHook(url string) webhook {
+	return webhook{
+		Webhook: models.Webhook{ID: "morning-hook", URL: url, Events: []string{models.PlaylistUpdatedEvent}},
+		UserID:  "thomas",
+		Secret:  "secret",
+	}
+}
+
+// webhooksRequest creates a request of the user sent with the spotify client and the vars of its route
+// The user is asked to spotify when empty
+func webhooksRequest(method, target, body, userID string, vars map[string]string, client spotifyClient) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "token")
+	if userID != "" {
+		r.Header.Set(identity.Header, userID)
+	}
+	r = mux.SetURLVars(r, vars)
+	return r.WithContext(context.WithValue(r.Context(), CLIENT_CONTEXT, client))
+}
+
+func Test_registerHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		userID       string
+		client       *mockSpotifyClient
+		allowPrivate bool
+		wantStatus   int
+		wantEvents   []string
+	}{
+		{
+			name:       "should register the webhook of the user",
+			body:       `{"url": "https://93.184.215.14/hook", "secret": "secret", "events": ["track_changed", "new_release", "track_changed"]}`,
+			userID:     "thomas",
+			client:     &mockSpotifyClient{},
+			wantStatus: http.StatusOK,
+			wantEvents: []string{models.TrackChangedEvent, models.NewReleaseEvent},
+		},
+		{
+			name:       "should ask the user to spotify when called directly",
+			body:       `{"url": "https://93.184.215.14/hook", "secret": "secret", "events": ["playlist_updated"]}`,
+			client:     &mockSpotifyClient{},
+			wantStatus: http.StatusOK,
+			wantEvents: []string{models.PlaylistUpdatedEvent},
+		},
+		{
+			name:         "should register a webhook on the loopback when the private addresses are allowed",
+			body:         `{"url": "http://127.0.0.1:9000/hook", "secret": "secret", "events": ["new_release"]}`,
+			userID:       "thomas",
+			client:       &mockSpotifyClient{},
+			allowPrivate: true,
+			wantStatus:   http.StatusOK,
+			wantEvents:   []string{models.NewReleaseEvent},
+		},
+		{name: "should refuse the loopback", body: `{"url": "http://127.0.0.1:9000/hook", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse a host resolving to the loopback", body: `{"url": "http://localhost:9000/hook", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse the ipv6 loopback", body: `{"url": "http://[::1]:9000/hook", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse a private address", body: `{"url": "http://172.18.0.5:8080/events", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse a link-local address", body: `{"url": "http://169.254.169.254/latest/meta-data", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse a host that does not resolve", body: `{"url": "http://webhooks.invalid/events", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse a relative url", body: `{"url": "/hook", "secret": "secret", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse an empty secret", body: `{"url": "http://example.com", "events": ["new_release"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse an unknown event", body: `{"url": "http://example.com", "secret": "secret", "events": ["user_login"]}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should refuse a webhook without events", body: `{"url": "http://example.com", "secret": "secret"}`, userID: "thomas", client: &mockSpotifyClient{}, wantStatus: http.StatusBadRequest},
+		{name: "should fail when the user is unknown", body: `{"url": "http://93.184.215.14", "secret": "secret", "events": ["new_release"]}`, client: &mockSpotifyClient{err: errors.New("unauthorized")}, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			rr := httptest.NewRecorder()
+			registerHandler(store, tt.allowPrivate)(rr, webhooksRequest("POST", "/webhooks", tt.body, tt.userID, nil, tt.client))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := store.webhooks("thomas"); len(got) != 0 {
+					t.Errorf("webhooks() = %+v, want none", got)
+				}
+				return
+			}
+			var got models.Webhook
+			json.NewDecoder(rr.Body).Decode(&got)
+			if got.ID == "" || got.CreatedAt.IsZero() || !reflect.DeepEqual(got.Events, tt.wantEvents) || strings.Contains(rr.Body.String(), "secret") {
+				t.Errorf("handler returned %s, want the webhook without its secret", rr.Body.String())
+			}
+			if listed := store.webhooks("thomas"); len(listed) != 1 || listed[0].ID != got.ID {
+				t.Errorf("webhooks() = %+v, want the webhook registered", listed)
+			}
+		})
+	}
+}
+
+func Test_unregisterHandler(t *testing.T) {
+	store := newTestStore(t)
+	store.add(morningHook("http://127.0.0.1:9000/hook"))
+	store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{}`), time.Now())
+
+	rr := httptest.NewRecorder()
+	unregisterHandler(store)(rr, webhooksRequest("DELETE", "/webhooks/morning-hook", "", "paul", map[string]string{"webhookID": "morning-hook"}, &mockSpotifyClient{}))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("another user removing the webhook got %v, want %v", rr.Code, http.StatusNotFound)
+	}
+	rr = httptest.NewRecorder()
+	unregisterHandler(store)(rr, webhooksRequest("DELETE", "/webhooks/morning-hook", "", "thomas", map[string]string{"webhookID": "morning-hook"}, &mockSpotifyClient{}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := store.webhooks("thomas"); len(got) != 0 {
+		t.Errorf("webhooks() = %+v, want none", got)
+	}
+	if got := store.due(time.Now()); len(got) != 0 {
+		t.Errorf("due() = %+v, want the pending deliveries dropped", got)
+	}
+}
+
+func Test_deliveriesHandler(t *testing.T) {
+	store := newTestStore(t)
+	store.add(morningHook("http://127.0.0.1:9000/hook"))
+	store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{"event":"playlist_updated"}`), time.Now())
+	tests := []struct {
+		name       string
+		userID     string
+		webhookID  string
+		wantStatus int
+	}{
+		{name: "should log the deliveries of the webhook", userID: "thomas", webhookID: "morning-hook", wantStatus: http.StatusOK},
+		{name: "should not log the deliveries of another user", userID: "paul", webhookID: "morning-hook", wantStatus: http.StatusNotFound},
+		{name: "should not log an unknown webhook", userID: "thomas", webhookID: "unknown", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			deliveriesHandler(store)(rr, webhooksRequest("GET", "/webhooks/"+tt.webhookID+"/deliveries", "", tt.userID, map[string]string{"webhookID": tt.webhookID}, &mockSpotifyClient{}))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got []models.WebhookDelivery
+			json.NewDecoder(rr.Body).Decode(&got)
+			if len(got) != 1 || got[0].Status != statusPending || got[0].Event != models.PlaylistUpdatedEvent || string(got[0].Payload) != `{"event":"playlist_updated"}` {
+				t.Errorf("handler returned %s, want the pending delivery", rr.Body.String())
+			}
+		})
+	}
+}
+
+func Test_publishHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		token      string
+		wantStatus int
+		wantQueued int
+	}{
+		{name: "should refuse an event without the token of the services", body: `{"event": "playlist_updated", "user_id": "thomas", "data": {}}`, wantStatus: http.StatusUnauthorized},
+		{name: "should refuse an event with another token", body: `{"event": "playlist_updated", "user_id": "thomas", "data": {}}`, token: "user", wantStatus: http.StatusUnauthorized},
+		{name: "should queue a delivery for the webhook registered", body: `{"event": "playlist_updated", "user_id": "thomas", "data": {"playlist_id": "morning"}}`, token: "services", wantStatus: http.StatusAccepted, wantQueued: 1},
+		{name: "should queue nothing for the other events", body: `{"event": "track_changed", "user_id": "thomas", "data": {}}`, token: "services", wantStatus: http.StatusAccepted},
+		{name: "should queue nothing for the other users", body: `{"event": "playlist_updated", "user_id": "paul", "data": {}}`, token: "services", wantStatus: http.StatusAccepted},
+		{name: "should refuse an unknown event", body: `{"event": "user_login", "user_id": "thomas"}`, token: "services", wantStatus: http.StatusBadRequest},
+		{name: "should refuse an event without user", body: `{"event": "playlist_updated"}`, token: "services", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			store.add(morningHook("http://127.0.0.1:9000/hook"))
+			d := newDispatcher(store, 3, time.Second, true)
+			r := httptest.NewRequest("POST", "/events", strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set(webhooks.TokenHeader, tt.token)
+			}
+			rr := httptest.NewRecorder()
+			publishHandler(store, d, "services")(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatus)
+			}
+			due := store.due(time.Now())
+			if len(due) != tt.wantQueued || len(d.wake) != tt.wantQueued {
+				t.Fatalf("due() = %+v with %d wake up, want %d queued", due, len(d.wake), tt.wantQueued)
+			}
+			if tt.wantQueued == 0 {
+				return
+			}
+			var payload models.Event
+			json.Unmarshal(due[0].delivery.Payload, &payload)
+			if payload.ID == "" || payload.UserID != "thomas" || payload.OccurredAt.IsZero() || string(payload.Data) != `{"playlist_id":"morning"}` {
+				t.Errorf("payload = %s, want the event with its ID", due[0].delivery.Payload)
+			}
+		})
+	}
+}
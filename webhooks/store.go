@@ -0,0 +1,261 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"common/jsonfile"
+	"common/models"
+)
+
+const (
+	// deliveryLogSize is the number of finished deliveries kept for each webhook, the pending ones are always kept
+	deliveryLogSize = 100
+	// deadLetterSize is the number of dead letters kept for each user
+	deadLetterSize = 100
+)
+
+// The status of a delivery
+const (
+	statusPending    = "pending"
+	statusDelivered  = "delivered"
+	statusDeadLetter = "dead_letter"
+)
+
+// webhook is a webhook of a user with the secret signing its payloads
+type webhook struct {
+	models.Webhook
+	UserID string `json:"user_id"`
+	Secret string `json:"secret"`
+}
+
+// subscribed tells if the webhook is registered for the event
+func (w webhook) subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookState is what the store keeps, the deliveries and the dead letters of each user are the most recent first
+type webhookState struct {
+	Webhooks    []webhook                           `json:"webhooks"`
+	Deliveries  []models.WebhookDelivery            `json:"deliveries"`
+	DeadLetters map[string][]models.WebhookDelivery `json:"dead_letters"`
+}
+
+// clone returns a copy of the state that can be changed without changing the state
+func (s webhookState) clone() webhookState {
+	c := webhookState{
+		Webhooks:    append([]webhook{}, s.Webhooks...),
+		Deliveries:  append([]models.WebhookDelivery{}, s.Deliveries...),
+		DeadLetters: make(map[string][]models.WebhookDelivery, len(s.DeadLetters)),
+	}
+	for userID, letters := range s.DeadLetters {
+		c.DeadLetters[userID] = letters
+	}
+	return c
+}
+
+// find returns the index of the webhook of the user, -1 when the user has no such webhook
+func (s webhookState) find(userID, id string) int {
+	for i, w := range s.Webhooks {
+		if w.ID == id && w.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// webhookStore keeps the webhooks, their deliveries and the dead letters in a json file
+// The file is rewritten on every change, the finished deliveries and the dead letters are trimmed so it stays small
+type webhookStore struct {
+	path string
+
+	mu    sync.Mutex
+	state webhookState
+}
+
+// openWebhookStore loads the state kept in the file at path, there is no webhook when the file does not exist
+func openWebhookStore(path string) (*webhookStore, error) {
+	s := &webhookStore{path: path, state: webhookState{DeadLetters: map[string][]models.WebhookDelivery{}}}
+	if err := jsonfile.Read(path, &s.state); err != nil {
+		return nil, err
+	}
+	if s.state.DeadLetters == nil {
+		s.state.DeadLetters = map[string][]models.WebhookDelivery{}
+	}
+	return s, nil
+}
+
+// update changes a copy of the state with change and keeps it once saved, the state is unchanged when change fails
+func (s *webhookStore) update(change func(state *webhookState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state.clone()
+	if err := change(&state); err != nil {
+		return err
+	}
+	if err := jsonfile.Write(s.path, state); err != nil {
+		return err
+	}
+	s.state = state
+	return nil
+}
+
+// add registers the webhook
+func (s *webhookStore) add(w webhook) error {
+	return s.update(func(state *webhookState) error {
+		state.Webhooks = append(state.Webhooks, w)
+		return nil
+	})
+}
+
+// webhooks returns the webhooks of the user in the order they were registered
+func (s *webhookStore) webhooks(userID string) []models.Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := []models.Webhook{}
+	for _, w := range s.state.Webhooks {
+		if w.UserID == userID {
+			webhooks = append(webhooks, w.Webhook)
+		}
+	}
+	return webhooks
+}
+
+// remove drops the webhook of the user with its deliveries, the dead letters are kept
+// It returns false when the user has no such webhook
+func (s *webhookStore) remove(userID, id string) (bool, error) {
+	found := false
+	err := s.update(func(state *webhookState) error {
+		i := state.find(userID, id)
+		if i < 0 {
+			return nil
+		}
+		found = true
+		state.Webhooks = append(state.Webhooks[:i:i], state.Webhooks[i+1:]...)
+		deliveries := []models.WebhookDelivery{}
+		for _, d := range state.Deliveries {
+			if d.WebhookID != id {
+				deliveries = append(deliveries, d)
+			}
+		}
+		state.Deliveries = deliveries
+		return nil
+	})
+	return found, err
+}
+
+// enqueue creates a pending delivery of the event for each webhook of its user registered for it
+func (s *webhookStore) enqueue(event models.Event, payload []byte, now time.Time) ([]models.WebhookDelivery, error) {
+	var queued []models.WebhookDelivery
+	err := s.update(func(state *webhookState) error {
+		queued = nil
+		for _, w := range state.Webhooks {
+			if w.UserID != event.UserID || !w.subscribed(event.Event) {
+				continue
+			}
+			id, err := newID()
+			if err != nil {
+				return err
+			}
+			queued = append(queued, models.WebhookDelivery{
+				ID:            id,
+				WebhookID:     w.ID,
+				Event:         event.Event,
+				Status:        statusPending,
+				CreatedAt:     now,
+				NextAttemptAt: now,
+				Payload:       payload,
+			})
+		}
+		// the deliveries are the most recent first, those of the same event keep the order of the webhooks
+		state.Deliveries = append(append([]models.WebhookDelivery{}, queued...), state.Deliveries...)
+		return nil
+	})
+	return queued, err
+}
+
+// dueDelivery is a pending delivery to try now with its webhook
+type dueDelivery struct {
+	webhook  webhook
+	delivery models.WebhookDelivery
+}
+
+// due returns the pending deliveries whose next attempt is before now, the oldest first
+func (s *webhookStore) due(now time.Time) []dueDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := map[string]webhook{}
+	for _, w := range s.state.Webhooks {
+		webhooks[w.ID] = w
+	}
+	var due []dueDelivery
+	for i := len(s.state.Deliveries) - 1; i >= 0; i-- {
+		d := s.state.Deliveries[i]
+		if d.Status == statusPending && !d.NextAttemptAt.After(now) {
+			due = append(due, dueDelivery{webhook: webhooks[d.WebhookID], delivery: d})
+		}
+	}
+	return due
+}
+
+// record keeps the outcome of an attempt of the delivery made to the webhook of the user
+// A dead letter is copied to the dead letters of the user, only the last finished deliveries of the webhook are kept
+// The delivery is dropped when its webhook was removed meanwhile
+func (s *webhookStore) record(userID string, delivery models.WebhookDelivery) error {
+	return s.update(func(state *webhookState) error {
+		kept := make([]models.WebhookDelivery, 0, len(state.Deliveries))
+		found, finished := false, 0
+		for _, d := range state.Deliveries {
+			if d.ID == delivery.ID {
+				d, found = delivery, true
+			}
+			if d.WebhookID == delivery.WebhookID && d.Status != statusPending {
+				if finished++; finished > deliveryLogSize {
+					continue
+				}
+			}
+			kept = append(kept, d)
+		}
+		if !found {
+			return nil
+		}
+		state.Deliveries = kept
+		if delivery.Status == statusDeadLetter {
+			letters := append([]models.WebhookDelivery{delivery}, state.DeadLetters[userID]...)
+			if len(letters) > deadLetterSize {
+				letters = letters[:deadLetterSize]
+			}
+			state.DeadLetters[userID] = letters
+		}
+		return nil
+	})
+}
+
+// deliveries returns the deliveries of the webhook of the user, the most recent first
+// It returns false when the user has no such webhook
+func (s *webhookStore) deliveries(userID, id string) ([]models.WebhookDelivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.find(userID, id) < 0 {
+		return nil, false
+	}
+	deliveries := []models.WebhookDelivery{}
+	for _, d := range s.state.Deliveries {
+		if d.WebhookID == id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, true
+}
+
+// deadLetters returns the deliveries to the webhooks of the user that failed every attempt, the most recent first
+func (s *webhookStore) deadLetters(userID string) []models.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.WebhookDelivery{}, s.state.DeadLetters[userID]...)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/models"
+)
+
+func Test_webhookStore_reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	store, err := openWebhookStore(path)
+	if err != nil {
+		t.Fatalf("openWebhookStore() error = %v", err)
+	}
+	store.add(morningHook("http://127.0.0.1:9000/hook"))
+	queued, _ := store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{}`), time.Now())
+
+	reopened, err := openWebhookStore(path)
+	if err != nil {
+		t.Fatalf("openWebhookStore() error = %v", err)
+	}
+	if got := reopened.webhooks("thomas"); len(got) != 1 || got[0].ID != "morning-hook" {
+		t.Errorf("webhooks() = %+v, want the webhook kept", got)
+	}
+	if due := reopened.due(time.Now()); len(due) != 1 || due[0].delivery.ID != queued[0].ID || due[0].webhook.Secret != "secret" {
+		t.Errorf("due() = %+v, want the pending delivery kept", due)
+	}
+}
+
+func Test_webhookStore_record(t *testing.T) {
+	store := newTestStore(t)
+	store.add(morningHook("http://127.0.0.1:9000/hook"))
+	for i := 0; i < deliveryLogSize+2; i++ {
+		store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{}`), time.Now())
+	}
+	due := store.due(time.Now())
+	for _, d := range due[:len(due)-1] {
+		d.delivery.Status = statusDelivered
+		store.record("thomas", d.delivery)
+	}
+	last := due[len(due)-1].delivery
+
+	got, _ := store.deliveries("thomas", "morning-hook")
+	if len(got) != deliveryLogSize+1 || got[0].ID != last.ID || got[0].Status != statusPending {
+		t.Fatalf("deliveries() = %d with %+v first, want the pending one and the last %d delivered", len(got), got[0], deliveryLogSize)
+	}
+
+	last.Status = statusDeadLetter
+	store.record("thomas", last)
+	if dead := store.deadLetters("thomas"); len(dead) != 1 || dead[0].ID != last.ID {
+		t.Errorf("deadLetters() = %+v, want the last delivery", dead)
+	}
+	// a delivery of a removed webhook is not recorded
+	store.remove("thomas", "morning-hook")
+	store.record("thomas", last)
+	if dead := store.deadLetters("thomas"); len(dead) != 1 {
+		t.Errorf("deadLetters() = %+v, want the dead letter of the removed webhook kept once", dead)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/models"
+)
+
+func Test_publicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.215.14", want: true},
+		{ip: "2606:2800:21f:cb07:6820:80da:af6b:8b2c", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "10.1.2.3"},
+		{ip: "172.18.0.5"},
+		{ip: "192.168.1.1"},
+		{ip: "100.64.0.1"},
+		{ip: "169.254.169.254"},
+		{ip: "0.0.0.0"},
+		{ip: "224.0.0.1"},
+		{ip: "::1"},
+		{ip: "::"},
+		{ip: "fd00::1"},
+		{ip: "fe80::1"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "64:ff9b::7f00:1"},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func Test_dispatcher_deliver_private(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	store := newTestStore(t)
+	// a webhook registered on a public host resolving to the loopback since
+	store.add(morningHook(server.URL))
+	now := time.Now()
+	store.enqueue(models.Event{Event: models.PlaylistUpdatedEvent, UserID: "thomas"}, []byte(`{"event":"playlist_updated"}`), now)
+
+	newDispatcher(store, 3, time.Second, false).deliver(now)
+
+	if len(rc.bodies) != 0 {
+		t.Errorf("the loopback received %d deliveries, want none", len(rc.bodies))
+	}
+	got, _ := store.deliveries("thomas", "morning-hook")
+	if len(got) != 1 || got[0].Attempts != 1 || got[0].Status == statusDelivered || got[0].Error == "" {
+		t.Errorf("deliveries() = %+v, want a failed attempt", got)
+	}
+}